@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/internal/bench"
+	"github.com/lucasdc6/gdns/pkg/errors"
+	"github.com/pborman/getopt/v2"
+)
+
+func main() {
+	getopt.SetParameters("queries.txt")
+	serverFlag := getopt.StringLong("server", 's', "127.0.0.1:3000", "Define the address of the server the queries are sent to")
+	transportFlag := getopt.EnumLong("transport", 'm', []string{"udp", "tcp"}, "udp", "Send the queries over udp or tcp")
+	concurrencyFlag := getopt.IntLong("concurrency", 'c', 10, "Define the queries waiting for their response at the same time")
+	rateFlag := getopt.IntLong("rate", 'r', 0, "Define the queries sent each second, without limit when 0")
+	durationFlag := getopt.DurationLong("duration", 'l', 10*time.Second, "Define the time sending queries, without limit when 0")
+	countFlag := getopt.IntLong("count", 'n', 0, "Define the queries sent, without limit when 0")
+	timeoutFlag := getopt.DurationLong("timeout", 't', 2*time.Second, "Define the time waited for each response")
+	noRecursionFlag := getopt.BoolLong("no-recursion", 0, "Send the queries without the RD flag")
+	helpFlag := getopt.BoolLong("help", '?', "Show this help")
+
+	getopt.Parse()
+
+	if *helpFlag || getopt.NArgs() != 1 {
+		getopt.PrintUsage(os.Stderr)
+		return
+	}
+
+	var input io.Reader = os.Stdin
+
+	if getopt.Arg(0) != "-" {
+		file, err := os.Open(getopt.Arg(0))
+
+		if err != nil {
+			log.Fatalf("Error reading the query list: %s", err)
+			os.Exit(errors.ReadingQueryList)
+		}
+
+		defer file.Close()
+		input = file
+	}
+
+	queries, err := bench.ReadQueries(input)
+
+	if err != nil {
+		log.Fatalf("Error reading the query list: %s", err)
+		os.Exit(errors.ReadingQueryList)
+	}
+
+	// An interrupt stops the run, reporting the queries already sent
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	log.Printf("Sending %d queries to %s over %s", len(queries), *serverFlag, *transportFlag)
+
+	result, err := bench.Run(ctx, queries, bench.Options{
+		Server:      *serverFlag,
+		Transport:   *transportFlag,
+		Concurrency: *concurrencyFlag,
+		Rate:        *rateFlag,
+		Duration:    *durationFlag,
+		Count:       *countFlag,
+		Timeout:     *timeoutFlag,
+		Recursion:   !*noRecursionFlag,
+	})
+
+	if err != nil {
+		log.Fatalf("Error running the benchmark: %s", err)
+		os.Exit(errors.RunningBenchmark)
+	}
+
+	result.Report(os.Stdout)
+}
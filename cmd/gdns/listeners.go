@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/internal/activation"
+	"github.com/lucasdc6/gdns/internal/server"
+	"github.com/lucasdc6/gdns/pkg/config"
+)
+
+// defaultPorts - Port of the listeners of each transport without one
+var defaultPorts = map[string]int{
+	"udp":   53,
+	"tcp":   53,
+	"tls":   853,
+	"https": 443,
+	"quic":  853,
+}
+
+// listenerServers - Servers of a listener, one for each of its
+// transports or passed sockets, with the rest of their configuration
+// copied from base
+func listenerServers(base server.Server, listener config.Listener, views []*server.View, sockets []activation.Socket) ([]server.Server, error) {
+	transports := []string{listener.Transport}
+
+	switch listener.Transport {
+	case "":
+		transports = []string{"udp"}
+	case "both":
+		transports = []string{"udp", "tcp"}
+	case "udp", "tcp", "tls", "https", "quic", "unix":
+	default:
+		return nil, fmt.Errorf("unknown transport %q, choose one of udp, tcp, both, tls, https, quic or unix", listener.Transport)
+	}
+
+	if listener.View != "" {
+		base.Views = nil
+
+		for _, view := range views {
+			if view.Name == listener.View {
+				base.Views = []*server.View{view}
+			}
+		}
+
+		if base.Views == nil {
+			return nil, fmt.Errorf("unknown view %s", listener.View)
+		}
+	}
+
+	if listener.ReusePort > 1 && (listener.Socket != "" || (listener.Transport != "" && listener.Transport != "udp" && listener.Transport != "both")) {
+		return nil, fmt.Errorf("reuse-port is only for the udp transport")
+	}
+
+	base.Host = listener.Address
+	base.AllowQuery = listener.AllowQuery
+
+	if listener.Socket != "" {
+		return socketServers(base, listener, sockets)
+	}
+
+	if listener.Transport == "unix" && base.Host == "" {
+		return nil, fmt.Errorf("unix transport without the path of the socket")
+	}
+
+	if base.Host == "*" {
+		base.Host = ""
+	}
+
+	servers := []server.Server{}
+
+	for _, transport := range transports {
+		listenerServer := base
+		listenerServer.Mode = transport
+		listenerServer.Port = listener.Port
+
+		if transport == "udp" {
+			listenerServer.ReusePort = listener.ReusePort
+		}
+
+		if listenerServer.Port == 0 {
+			listenerServer.Port = defaultPorts[transport]
+		}
+
+		servers = append(servers, listenerServer)
+	}
+
+	return servers, nil
+}
+
+// socketServers - Servers of the passed sockets named as the socket of
+// a listener
+func socketServers(base server.Server, listener config.Listener, sockets []activation.Socket) ([]server.Server, error) {
+	servers := []server.Server{}
+
+	for _, socket := range sockets {
+		if socket.Name != listener.Socket {
+			continue
+		}
+
+		mode, err := socketMode(listener.Transport, socket)
+
+		if err != nil {
+			return nil, err
+		}
+
+		socketServer := base
+		socketServer.Mode = mode
+		socketServer.Host = socket.Address()
+		socketServer.Listener, socketServer.PacketConn = socket.Listener, socket.PacketConn
+		servers = append(servers, socketServer)
+	}
+
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no socket %s passed by the service manager", listener.Socket)
+	}
+
+	return servers, nil
+}
+
+// socketMode - Mode of the server of a passed socket: the stream
+// sockets serve tcp, tls, https or unix, and the datagram ones udp or
+// quic. Without transport, or with both, they serve the one of their
+// network
+func socketMode(transport string, socket activation.Socket) (string, error) {
+	stream := socket.Listener != nil
+
+	switch transport {
+	case "", "both":
+		return socket.Network(), nil
+	case "tcp", "tls", "https", "unix":
+		if stream {
+			return transport, nil
+		}
+	case "udp", "quic":
+		if !stream {
+			return transport, nil
+		}
+	}
+
+	return "", fmt.Errorf("the %s socket %s at %s can't serve the %s transport", socket.Network(), socket.Name, socket.Address(), transport)
+}
+
+// socketListeners - Listeners of the passed sockets, one for each name,
+// used when the configuration doesn't have any
+func socketListeners(sockets []activation.Socket) []config.Listener {
+	listeners := []config.Listener{}
+	names := map[string]bool{}
+
+	for _, socket := range sockets {
+		if !names[socket.Name] {
+			names[socket.Name] = true
+			listeners = append(listeners, config.Listener{Socket: socket.Name})
+		}
+	}
+
+	return listeners
+}
+
+// closeUnusedSockets - Close the passed sockets without listener
+func closeUnusedSockets(listeners []config.Listener, sockets []activation.Socket) {
+	names := map[string]bool{}
+
+	for _, listener := range listeners {
+		names[listener.Socket] = true
+	}
+
+	for _, socket := range sockets {
+		if names[socket.Name] {
+			continue
+		}
+
+		log.Warnf("Closing the socket %s at %s without listener", socket.Name, socket.Address())
+
+		if socket.Listener != nil {
+			socket.Listener.Close()
+		} else {
+			socket.PacketConn.Close()
+		}
+	}
+}
+
+// flagListeners - Listeners of the command line flags, used when the
+// configuration doesn't have any
+func flagListeners(mode string, hosts map[string]string, ports map[string]int) []config.Listener {
+	transports := []string{mode}
+
+	if mode == "both" {
+		transports = []string{"udp", "tcp"}
+	}
+
+	listeners := []config.Listener{}
+
+	for _, transport := range transports {
+		listeners = append(listeners, config.Listener{Address: hosts[transport], Port: ports[transport], Transport: transport})
+	}
+
+	return listeners
+}
+
+// listenerName - Name of a listener in the messages: its socket, the
+// path of the unix transport, or its address and port
+func listenerName(listener config.Listener) string {
+	switch {
+	case listener.Socket != "":
+		return "socket " + listener.Socket
+	case listener.Transport == "unix":
+		return listener.Address
+	}
+
+	return net.JoinHostPort(listener.Address, strconv.Itoa(listener.Port))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/internal/activation"
+	"github.com/lucasdc6/gdns/internal/server"
+	"github.com/lucasdc6/gdns/pkg/config"
+)
+
+// listenerServer - Fields of a server set by its listener
+type listenerServer struct {
+	Mode       string
+	Host       string
+	Port       int
+	ReusePort  int
+	View       string
+	AllowQuery []string
+	Socket     bool
+}
+
+// testSockets - Sockets passed to the server, a TCP and a UDP one named
+// dns and a unix stream one named control
+func testSockets(t *testing.T) []activation.Socket {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+
+	unix, err := net.Listen("unix", filepath.Join(t.TempDir(), "control.sock"))
+
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	t.Cleanup(func() {
+		tcp.Close()
+		udp.Close()
+		unix.Close()
+	})
+
+	return []activation.Socket{
+		{Name: "dns", Listener: tcp},
+		{Name: "dns", PacketConn: udp},
+		{Name: "control", Listener: unix},
+	}
+}
+
+func TestListenerServers(t *testing.T) {
+	sockets := testSockets(t)
+	views := []*server.View{{Name: "internal"}, {Name: "external"}}
+	base := server.Server{Host: "127.0.0.1", Mode: "udp", Views: views}
+
+	tests := []struct {
+		name        string
+		listener    config.Listener
+		wantServers []listenerServer
+		wantErr     string
+	}{
+		{
+			name:        "Default transport and port",
+			listener:    config.Listener{Address: "192.0.2.1"},
+			wantServers: []listenerServer{{Mode: "udp", Host: "192.0.2.1", Port: 53}},
+		},
+		{
+			name:     "Both transports, in every address",
+			listener: config.Listener{Address: "*", Port: 5353, Transport: "both", ReusePort: 4},
+			wantServers: []listenerServer{
+				{Mode: "udp", Port: 5353, ReusePort: 4},
+				{Mode: "tcp", Port: 5353},
+			},
+		},
+		{
+			name:        "Default port of tls",
+			listener:    config.Listener{Address: "::1", Transport: "tls"},
+			wantServers: []listenerServer{{Mode: "tls", Host: "::1", Port: 853}},
+		},
+		{
+			name:        "View and allow-query",
+			listener:    config.Listener{Transport: "https", View: "external", AllowQuery: []string{"10.0.0.0/8"}},
+			wantServers: []listenerServer{{Mode: "https", Port: 443, View: "external", AllowQuery: []string{"10.0.0.0/8"}}},
+		},
+		{
+			name:        "Unix socket",
+			listener:    config.Listener{Address: "/run/gdns/dns.sock", Transport: "unix"},
+			wantServers: []listenerServer{{Mode: "unix", Host: "/run/gdns/dns.sock"}},
+		},
+		{
+			name:     "Unix socket without path",
+			listener: config.Listener{Transport: "unix"},
+			wantErr:  "unix transport without the path of the socket",
+		},
+		{
+			name:     "Passed sockets",
+			listener: config.Listener{Socket: "dns"},
+			wantServers: []listenerServer{
+				{Mode: "tcp", Host: sockets[0].Address(), Socket: true},
+				{Mode: "udp", Host: sockets[1].Address(), Socket: true},
+			},
+		},
+		{
+			name:        "Passed unix socket",
+			listener:    config.Listener{Socket: "control"},
+			wantServers: []listenerServer{{Mode: "unix", Host: sockets[2].Address(), Socket: true}},
+		},
+		{
+			name:     "Passed sockets with other transport",
+			listener: config.Listener{Socket: "dns", Transport: "tls"},
+			wantErr:  "the udp socket dns at " + sockets[1].Address() + " can't serve the tls transport",
+		},
+		{
+			name:     "Unknown socket",
+			listener: config.Listener{Socket: "metrics"},
+			wantErr:  "no socket metrics passed by the service manager",
+		},
+		{
+			name:     "Unknown transport",
+			listener: config.Listener{Transport: "sctp"},
+			wantErr:  `unknown transport "sctp", choose one of udp, tcp, both, tls, https, quic or unix`,
+		},
+		{
+			name:     "Unknown view",
+			listener: config.Listener{View: "guest"},
+			wantErr:  "unknown view guest",
+		},
+		{
+			name:     "reuse-port with tcp",
+			listener: config.Listener{Transport: "tcp", ReusePort: 2},
+			wantErr:  "reuse-port is only for the udp transport",
+		},
+		{
+			name:     "reuse-port with passed sockets",
+			listener: config.Listener{Socket: "dns", ReusePort: 2},
+			wantErr:  "reuse-port is only for the udp transport",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			servers, err := listenerServers(base, tt.listener, views, sockets)
+			gotErr := ""
+
+			if err != nil {
+				gotErr = err.Error()
+			}
+
+			if diff := cmp.Diff(tt.wantErr, gotErr); diff != "" {
+				t.Fatalf("listenerServers() error mismatch (-want +got):\n%s", diff)
+			}
+
+			var gotServers []listenerServer
+
+			for _, got := range servers {
+				view := ""
+
+				if len(got.Views) == 1 {
+					view = got.Views[0].Name
+				} else if len(got.Views) != len(views) {
+					t.Errorf("listenerServers() views = %d, want %d", len(got.Views), len(views))
+				}
+
+				gotServers = append(gotServers, listenerServer{
+					Mode:       got.Mode,
+					Host:       got.Host,
+					Port:       got.Port,
+					ReusePort:  got.ReusePort,
+					View:       view,
+					AllowQuery: got.AllowQuery,
+					Socket:     got.Listener != nil || got.PacketConn != nil,
+				})
+			}
+
+			if diff := cmp.Diff(tt.wantServers, gotServers); diff != "" {
+				t.Errorf("listenerServers() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCloseUnusedSockets(t *testing.T) {
+	sockets := testSockets(t)
+
+	closeUnusedSockets([]config.Listener{{Socket: "dns"}, {Address: "127.0.0.1"}}, sockets)
+
+	// The unused unix socket doesn't accept connections
+	if conn, err := net.Dial("unix", sockets[2].Address()); err == nil {
+		conn.Close()
+		t.Errorf("Dial() of the unused socket control succeeded, want it closed")
+	}
+
+	conn, err := net.Dial("tcp", sockets[0].Address())
+
+	if err != nil {
+		t.Fatalf("Dial() of the socket dns error = %v, want it open", err)
+	}
+
+	conn.Close()
+}
+
+func TestSocketListeners(t *testing.T) {
+	got := socketListeners(testSockets(t))
+	want := []config.Listener{{Socket: "dns"}, {Socket: "control"}}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("socketListeners() mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -1,23 +1,53 @@
 package main
 
 import (
+	"crypto/tls"
+	"io"
+	"os"
 	"sync"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/lucasdc6/gdns/internal/activation"
+	"github.com/lucasdc6/gdns/internal/capture"
+	"github.com/lucasdc6/gdns/internal/metrics"
+	"github.com/lucasdc6/gdns/internal/querylog"
+	"github.com/lucasdc6/gdns/internal/ratelimit"
 	"github.com/lucasdc6/gdns/internal/server"
+	"github.com/lucasdc6/gdns/internal/tap"
 	"github.com/lucasdc6/gdns/internal/usage"
+	"github.com/lucasdc6/gdns/pkg/blocklist"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/errors"
+	"github.com/lucasdc6/gdns/pkg/rewrite"
+	"github.com/lucasdc6/gdns/pkg/rpz"
+	"github.com/lucasdc6/gdns/pkg/tsig"
 	"github.com/pborman/getopt/v2"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplay(os.Args[1:])
+		return
+	}
+
 	hostFlag := getopt.StringLong("host", 'h', "127.0.0.1", "Define the udp service host")
 	tcpHostFlag := getopt.StringLong("tcp-host", 0, "127.0.0.1", "Define the tcp service host")
 	portFlag := getopt.IntLong("port", 'p', 3000, "Define the udp service port")
 	tcpPortFlag := getopt.IntLong("tcp-port", 0, 3000, "Define the tcp service port")
+	tlsHostFlag := getopt.StringLong("tls-host", 0, "127.0.0.1", "Define the tls service host")
+	tlsPortFlag := getopt.IntLong("tls-port", 0, 853, "Define the tls service port")
+	httpsHostFlag := getopt.StringLong("https-host", 0, "127.0.0.1", "Define the https service host")
+	httpsPortFlag := getopt.IntLong("https-port", 0, 443, "Define the https service port")
+	quicHostFlag := getopt.StringLong("quic-host", 0, "127.0.0.1", "Define the quic service host")
+	quicPortFlag := getopt.IntLong("quic-port", 0, 853, "Define the quic service port")
+	tlsCertFlag := getopt.StringLong("tls-cert", 0, "", "Define the path to the tls certificate")
+	tlsKeyFlag := getopt.StringLong("tls-key", 0, "", "Define the path to the tls certificate key")
+	tlsClientCAFlag := getopt.StringLong("tls-client-ca", 0, "", "Require tls client certificates signed by this CA")
+	metricsFlag := getopt.StringLong("metrics", 0, "", "Expose the Prometheus metrics at /metrics of this address")
 	fileFlag := getopt.StringLong("file", 'f', "", "Define the path to the configuration file")
 	manFlag := getopt.EnumLong("man", 'm', []string{"file-syntax"}, "", "Show usage for the following modules\n- file-syntax")
-	modeFlag := getopt.EnumLong("mode", 0, []string{"tcp", "udp", "both"}, "udp", "Run the server in udp, tcp or both")
+	modeFlag := getopt.EnumLong("mode", 0, []string{"tcp", "udp", "both", "tls", "https", "quic"}, "udp", "Run the server in udp, tcp, both, tls, https or quic")
 	verboseLevelFlag := getopt.EnumLong("verbose", 'v', []string{"All", "Info"}, "Info", "Set the verbose mode")
 	helpFlag := getopt.BoolLong("help", '?', "Show this help")
 
@@ -35,32 +65,230 @@ func main() {
 		return
 	}
 
-	var wg sync.WaitGroup
+	configuration := config.Load(*fileFlag)
+	keys := tsig.NewKeyring()
+	err := keys.Load(configuration.Keys)
+
+	if err != nil {
+		log.Fatalf("Error loading keys: %s", err)
+		os.Exit(errors.LoadingKeys)
+	}
 
-	if *modeFlag == "udp" || *modeFlag == "both" {
-		serverConfig := server.Server{
-			Host:              *hostFlag,
-			Port:              *portFlag,
-			ConfigurationFile: *fileFlag,
-			Mode:              "udp",
-			WG:                &wg,
-			Verbose:           *verboseLevelFlag,
+	views := []*server.View{}
+	names := map[string]bool{}
+
+	// The default view, without name, answers the clients of no view
+	for _, viewConfig := range append(configuration.Views, config.View{Zones: configuration.Zones}) {
+		if names[viewConfig.Name] {
+			log.Fatalf("Error loading views: duplicated or missing view name '%s'", viewConfig.Name)
+			os.Exit(errors.LoadingViews)
 		}
 
-		serverConfig.WG.Add(1)
+		names[viewConfig.Name] = true
+		view, err := server.NewView(viewConfig, configuration.Global, keys)
 
-		go server.Start(serverConfig)
+		if err != nil {
+			log.Fatalf("Error loading zones: %s", err)
+			os.Exit(errors.LoadingZones)
+		}
+
+		if configuration.Global.DNSSECValidation {
+			view.Validator, err = server.NewValidator(view.Forwarder, configuration.TrustAnchors)
+
+			if err != nil {
+				log.Fatalf("Error loading trust anchors: %s", err)
+				os.Exit(errors.LoadingTrustAnchors)
+			}
+		}
+
+		view.Secondaries.Sync()
+		views = append(views, view)
+	}
+
+	blocked := blocklist.New()
+
+	if configuration.Global.Blocklist != nil {
+		err = blocked.Load(*configuration.Global.Blocklist)
+
+		if err != nil {
+			log.Fatalf("Error loading the blocklist: %s", err)
+			os.Exit(errors.LoadingBlocklist)
+		}
+
+		log.Infof("Blocklist loaded with %d domains", blocked.Size())
+	}
+
+	rewrites := rewrite.New()
+	err = rewrites.Load(configuration.Global.Rewrite)
+
+	if err != nil {
+		log.Fatalf("Error loading the rewrite rules: %s", err)
+		os.Exit(errors.LoadingRewrite)
+	}
+
+	if rewrites.Size() > 0 {
+		log.Infof("Loaded %d rewrite rules", rewrites.Size())
+	}
+
+	policies := rpz.New()
+	err = policies.Load(configuration.Global.ResponsePolicy)
+
+	if err != nil {
+		log.Fatalf("Error loading the response policy zones: %s", err)
+		os.Exit(errors.LoadingResponsePolicy)
+	}
+
+	if policies.Size() > 0 {
+		log.Infof("Loaded %d response policy rules", policies.Size())
+	}
+
+	if *fileFlag != "" {
+		go server.WatchReload(*fileFlag, views, keys, blocked, rewrites, policies)
+	}
+
+	var queryLog *querylog.Logger
+
+	if configuration.Global.QueryLog != nil {
+		queryLog, err = querylog.Open(configuration.Global.QueryLog.File, int64(configuration.Global.QueryLog.MaxSize)<<20, configuration.Global.QueryLog.MaxFiles)
+
+		if err != nil {
+			log.Fatalf("Error opening the query log: %s", err)
+			os.Exit(errors.OpeningQueryLog)
+		}
+	}
+
+	var dnstap *tap.Tap
+
+	if configuration.Global.Dnstap != nil {
+		dnstap, err = tap.New(*configuration.Global.Dnstap)
+
+		if err != nil {
+			log.Fatalf("Error opening the dnstap output: %s", err)
+			os.Exit(errors.OpeningDnstap)
+		}
+
+		for _, view := range views {
+			if view.Validator != nil {
+				view.Validator.Tap = dnstap
+			}
+		}
 	}
 
-	if *modeFlag == "tcp" || *modeFlag == "both" {
-		serverConfig := server.Server{
-			Host:              *tcpHostFlag,
-			Port:              *tcpPortFlag,
-			ConfigurationFile: *fileFlag,
-			Mode:              "tcp",
-			WG:                &wg,
-			Verbose:           *verboseLevelFlag,
+	var packets *capture.Capture
+
+	if configuration.Global.Capture != "" {
+		packets, err = capture.Open(configuration.Global.Capture)
+
+		if err != nil {
+			log.Fatalf("Error opening the capture: %s", err)
+			os.Exit(errors.OpeningCapture)
 		}
+
+		for _, view := range views {
+			if view.Validator != nil {
+				view.Validator.Capture = packets
+			}
+		}
+	}
+
+	var limiter *ratelimit.Limiter
+
+	if configuration.Global.RateLimit != nil {
+		limiter, err = ratelimit.New(*configuration.Global.RateLimit)
+
+		if err != nil {
+			log.Fatalf("Error loading the rate limit: %s", err)
+			os.Exit(errors.LoadingRateLimit)
+		}
+	}
+
+	outputs := []io.Closer{}
+
+	if queryLog != nil {
+		outputs = append(outputs, queryLog)
+	}
+
+	if dnstap != nil {
+		outputs = append(outputs, dnstap)
+	}
+
+	if packets != nil {
+		outputs = append(outputs, packets)
+	}
+
+	go server.WatchShutdown(outputs...)
+
+	if *metricsFlag != "" {
+		go metrics.Serve(*metricsFlag)
+	}
+
+	var wg sync.WaitGroup
+
+	sockets, err := activation.Sockets()
+
+	if err != nil {
+		log.Fatalf("Error loading the sockets of the service manager: %s", err)
+		os.Exit(errors.ActivatingSockets)
+	}
+
+	listeners := configuration.Listeners
+
+	switch {
+	case len(listeners) > 0:
+	case len(sockets) > 0:
+		listeners = socketListeners(sockets)
+	default:
+		hosts := map[string]string{"udp": *hostFlag, "tcp": *tcpHostFlag, "tls": *tlsHostFlag, "https": *httpsHostFlag, "quic": *quicHostFlag}
+		ports := map[string]int{"udp": *portFlag, "tcp": *tcpPortFlag, "tls": *tlsPortFlag, "https": *httpsPortFlag, "quic": *quicPortFlag}
+		listeners = flagListeners(*modeFlag, hosts, ports)
+	}
+
+	closeUnusedSockets(listeners, sockets)
+
+	base := server.Server{
+		ConfigurationFile: *fileFlag,
+		Configuration:     configuration,
+		Views:             views,
+		Keys:              keys,
+		QueryLog:          queryLog,
+		Tap:               dnstap,
+		Capture:           packets,
+		RateLimit:         limiter,
+		Blocklist:         blocked,
+		Rewrite:           rewrites,
+		Policies:          policies,
+		WG:                &wg,
+		Verbose:           *verboseLevelFlag,
+	}
+	servers := []server.Server{}
+
+	for _, listener := range listeners {
+		listenerServers, err := listenerServers(base, listener, views, sockets)
+
+		if err != nil {
+			log.Fatalf("Error loading the listener %s: %s", listenerName(listener), err)
+			os.Exit(errors.LoadingListeners)
+		}
+
+		servers = append(servers, listenerServers...)
+	}
+
+	var tlsConfig *tls.Config
+
+	for _, serverConfig := range servers {
+		if serverConfig.Mode == "tls" || serverConfig.Mode == "https" || serverConfig.Mode == "quic" {
+			if tlsConfig == nil {
+				tlsConfig, err = server.TLSConfig(*tlsCertFlag, *tlsKeyFlag, *tlsClientCAFlag)
+
+				if err != nil {
+					log.Fatalf("Error loading the tls certificate: %s", err)
+					os.Exit(errors.LoadingTLSCertificate)
+				}
+			}
+
+			serverConfig.TLS = tlsConfig
+		}
+
 		serverConfig.WG.Add(1)
 
 		go server.Start(serverConfig)
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/internal/replay"
+	"github.com/lucasdc6/gdns/pkg/errors"
+	"github.com/pborman/getopt/v2"
+)
+
+// runReplay - Run the replay command: send the queries of a pcap capture
+// to a server and report the responses different from the recorded
+// ones
+func runReplay(args []string) {
+	set := getopt.New()
+	set.SetProgram("gdns replay")
+	set.SetParameters("capture.pcap")
+	serverFlag := set.StringLong("server", 's', "127.0.0.1:3000", "Define the address of the server the queries are sent to")
+	portFlag := set.Uint16Long("port", 'p', 0, "Replay only the queries sent to this port")
+	timeoutFlag := set.DurationLong("timeout", 't', 2*time.Second, "Define the time waited for each response")
+	helpFlag := set.BoolLong("help", '?', "Show this help")
+
+	set.Parse(args)
+
+	if *helpFlag || set.NArgs() != 1 {
+		set.PrintUsage(os.Stderr)
+		return
+	}
+
+	exchanges, err := replay.Read(set.Arg(0), *portFlag)
+
+	if err != nil {
+		log.Fatalf("Error reading the capture: %s", err)
+		os.Exit(errors.ReadingCapture)
+	}
+
+	result, err := replay.Replay(exchanges, *serverFlag, *timeoutFlag, os.Stdout)
+
+	if err != nil {
+		log.Fatalf("Error replaying the capture: %s", err)
+		os.Exit(errors.ReplayingCapture)
+	}
+
+	fmt.Printf("%d queries: %d matched, %d mismatched, %d without recorded response, %d without response\n",
+		result.Queries, result.Matched, result.Mismatched, result.Unrecorded, result.Failed)
+
+	if result.Mismatched > 0 || result.Failed > 0 {
+		os.Exit(errors.ReplayMismatch)
+	}
+}
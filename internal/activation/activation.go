@@ -0,0 +1,124 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package activation define the sockets opened by the service manager
+// and passed to the server (systemd socket activation), so it can
+// listen in privileged ports without running as root
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart - First file descriptor of the passed sockets, the
+// ones after stdin, stdout and stderr
+const listenFDsStart = 3
+
+// Socket - Socket passed by the service manager, with a Listener for
+// the stream sockets or a PacketConn for the datagram ones
+type Socket struct {
+	// Name - Name of the socket in LISTEN_FDNAMES, "unknown" without it
+	Name       string
+	Listener   net.Listener
+	PacketConn net.PacketConn
+}
+
+// Network - Network of the address of the socket
+func (socket Socket) Network() string {
+	if socket.Listener != nil {
+		return socket.Listener.Addr().Network()
+	}
+
+	return socket.PacketConn.LocalAddr().Network()
+}
+
+// Address - Address of the socket
+func (socket Socket) Address() string {
+	if socket.Listener != nil {
+		return socket.Listener.Addr().String()
+	}
+
+	return socket.PacketConn.LocalAddr().String()
+}
+
+// Sockets - Sockets passed to the process in LISTEN_FDS, without any
+// when LISTEN_PID is of other process. The variables are removed from
+// the environment, so the children don't take the sockets
+func Sockets() ([]Socket, error) {
+	pid, fds, fdNames := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid == "" || fds == "" || pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(fds)
+
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %s", fds)
+	}
+
+	names := strings.Split(fdNames, ":")
+	sockets := []Socket{}
+
+	for i := 0; i < count; i++ {
+		name := "unknown"
+
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		socket, err := fileSocket(os.NewFile(uintptr(listenFDsStart+i), name))
+
+		if err != nil {
+			return nil, fmt.Errorf("socket %s (file descriptor %d): %w", name, listenFDsStart+i, err)
+		}
+
+		sockets = append(sockets, socket)
+	}
+
+	return sockets, nil
+}
+
+// fileSocket - Socket of a file descriptor: TCP and unix stream sockets
+// are listeners, and UDP sockets packet connections
+func fileSocket(file *os.File) (Socket, error) {
+	defer file.Close()
+
+	socket := Socket{Name: file.Name()}
+	listener, err := net.FileListener(file)
+
+	if err == nil {
+		if network := listener.Addr().Network(); network == "tcp" || network == "unix" {
+			socket.Listener = listener
+
+			return socket, nil
+		}
+
+		listener.Close()
+	}
+
+	conn, err := net.FilePacketConn(file)
+
+	if err != nil {
+		return socket, err
+	}
+
+	if network := conn.LocalAddr().Network(); network != "udp" {
+		conn.Close()
+
+		return socket, fmt.Errorf("unsupported %s socket", network)
+	}
+
+	socket.PacketConn = conn
+
+	return socket, nil
+}
@@ -0,0 +1,208 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package activation define all the tests for the activation package
+package activation
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSockets(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		name        string
+		pid         string
+		fds         string
+		wantSockets []Socket
+		wantErr     string
+	}{
+		{
+			name: "Without variables",
+		},
+		{
+			name: "Without LISTEN_PID",
+			fds:  "2",
+		},
+		{
+			name: "LISTEN_PID of other process",
+			pid:  strconv.Itoa(os.Getpid() + 1),
+			fds:  "2",
+		},
+		{
+			name: "Without LISTEN_FDS",
+			pid:  pid,
+		},
+		{
+			name:        "Without sockets",
+			pid:         pid,
+			fds:         "0",
+			wantSockets: []Socket{},
+		},
+		{
+			name:    "Invalid LISTEN_FDS",
+			pid:     pid,
+			fds:     "two",
+			wantErr: "invalid LISTEN_FDS two",
+		},
+		{
+			name:    "Negative LISTEN_FDS",
+			pid:     pid,
+			fds:     "-1",
+			wantErr: "invalid LISTEN_FDS -1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("LISTEN_PID", tt.pid)
+			t.Setenv("LISTEN_FDS", tt.fds)
+			t.Setenv("LISTEN_FDNAMES", "dns:dns")
+
+			gotSockets, err := Sockets()
+			gotErr := ""
+
+			if err != nil {
+				gotErr = err.Error()
+			}
+
+			if diff := cmp.Diff(tt.wantErr, gotErr); diff != "" {
+				t.Errorf("Sockets() error mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.wantSockets, gotSockets); diff != "" {
+				t.Errorf("Sockets() mismatch (-want +got):\n%s", diff)
+			}
+
+			// The children of the server don't take the sockets
+			for _, variable := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+				if value, ok := os.LookupEnv(variable); ok {
+					t.Errorf("%s = %q after Sockets(), want it removed", variable, value)
+				}
+			}
+		})
+	}
+}
+
+func TestFileSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	// file - File of a new socket, as the ones passed to the server
+	type file func(t *testing.T) *os.File
+
+	tests := []struct {
+		name        string
+		file        file
+		wantNetwork string
+		wantStream  bool
+		wantErr     string
+	}{
+		{
+			name: "TCP listener",
+			file: func(t *testing.T) *os.File {
+				listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+				if err != nil {
+					t.Fatalf("ListenTCP() error = %v", err)
+				}
+
+				defer listener.Close()
+				file, _ := listener.File()
+
+				return file
+			},
+			wantNetwork: "tcp",
+			wantStream:  true,
+		},
+		{
+			name: "UDP socket",
+			file: func(t *testing.T) *os.File {
+				conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+
+				if err != nil {
+					t.Fatalf("ListenUDP() error = %v", err)
+				}
+
+				defer conn.Close()
+				file, _ := conn.File()
+
+				return file
+			},
+			wantNetwork: "udp",
+		},
+		{
+			name: "Unix stream listener",
+			file: func(t *testing.T) *os.File {
+				listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "dns.sock"), Net: "unix"})
+
+				if err != nil {
+					t.Fatalf("ListenUnix() error = %v", err)
+				}
+
+				defer listener.Close()
+				file, _ := listener.File()
+
+				return file
+			},
+			wantNetwork: "unix",
+			wantStream:  true,
+		},
+		{
+			name: "Unix datagram socket",
+			file: func(t *testing.T) *os.File {
+				conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(dir, "dns.dgram"), Net: "unixgram"})
+
+				if err != nil {
+					t.Fatalf("ListenUnixgram() error = %v", err)
+				}
+
+				defer conn.Close()
+				file, _ := conn.File()
+
+				return file
+			},
+			wantErr: "unsupported unixgram socket",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socket, err := fileSocket(tt.file(t))
+			gotErr := ""
+
+			if err != nil {
+				gotErr = err.Error()
+			}
+
+			if diff := cmp.Diff(tt.wantErr, gotErr); diff != "" {
+				t.Fatalf("fileSocket() error mismatch (-want +got):\n%s", diff)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if socket.Listener != nil {
+				defer socket.Listener.Close()
+			} else {
+				defer socket.PacketConn.Close()
+			}
+
+			if got := socket.Network(); got != tt.wantNetwork {
+				t.Errorf("Network() = %s, want %s", got, tt.wantNetwork)
+			}
+
+			if got := socket.Listener != nil; got != tt.wantStream {
+				t.Errorf("fileSocket() listener = %v, want %v", got, tt.wantStream)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package bench define all the tests for the bench package
+package bench_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/internal/bench"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+func TestReadQueries(t *testing.T) {
+	tests := []struct {
+		name        string
+		input       string
+		wantQueries []bench.Query
+		wantErr     string
+	}{
+		{
+			name:        "Empty list",
+			input:       "",
+			wantQueries: []bench.Query{},
+		},
+		{
+			name:  "Names and types",
+			input: "www.example.com A\nexample.com MX\nexample.com aaaa\n",
+			wantQueries: []bench.Query{
+				{Name: "www.example.com", Type: types.A},
+				{Name: "example.com", Type: types.MX},
+				{Name: "example.com", Type: types.AAAA},
+			},
+		},
+		{
+			name:        "Default type",
+			input:       "www.example.com",
+			wantQueries: []bench.Query{{Name: "www.example.com", Type: types.A}},
+		},
+		{
+			name:  "Comments, empty lines and spaces",
+			input: "# queries of example.com\n\n; mail\n  example.com\tMX  \n   \nwww.example.com TXT\n",
+			wantQueries: []bench.Query{
+				{Name: "example.com", Type: types.MX},
+				{Name: "www.example.com", Type: types.TXT},
+			},
+		},
+		{
+			name:    "Unknown type",
+			input:   "www.example.com A\n\nexample.com BOGUS\n",
+			wantErr: "line 3: Name BOGUS not available",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotQueries, err := bench.ReadQueries(strings.NewReader(tt.input))
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Errorf("ReadQueries() error = %v, want it starting with %q", err, tt.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ReadQueries() error = %v", err)
+			}
+
+			if diff := cmp.Diff(tt.wantQueries, gotQueries); diff != "" {
+				t.Errorf("ReadQueries() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	// latencies - Latencies of 1ms to n ms, in order
+	latencies := func(n int) []time.Duration {
+		list := []time.Duration{}
+
+		for i := 1; i <= n; i++ {
+			list = append(list, time.Duration(i)*time.Millisecond)
+		}
+
+		return list
+	}
+
+	tests := []struct {
+		name      string
+		latencies []time.Duration
+		p         float64
+		want      time.Duration
+	}{
+		{
+			name:      "Empty sample",
+			latencies: []time.Duration{},
+			p:         50,
+			want:      0,
+		},
+		{
+			name:      "Single sample, p0",
+			latencies: latencies(1),
+			p:         0,
+			want:      time.Millisecond,
+		},
+		{
+			name:      "Single sample, p50",
+			latencies: latencies(1),
+			p:         50,
+			want:      time.Millisecond,
+		},
+		{
+			name:      "Single sample, p100",
+			latencies: latencies(1),
+			p:         100,
+			want:      time.Millisecond,
+		},
+		{
+			name:      "p50 of an even sample",
+			latencies: latencies(10),
+			p:         50,
+			want:      5 * time.Millisecond,
+		},
+		{
+			name:      "p50 of an odd sample",
+			latencies: latencies(5),
+			p:         50,
+			want:      3 * time.Millisecond,
+		},
+		{
+			name:      "p90",
+			latencies: latencies(100),
+			p:         90,
+			want:      90 * time.Millisecond,
+		},
+		{
+			name:      "p99.9 of a small sample",
+			latencies: latencies(100),
+			p:         99.9,
+			want:      100 * time.Millisecond,
+		},
+		{
+			name:      "p99.9 of a large sample",
+			latencies: latencies(2000),
+			p:         99.9,
+			want:      1998 * time.Millisecond,
+		},
+		{
+			name:      "Below p0",
+			latencies: latencies(10),
+			p:         -10,
+			want:      time.Millisecond,
+		},
+		{
+			name:      "Above p100",
+			latencies: latencies(10),
+			p:         150,
+			want:      10 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := bench.Result{Latencies: tt.latencies}
+
+			if got := result.Percentile(tt.p); got != tt.want {
+				t.Errorf("Percentile(%v) = %s, want %s", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQPS(t *testing.T) {
+	tests := []struct {
+		name   string
+		result bench.Result
+		want   float64
+	}{
+		{
+			name:   "Without elapsed time",
+			result: bench.Result{Completed: 10},
+			want:   0,
+		},
+		{
+			name:   "Completed queries each second",
+			result: bench.Result{Sent: 600, Completed: 500, Elapsed: 2 * time.Second},
+			want:   250,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.result.QPS(); got != tt.want {
+				t.Errorf("QPS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
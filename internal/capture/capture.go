@@ -0,0 +1,101 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package capture define the pcap capture of the messages received
+// and sent by the server
+package capture
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/pcap"
+)
+
+// Capture - Writer of the messages to a pcap file, as UDP datagrams
+// whatever their transport. The methods of a nil Capture do nothing
+type Capture struct {
+	file   *os.File
+	writer *pcap.Writer
+	mutex  sync.Mutex
+}
+
+// Open - Create the pcap file of path, replacing the previous one
+func Open(path string) (*Capture, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := pcap.NewWriter(file)
+
+	if err != nil {
+		file.Close()
+
+		return nil, err
+	}
+
+	return &Capture{file: file, writer: writer}, nil
+}
+
+// Write - Write a message sent from source to destination at a time.
+// The addresses that aren't an IP and port, like the unknown local
+// address of the queries to the forwarder, are written as unspecified
+// with the port 0
+func (capture *Capture) Write(source, destination string, message []byte, at time.Time) {
+	if capture == nil {
+		return
+	}
+
+	packet := pcap.Packet{
+		Time:        at,
+		Source:      addressPort(source),
+		Destination: addressPort(destination),
+		Payload:     message,
+	}
+
+	capture.mutex.Lock()
+	defer capture.mutex.Unlock()
+
+	if err := capture.writer.WritePacket(packet); err != nil {
+		log.Errorf("Error writing the capture: %s", err)
+	}
+}
+
+// Close - Close the pcap file
+func (capture *Capture) Close() error {
+	capture.mutex.Lock()
+	defer capture.mutex.Unlock()
+
+	return capture.file.Close()
+}
+
+// addressPort - IP and port of an address. The listeners in every
+// address, as ":53", keep their port with the unspecified address
+func addressPort(address string) netip.AddrPort {
+	if addressPort, err := netip.ParseAddrPort(address); err == nil {
+		return addressPort
+	}
+
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil || host != "" {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	}
+
+	number, err := strconv.ParseUint(port, 10, 16)
+
+	if err != nil {
+		return netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	}
+
+	return netip.AddrPortFrom(netip.IPv4Unspecified(), uint16(number))
+}
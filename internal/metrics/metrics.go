@@ -0,0 +1,114 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package metrics define the Prometheus metrics of the server
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+var (
+	// Queries - Queries answered, by transport, type, opcode and
+	// response code
+	Queries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gdns_queries_total",
+		Help: "Queries answered, by transport, qtype, opcode and rcode.",
+	}, []string{"transport", "qtype", "opcode", "rcode"})
+
+	// ParseFailures - Messages received that couldn't be parsed
+	ParseFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gdns_parse_failures_total",
+		Help: "Messages received that couldn't be parsed, by transport.",
+	}, []string{"transport"})
+
+	// UpstreamDuration - Time waited for the responses of the
+	// forwarder, primaries and the servers notified
+	UpstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gdns_upstream_duration_seconds",
+		Help:    "Time waited for the responses of upstream servers, by server and transport.",
+		Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"upstream", "transport"})
+
+	// CacheLookups - Lookups of the caches, by cache and result
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gdns_cache_lookups_total",
+		Help: "Lookups of the caches, by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	// Connections - Connections open, by transport
+	Connections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gdns_connections",
+		Help: "Connections open, by transport.",
+	}, []string{"transport"})
+
+	// RateLimited - Responses of the UDP listener over the rate limit,
+	// by action
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gdns_rate_limited_responses_total",
+		Help: "Responses over the rate limit, by action (dropped or slipped).",
+	}, []string{"action"})
+
+	// UDPWorkers - Queries of the UDP listeners being answered, by
+	// listener
+	UDPWorkers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gdns_udp_workers_busy",
+		Help: "Queries of the UDP listeners being answered, by listener.",
+	}, []string{"listener"})
+
+	// UDPSaturated - Packets of the UDP listeners waiting for a free
+	// worker, by listener
+	UDPSaturated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gdns_udp_saturated_total",
+		Help: "Packets of the UDP listeners waiting for a free worker, by listener.",
+	}, []string{"listener"})
+
+	// BlocklistHits - Queries blocked, by list
+	BlocklistHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gdns_blocklist_hits_total",
+		Help: "Queries blocked, by list.",
+	}, []string{"list"})
+)
+
+// ObserveQuery - Count a query answered with rcode
+func ObserveQuery(transport string, question types.DNSQuestion, opcode types.OpCode, rcode types.RCode) {
+	Queries.WithLabelValues(transport, question.Type.Name, opcode.Name, rcode.Name).Inc()
+}
+
+// ObserveUpstream - Record the time waited for an upstream server
+// since start
+func ObserveUpstream(upstream, transport string, start time.Time) {
+	UpstreamDuration.WithLabelValues(upstream, transport).Observe(time.Since(start).Seconds())
+}
+
+// ObserveCache - Count a lookup of a cache
+func ObserveCache(cache string, hit bool) {
+	result := "miss"
+
+	if hit {
+		result = "hit"
+	}
+
+	CacheLookups.WithLabelValues(cache, result).Inc()
+}
+
+// Serve - Expose the metrics at /metrics of address
+func Serve(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("Metrics server started at %s", address)
+
+	if err := http.ListenAndServe(address, mux); err != nil {
+		log.Errorf("Error serving the metrics at %s: %s", address, err)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package querylog define the log of the queries answered, with a
+// JSON line for each query
+package querylog
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sources of the responses
+const (
+	// Local - Response built by the server, from its zones or an error
+	Local = "local"
+	// Forwarded - Response received from the forwarder
+	Forwarded = "forwarded"
+	// Blocked - Response built by the server for a query blocked by a
+	// blocklist
+	Blocked = "blocked"
+	// Policy - Response built by the server with the action of a
+	// response policy zone
+	Policy = "policy"
+)
+
+// defaultMaxFiles - Number of rotated files kept when it isn't set
+const defaultMaxFiles = 5
+
+// Entry - Line of the query log
+type Entry struct {
+	Time      time.Time `json:"time"`
+	Client    string    `json:"client"`
+	Port      int       `json:"port"`
+	Transport string    `json:"transport"`
+	ID        uint16    `json:"id"`
+	Name      string    `json:"qname"`
+	Type      string    `json:"qtype"`
+	Class     string    `json:"qclass"`
+	Flags     []string  `json:"flags"`
+	RCode     string    `json:"rcode"`
+	Answers   int       `json:"answers"`
+	Size      int       `json:"size"`
+	Latency   float64   `json:"latency_ms"`
+	Source    string    `json:"source"`
+	// Policy - Rule of a response policy zone matching the query, nil
+	// when none matches
+	Policy *PolicyMatch `json:"policy,omitempty"`
+}
+
+// PolicyMatch - Rule of a response policy zone matching a query
+type PolicyMatch struct {
+	Zone    string `json:"zone"`
+	Trigger string `json:"trigger"`
+	Rule    string `json:"rule"`
+	Action  string `json:"action"`
+}
+
+// Logger - Writer of the query log to a file, which is rotated when it
+// reaches its maximum size: the file is renamed with the suffix .1,
+// and the previous ones with the next suffix, up to maxFiles
+type Logger struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+	mutex    sync.Mutex
+}
+
+// Open - Open the query log of path, appending to it. A maxSize of 0
+// disables the rotation
+func Open(path string, maxSize int64, maxFiles int) (*Logger, error) {
+	if maxFiles <= 0 {
+		maxFiles = defaultMaxFiles
+	}
+
+	logger := &Logger{path: path, maxSize: maxSize, maxFiles: maxFiles}
+
+	return logger, logger.open()
+}
+
+// open - Open the file of the log, keeping its current size
+func (logger *Logger) open() error {
+	file, err := os.OpenFile(logger.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+
+	if err != nil {
+		file.Close()
+
+		return err
+	}
+
+	logger.file, logger.size = file, info.Size()
+
+	return nil
+}
+
+// Log - Write an entry in the log, rotating the file before when the
+// entry doesn't fit in it
+func (logger *Logger) Log(entry Entry) error {
+	line, err := json.Marshal(entry)
+
+	if err != nil {
+		return err
+	}
+
+	line = append(line, '\n')
+
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	var rotateErr error
+
+	if logger.maxSize > 0 && logger.size > 0 && logger.size+int64(len(line)) > logger.maxSize {
+		// When the rotation fails the entry is written to the current
+		// file, reopened by rotate
+		rotateErr = logger.rotate()
+	}
+
+	n, err := logger.file.Write(line)
+	logger.size += int64(n)
+
+	if rotateErr != nil {
+		return rotateErr
+	}
+
+	return err
+}
+
+// rotate - Rename the files of the log and open a new one. When the
+// current file can't be renamed, it's opened again
+func (logger *Logger) rotate() error {
+	logger.file.Close()
+
+	for i := logger.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", logger.path, i), fmt.Sprintf("%s.%d", logger.path, i+1))
+	}
+
+	if err := os.Rename(logger.path, logger.path+".1"); err != nil {
+		logger.open()
+
+		return err
+	}
+
+	return logger.open()
+}
+
+// Close - Close the file of the log
+func (logger *Logger) Close() error {
+	logger.mutex.Lock()
+	defer logger.mutex.Unlock()
+
+	return logger.file.Close()
+}
@@ -0,0 +1,216 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package querylog define all the tests for the querylog package
+package querylog_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/internal/querylog"
+)
+
+// readIDs - Files of the directory of a log, with the IDs of the
+// entries of each one
+func readIDs(t *testing.T, dir string) map[string][]uint16 {
+	files, err := os.ReadDir(dir)
+
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+
+	ids := map[string][]uint16{}
+
+	for _, file := range files {
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+
+		ids[file.Name()] = []uint16{}
+
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			entry := querylog.Entry{}
+
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatalf("Unmarshal() of %s error = %v", file.Name(), err)
+			}
+
+			ids[file.Name()] = append(ids[file.Name()], entry.ID)
+		}
+	}
+
+	return ids
+}
+
+func TestRotation(t *testing.T) {
+	entry := querylog.Entry{Client: "192.0.2.1", Transport: "udp", Name: "www.example.com", Type: "A", Class: "IN", Flags: []string{"rd"}, RCode: "NoError", Source: querylog.Local}
+	line, _ := json.Marshal(entry)
+	// Room for two entries in each file
+	twoEntries := int64(2*(len(line)+1) + 4)
+
+	tests := []struct {
+		name     string
+		maxSize  int64
+		maxFiles int
+		entries  int
+		want     map[string][]uint16
+	}{
+		{
+			name:     "Without rotation",
+			maxSize:  0,
+			maxFiles: 2,
+			entries:  5,
+			want:     map[string][]uint16{"queries.log": {0, 1, 2, 3, 4}},
+		},
+		{
+			name:     "Rotated files pruned to maxFiles",
+			maxSize:  twoEntries,
+			maxFiles: 2,
+			entries:  9,
+			want: map[string][]uint16{
+				"queries.log":   {8},
+				"queries.log.1": {6, 7},
+				"queries.log.2": {4, 5},
+			},
+		},
+		{
+			name:     "Default maxFiles",
+			maxSize:  twoEntries,
+			maxFiles: 0,
+			entries:  14,
+			want: map[string][]uint16{
+				"queries.log":   {12, 13},
+				"queries.log.1": {10, 11},
+				"queries.log.2": {8, 9},
+				"queries.log.3": {6, 7},
+				"queries.log.4": {4, 5},
+				"queries.log.5": {2, 3},
+			},
+		},
+		{
+			name:     "Entries larger than the maximum size",
+			maxSize:  1,
+			maxFiles: 1,
+			entries:  3,
+			want: map[string][]uint16{
+				"queries.log":   {2},
+				"queries.log.1": {1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			logger, err := querylog.Open(filepath.Join(dir, "queries.log"), tt.maxSize, tt.maxFiles)
+
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+
+			for i := 0; i < tt.entries; i++ {
+				entry.ID = uint16(i)
+
+				if err := logger.Log(entry); err != nil {
+					t.Fatalf("Log() error = %v", err)
+				}
+			}
+
+			logger.Close()
+
+			if diff := cmp.Diff(tt.want, readIDs(t, dir)); diff != "" {
+				t.Errorf("files mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.log")
+	entry := querylog.Entry{Name: "www.example.com"}
+	line, _ := json.Marshal(entry)
+	maxSize := int64(2*(len(line)+1) + 4)
+
+	// The size of the existing file counts for the rotation
+	for i := 0; i < 3; i++ {
+		logger, err := querylog.Open(path, maxSize, 3)
+
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+
+		entry.ID = uint16(i)
+		logger.Log(entry)
+		logger.Close()
+	}
+
+	got := readIDs(t, dir)
+	names := []string{}
+
+	for name := range got {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if diff := cmp.Diff([]string{"queries.log", "queries.log.1"}, names); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]uint16{2}, got["queries.log"]); diff != "" {
+		t.Errorf("entries mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestRotationError(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "queries.log")
+	entry := querylog.Entry{Name: "www.example.com"}
+
+	// A directory that isn't empty can't be replaced by the log
+	os.MkdirAll(filepath.Join(path+".1", "entries"), 0755)
+
+	logger, err := querylog.Open(path, 1, 1)
+
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	defer logger.Close()
+
+	for i := 0; i < 3; i++ {
+		entry.ID = uint16(i)
+		err := logger.Log(entry)
+
+		if (err != nil) != (i > 0) {
+			t.Errorf("Log() of entry %d error = %v, want the error of the rotation", i, err)
+		}
+	}
+
+	// The log keeps being written, and rotated once it can
+	os.RemoveAll(path + ".1")
+	entry.ID = 3
+
+	if err := logger.Log(entry); err != nil {
+		t.Fatalf("Log() error = %v", err)
+	}
+
+	want := map[string][]uint16{
+		"queries.log":   {3},
+		"queries.log.1": {0, 1, 2},
+	}
+
+	if diff := cmp.Diff(want, readIDs(t, dir)); diff != "" {
+		t.Errorf("files mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,249 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ratelimit define the response rate limiting of the UDP
+// listener, with a token bucket for each client prefix and response
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+const (
+	// defaultSlip - Responses over the limit for each one sent
+	// truncated, when it isn't set
+	defaultSlip = 2
+	// defaultIPv4PrefixLength - Length of the IPv4 client prefixes
+	// when it isn't set
+	defaultIPv4PrefixLength = 24
+	// defaultIPv6PrefixLength - Length of the IPv6 client prefixes
+	// when it isn't set
+	defaultIPv6PrefixLength = 56
+	// sweepInterval - Time between the removals of the full buckets
+	sweepInterval = time.Minute
+)
+
+// Action - What to do with a response
+type Action int
+
+// Actions of the responses
+const (
+	// Send - Send the response
+	Send Action = iota
+	// Drop - Don't send the response
+	Drop
+	// Slip - Send the response truncated, without records, so the
+	// legitimate clients retry over TCP
+	Slip
+)
+
+// Limiter - Response rate limiter, keeping a token bucket for each
+// client prefix and identical response. The methods of a nil Limiter
+// send every response
+type Limiter struct {
+	rate       float64
+	burst      float64
+	slip       int
+	ipv4Length int
+	ipv6Length int
+	exempt     []netip.Prefix
+	buckets    map[key]*bucket
+	swept      time.Time
+	// now - Clock of the buckets
+	now   func() time.Time
+	mutex sync.Mutex
+}
+
+// key - Client prefix and response sharing a bucket
+type key struct {
+	prefix netip.Prefix
+	rcode  int
+	name   string
+	qtype  int
+}
+
+// bucket - Tokens of a key, refilled at the rate of the limiter
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// limited - Responses over the limit, counting the slip
+	limited int
+}
+
+// New - Generate a limiter with the rate limit configuration
+func New(configuration config.RateLimit) (*Limiter, error) {
+	if configuration.ResponsesPerSecond <= 0 {
+		return nil, fmt.Errorf("rate-limit without responses-per-second")
+	}
+
+	limiter := &Limiter{
+		rate:       configuration.ResponsesPerSecond,
+		burst:      float64(configuration.Burst),
+		slip:       defaultSlip,
+		ipv4Length: defaultIPv4PrefixLength,
+		ipv6Length: defaultIPv6PrefixLength,
+		buckets:    map[key]*bucket{},
+		swept:      time.Now(),
+		now:        time.Now,
+	}
+
+	if limiter.burst < limiter.rate {
+		limiter.burst = limiter.rate
+	}
+
+	if configuration.Slip != nil {
+		limiter.slip = *configuration.Slip
+	}
+
+	if configuration.IPv4PrefixLength != 0 {
+		limiter.ipv4Length = configuration.IPv4PrefixLength
+	}
+
+	if configuration.IPv6PrefixLength != 0 {
+		limiter.ipv6Length = configuration.IPv6PrefixLength
+	}
+
+	if limiter.slip < 0 {
+		return nil, fmt.Errorf("invalid slip %d in rate-limit, it can't be negative", limiter.slip)
+	}
+
+	if limiter.ipv4Length < 1 || limiter.ipv4Length > 32 {
+		return nil, fmt.Errorf("invalid ipv4-prefix-length %d in rate-limit, choose one from 1 to 32", limiter.ipv4Length)
+	}
+
+	if limiter.ipv6Length < 1 || limiter.ipv6Length > 128 {
+		return nil, fmt.Errorf("invalid ipv6-prefix-length %d in rate-limit, choose one from 1 to 128", limiter.ipv6Length)
+	}
+
+	for _, cidr := range configuration.Exempt {
+		prefix, err := netip.ParsePrefix(cidr)
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid exempt network: %w", err)
+		}
+
+		limiter.exempt = append(limiter.exempt, prefix.Masked())
+	}
+
+	return limiter, nil
+}
+
+// Limit - Take a token of the bucket of the response to client,
+// returning whether it's sent, dropped or sent truncated
+func (limiter *Limiter) Limit(client net.IP, response types.DNSMessage) Action {
+	if limiter == nil {
+		return Send
+	}
+
+	address, ok := netip.AddrFromSlice(client)
+
+	if !ok {
+		return Send
+	}
+
+	address = address.Unmap()
+
+	for _, prefix := range limiter.exempt {
+		if prefix.Contains(address) {
+			return Send
+		}
+	}
+
+	now := limiter.now()
+	k := limiter.key(address, response)
+
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+
+	if now.Sub(limiter.swept) > sweepInterval {
+		limiter.sweep(now)
+	}
+
+	b, ok := limiter.buckets[k]
+
+	if !ok {
+		b = &bucket{tokens: limiter.burst, updated: now}
+		limiter.buckets[k] = b
+	}
+
+	b.tokens = limiter.refill(b, now)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+
+		return Send
+	}
+
+	b.limited++
+
+	if limiter.slip > 0 && b.limited%limiter.slip == 0 {
+		return Slip
+	}
+
+	return Drop
+}
+
+// key - Bucket of a response to a client address. The name errors
+// share the bucket of their zone, and the other errors the bucket of
+// their response code
+func (limiter *Limiter) key(address netip.Addr, response types.DNSMessage) key {
+	length := limiter.ipv6Length
+
+	if address.Is4() {
+		length = limiter.ipv4Length
+	}
+
+	prefix, _ := address.Prefix(length)
+	k := key{prefix: prefix, rcode: response.Header.RCode.Code}
+
+	switch {
+	case response.Header.RCode.Code == types.NXDomain.Code:
+		for _, resource := range response.Authority {
+			if resource.Type.Code == types.SOA.Code {
+				k.name = strings.ToLower(resource.Name)
+			}
+		}
+
+		if k.name == "" && len(response.Questions) > 0 {
+			k.name = strings.ToLower(response.Questions[0].Name)
+		}
+	case response.Header.RCode.Code == types.NoError.Code && len(response.Questions) > 0:
+		k.name = strings.ToLower(response.Questions[0].Name)
+		k.qtype = response.Questions[0].Type.Code
+	}
+
+	return k
+}
+
+// refill - Tokens of a bucket at a time
+func (limiter *Limiter) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.updated).Seconds()*limiter.rate
+
+	if tokens > limiter.burst {
+		return limiter.burst
+	}
+
+	return tokens
+}
+
+// sweep - Remove the buckets refilled up to the burst, which are the
+// same as new ones
+func (limiter *Limiter) sweep(now time.Time) {
+	for k, b := range limiter.buckets {
+		if limiter.refill(b, now) >= limiter.burst {
+			delete(limiter.buckets, k)
+		}
+	}
+
+	limiter.swept = now
+}
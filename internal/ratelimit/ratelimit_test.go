@@ -0,0 +1,204 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package ratelimit define all the tests for the ratelimit package
+package ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+func TestNew(t *testing.T) {
+	slip := func(value int) *int { return &value }
+
+	tests := []struct {
+		name    string
+		config  config.RateLimit
+		wantErr string
+	}{
+		{
+			name:   "Defaults",
+			config: config.RateLimit{ResponsesPerSecond: 5},
+		},
+		{
+			name:   "Every option",
+			config: config.RateLimit{ResponsesPerSecond: 5, Burst: 10, Slip: slip(0), IPv4PrefixLength: 32, IPv6PrefixLength: 128, Exempt: []string{"10.0.0.0/8"}},
+		},
+		{
+			name:    "Without responses-per-second",
+			config:  config.RateLimit{},
+			wantErr: "rate-limit without responses-per-second",
+		},
+		{
+			name:    "Negative slip",
+			config:  config.RateLimit{ResponsesPerSecond: 5, Slip: slip(-1)},
+			wantErr: "invalid slip -1 in rate-limit, it can't be negative",
+		},
+		{
+			name:    "Negative ipv4-prefix-length",
+			config:  config.RateLimit{ResponsesPerSecond: 5, IPv4PrefixLength: -8},
+			wantErr: "invalid ipv4-prefix-length -8 in rate-limit, choose one from 1 to 32",
+		},
+		{
+			name:    "Too long ipv4-prefix-length",
+			config:  config.RateLimit{ResponsesPerSecond: 5, IPv4PrefixLength: 33},
+			wantErr: "invalid ipv4-prefix-length 33 in rate-limit, choose one from 1 to 32",
+		},
+		{
+			name:    "Negative ipv6-prefix-length",
+			config:  config.RateLimit{ResponsesPerSecond: 5, IPv6PrefixLength: -1},
+			wantErr: "invalid ipv6-prefix-length -1 in rate-limit, choose one from 1 to 128",
+		},
+		{
+			name:    "Too long ipv6-prefix-length",
+			config:  config.RateLimit{ResponsesPerSecond: 5, IPv6PrefixLength: 129},
+			wantErr: "invalid ipv6-prefix-length 129 in rate-limit, choose one from 1 to 128",
+		},
+		{
+			name:    "Invalid exempt network",
+			config:  config.RateLimit{ResponsesPerSecond: 5, Exempt: []string{"10.0.0.1"}},
+			wantErr: `invalid exempt network: netip.ParsePrefix("10.0.0.1"): no '/'`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.config)
+			gotErr := ""
+
+			if err != nil {
+				gotErr = err.Error()
+			}
+
+			if diff := cmp.Diff(tt.wantErr, gotErr); diff != "" {
+				t.Errorf("New() error mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLimit(t *testing.T) {
+	slip := func(value int) *int { return &value }
+	response := types.DNSMessage{
+		Header:    types.DNSHeader{RCode: types.NoError},
+		Questions: []types.DNSQuestion{{Name: "www.example.com", Type: types.A, Class: types.IN}},
+	}
+
+	// step - Response to a client, after waiting since the previous one
+	type step struct {
+		wait   time.Duration
+		client string
+		want   Action
+	}
+
+	tests := []struct {
+		name   string
+		config config.RateLimit
+		steps  []step
+	}{
+		{
+			name:   "Burst, then slip every second response over the limit",
+			config: config.RateLimit{ResponsesPerSecond: 1, Burst: 2},
+			steps: []step{
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Drop},
+				{0, "192.0.2.1", Slip},
+				{0, "192.0.2.1", Drop},
+			},
+		},
+		{
+			name:   "Refill at the rate, up to the burst",
+			config: config.RateLimit{ResponsesPerSecond: 2, Burst: 2},
+			steps: []step{
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Drop},
+				{500 * time.Millisecond, "192.0.2.1", Send},
+				{0, "192.0.2.1", Slip},
+				{time.Minute, "192.0.2.1", Send},
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Drop},
+			},
+		},
+		{
+			name:   "Drop every response over the limit without slip",
+			config: config.RateLimit{ResponsesPerSecond: 1, Slip: slip(0)},
+			steps: []step{
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Drop},
+				{0, "192.0.2.1", Drop},
+				{0, "192.0.2.1", Drop},
+			},
+		},
+		{
+			name:   "Slip every response over the limit",
+			config: config.RateLimit{ResponsesPerSecond: 1, Slip: slip(1)},
+			steps: []step{
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Slip},
+				{0, "192.0.2.1", Slip},
+			},
+		},
+		{
+			name:   "Clients of a prefix share the bucket",
+			config: config.RateLimit{ResponsesPerSecond: 1, Slip: slip(0)},
+			steps: []step{
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.200", Drop},
+				{0, "198.51.100.1", Send},
+				{0, "2001:db8:0:1::1", Send},
+				{0, "2001:db8:0:2::1", Drop},
+			},
+		},
+		{
+			name:   "Exempt networks",
+			config: config.RateLimit{ResponsesPerSecond: 1, Slip: slip(0), Exempt: []string{"192.0.2.0/28", "2001:db8::/32"}},
+			steps: []step{
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Send},
+				{0, "192.0.2.1", Send},
+				{0, "2001:db8::1", Send},
+				{0, "2001:db8::1", Send},
+				{0, "192.0.2.200", Send},
+				{0, "192.0.2.201", Drop},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, err := New(tt.config)
+
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			now := time.Now()
+			limiter.now = func() time.Time { return now }
+
+			for i, step := range tt.steps {
+				now = now.Add(step.wait)
+
+				if got := limiter.Limit(net.ParseIP(step.client), response); got != step.want {
+					t.Errorf("Limit() of step %d = %v, want %v", i, got, step.want)
+				}
+			}
+		})
+	}
+}
+
+func TestNilLimiter(t *testing.T) {
+	var limiter *Limiter
+
+	if got := limiter.Limit(net.ParseIP("192.0.2.1"), types.DNSMessage{}); got != Send {
+		t.Errorf("Limit() = %v, want %v", got, Send)
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package replay define the replay of the queries of a pcap capture
+// against a server, comparing its responses with the recorded ones
+package replay
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/pcap"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// Exchange - Query of a capture and the first response recorded for
+// it, nil when the capture doesn't have it
+type Exchange struct {
+	Client   netip.AddrPort
+	Server   netip.AddrPort
+	Query    []byte
+	Response []byte
+}
+
+// Result - Summary of a replay
+type Result struct {
+	// Queries - Queries sent
+	Queries int
+	// Matched - Responses equal to the recorded ones
+	Matched int
+	// Mismatched - Responses different from the recorded ones
+	Mismatched int
+	// Unrecorded - Queries without recorded response, sent but not
+	// compared
+	Unrecorded int
+	// Failed - Queries without response from the server
+	Failed int
+}
+
+// exchangeKey - Client, server and message ID pairing a query with its
+// response
+type exchangeKey struct {
+	client netip.AddrPort
+	server netip.AddrPort
+	id     uint16
+}
+
+// Read - Read the exchanges of the capture of path, in the order of
+// their queries. With a port other than 0, only the queries sent to
+// that port are read. The queries from the unspecified address and
+// port 0 are the copies sent to the forwarder by the server, and they
+// are skipped
+func Read(path string, port uint16) ([]*Exchange, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	reader, err := pcap.NewReader(file)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exchanges := []*Exchange{}
+	pending := map[exchangeKey]*Exchange{}
+
+	for {
+		packet, err := reader.Next()
+
+		if err == io.EOF {
+			return exchanges, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		message := packet.Payload
+
+		// Datagrams too short for a DNS header
+		if len(message) < 12 {
+			continue
+		}
+
+		id := binary.BigEndian.Uint16(message)
+
+		if message[2]&0x80 == 0 {
+			if (port != 0 && packet.Destination.Port() != port) || forwarded(packet.Source) {
+				continue
+			}
+
+			exchange := &Exchange{Client: packet.Source, Server: packet.Destination, Query: message}
+			exchanges = append(exchanges, exchange)
+			pending[exchangeKey{packet.Source, packet.Destination, id}] = exchange
+
+			continue
+		}
+
+		key := exchangeKey{packet.Destination, packet.Source, id}
+
+		if exchange, ok := pending[key]; ok {
+			exchange.Response = message
+			delete(pending, key)
+		}
+	}
+}
+
+// forwarded - Check if the source of a query is the one of the queries
+// sent to the forwarder, unknown in the capture of the server
+func forwarded(source netip.AddrPort) bool {
+	return source.Addr().IsUnspecified() && source.Port() == 0
+}
+
+// Replay - Send the queries of the exchanges to the server over UDP,
+// waiting timeout for each response, and report the responses
+// different from the recorded ones to output
+func Replay(exchanges []*Exchange, server string, timeout time.Duration, output io.Writer) (Result, error) {
+	result := Result{}
+	conn, err := net.Dial("udp", server)
+
+	if err != nil {
+		return result, err
+	}
+
+	defer conn.Close()
+
+	buffer := make([]byte, 65535)
+
+	for _, exchange := range exchanges {
+		result.Queries++
+
+		response, err := exchangeUDP(conn, buffer, exchange.Query, timeout)
+
+		if err != nil {
+			result.Failed++
+			fmt.Fprintf(output, "%s: %s\n", describe(exchange), err)
+
+			continue
+		}
+
+		if exchange.Response == nil {
+			result.Unrecorded++
+
+			continue
+		}
+
+		if differences := Compare(exchange.Response, response); len(differences) > 0 {
+			result.Mismatched++
+			fmt.Fprintf(output, "%s:\n  %s\n", describe(exchange), strings.Join(differences, "\n  "))
+
+			continue
+		}
+
+		result.Matched++
+	}
+
+	return result, nil
+}
+
+// exchangeUDP - Send a query and read its response, skipping the
+// late responses of the previous queries
+func exchangeUDP(conn net.Conn, buffer, query []byte, timeout time.Duration) ([]byte, error) {
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+
+	for {
+		n, err := conn.Read(buffer)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if n >= 2 && binary.BigEndian.Uint16(buffer) == binary.BigEndian.Uint16(query) {
+			return append([]byte{}, buffer[:n]...), nil
+		}
+	}
+}
+
+// describe - Question and client of the query of an exchange
+func describe(exchange *Exchange) string {
+	query, err := parser.ParseDNSMessage(exchange.Query)
+
+	if err != nil || len(query.Questions) == 0 {
+		return fmt.Sprintf("query %d from %s", binary.BigEndian.Uint16(exchange.Query), exchange.Client)
+	}
+
+	question := query.Questions[0]
+
+	return fmt.Sprintf("%s %s %s from %s", question.Name, question.Class.Name, question.Type.Name, exchange.Client)
+}
+
+// Compare - Differences between a recorded response and the one
+// received: the response code, the AA and TC bits, and the records of
+// each section, ignoring their TTLs and order. The OPT and TSIG
+// records aren't compared
+func Compare(recorded, received []byte) []string {
+	want, err := parser.ParseDNSMessage(recorded)
+
+	if err != nil {
+		return compareRaw(recorded, received)
+	}
+
+	got, err := parser.ParseDNSMessage(received)
+
+	if err != nil {
+		return []string{fmt.Sprintf("response can't be parsed: %s", err)}
+	}
+
+	differences := []string{}
+
+	if want.Header.RCode.Code != got.Header.RCode.Code {
+		differences = append(differences, fmt.Sprintf("rcode: %s, got %s", want.Header.RCode.Name, got.Header.RCode.Name))
+	}
+
+	if want.Header.AuthoritativeAnswer != got.Header.AuthoritativeAnswer {
+		differences = append(differences, fmt.Sprintf("aa: %t, got %t", want.Header.AuthoritativeAnswer, got.Header.AuthoritativeAnswer))
+	}
+
+	if want.Header.TruncatedMessage != got.Header.TruncatedMessage {
+		differences = append(differences, fmt.Sprintf("tc: %t, got %t", want.Header.TruncatedMessage, got.Header.TruncatedMessage))
+	}
+
+	differences = append(differences, compareRecords("answer", want.Answers, got.Answers)...)
+	differences = append(differences, compareRecords("authority", want.Authority, got.Authority)...)
+	differences = append(differences, compareRecords("additional", want.Additional, got.Additional)...)
+
+	return differences
+}
+
+// compareRaw - Differences between responses that can't be parsed,
+// apart from their ID
+func compareRaw(recorded, received []byte) []string {
+	if len(recorded) == len(received) && string(recorded[2:]) == string(received[2:]) {
+		return nil
+	}
+
+	return []string{"response differs from the recorded one"}
+}
+
+// compareRecords - Records of a section missing (-) or unexpected (+)
+// in the received response
+func compareRecords(section string, want, got []types.DNSResource) []string {
+	counts := map[string]int{}
+
+	for _, record := range records(want) {
+		counts[record]++
+	}
+
+	for _, record := range records(got) {
+		counts[record]--
+	}
+
+	names := []string{}
+
+	for record := range counts {
+		names = append(names, record)
+	}
+
+	sort.Strings(names)
+	differences := []string{}
+
+	for _, record := range names {
+		for count := counts[record]; count > 0; count-- {
+			differences = append(differences, fmt.Sprintf("%s: - %s", section, record))
+		}
+
+		for count := counts[record]; count < 0; count++ {
+			differences = append(differences, fmt.Sprintf("%s: + %s", section, record))
+		}
+	}
+
+	return differences
+}
+
+// records - Presentation format of the records of a section without
+// TTL, skipping the OPT and TSIG records
+func records(resources []types.DNSResource) []string {
+	result := []string{}
+
+	for _, resource := range resources {
+		if resource.Type.Code == types.OPT.Code || resource.Type.Code == types.TSIG.Code {
+			continue
+		}
+
+		result = append(result, fmt.Sprintf("%s %s %s %s", resource.Name, resource.Class.Name, resource.Type.Name, resource.RData))
+	}
+
+	return result
+}
@@ -0,0 +1,275 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package replay define all the tests for the replay package
+package replay_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/internal/capture"
+	"github.com/lucasdc6/gdns/internal/replay"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// compareAddresses - Compare the addresses of the exchanges by value
+var compareAddresses = cmp.Comparer(func(a, b netip.AddrPort) bool { return a == b })
+
+// record - A record of www.example.com with the address and TTL
+func record(address string, ttl int32) types.DNSResource {
+	return types.DNSResource{Name: "www.example.com", Type: types.A, Class: types.IN, TTL: ttl, RData: address}
+}
+
+// build - Message of www.example.com A with the ID, the QR bit when it
+// has a response code, and the answers
+func build(t *testing.T, id uint16, rcode *types.RCode, answers ...types.DNSResource) []byte {
+	message := types.DNSMessage{
+		Header:     types.DNSHeader{Identifier: id, OpCode: types.Query, RecursionDesired: true, RCode: types.NoError},
+		Questions:  []types.DNSQuestion{{Name: "www.example.com", Type: types.A, Class: types.IN}},
+		Answers:    answers,
+		Authority:  []types.DNSResource{},
+		Additional: []types.DNSResource{},
+	}
+
+	if rcode != nil {
+		message.Header.QR = true
+		message.Header.RCode = *rcode
+	}
+
+	data, err := parser.BuildDNSMessage(message)
+
+	if err != nil {
+		t.Fatalf("BuildDNSMessage() error = %v", err)
+	}
+
+	return data
+}
+
+func TestRead(t *testing.T) {
+	noError, nxDomain := types.NoError, types.NXDomain
+	query := build(t, 1, nil)
+	response := build(t, 1, &noError, record("192.0.2.1", 300))
+	upstream := build(t, 1, &noError, record("192.0.2.99", 300))
+	tlsQuery := build(t, 2, nil)
+	tlsResponse := build(t, 2, &nxDomain)
+	unanswered := build(t, 3, nil)
+
+	// The capture of a server listening in every address, forwarding
+	// the first query
+	path := filepath.Join(t.TempDir(), "capture.pcap")
+	packets, err := capture.Open(path)
+
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	at := time.Now()
+	packets.Write("192.0.2.10:40000", ":53", query, at)
+	packets.Write("", "198.51.100.1:53", query, at)
+	packets.Write("198.51.100.1:53", "", upstream, at)
+	packets.Write(":53", "192.0.2.10:40000", response, at)
+	packets.Write("[2001:db8::10]:40001", "[2001:db8::1]:853", tlsQuery, at)
+	packets.Write("[2001:db8::1]:853", "[2001:db8::10]:40001", tlsResponse, at)
+	packets.Write("192.0.2.10:40002", "0.0.0.0:53", unanswered, at)
+	packets.Write("192.0.2.10:40003", "0.0.0.0:53", []byte{0, 1, 2}, at)
+	packets.Close()
+
+	first := &replay.Exchange{
+		Client:   netip.MustParseAddrPort("192.0.2.10:40000"),
+		Server:   netip.MustParseAddrPort("0.0.0.0:53"),
+		Query:    query,
+		Response: response,
+	}
+	second := &replay.Exchange{
+		Client:   netip.MustParseAddrPort("[2001:db8::10]:40001"),
+		Server:   netip.MustParseAddrPort("[2001:db8::1]:853"),
+		Query:    tlsQuery,
+		Response: tlsResponse,
+	}
+	third := &replay.Exchange{
+		Client: netip.MustParseAddrPort("192.0.2.10:40002"),
+		Server: netip.MustParseAddrPort("0.0.0.0:53"),
+		Query:  unanswered,
+	}
+
+	tests := []struct {
+		name string
+		port uint16
+		want []*replay.Exchange
+	}{
+		{
+			name: "Every port",
+			want: []*replay.Exchange{first, second, third},
+		},
+		{
+			name: "Port of the listener in every address",
+			port: 53,
+			want: []*replay.Exchange{first, third},
+		},
+		{
+			name: "Other port",
+			port: 853,
+			want: []*replay.Exchange{second},
+		},
+		{
+			name: "Port without queries",
+			port: 5353,
+			want: []*replay.Exchange{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := replay.Read(path, tt.port)
+
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got, compareAddresses); diff != "" {
+				t.Errorf("Read() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	noError, refused := types.NoError, types.Refuced
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+
+	defer conn.Close()
+
+	// The server answers the queries by ID, with the same records in
+	// other order and TTLs for the ID 1, and doesn't answer the ID 4
+	answers := map[uint16][]byte{
+		1: build(t, 1, &noError, record("192.0.2.2", 60), record("192.0.2.1", 60)),
+		2: build(t, 2, &refused),
+		3: build(t, 3, &noError, record("192.0.2.1", 300)),
+	}
+
+	go func() {
+		buffer := make([]byte, 65535)
+
+		for {
+			n, client, err := conn.ReadFrom(buffer)
+
+			if err != nil {
+				return
+			}
+
+			if answer, ok := answers[binary.BigEndian.Uint16(buffer[:n])]; ok {
+				conn.WriteTo(answer, client)
+			}
+		}
+	}()
+
+	client := netip.MustParseAddrPort("192.0.2.10:40000")
+	exchanges := []*replay.Exchange{
+		{Client: client, Query: build(t, 1, nil), Response: build(t, 1, &noError, record("192.0.2.1", 300), record("192.0.2.2", 300))},
+		{Client: client, Query: build(t, 2, nil), Response: build(t, 2, &noError, record("192.0.2.1", 300))},
+		{Client: client, Query: build(t, 3, nil)},
+		{Client: client, Query: build(t, 4, nil), Response: build(t, 4, &noError)},
+	}
+	output := &bytes.Buffer{}
+
+	result, err := replay.Replay(exchanges, conn.LocalAddr().String(), 200*time.Millisecond, output)
+
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+
+	want := replay.Result{Queries: 4, Matched: 1, Mismatched: 1, Unrecorded: 1, Failed: 1}
+
+	if diff := cmp.Diff(want, result); diff != "" {
+		t.Errorf("Replay() mismatch (-want +got):\n%s", diff)
+	}
+
+	wantOutput := "www.example.com IN A from 192.0.2.10:40000:\n" +
+		"  rcode: NoError, got Refuced\n" +
+		"  answer: - www.example.com IN A 192.0.2.1\n"
+
+	if !strings.HasPrefix(output.String(), wantOutput) || !strings.Contains(output.String(), "\nwww.example.com IN A from 192.0.2.10:40000: ") {
+		t.Errorf("Replay() output = %q, want the mismatch and the query without response", output)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	noError, nxDomain := types.NoError, types.NXDomain
+	opt := types.DNSResource{Type: types.OPT, Class: types.QClass{Name: "CLASS1232", Code: 1232}, RData: "\\# 0"}
+
+	tests := []struct {
+		name     string
+		recorded []byte
+		received []byte
+		want     []string
+	}{
+		{
+			name:     "Same records in other order, with other TTLs and ID",
+			recorded: build(t, 1, &noError, record("192.0.2.1", 300), record("192.0.2.2", 300)),
+			received: build(t, 7, &noError, record("192.0.2.2", 10), record("192.0.2.1", 10)),
+			want:     []string{},
+		},
+		{
+			name:     "Other response code",
+			recorded: build(t, 1, &noError, record("192.0.2.1", 300)),
+			received: build(t, 1, &nxDomain),
+			want:     []string{"rcode: NoError, got NXDomain", "answer: - www.example.com IN A 192.0.2.1"},
+		},
+		{
+			name:     "Missing and unexpected records",
+			recorded: build(t, 1, &noError, record("192.0.2.1", 300), record("192.0.2.2", 300)),
+			received: build(t, 1, &noError, record("192.0.2.2", 300), record("192.0.2.3", 300), record("192.0.2.3", 300)),
+			want: []string{
+				"answer: - www.example.com IN A 192.0.2.1",
+				"answer: + www.example.com IN A 192.0.2.3",
+				"answer: + www.example.com IN A 192.0.2.3",
+			},
+		},
+		{
+			name:     "OPT records aren't compared",
+			recorded: build(t, 1, &noError, record("192.0.2.1", 300)),
+			received: func() []byte {
+				message, _ := parser.ParseDNSMessage(build(t, 1, &noError, record("192.0.2.1", 300)))
+				message.Additional = []types.DNSResource{opt}
+				data, _ := parser.BuildDNSMessage(message)
+
+				return data
+			}(),
+			want: []string{},
+		},
+		{
+			name:     "Same unparsable responses with other ID",
+			recorded: []byte{0, 1, 0x81, 0x80, 0xff},
+			received: []byte{0, 2, 0x81, 0x80, 0xff},
+			want:     nil,
+		},
+		{
+			name:     "Other unparsable responses",
+			recorded: []byte{0, 1, 0x81, 0x80, 0xff},
+			received: []byte{0, 1, 0x81, 0x80, 0xfe},
+			want:     []string{"response differs from the recorded one"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, replay.Compare(tt.recorded, tt.received)); diff != "" {
+				t.Errorf("Compare() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,817 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/internal/capture"
+	"github.com/lucasdc6/gdns/internal/metrics"
+	"github.com/lucasdc6/gdns/internal/querylog"
+	"github.com/lucasdc6/gdns/internal/ratelimit"
+	"github.com/lucasdc6/gdns/internal/tap"
+	"github.com/lucasdc6/gdns/pkg/blocklist"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/rewrite"
+	"github.com/lucasdc6/gdns/pkg/rpz"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+const (
+	// defaultForwarder - Server used to resolve the names outside
+	// of the configured zones
+	defaultForwarder = "8.8.8.8:53"
+	// upstreamTimeout - Time waited for the response of the forwarder
+	upstreamTimeout = 5 * time.Second
+	// maxUDPSize - Size of the UDP messages without EDNS (RFC 1035 - Section 4.2.1)
+	maxUDPSize = 512
+	// ednsUDPSize - UDP payload size announced in the responses
+	ednsUDPSize = 1232
+	// transferChunk - Number of records sent in each message of a zone transfer
+	transferChunk = 100
+	// dnssecOK - DO bit of the flags in the TTL of the OPT record
+	// (RFC 3225 - Section 3)
+	dnssecOK = 0x8000
+	// blockedTTL - TTL of the sinkhole answers of the blocked queries
+	blockedTTL = 60
+)
+
+// Request - DNS message received by one of the listeners
+type Request struct {
+	Message   types.DNSMessage
+	Raw       []byte
+	Client    net.Addr
+	Transport string
+	// Key - Key of the TSIG signature of the message, nil when
+	// the message isn't signed
+	Key *tsig.Key
+	// MAC - MAC of the message, or of the last response sent
+	MAC []byte
+	// Source - Source of the response: local, forwarded, blocked or
+	// policy
+	Source string
+	// Policy - Rule of a response policy zone matching the request
+	Policy *rpz.Rule
+	// View - View answering the request
+	View *View
+}
+
+// SignedWith - Check if the request was signed with the key name
+func (request Request) SignedWith(name string) bool {
+	return request.Key != nil && request.Key.Name == name
+}
+
+// serve - Resolve a message in wire format received from client and
+// return the responses in wire format
+func (server *Server) serve(query []byte, client net.Addr) [][]byte {
+	if allowed(client, server.Configuration.Global.Blackhole) {
+		log.Debugf("Dropping message from blackholed client %v", client)
+
+		return nil
+	}
+
+	start := time.Now()
+	address := server.localAddress()
+	server.Tap.ClientQuery(client, address, server.Mode, query, start)
+	server.Capture.Write(client.String(), address, query, start)
+	responses := server.answer(query, client, start)
+
+	if server.Mode == "udp" {
+		responses = server.limit(client, responses)
+	}
+
+	for _, response := range responses {
+		at := time.Now()
+		server.Tap.ClientResponse(client, address, server.Mode, response, start, at)
+		server.Capture.Write(address, client.String(), response, at)
+	}
+
+	return responses
+}
+
+// limit - Apply the rate limit to the responses of the UDP listener,
+// dropping them or sending them truncated, without records
+func (server *Server) limit(client net.Addr, responses [][]byte) [][]byte {
+	address, ok := client.(*net.UDPAddr)
+
+	if server.RateLimit == nil || !ok || len(responses) == 0 {
+		return responses
+	}
+
+	response, err := parser.ParseDNSMessage(responses[0])
+
+	if err != nil {
+		return responses
+	}
+
+	switch server.RateLimit.Limit(address.IP, response) {
+	case ratelimit.Drop:
+		log.Debugf("Dropping response to %v over the rate limit", client)
+		metrics.RateLimited.WithLabelValues("dropped").Inc()
+
+		return nil
+	case ratelimit.Slip:
+		log.Debugf("Truncating response to %v over the rate limit", client)
+		metrics.RateLimited.WithLabelValues("slipped").Inc()
+
+		truncated := reply(response, response.Header.RCode)
+		truncated.Header.AuthoritativeAnswer = response.Header.AuthoritativeAnswer
+		truncated.Header.TruncatedMessage = true
+		data, err := parser.BuildDNSMessage(truncated)
+
+		if err != nil {
+			return nil
+		}
+
+		return [][]byte{data}
+	}
+
+	return responses
+}
+
+// answer - Resolve a message in wire format received from client at
+// start, returning the responses in wire format
+func (server *Server) answer(query []byte, client net.Addr, start time.Time) [][]byte {
+	message, err := parser.ParseDNSMessage(query)
+
+	if err != nil {
+		log.Errorf("Error parsing DNS message from %v: %s", client, err)
+		metrics.ParseFailures.WithLabelValues(server.Mode).Inc()
+
+		return formatError(query)
+	}
+
+	request := Request{
+		Message:   message,
+		Raw:       query,
+		Client:    client,
+		Transport: server.Mode,
+		Source:    querylog.Local,
+	}
+
+	if failure := server.verify(&request); failure != nil {
+		server.observe(request, [][]byte{failure}, start)
+
+		return [][]byte{failure}
+	}
+
+	request.View = server.view(request)
+
+	responses := [][]byte{}
+
+	for i, response := range server.handle(&request) {
+		data, err := server.encode(request, response)
+
+		if err != nil {
+			log.Errorf("Error building the response for %v: %s", client, err)
+			data, _ = parser.BuildDNSMessage(reply(message, types.ServerFailure))
+		}
+
+		if request.Key != nil {
+			// RFC 8945 - Section 5.3.1: every message of a
+			// response is signed, chaining the MACs
+			data, request.MAC = tsig.Sign(data, *request.Key, request.MAC, i > 0)
+		}
+
+		responses = append(responses, data)
+	}
+
+	// The queries dropped by a policy are logged without response
+	if len(responses) > 0 || request.Policy != nil {
+		server.observe(request, responses, start)
+	}
+
+	return responses
+}
+
+// observe - Count the query of a request in the metrics, with the
+// response code of its first response, and write it in the query log
+func (server *Server) observe(request Request, responses [][]byte, start time.Time) {
+	message := request.Message
+
+	if len(message.Questions) == 0 {
+		return
+	}
+
+	question := message.Questions[0]
+	entry := querylog.Entry{
+		Time:      start,
+		Transport: server.Mode,
+		ID:        message.Header.Identifier,
+		Name:      question.Name,
+		Type:      question.Type.Name,
+		Class:     question.Class.Name,
+		Flags:     []string{},
+		Latency:   float64(time.Since(start).Microseconds()) / 1000,
+		Source:    request.Source,
+	}
+
+	if len(responses) > 0 {
+		response := responses[0]
+
+		if len(response) < 12 {
+			return
+		}
+
+		rcode, err := types.RCodeFromCode(int(response[3] & 0x0F))
+
+		if err != nil {
+			return
+		}
+
+		metrics.ObserveQuery(server.Mode, question, message.Header.OpCode, rcode)
+		entry.Flags = responseFlags(response, wantsDNSSEC(message))
+		entry.RCode = rcode.Name
+	}
+
+	if server.QueryLog == nil {
+		return
+	}
+
+	if rule := request.Policy; rule != nil {
+		entry.Policy = &querylog.PolicyMatch{Zone: rule.Zone, Trigger: rule.Trigger, Rule: rule.Name, Action: rule.Action}
+	}
+
+	if host, port, err := net.SplitHostPort(request.Client.String()); err == nil {
+		entry.Client = host
+		entry.Port, _ = strconv.Atoi(port)
+	}
+
+	for _, data := range responses {
+		entry.Answers += int(binary.BigEndian.Uint16(data[6:]))
+		entry.Size += len(data)
+	}
+
+	if err := server.QueryLog.Log(entry); err != nil {
+		log.Errorf("Error writing the query log: %s", err)
+	}
+}
+
+// responseFlags - Names of the flags set in the header of a response
+// in wire format, and the DO bit of its query
+func responseFlags(response []byte, dnssecOK bool) []string {
+	flags := []string{}
+	bits := []struct {
+		name  string
+		octet int
+		mask  byte
+	}{
+		{"qr", 2, 0x80}, {"aa", 2, 0x04}, {"tc", 2, 0x02}, {"rd", 2, 0x01},
+		{"ra", 3, 0x80}, {"ad", 3, 0x20}, {"cd", 3, 0x10},
+	}
+
+	for _, bit := range bits {
+		if response[bit.octet]&bit.mask != 0 {
+			flags = append(flags, bit.name)
+		}
+	}
+
+	if dnssecOK {
+		flags = append(flags, "do")
+	}
+
+	return flags
+}
+
+// verify - Check the TSIG signature of a request, removing it from
+// the message. Return the response when the verification fails
+func (server *Server) verify(request *Request) []byte {
+	record, _, found, err := tsig.Find(request.Raw)
+
+	if err != nil {
+		log.Errorf("Error reading the TSIG record from %v: %s", request.Client, err)
+		data, _ := parser.BuildDNSMessage(reply(request.Message, types.FormatError))
+
+		return data
+	}
+
+	if !found {
+		return nil
+	}
+
+	response := reply(request.Message, types.NotAuthoritative)
+	response.Additional = []types.DNSResource{}
+	data, _ := parser.BuildDNSMessage(response)
+	key, ok := server.Keys.Get(record.Name)
+
+	if !ok {
+		log.Warnf("Request from %v signed with the unknown key %s", request.Client, record.Name)
+
+		return tsig.Unsigned(data, record, types.BADKEY)
+	}
+
+	unsigned, mac, err := tsig.Verify(request.Raw, key, nil, false)
+
+	switch {
+	case err == tsig.ErrBadTime:
+		log.Warnf("Request from %v signed with key %s out of time", request.Client, key.Name)
+
+		return tsig.SignBadTime(data, key, mac)
+	case err == tsig.ErrBadKey, err == tsig.ErrBadSig:
+		log.Warnf("Request from %v with invalid signature of key %s", request.Client, key.Name)
+
+		return tsig.Unsigned(data, record, err.(*tsig.Error).RCode)
+	case err != nil:
+		log.Warnf("Request from %v with invalid TSIG record: %s", request.Client, err)
+
+		return tsig.Unsigned(data, record, types.BADSIG)
+	}
+
+	request.Key = &key
+	request.MAC = mac
+	request.Raw = unsigned
+	// The TSIG record is always the last one
+	request.Message.Additional = request.Message.Additional[:len(request.Message.Additional)-1]
+
+	return nil
+}
+
+// encode - Generate the wire format of a response, truncating it
+// when doesn't fit in the UDP payload of the client
+func (server *Server) encode(request Request, response types.DNSMessage) ([]byte, error) {
+	data, err := parser.BuildDNSMessage(response)
+
+	limit := udpSize(request.Message)
+
+	if request.Key != nil {
+		limit -= tsig.Size(*request.Key)
+	}
+
+	if err != nil || request.Transport != "udp" || len(data) <= limit {
+		return data, err
+	}
+
+	if len(response.Questions) == 1 && response.Questions[0].Type.Code == types.IXFR.Code {
+		// RFC 1995 - Section 2: the current SOA tells the client to retry over TCP
+		response.Answers = response.Answers[:1]
+	} else {
+		response.Header.TruncatedMessage = true
+		response.Answers = []types.DNSResource{}
+	}
+	response.Authority = []types.DNSResource{}
+	response.Additional = edns(request.Message)
+
+	return parser.BuildDNSMessage(response)
+}
+
+// formatError - FORMERR response for a malformed message, when at
+// least its header can be read
+func formatError(query []byte) [][]byte {
+	if len(query) < 12 || query[2]&128 != 0 {
+		return nil
+	}
+
+	header := types.DNSHeader{
+		Identifier: binary.BigEndian.Uint16(query[0:2]),
+		QR:         true,
+		OpCode:     types.OpCode{Code: int(query[2] >> 3 & 15)},
+		RCode:      types.FormatError,
+	}
+	data, _ := parser.BuildDNSMessage(types.DNSMessage{Header: header})
+
+	return [][]byte{data}
+}
+
+// udpSize - Maximum size of an UDP response for the message,
+// taken from its OPT record (RFC 6891 - Section 6.2.3)
+func udpSize(message types.DNSMessage) int {
+	for _, resource := range message.Additional {
+		if resource.Type.Code == types.OPT.Code && resource.Class.Code > maxUDPSize {
+			return resource.Class.Code
+		}
+	}
+
+	return maxUDPSize
+}
+
+// edns - OPT record for the response of a message, empty when the
+// message doesn't use EDNS. The DO bit is copied from the message
+func edns(message types.DNSMessage) []types.DNSResource {
+	for _, resource := range message.Additional {
+		if resource.Type.Code == types.OPT.Code {
+			return []types.DNSResource{{
+				Type:  types.OPT,
+				Class: types.QClass{Name: "CLASS1232", Code: ednsUDPSize},
+				TTL:   resource.TTL & dnssecOK,
+				RData: "\\# 0",
+			}}
+		}
+	}
+
+	return []types.DNSResource{}
+}
+
+// wantsDNSSEC - Check if the message sets the DO bit, asking for the
+// DNSSEC records of the answer
+func wantsDNSSEC(message types.DNSMessage) bool {
+	for _, resource := range message.Additional {
+		if resource.Type.Code == types.OPT.Code {
+			return resource.TTL&dnssecOK != 0
+		}
+	}
+
+	return false
+}
+
+// reply - Generate an empty response for the message
+func reply(message types.DNSMessage, rcode types.RCode) types.DNSMessage {
+	return types.DNSMessage{
+		Header: types.DNSHeader{
+			Identifier:       message.Header.Identifier,
+			QR:               true,
+			OpCode:           message.Header.OpCode,
+			RecursionDesired: message.Header.RecursionDesired,
+			CD:               message.Header.CD,
+			RCode:            rcode,
+		},
+		Questions:  message.Questions,
+		Answers:    []types.DNSResource{},
+		Authority:  []types.DNSResource{},
+		Additional: edns(message),
+	}
+}
+
+// handle - Resolve a request, returning more than one response
+// only for the zone transfers
+func (server *Server) handle(request *Request) []types.DNSMessage {
+	message := request.Message
+
+	if message.Header.QR {
+		log.Debugf("Ignoring response received from %v", request.Client)
+
+		return nil
+	}
+
+	switch message.Header.OpCode.Code {
+	case types.Query.Code:
+	case types.Notify.Code:
+		return []types.DNSMessage{server.receiveNotify(*request)}
+	case types.Update.Code:
+		return []types.DNSMessage{server.receiveUpdate(*request)}
+	default:
+		return []types.DNSMessage{reply(message, types.NotImplemented)}
+	}
+
+	if len(message.Questions) != 1 {
+		return []types.DNSMessage{reply(message, types.FormatError)}
+	}
+
+	if rewritten, found := server.Rewrite.Rewrite(message.Questions[0].Name); found {
+		return server.resolveRewritten(request, rewritten)
+	}
+
+	return server.query(request)
+}
+
+// query - Resolve a query with the zones, the blocklist or the forwarder
+// of its view
+func (server *Server) query(request *Request) []types.DNSMessage {
+	message := request.Message
+	question := message.Questions[0]
+	queryZone := request.View.Zones.Find(question.Name)
+
+	if queryZone != nil && allowed(request.Client, queryZone.Blackhole()) {
+		log.Debugf("Dropping query of zone %s from blackholed client %v", queryZone.Name, request.Client)
+
+		return nil
+	}
+
+	if !server.queryAllowed(request.Client, queryZone) {
+		log.Warnf("Query of %s from %v refused", question.Name, request.Client)
+
+		return []types.DNSMessage{reply(message, types.Refuced)}
+	}
+
+	switch question.Type.Code {
+	case types.AXFR.Code, types.IXFR.Code:
+		return server.transfer(*request)
+	}
+
+	if block, found := server.Blocklist.Match(question.Name); found {
+		log.Debugf("Query of %s from %v blocked by list %s", question.Name, request.Client, block.List)
+		metrics.BlocklistHits.WithLabelValues(block.List).Inc()
+		request.Source = querylog.Blocked
+
+		return []types.DNSMessage{blocked(message, block)}
+	}
+
+	if rule, found := server.Policies.MatchName(question.Name); found {
+		if responses, applied := server.applyPolicy(request, rule); applied {
+			return responses
+		}
+	}
+
+	if queryZone != nil {
+		if !queryZone.Available() {
+			log.Errorf("Zone %s isn't available", queryZone.Name)
+
+			return []types.DNSMessage{reply(message, types.ServerFailure)}
+		}
+
+		return server.checkResponse(request, authoritative(message, queryZone), queryZone)
+	}
+
+	if networks := server.Configuration.Global.AllowRecursion; len(networks) > 0 && !allowed(request.Client, networks) {
+		log.Warnf("Recursive query of %s from %v refused", question.Name, request.Client)
+
+		return []types.DNSMessage{reply(message, types.Refuced)}
+	}
+
+	request.Source = querylog.Forwarded
+
+	return server.checkResponse(request, server.forward(*request), nil)
+}
+
+// resolveRewritten - Resolve a query with its name rewritten, mapping
+// the owner names of the responses back to the original name
+func (server *Server) resolveRewritten(request *Request, rewritten rewrite.Rewritten) []types.DNSMessage {
+	message, raw := request.Message, request.Raw
+	log.Debugf("Rewriting query of %s from %v to %s", rewritten.Original, request.Client, rewritten.Name)
+
+	question := message.Questions[0]
+	question.Name = rewritten.Name
+	request.Message.Questions = []types.DNSQuestion{question}
+	data, err := parser.BuildDNSMessage(request.Message)
+
+	if err != nil {
+		log.Errorf("Error building the query of %s rewritten to %s: %s", rewritten.Original, rewritten.Name, err)
+		request.Message = message
+
+		return []types.DNSMessage{reply(message, types.ServerFailure)}
+	}
+
+	// The forwarder receives the rewritten query
+	request.Raw = data
+	responses := server.query(request)
+	request.Message, request.Raw = message, raw
+
+	for i := range responses {
+		responses[i].Questions = message.Questions
+		responses[i].Answers = restoreNames(responses[i].Answers, rewritten)
+		responses[i].Authority = restoreNames(responses[i].Authority, rewritten)
+		responses[i].Additional = restoreNames(responses[i].Additional, rewritten)
+	}
+
+	return responses
+}
+
+// restoreNames - Copy of the records with their owner names mapped
+// back to the names before the rewrite, and the targets of the CNAME
+// records too, keeping the chains of the answers
+func restoreNames(records []types.DNSResource, rewritten rewrite.Rewritten) []types.DNSResource {
+	restored := []types.DNSResource{}
+
+	for _, record := range records {
+		if record.Type.Code != types.OPT.Code {
+			record.Name = rewritten.Restore(record.Name)
+		}
+
+		if record.Type.Code == types.CNAME.Code {
+			record.RData = rewritten.Restore(record.RData)
+		}
+
+		restored = append(restored, record)
+	}
+
+	return restored
+}
+
+// view - First view matching the request, the default view when none
+// of the named views match
+func (server *Server) view(request Request) *View {
+	for _, view := range server.Views {
+		if view.Matches(request) {
+			return view
+		}
+	}
+
+	return server.Views[len(server.Views)-1]
+}
+
+// queryAllowed - Check if the client is allowed to query the zone, nil
+// for the names outside of the zones, with its allow-query or the
+// global one when the zone doesn't have it. Every client is allowed
+// when both are empty. The allow-query of the listener is checked too
+func (server *Server) queryAllowed(client net.Addr, queryZone *zone.Zone) bool {
+	if len(server.AllowQuery) > 0 && !allowed(client, server.AllowQuery) {
+		return false
+	}
+
+	networks := server.Configuration.Global.AllowQuery
+
+	if queryZone != nil && len(queryZone.AllowQuery()) > 0 {
+		networks = queryZone.AllowQuery()
+	}
+
+	return len(networks) == 0 || allowed(client, networks)
+}
+
+// authoritative - Answer the question with the records of the zone
+func authoritative(message types.DNSMessage, zone *zone.Zone) types.DNSMessage {
+	question := message.Questions[0]
+	answer := zone.Lookup(question.Name, question.Type)
+
+	if wantsDNSSEC(message) {
+		answer = zone.SignAnswer(question.Name, question.Type, answer)
+	}
+
+	response := reply(message, answer.RCode)
+	response.Header.AuthoritativeAnswer = true
+	response.Answers = append(response.Answers, answer.Answers...)
+	response.Authority = append(response.Authority, answer.Authority...)
+
+	return response
+}
+
+// blocked - Answer a blocked question with the action of its block.
+// The sinkhole answers have the addresses of the question type, without
+// records for the other types
+func blocked(message types.DNSMessage, block blocklist.Block) types.DNSMessage {
+	switch block.Action {
+	case blocklist.Refused:
+		return reply(message, types.Refuced)
+	case blocklist.NXDomain:
+		return reply(message, types.NXDomain)
+	}
+
+	question := message.Questions[0]
+	response := reply(message, types.NoError)
+
+	for _, address := range block.Sinkhole {
+		ipv4 := address.To4() != nil
+
+		if (question.Type.Code == types.A.Code && ipv4) || (question.Type.Code == types.AAAA.Code && !ipv4) {
+			response.Answers = append(response.Answers, types.DNSResource{
+				Name:  question.Name,
+				Type:  question.Type,
+				Class: types.IN,
+				TTL:   blockedTTL,
+				RData: address.String(),
+			})
+		}
+	}
+
+	return response
+}
+
+// transfer - Answer the AXFR (RFC 5936) and IXFR (RFC 1995) requests,
+// splitting the records in several messages. Over udp and https the
+// answer is a single message
+func (server *Server) transfer(request Request) []types.DNSMessage {
+	message := request.Message
+	question := message.Questions[0]
+	transferZone := request.View.Zones.Get(question.Name)
+
+	if transferZone == nil {
+		return []types.DNSMessage{reply(message, types.NotAuthoritative)}
+	}
+
+	if !transferZone.Available() {
+		return []types.DNSMessage{reply(message, types.ServerFailure)}
+	}
+
+	if key := transferZone.TransferKey(); key != "" && !request.SignedWith(key) {
+		log.Warnf("Refusing transfer of zone %s to %v without the key %s", transferZone.Name, request.Client, key)
+
+		return []types.DNSMessage{reply(message, types.Refuced)}
+	}
+
+	var records []types.DNSResource
+
+	if question.Type.Code == types.AXFR.Code {
+		if request.Transport == "udp" || request.Transport == "https" {
+			return []types.DNSMessage{reply(message, types.FormatError)}
+		}
+
+		records = transferZone.Transfer()
+	} else {
+		serial, ok := clientSerial(message)
+
+		if !ok {
+			return []types.DNSMessage{reply(message, types.FormatError)}
+		}
+
+		records = transferZone.Changes(serial)
+
+		// RFC 1995 - Section 2: when the changes don't fit in a message,
+		// only the current SOA is sent, so the client asks over TCP
+		if (request.Transport == "udp" || request.Transport == "https") && len(records) > transferChunk {
+			records = []types.DNSResource{transferZone.SOARecord()}
+		}
+	}
+
+	log.Infof("Transfer %s of zone %s to %v with %d records", question.Type, transferZone.Name, request.Client, len(records))
+
+	responses := []types.DNSMessage{}
+
+	for start := 0; start < len(records); start += transferChunk {
+		end := start + transferChunk
+		if end > len(records) {
+			end = len(records)
+		}
+
+		response := reply(message, types.NoError)
+		response.Header.AuthoritativeAnswer = true
+		response.Answers = records[start:end]
+		responses = append(responses, response)
+	}
+
+	return responses
+}
+
+// clientSerial - Serial of the SOA sent in the authority section
+// of an IXFR request
+func clientSerial(message types.DNSMessage) (uint32, bool) {
+	for _, resource := range message.Authority {
+		if resource.Type.Code != types.SOA.Code {
+			continue
+		}
+
+		soa, err := zone.ParseSOA(resource.RData)
+
+		return soa.Serial, err == nil
+	}
+
+	return 0, false
+}
+
+// forwarderAddress - Address of the forwarder configured, the
+// default one when it isn't set
+func forwarderAddress(global config.Global) string {
+	if global.Forwarder == "" {
+		return defaultForwarder
+	}
+
+	return withDefaultPort(global.Forwarder, 53)
+}
+
+// exchangeForwarder - Send a query to the forwarder over UDP, retrying
+// over TCP when the response is truncated and retryTCP is set. The
+// messages are written to the dnstap output and the pcap capture
+func exchangeForwarder(output *tap.Tap, packets *capture.Capture, forwarder string, query []byte, retryTCP bool) ([]byte, error) {
+	start := time.Now()
+	output.ForwarderQuery(forwarder, "udp", query, start)
+	packets.Write("", forwarder, query, start)
+	res, err := sendUDP(forwarder, query)
+
+	if err == nil {
+		at := time.Now()
+		output.ForwarderResponse(forwarder, "udp", res, start, at)
+		packets.Write(forwarder, "", res, at)
+	}
+
+	if err == nil && retryTCP && len(res) > 2 && res[2]&2 != 0 {
+		start = time.Now()
+		output.ForwarderQuery(forwarder, "tcp", query, start)
+		packets.Write("", forwarder, query, start)
+		res, err = sendTCP(forwarder, query)
+
+		if err == nil {
+			at := time.Now()
+			output.ForwarderResponse(forwarder, "tcp", res, start, at)
+			packets.Write(forwarder, "", res, at)
+		}
+	}
+
+	return res, err
+}
+
+// forward - Resolve the request with the forwarder, retrying over
+// TCP when the response is truncated. The responses are validated
+// when the server has a validator, unless the client sets CD
+func (server *Server) forward(request Request) types.DNSMessage {
+	if request.View.Validator != nil && !request.Message.Header.CD {
+		return request.View.Validator.Resolve(request.Message)
+	}
+
+	forwarder := request.View.Forwarder
+	log.Printf("Send query to authoritative server")
+	res, err := exchangeForwarder(server.Tap, server.Capture, forwarder, request.Raw, request.Transport != "udp")
+
+	if err != nil {
+		log.Errorf("Error forwarding query to %s: %s", forwarder, err)
+
+		return reply(request.Message, types.ServerFailure)
+	}
+
+	response, err := parser.ParseDNSMessage(res)
+
+	if err != nil {
+		log.Errorf("Error parsing response from %s: %s", forwarder, err)
+
+		return reply(request.Message, types.ServerFailure)
+	}
+
+	return response
+}
@@ -0,0 +1,200 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/lucasdc6/gdns/internal/metrics"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+func TestTransferIXFR(t *testing.T) {
+	records := []config.Record{}
+
+	for i := 0; i < 150; i++ {
+		records = append(records, config.Record{Name: fmt.Sprintf("host%d", i), Type: types.A, Value: fmt.Sprintf("192.0.2.%d", i), TTL: 300})
+	}
+
+	view, err := NewView(config.View{Zones: []config.Zone{{Name: "example.com", Records: records}}}, config.Global{}, tsig.NewKeyring())
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	server := &Server{Views: []*View{view}, Keys: tsig.NewKeyring()}
+	transferZone := view.Zones.Get("example.com")
+	soaTTL := transferZone.SOARecord().TTL
+	first := transferZone.Serial()
+
+	// Every record changed, and then a small change
+	changed := []types.DNSResource{}
+
+	for _, record := range transferZone.Records() {
+		record.TTL = 600
+		changed = append(changed, record)
+	}
+
+	transferZone.Update(transferZone.SOA(), soaTTL, changed)
+	second := transferZone.Serial()
+	added := append(transferZone.Records(), types.DNSResource{Name: "www.example.com", Type: types.A, Class: types.IN, TTL: 300, RData: "198.51.100.1"})
+	transferZone.Update(transferZone.SOA(), soaTTL, added)
+	current := transferZone.Serial()
+
+	tests := []struct {
+		name      string
+		transport string
+		serial    uint32
+		// wantRecords - Records of each message, the current SOA alone
+		// when it's 1
+		wantRecords []int
+	}{
+		{
+			name:        "Small change over udp",
+			transport:   "udp",
+			serial:      second,
+			wantRecords: []int{5},
+		},
+		{
+			name:        "Up to date over udp",
+			transport:   "udp",
+			serial:      current,
+			wantRecords: []int{1},
+		},
+		{
+			name:        "Large change over udp",
+			transport:   "udp",
+			serial:      first,
+			wantRecords: []int{1},
+		},
+		{
+			name:        "Large change over https",
+			transport:   "https",
+			serial:      first,
+			wantRecords: []int{1},
+		},
+		{
+			name:        "Large change over tcp",
+			transport:   "tcp",
+			serial:      first,
+			wantRecords: []int{100, 100, 100, 7},
+		},
+		{
+			name:        "Whole zone over udp, without history",
+			transport:   "udp",
+			serial:      first - 1,
+			wantRecords: []int{1},
+		},
+		{
+			name:        "Whole zone over tls, without history",
+			transport:   "tls",
+			serial:      first - 1,
+			wantRecords: []int{100, 53},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := parser.ParseDNSMessage(testQuery(t, 1, "example.com", types.IXFR))
+
+			if err != nil {
+				t.Fatalf("ParseDNSMessage() error = %v", err)
+			}
+
+			soa := transferZone.SOA()
+			soa.Serial = tt.serial
+			message.Authority = []types.DNSResource{{Name: "example.com", Type: types.SOA, Class: types.IN, TTL: soaTTL, RData: soa.String()}}
+
+			responses := server.transfer(Request{
+				Message:   message,
+				Client:    &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353},
+				Transport: tt.transport,
+				View:      view,
+			})
+			gotRecords := []int{}
+
+			for _, response := range responses {
+				gotRecords = append(gotRecords, len(response.Answers))
+			}
+
+			if fmt.Sprint(gotRecords) != fmt.Sprint(tt.wantRecords) {
+				t.Fatalf("transfer() records = %v, want %v", gotRecords, tt.wantRecords)
+			}
+
+			if len(tt.wantRecords) == 1 && tt.wantRecords[0] == 1 {
+				answer := responses[0].Answers[0]
+				soa, err := zone.ParseSOA(answer.RData)
+
+				if answer.Type.Code != types.SOA.Code || err != nil || soa.Serial != current {
+					t.Errorf("transfer() = %s %s, want the SOA with serial %d", answer.Type, answer.RData, current)
+				}
+			}
+		})
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		query  []byte
+		client net.Addr
+		// wantLabels - Transport, qtype, opcode and rcode of the query
+		wantLabels []string
+	}{
+		{
+			name:       "Answer",
+			mode:       "udp",
+			query:      testQuery(t, 1, "www.example.com", types.A),
+			client:     &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353},
+			wantLabels: []string{"udp", "A", "Query", "NoError"},
+		},
+		{
+			name:       "Missing name",
+			mode:       "tcp",
+			query:      testQuery(t, 2, "missing.example.com", types.AAAA),
+			client:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353},
+			wantLabels: []string{"tcp", "AAAA", "Query", "NXDomain"},
+		},
+		{
+			name:       "Query out of allow-query",
+			mode:       "https",
+			query:      testQuery(t, 3, "private.example.com", types.MX),
+			client:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353},
+			wantLabels: []string{"https", "MX", "Query", "Refuced"},
+		},
+		{
+			name:       "NOTIFY of a primary zone",
+			mode:       "tls",
+			query:      testNotify(t, types.Notify, "example.com", types.SOA),
+			client:     &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353},
+			wantLabels: []string{"tls", "SOA", "Notify", "NotAuthoritative"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := metrics.Queries.WithLabelValues(tt.wantLabels...)
+			before := testutil.ToFloat64(counter)
+
+			if responses := testServer(t, tt.mode).serve(tt.query, tt.client); len(responses) != 1 {
+				t.Fatalf("serve() returned %d responses, want 1", len(responses))
+			}
+
+			if diff := cmp.Diff(1.0, testutil.ToFloat64(counter)-before); diff != "" {
+				t.Errorf("Queries%v mismatch (-want +got):\n%s", tt.wantLabels, diff)
+			}
+		})
+	}
+}
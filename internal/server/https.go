@@ -0,0 +1,246 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/errors"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+const (
+	// dnsMessageType - Media type of the DNS messages in wire format
+	// (RFC 8484 - Section 6)
+	dnsMessageType = "application/dns-message"
+	// dnsJSONType - Media type of the DNS messages in JSON format
+	dnsJSONType = "application/dns-json"
+)
+
+// startHTTPSServer - Listen for DNS over HTTPS requests (RFC 8484),
+// over HTTP/2 when the client supports it
+func startHTTPSServer(server *Server) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/dns-query", server.dnsQuery)
+	mux.HandleFunc("/resolve", server.resolve)
+
+	// Slow headers and idle connections are closed, as in the TCP and
+	// QUIC listeners
+	httpServer := &http.Server{
+		Addr:              server.localAddress(),
+		Handler:           mux,
+		TLSConfig:         server.TLS.Clone(),
+		ReadHeaderTimeout: upstreamTimeout,
+		IdleTimeout:       tcpIdleTimeout,
+	}
+
+	listener, err := server.listen("tcp")
+
+	if err == nil {
+		log.Printf("HTTPS Server started at %s\n", server.localAddress())
+		err = httpServer.ServeTLS(listener, "", "")
+	}
+
+	log.Fatalf("Error starting the server: %v\n", err)
+	os.Exit(errors.StartingServer)
+}
+
+// httpClient - Address of the client of an HTTP request
+func httpClient(request *http.Request) net.Addr {
+	address, err := net.ResolveTCPAddr("tcp", request.RemoteAddr)
+
+	if err != nil {
+		return &net.TCPAddr{}
+	}
+
+	return address
+}
+
+// dnsQuery - Answer a DNS message received in the dns parameter of a
+// GET request, or in the body of a POST request
+func (server *Server) dnsQuery(w http.ResponseWriter, request *http.Request) {
+	var query []byte
+	var err error
+
+	switch request.Method {
+	case http.MethodGet:
+		query, err = base64.RawURLEncoding.DecodeString(request.URL.Query().Get("dns"))
+
+		if err == nil && len(query) == 0 {
+			err = fmt.Errorf("missing dns parameter")
+		}
+	case http.MethodPost:
+		if request.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "unsupported media type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		query, err = ioutil.ReadAll(io.LimitReader(request.Body, 65535))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client := httpClient(request)
+	log.Printf("HTTPS Query recived from %v", client)
+
+	responses := server.serve(query, client)
+
+	if len(responses) == 0 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	// Only the first message of a zone transfer fits in a response
+	response := responses[0]
+
+	w.Header().Set("Content-Type", dnsMessageType)
+
+	if maxAge, ok := cacheTime(response); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge))
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(response)))
+	w.Write(response)
+}
+
+// resolve - Answer the query of the name and type parameters in the
+// JSON format of the DNS over HTTPS APIs of Google and Cloudflare. The
+// cd and do parameters set the CD and DO bits of the query
+func (server *Server) resolve(w http.ResponseWriter, request *http.Request) {
+	parameters := request.URL.Query()
+	name := parameters.Get("name")
+	qtype, err := jsonQType(parameters.Get("type"))
+
+	if name == "" {
+		err = fmt.Errorf("missing name parameter")
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	message := newQuery(name, qtype)
+	message.Header.RecursionDesired = true
+	message.Header.CD = jsonFlag(parameters.Get("cd"))
+
+	if jsonFlag(parameters.Get("do")) {
+		message.Additional = []types.DNSResource{{
+			Type:  types.OPT,
+			Class: types.QClass{Name: "CLASS1232", Code: ednsUDPSize},
+			TTL:   dnssecOK,
+			RData: "\\# 0",
+		}}
+	}
+
+	query, err := parser.BuildDNSMessage(message)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	client := httpClient(request)
+	log.Printf("HTTPS JSON Query recived from %v", client)
+
+	responses := server.serve(query, client)
+
+	if len(responses) == 0 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	response := responses[0]
+	result, err := parser.ParseDNSMessage(response)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data, err := json.Marshal(types.NewDNSJSON(result))
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", dnsJSONType)
+
+	if maxAge, ok := cacheTime(response); ok {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge))
+	}
+
+	w.Write(data)
+}
+
+// jsonQType - Type of the type parameter of a JSON query, by name or
+// number. The default is A
+func jsonQType(value string) (types.QType, error) {
+	if value == "" {
+		return types.A, nil
+	}
+
+	if code, err := strconv.Atoi(value); err == nil {
+		return types.QTypeFromCode(code)
+	}
+
+	return types.QTypeFromString(strings.ToUpper(value))
+}
+
+// jsonFlag - Value of a boolean parameter of a JSON query
+func jsonFlag(value string) bool {
+	return value == "1" || strings.ToLower(value) == "true"
+}
+
+// cacheTime - Freshness lifetime of a response, the smallest TTL of its
+// records (RFC 8484 - Section 5.1). Responses without records aren't
+// cached
+func cacheTime(response []byte) (int32, bool) {
+	message, err := parser.ParseDNSMessage(response)
+
+	if err != nil {
+		return 0, false
+	}
+
+	records := append(append([]types.DNSResource{}, message.Answers...), message.Authority...)
+
+	if len(records) == 0 {
+		return 0, false
+	}
+
+	minimum := records[0].TTL
+
+	for _, record := range records[1:] {
+		if record.TTL < minimum {
+			minimum = record.TTL
+		}
+	}
+
+	if minimum < 0 {
+		minimum = 0
+	}
+
+	return minimum, true
+}
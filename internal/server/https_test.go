@@ -0,0 +1,165 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+func TestDNSQuery(t *testing.T) {
+	server := testServer(t, "https")
+	query := testQuery(t, 0, "www.example.com", types.A)
+
+	tests := []struct {
+		name             string
+		method           string
+		target           string
+		contentType      string
+		body             []byte
+		wantStatus       int
+		wantCacheControl string
+		wantRCode        string
+		wantAnswers      []string
+	}{
+		{
+			name:             "GET with the dns parameter",
+			method:           http.MethodGet,
+			target:           "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(query),
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "max-age=300",
+			wantRCode:        "NoError",
+			wantAnswers:      []string{"192.0.2.10"},
+		},
+		{
+			name:             "POST of a DNS message",
+			method:           http.MethodPost,
+			target:           "/dns-query",
+			contentType:      "application/dns-message",
+			body:             testQuery(t, 0, "mail.example.com", types.A),
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "max-age=60",
+			wantRCode:        "NoError",
+			wantAnswers:      []string{"192.0.2.20"},
+		},
+		{
+			name:             "Negative answer cached with the SOA",
+			method:           http.MethodGet,
+			target:           "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(testQuery(t, 0, "missing.example.com", types.A)),
+			wantStatus:       http.StatusOK,
+			wantCacheControl: "max-age=120",
+			wantRCode:        "NXDomain",
+			wantAnswers:      []string{},
+		},
+		{
+			name:        "Refused answer without records isn't cached",
+			method:      http.MethodPost,
+			target:      "/dns-query",
+			contentType: "application/dns-message",
+			body:        testQuery(t, 0, "private.example.com", types.A),
+			wantStatus:  http.StatusOK,
+			wantRCode:   "Refuced",
+			wantAnswers: []string{},
+		},
+		{
+			name:       "GET without the dns parameter",
+			method:     http.MethodGet,
+			target:     "/dns-query",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "GET with padded base64",
+			method:     http.MethodGet,
+			target:     "/dns-query?dns=" + base64.URLEncoding.EncodeToString(query[:len(query)-1]),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "POST with other content type",
+			method:      http.MethodPost,
+			target:      "/dns-query",
+			contentType: "application/octet-stream",
+			body:        query,
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "POST without content type",
+			method:     http.MethodPost,
+			target:     "/dns-query",
+			body:       query,
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "Other method",
+			method:     http.MethodPut,
+			target:     "/dns-query",
+			body:       query,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "Dropped query",
+			method:     http.MethodGet,
+			target:     "/dns-query?dns=" + base64.RawURLEncoding.EncodeToString(testQuery(t, 0, "blocked.example.com", types.A)),
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.target, bytes.NewReader(tt.body))
+			request.RemoteAddr = "192.0.2.1:40000"
+
+			if tt.contentType != "" {
+				request.Header.Set("Content-Type", tt.contentType)
+			}
+
+			recorder := httptest.NewRecorder()
+			server.dnsQuery(recorder, request)
+			response := recorder.Result()
+
+			if response.StatusCode != tt.wantStatus {
+				t.Fatalf("dnsQuery() status = %d, want %d: %s", response.StatusCode, tt.wantStatus, recorder.Body)
+			}
+
+			if got := response.Header.Get("Cache-Control"); got != tt.wantCacheControl {
+				t.Errorf("dnsQuery() Cache-Control = %q, want %q", got, tt.wantCacheControl)
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			if got := response.Header.Get("Content-Type"); got != "application/dns-message" {
+				t.Errorf("dnsQuery() Content-Type = %q, want application/dns-message", got)
+			}
+
+			message, err := parser.ParseDNSMessage(recorder.Body.Bytes())
+
+			if err != nil {
+				t.Fatalf("ParseDNSMessage() error = %v", err)
+			}
+
+			if message.Header.RCode.Name != tt.wantRCode {
+				t.Errorf("dnsQuery() rcode = %s, want %s", message.Header.RCode.Name, tt.wantRCode)
+			}
+
+			answers := []string{}
+
+			for _, answer := range message.Answers {
+				answers = append(answers, answer.RData)
+			}
+
+			if diff := cmp.Diff(tt.wantAnswers, answers); diff != "" {
+				t.Errorf("dnsQuery() answers mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+// notifyAttempts - Times a NOTIFY is sent while it isn't answered
+const notifyAttempts = 3
+
+// notifyInterval - Time waited between the attempts to send a NOTIFY
+var notifyInterval = 2 * time.Second
+
+// SendNotify - Tell the also-notify servers of a zone that a new
+// version is available (RFC 1996 - Section 3.7)
+func SendNotify(notifyZone *zone.Zone) {
+	for _, target := range notifyZone.AlsoNotify() {
+		go notify(notifyZone, withDefaultPort(target, 53))
+	}
+}
+
+// notify - Send the NOTIFY of the current version of a zone to a
+// server, retrying while it isn't answered
+func notify(notifyZone *zone.Zone, address string) {
+	message := newQuery(notifyZone.Name, types.SOA)
+	message.Header.OpCode = types.Notify
+	message.Header.AuthoritativeAnswer = true
+	message.Answers = []types.DNSResource{notifyZone.SOARecord()}
+
+	data, err := parser.BuildDNSMessage(message)
+
+	if err != nil {
+		log.Errorf("Error building NOTIFY of zone %s: %s", notifyZone.Name, err)
+		return
+	}
+
+	for attempt := 1; attempt <= notifyAttempts; attempt++ {
+		res, err := sendUDP(address, data)
+
+		if err == nil {
+			_, err = checkNotifyResponse(message, res)
+		}
+
+		if err == nil {
+			log.Infof("NOTIFY of zone %s with serial %d sent to %s", notifyZone.Name, notifyZone.Serial(), address)
+			return
+		}
+
+		log.Warnf("Error sending NOTIFY of zone %s to %s (attempt %d): %s", notifyZone.Name, address, attempt, err)
+
+		if attempt < notifyAttempts {
+			time.Sleep(notifyInterval)
+		}
+	}
+}
+
+// checkNotifyResponse - Verify the response to a NOTIFY
+func checkNotifyResponse(message types.DNSMessage, data []byte) (types.DNSMessage, error) {
+	response, err := checkResponse(message, data)
+
+	if err == nil && response.Header.OpCode.Code != types.Notify.Code {
+		err = fmt.Errorf("response with opcode %s", response.Header.OpCode)
+	}
+
+	return response, err
+}
+
+// receiveNotify - Refresh a secondary zone when one of its primaries
+// notify a change (RFC 1996 - Section 3.11)
+func (server *Server) receiveNotify(request Request) types.DNSMessage {
+	message := request.Message
+
+	if len(message.Questions) != 1 || message.Questions[0].Type.Code != types.SOA.Code {
+		return reply(message, types.FormatError)
+	}
+
+	name := message.Questions[0].Name
+	secondary := request.View.Zones.Get(name)
+
+	if secondary == nil || !secondary.Secondary || request.View.Secondaries == nil {
+		log.Warnf("Ignoring NOTIFY from %v for zone %s, it isn't a secondary zone", request.Client, name)
+
+		return reply(message, types.NotAuthoritative)
+	}
+
+	if !request.View.Secondaries.FromPrimary(secondary.Name, request.Client) {
+		log.Warnf("Refusing NOTIFY from %v for zone %s, it isn't one of its primaries", request.Client, secondary.Name)
+
+		return reply(message, types.Refuced)
+	}
+
+	log.Infof("NOTIFY received from %v for zone %s", request.Client, secondary.Name)
+	request.View.Secondaries.Refresh(secondary.Name)
+
+	response := reply(message, types.NoError)
+	response.Header.AuthoritativeAnswer = true
+
+	return response
+}
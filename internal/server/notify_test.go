@@ -0,0 +1,248 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// testNotify - NOTIFY of a name, or a query when opcode isn't Notify
+func testNotify(t *testing.T, opcode types.OpCode, name string, qtype types.QType) []byte {
+	data, err := parser.BuildDNSMessage(types.DNSMessage{
+		Header:     types.DNSHeader{Identifier: 7, OpCode: opcode, AuthoritativeAnswer: true, RCode: types.NoError},
+		Questions:  []types.DNSQuestion{{Name: name, Type: qtype, Class: types.IN}},
+		Answers:    []types.DNSResource{},
+		Authority:  []types.DNSResource{},
+		Additional: []types.DNSResource{},
+	})
+
+	if err != nil {
+		t.Fatalf("BuildDNSMessage() error = %v", err)
+	}
+
+	return data
+}
+
+func TestReceiveNotify(t *testing.T) {
+	view, err := NewView(config.View{Zones: []config.Zone{
+		{
+			Name:    "example.com",
+			Records: []config.Record{{Name: "www", Type: types.A, Value: "192.0.2.10", TTL: 300}},
+		},
+		{
+			Name:      "secondary.example",
+			Type:      "secondary",
+			Primaries: []string{"192.0.2.53", "192.0.2.54:5300"},
+		},
+	}}, config.Global{}, tsig.NewKeyring())
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	// Stand-in of the refresh loop, without checking the primaries
+	trigger := make(chan bool, 1)
+	view.Secondaries.running["secondary.example"] = trigger
+	view.Secondaries.resolvePrimaries(view.Zones.Get("secondary.example"))
+
+	server := &Server{Mode: "udp", Views: []*View{view}, Keys: tsig.NewKeyring()}
+	primary := &net.UDPAddr{IP: net.ParseIP("192.0.2.54"), Port: 5300}
+
+	tests := []struct {
+		name        string
+		query       []byte
+		client      net.Addr
+		wantOpCode  types.OpCode
+		wantRCode   types.RCode
+		wantRefresh bool
+	}{
+		{
+			name:       "Query of the SOA",
+			query:      testNotify(t, types.Query, "secondary.example", types.SOA),
+			client:     primary,
+			wantOpCode: types.Query,
+			wantRCode:  types.ServerFailure,
+		},
+		{
+			name:       "NOTIFY of another type",
+			query:      testNotify(t, types.Notify, "secondary.example", types.A),
+			client:     primary,
+			wantOpCode: types.Notify,
+			wantRCode:  types.FormatError,
+		},
+		{
+			name:       "NOTIFY of a primary zone",
+			query:      testNotify(t, types.Notify, "example.com", types.SOA),
+			client:     primary,
+			wantOpCode: types.Notify,
+			wantRCode:  types.NotAuthoritative,
+		},
+		{
+			name:       "NOTIFY from another server",
+			query:      testNotify(t, types.Notify, "secondary.example", types.SOA),
+			client:     &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 53},
+			wantOpCode: types.Notify,
+			wantRCode:  types.Refuced,
+		},
+		{
+			name:        "NOTIFY from a primary",
+			query:       testNotify(t, types.Notify, "secondary.example", types.SOA),
+			client:      primary,
+			wantOpCode:  types.Notify,
+			wantRCode:   types.NoError,
+			wantRefresh: true,
+		},
+		{
+			name:        "NOTIFY from a primary on another port",
+			query:       testNotify(t, types.Notify, "SECONDARY.example.", types.SOA),
+			client:      &net.UDPAddr{IP: net.ParseIP("192.0.2.53"), Port: 40000},
+			wantOpCode:  types.Notify,
+			wantRCode:   types.NoError,
+			wantRefresh: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := server.serve(tt.query, tt.client)
+
+			if len(responses) != 1 {
+				t.Fatalf("serve() returned %d responses, want 1", len(responses))
+			}
+
+			response, err := parser.ParseDNSMessage(responses[0])
+
+			if err != nil {
+				t.Fatalf("ParseDNSMessage() error = %v", err)
+			}
+
+			if diff := cmp.Diff(tt.wantOpCode.Code, response.Header.OpCode.Code); diff != "" {
+				t.Errorf("serve() opcode mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.wantRCode.Code, response.Header.RCode.Code); diff != "" {
+				t.Errorf("serve() rcode mismatch (-want +got):\n%s", diff)
+			}
+
+			refreshed := false
+
+			select {
+			case <-trigger:
+				refreshed = true
+			default:
+			}
+
+			if refreshed != tt.wantRefresh {
+				t.Errorf("serve() refreshed = %v, want %v", refreshed, tt.wantRefresh)
+			}
+
+			if tt.wantRefresh && !response.Header.AuthoritativeAnswer {
+				t.Errorf("serve() response without AA")
+			}
+		})
+	}
+}
+
+func TestNotifyRetry(t *testing.T) {
+	defer func(interval time.Duration) { notifyInterval = interval }(notifyInterval)
+	notifyInterval = 200 * time.Millisecond
+
+	view, err := NewView(config.View{Zones: []config.Zone{
+		{
+			Name:    "example.com",
+			Records: []config.Record{{Name: "www", Type: types.A, Value: "192.0.2.10", TTL: 300}},
+		},
+	}}, config.Global{}, tsig.NewKeyring())
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		// failures - Attempts answered with the opcode of a query
+		failures     int32
+		wantAttempts int32
+	}{
+		{
+			name:         "Answered",
+			failures:     0,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Answered after a wrong response",
+			failures:     1,
+			wantAttempts: 2,
+		},
+		{
+			name:         "Never answered",
+			failures:     notifyAttempts,
+			wantAttempts: notifyAttempts,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+			if err != nil {
+				t.Fatalf("ListenPacket() error = %v", err)
+			}
+			defer conn.Close()
+
+			var attempts atomic.Int32
+
+			go func() {
+				buffer := make([]byte, 512)
+
+				for {
+					n, client, err := conn.ReadFrom(buffer)
+
+					if err != nil {
+						return
+					}
+
+					message, err := parser.ParseDNSMessage(buffer[:n])
+
+					if err != nil {
+						continue
+					}
+
+					response := reply(message, types.NoError)
+
+					if attempts.Add(1) <= tt.failures {
+						response.Header.OpCode = types.Query
+					}
+
+					data, _ := parser.BuildDNSMessage(response)
+					conn.WriteTo(data, client)
+				}
+			}()
+
+			start := time.Now()
+			notify(view.Zones.Get("example.com"), conn.LocalAddr().String())
+			elapsed := time.Since(start)
+
+			if diff := cmp.Diff(tt.wantAttempts, attempts.Load()); diff != "" {
+				t.Errorf("notify() attempts mismatch (-want +got):\n%s", diff)
+			}
+
+			// Only waits between the attempts
+			if wait := time.Duration(tt.wantAttempts) * notifyInterval; elapsed >= wait {
+				t.Errorf("notify() took %v, want less than %v", elapsed, wait)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"net/netip"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/internal/querylog"
+	"github.com/lucasdc6/gdns/pkg/rpz"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+// applyPolicy - Answer a request with the action of a rule of the
+// response policy zones, with no responses to drop it. The passthru
+// rules, and the tcp-only rules out of UDP, don't change the answer
+// and return false
+func (server *Server) applyPolicy(request *Request, rule rpz.Rule) ([]types.DNSMessage, bool) {
+	message := request.Message
+	question := message.Questions[0]
+	request.Policy = &rule
+	log.Debugf("Query of %s from %v matched the %s rule %s of policy zone %s: %s", question.Name, request.Client, rule.Trigger, rule.Name, rule.Zone, rule.Action)
+
+	var response types.DNSMessage
+
+	switch rule.Action {
+	case rpz.Passthru:
+		return nil, false
+	case rpz.TCPOnly:
+		if request.Transport != "udp" {
+			return nil, false
+		}
+
+		response = reply(message, types.NoError)
+		response.Header.TruncatedMessage = true
+	case rpz.Drop:
+		request.Source = querylog.Policy
+
+		return nil, true
+	case rpz.NXDomain:
+		response = reply(message, types.NXDomain)
+	case rpz.NoData:
+		response = reply(message, types.NoError)
+	default:
+		response = reply(message, types.NoError)
+		response.Answers = rule.Answer(question)
+	}
+
+	request.Source = querylog.Policy
+
+	return []types.DNSMessage{response}, true
+}
+
+// checkResponse - Apply the response-ip and nsdname rules of the
+// response policy zones to the response of a request, answered by
+// queryZone or the forwarder when it's nil. The requests already
+// matching a qname rule aren't checked again
+func (server *Server) checkResponse(request *Request, response types.DNSMessage, queryZone *zone.Zone) []types.DNSMessage {
+	if request.Policy != nil || server.Policies.Size() == 0 {
+		return []types.DNSMessage{response}
+	}
+
+	if rule, found := server.responseRule(response, queryZone); found {
+		if responses, applied := server.applyPolicy(request, rule); applied {
+			return responses
+		}
+	}
+
+	return []types.DNSMessage{response}
+}
+
+// responseRule - First rule matching an address of the answers of a
+// response, or one of its name servers: the NS records of the response
+// and, for the local answers, of the zone
+func (server *Server) responseRule(response types.DNSMessage, queryZone *zone.Zone) (rpz.Rule, bool) {
+	for _, record := range response.Answers {
+		if record.Type.Code != types.A.Code && record.Type.Code != types.AAAA.Code {
+			continue
+		}
+
+		address, err := netip.ParseAddr(record.RData)
+
+		if err != nil {
+			continue
+		}
+
+		if rule, found := server.Policies.MatchIP(address); found {
+			return rule, true
+		}
+	}
+
+	records := append(append([]types.DNSResource{}, response.Answers...), response.Authority...)
+
+	if queryZone != nil {
+		records = append(records, queryZone.Lookup(queryZone.Name, types.NS).Answers...)
+	}
+
+	for _, record := range records {
+		if record.Type.Code != types.NS.Code {
+			continue
+		}
+
+		if rule, found := server.Policies.MatchNameServer(record.RData); found {
+			return rule, true
+		}
+	}
+
+	return rpz.Rule{}, false
+}
@@ -0,0 +1,111 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/internal/metrics"
+	"github.com/lucasdc6/gdns/pkg/errors"
+)
+
+// doqProtocolError - Error code of DNS over QUIC for the messages
+// breaking the protocol (RFC 9250 - Section 4.3)
+const doqProtocolError = 0x2
+
+// startQUICServer - Listen for DNS over QUIC connections (RFC 9250),
+// with a query and its responses on each stream
+func startQUICServer(server *Server) {
+	config := server.TLS.Clone()
+	config.NextProtos = []string{"doq"}
+	conn, err := server.listenPacket("udp")
+
+	if err != nil {
+		log.Fatalf("Error starting the server: %v\n", err)
+		os.Exit(errors.StartingServer)
+	}
+
+	listener, err := quic.Listen(conn, config, &quic.Config{MaxIdleTimeout: tcpIdleTimeout})
+
+	if err != nil {
+		log.Fatalf("Error starting the server: %v\n", err)
+		os.Exit(errors.StartingServer)
+	}
+
+	log.Printf("QUIC Server started at %s\n", server.localAddress())
+
+	for {
+		conn, err := listener.Accept(context.Background())
+
+		if err != nil {
+			log.Fatalf("Error when try to establish connection: %v", err)
+			os.Exit(errors.EstablishingTCPConn)
+		}
+		log.Printf("QUIC connection established from %v", conn.RemoteAddr())
+
+		go handleQUICConnection(server, conn)
+	}
+}
+
+// handleQUICConnection - Answer the streams opened in a connection
+// until the client close it or stay idle
+func handleQUICConnection(server *Server, conn *quic.Conn) {
+	connections := metrics.Connections.WithLabelValues(server.Mode)
+	connections.Inc()
+	defer connections.Dec()
+
+	for {
+		stream, err := conn.AcceptStream(context.Background())
+
+		if err != nil {
+			log.Debugf("Closing QUIC connection from %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		go handleQUICStream(server, conn, stream)
+	}
+}
+
+// handleQUICStream - Answer the query of a stream, closing the
+// connection when its message ID isn't 0 (RFC 9250 - Section 4.2.1)
+func handleQUICStream(server *Server, conn *quic.Conn, stream *quic.Stream) {
+	defer stream.Close()
+	remoteaddr := conn.RemoteAddr()
+
+	stream.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+	query, err := readTCPMessage(stream)
+
+	if err != nil {
+		log.Debugf("Error reading QUIC stream from %v: %v", remoteaddr, err)
+		stream.CancelRead(doqProtocolError)
+		return
+	}
+
+	if len(query) < 2 || binary.BigEndian.Uint16(query) != 0 {
+		log.Warnf("Closing QUIC connection from %v: message ID isn't 0", remoteaddr)
+		conn.CloseWithError(doqProtocolError, "message ID isn't 0")
+		return
+	}
+
+	log.Printf("QUIC Query recived from %v", remoteaddr)
+	log.Printf("Data:\n%s\n", hex.Dump(query))
+
+	for _, response := range server.serve(query, remoteaddr) {
+		stream.SetWriteDeadline(time.Now().Add(tcpIdleTimeout))
+
+		if err := writeTCPMessage(stream, response); err != nil {
+			log.Errorf("Error sending QUIC response to %v: %v", remoteaddr, err)
+			return
+		}
+	}
+}
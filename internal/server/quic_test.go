@@ -0,0 +1,211 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	stderrors "errors"
+	"io"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/quic-go/quic-go"
+
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// testCertificate - Self-signed certificate of localhost
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+
+	if err != nil {
+		t.Fatalf("CreateCertificate() error = %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// frame - Message prefixed with its length, as the queries of the
+// streams
+func frame(message []byte) []byte {
+	data := make([]byte, 2, len(message)+2)
+	binary.BigEndian.PutUint16(data, uint16(len(message)))
+
+	return append(data, message...)
+}
+
+func TestQUICStream(t *testing.T) {
+	server := testServer(t, "quic")
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+
+	defer conn.Close()
+
+	listener, err := quic.Listen(conn, &tls.Config{Certificates: []tls.Certificate{testCertificate(t)}, NextProtos: []string{"doq"}}, &quic.Config{MaxIdleTimeout: tcpIdleTimeout})
+
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+
+			if err != nil {
+				return
+			}
+
+			go handleQUICConnection(server, conn)
+		}
+	}()
+
+	query := testQuery(t, 0, "www.example.com", types.A)
+
+	tests := []struct {
+		name string
+		// writes - Data written in the stream, one write each
+		writes [][]byte
+		// wantAnswers - Answers of each response, in order
+		wantAnswers [][]string
+		// wantCloseCode - Error code of the connection closed by the
+		// server, 0 when it's kept
+		wantCloseCode quic.ApplicationErrorCode
+	}{
+		{
+			name:        "Query with message ID 0",
+			writes:      [][]byte{frame(query)},
+			wantAnswers: [][]string{{"192.0.2.10"}},
+		},
+		{
+			name:        "Length and query in different writes",
+			writes:      [][]byte{frame(query)[:1], frame(query)[1:5], frame(query)[5:]},
+			wantAnswers: [][]string{{"192.0.2.10"}},
+		},
+		{
+			name:          "Query with other message ID",
+			writes:        [][]byte{frame(testQuery(t, 4242, "www.example.com", types.A))},
+			wantCloseCode: doqProtocolError,
+		},
+		{
+			name:        "Query shorter than its length",
+			writes:      [][]byte{frame(query)[:len(query)-4]},
+			wantAnswers: [][]string{},
+		},
+		{
+			name:        "Dropped query",
+			writes:      [][]byte{frame(testQuery(t, 0, "blocked.example.com", types.A))},
+			wantAnswers: [][]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			client, err := quic.DialAddr(ctx, conn.LocalAddr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"doq"}}, nil)
+
+			if err != nil {
+				t.Fatalf("DialAddr() error = %v", err)
+			}
+
+			defer client.CloseWithError(0, "")
+
+			stream, err := client.OpenStreamSync(ctx)
+
+			if err != nil {
+				t.Fatalf("OpenStreamSync() error = %v", err)
+			}
+
+			stream.SetDeadline(time.Now().Add(5 * time.Second))
+
+			for _, data := range tt.writes {
+				if _, err := stream.Write(data); err != nil {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+
+			// The client ends the stream after its query
+			stream.Close()
+			data, err := io.ReadAll(stream)
+
+			if tt.wantCloseCode != 0 {
+				closeErr := &quic.ApplicationError{}
+
+				if !stderrors.As(err, &closeErr) || closeErr.ErrorCode != tt.wantCloseCode {
+					t.Errorf("ReadAll() error = %v, want the connection closed with code %d", err, tt.wantCloseCode)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+
+			reader := bytes.NewReader(data)
+			answers := [][]string{}
+
+			for reader.Len() > 0 {
+				response, err := readTCPMessage(reader)
+
+				if err != nil {
+					t.Fatalf("readTCPMessage() error = %v", err)
+				}
+
+				message, err := parser.ParseDNSMessage(response)
+
+				if err != nil {
+					t.Fatalf("ParseDNSMessage() error = %v", err)
+				}
+
+				if message.Header.Identifier != 0 {
+					t.Errorf("response message ID = %d, want 0", message.Header.Identifier)
+				}
+
+				rdata := []string{}
+
+				for _, answer := range message.Answers {
+					rdata = append(rdata, answer.RData)
+				}
+
+				answers = append(answers, rdata)
+			}
+
+			if diff := cmp.Diff(tt.wantAnswers, answers); diff != "" {
+				t.Errorf("responses mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/blocklist"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/rewrite"
+	"github.com/lucasdc6/gdns/pkg/rpz"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+)
+
+// WatchReload - Reload the keys, the zones of the views, the blocklist,
+// the rewrite rules and the response policy zones from the
+// configuration file every time the process receive a SIGHUP. The
+// views can't be added or removed without restarting the server, and
+// a configuration file that can't be read keeps the current one
+func WatchReload(path string, views []*View, keys *tsig.Keyring, blocked *blocklist.Blocklist, rewrites *rewrite.Rules, policies *rpz.Policies) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		log.Infof("Reloading configuration file '%s'", path)
+
+		configuration, err := config.Read(path)
+
+		if err != nil {
+			log.Errorf("Error reloading the configuration, keeping the current one: %s", err)
+
+			continue
+		}
+
+		err = keys.Load(configuration.Keys)
+
+		if err != nil {
+			log.Errorf("Error reloading keys: %s", err)
+		}
+
+		for _, view := range views {
+			zones, ok := viewZones(configuration, view.Name)
+
+			if !ok {
+				log.Errorf("Error reloading zones: view %s removed from the configuration", view.Name)
+
+				continue
+			}
+
+			err = view.Zones.Load(zones)
+
+			if err != nil {
+				log.Errorf("Error reloading zones: %s", err)
+			}
+
+			view.Secondaries.Sync()
+		}
+
+		blocklistConfig := config.Blocklist{}
+
+		if configuration.Global.Blocklist != nil {
+			blocklistConfig = *configuration.Global.Blocklist
+		}
+
+		if err = blocked.Load(blocklistConfig); err != nil {
+			log.Errorf("Error reloading the blocklist: %s", err)
+		} else {
+			log.Infof("Blocklist reloaded with %d domains", blocked.Size())
+		}
+
+		if err = rewrites.Load(configuration.Global.Rewrite); err != nil {
+			log.Errorf("Error reloading the rewrite rules: %s", err)
+		}
+
+		if err = policies.Load(configuration.Global.ResponsePolicy); err != nil {
+			log.Errorf("Error reloading the response policy zones: %s", err)
+		}
+	}
+}
+
+// WatchShutdown - Close the outputs, flushing their pending data, and
+// exit when the process receive a SIGINT or SIGTERM
+func WatchShutdown(outputs ...io.Closer) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	<-signals
+	log.Printf("Shutting down")
+
+	for _, output := range outputs {
+		if err := output.Close(); err != nil {
+			log.Errorf("Error closing output: %s", err)
+		}
+	}
+
+	os.Exit(0)
+}
@@ -0,0 +1,30 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix && !solaris
+
+// Package server define the DNS server
+package server
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// reusePort - Set SO_REUSEPORT in a socket before binding it, so the
+// sockets of the same address share its packets
+func reusePort(network, address string, conn syscall.RawConn) error {
+	var err error
+
+	controlErr := conn.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+
+	if controlErr != nil {
+		return controlErr
+	}
+
+	return err
+}
@@ -0,0 +1,19 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix || solaris
+
+// Package server define the DNS server
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// reusePort - SO_REUSEPORT isn't available in this system
+func reusePort(network, address string, conn syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT isn't supported in %s", runtime.GOOS)
+}
@@ -0,0 +1,458 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+const (
+	// initialRetry - Time between the attempts to transfer a secondary
+	// zone without a version to serve
+	initialRetry = 10 * time.Second
+	// transferTimeout - Time waited for each message of a zone transfer
+	transferTimeout = 30 * time.Second
+)
+
+// Secondaries - Keep the secondary zones of the store up to date with
+// their primaries, following the timers of RFC 1035 - Section 4.3.5
+type Secondaries struct {
+	Zones *zone.Store
+	Keys  *tsig.Keyring
+	// running - Channel used to trigger the refresh of each zone,
+	// closed to stop it
+	running map[string]chan bool
+	// primaries - Addresses of the primaries of each zone, resolved
+	// in each check to accept their NOTIFY without a lookup
+	primaries map[string][]net.IP
+	mutex     sync.Mutex
+}
+
+// NewSecondaries - Generate the refresher of the secondary zones of a
+// store, signing the requests with the keys of the keyring
+func NewSecondaries(zones *zone.Store, keys *tsig.Keyring) *Secondaries {
+	return &Secondaries{
+		Zones:     zones,
+		Keys:      keys,
+		running:   map[string]chan bool{},
+		primaries: map[string][]net.IP{},
+	}
+}
+
+// Sync - Start the refresh of the new secondary zones of the store
+// and stop the removed ones
+func (secondaries *Secondaries) Sync() {
+	secondaries.mutex.Lock()
+	defer secondaries.mutex.Unlock()
+
+	current := map[string]bool{}
+
+	for _, secondary := range secondaries.Zones.Zones() {
+		if !secondary.Secondary {
+			continue
+		}
+
+		current[secondary.Name] = true
+
+		if _, ok := secondaries.running[secondary.Name]; !ok {
+			trigger := make(chan bool, 1)
+			secondaries.running[secondary.Name] = trigger
+
+			go secondaries.refreshLoop(secondary.Name, trigger)
+		}
+	}
+
+	for name, trigger := range secondaries.running {
+		if !current[name] {
+			close(trigger)
+			delete(secondaries.running, name)
+			delete(secondaries.primaries, name)
+		}
+	}
+}
+
+// Refresh - Check a secondary zone with its primaries right now.
+// Return false when name isn't a secondary zone
+func (secondaries *Secondaries) Refresh(name string) bool {
+	secondaries.mutex.Lock()
+	defer secondaries.mutex.Unlock()
+
+	trigger, ok := secondaries.running[zone.CanonicalName(name)]
+
+	if !ok {
+		return false
+	}
+
+	select {
+	case trigger <- true:
+	default:
+	}
+
+	return true
+}
+
+// FromPrimary - Check if an address belongs to one of the primaries of
+// a secondary zone, as resolved in its last check
+func (secondaries *Secondaries) FromPrimary(name string, client net.Addr) bool {
+	host, _, err := net.SplitHostPort(client.String())
+
+	if err != nil {
+		return false
+	}
+
+	clientIP := net.ParseIP(host)
+
+	secondaries.mutex.Lock()
+	defer secondaries.mutex.Unlock()
+
+	for _, ip := range secondaries.primaries[zone.CanonicalName(name)] {
+		if ip.Equal(clientIP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvePrimaries - Keep the addresses of the primaries of a zone,
+// out of the path of the requests
+func (secondaries *Secondaries) resolvePrimaries(secondary *zone.Zone) {
+	addresses := []net.IP{}
+
+	for _, primary := range secondary.Primaries() {
+		host, _, err := net.SplitHostPort(withDefaultPort(primary, 53))
+
+		if err != nil {
+			continue
+		}
+
+		ips, err := net.LookupIP(host)
+
+		if err != nil {
+			log.Warnf("Error resolving the primary %s of zone %s: %s", primary, secondary.Name, err)
+			continue
+		}
+
+		addresses = append(addresses, ips...)
+	}
+
+	secondaries.mutex.Lock()
+	defer secondaries.mutex.Unlock()
+
+	secondaries.primaries[secondary.Name] = addresses
+}
+
+func (secondaries *Secondaries) refreshLoop(name string, trigger chan bool) {
+	for {
+		secondary := secondaries.Zones.Get(name)
+
+		if secondary == nil || !secondary.Secondary {
+			return
+		}
+
+		timer := time.NewTimer(secondaries.refresh(secondary))
+
+		select {
+		case _, ok := <-trigger:
+			timer.Stop()
+
+			if !ok {
+				return
+			}
+		case <-timer.C:
+		}
+	}
+}
+
+// seconds - Duration of a SOA timer
+func seconds(value uint32) time.Duration {
+	if value == 0 {
+		return time.Second
+	}
+
+	return time.Duration(value) * time.Second
+}
+
+// refresh - Check the zone with its primaries and return the time to
+// wait before the next check
+func (secondaries *Secondaries) refresh(secondary *zone.Zone) time.Duration {
+	err := secondaries.check(secondary)
+	soa := secondary.SOA()
+
+	if err == nil {
+		return seconds(soa.Refresh)
+	}
+
+	log.Errorf("Error refreshing zone %s: %s", secondary.Name, err)
+
+	if secondary.Available() && time.Since(secondary.LastRefresh()) > seconds(soa.Expire) {
+		log.Errorf("Zone %s expired, it won't be served until the next transfer", secondary.Name)
+		secondary.Expire()
+	}
+
+	if !secondary.Available() {
+		return initialRetry
+	}
+
+	return seconds(soa.Retry)
+}
+
+// check - Compare the serial of the zone with the primaries,
+// transferring the zone when a primary has a newer version
+func (secondaries *Secondaries) check(secondary *zone.Zone) error {
+	var key *tsig.Key
+
+	secondaries.resolvePrimaries(secondary)
+
+	if name := secondary.TransferKey(); name != "" {
+		found, ok := secondaries.Keys.Get(name)
+
+		if !ok {
+			return fmt.Errorf("unknown key %s", name)
+		}
+
+		key = &found
+	}
+
+	err := fmt.Errorf("no primaries")
+
+	for _, primary := range secondary.Primaries() {
+		address := withDefaultPort(primary, 53)
+		var serial uint32
+
+		serial, err = querySerial(secondary.Name, address, key)
+
+		if err != nil {
+			log.Warnf("Error getting the serial of zone %s from %s: %s", secondary.Name, address, err)
+			continue
+		}
+
+		if secondary.Available() && !zone.SerialLess(secondary.Serial(), serial) {
+			log.Debugf("Zone %s is up to date with %s (serial %d)", secondary.Name, address, serial)
+			secondary.Refreshed()
+			secondaries.Zones.Save(secondary)
+
+			return nil
+		}
+
+		soa, soaTTL, records, err := requestTransfer(secondary.Name, address, key)
+
+		if err != nil {
+			log.Warnf("Error transferring zone %s from %s: %s", secondary.Name, address, err)
+			continue
+		}
+
+		changed, err := secondary.Transferred(soa, soaTTL, records)
+
+		if err != nil {
+			log.Errorf("Error recording the changes of zone %s: %s", secondary.Name, err)
+		}
+
+		if changed {
+			log.Infof("Zone %s transferred from %s with serial %d and %d records", secondary.Name, address, soa.Serial, len(records))
+			secondaries.Zones.Changed(secondary)
+		} else {
+			secondaries.Zones.Save(secondary)
+		}
+
+		return nil
+	}
+
+	return err
+}
+
+// newID - Random identifier for the queries sent by the server
+func newID() uint16 {
+	id := make([]byte, 2)
+	rand.Read(id)
+
+	return binary.BigEndian.Uint16(id)
+}
+
+// newQuery - Generate a query for a single question
+func newQuery(name string, qtype types.QType) types.DNSMessage {
+	return types.DNSMessage{
+		Header: types.DNSHeader{
+			Identifier: newID(),
+			OpCode:     types.Query,
+			RCode:      types.NoError,
+		},
+		Questions:  []types.DNSQuestion{{Name: name, Type: qtype, Class: types.IN}},
+		Answers:    []types.DNSResource{},
+		Authority:  []types.DNSResource{},
+		Additional: []types.DNSResource{},
+	}
+}
+
+// checkResponse - Verify that a response belongs to the query and
+// doesn't have an error
+func checkResponse(query types.DNSMessage, data []byte) (types.DNSMessage, error) {
+	response, err := parser.ParseDNSMessage(data)
+
+	if err != nil {
+		return response, err
+	}
+
+	if response.Header.Identifier != query.Header.Identifier || !response.Header.QR {
+		return response, fmt.Errorf("unexpected response with id %d", response.Header.Identifier)
+	}
+
+	if response.Header.RCode.Code != types.NoError.Code {
+		return response, fmt.Errorf("response with rcode %s", response.Header.RCode)
+	}
+
+	return response, nil
+}
+
+// signQuery - Sign a query with the key, when given. Return the
+// message and its MAC
+func signQuery(data []byte, key *tsig.Key) ([]byte, []byte) {
+	if key == nil {
+		return data, nil
+	}
+
+	return tsig.Sign(data, *key, nil, false)
+}
+
+// verifyResponse - Verify the signature of a response to a query
+// signed with the key, when given, and remove it
+func verifyResponse(data []byte, key *tsig.Key, previous []byte, timersOnly bool) ([]byte, []byte, error) {
+	if key == nil {
+		return data, nil, nil
+	}
+
+	return tsig.Verify(data, *key, previous, timersOnly)
+}
+
+// querySerial - Ask the serial of a zone to a server
+func querySerial(name, address string, key *tsig.Key) (uint32, error) {
+	query := newQuery(name, types.SOA)
+	data, err := parser.BuildDNSMessage(query)
+
+	if err != nil {
+		return 0, err
+	}
+
+	data, mac := signQuery(data, key)
+	res, err := sendUDP(address, data)
+
+	if err == nil && len(res) > 2 && res[2]&2 != 0 {
+		res, err = sendTCP(address, data)
+	}
+
+	if err == nil {
+		res, _, err = verifyResponse(res, key, mac, false)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	response, err := checkResponse(query, res)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for _, answer := range response.Answers {
+		if answer.Type.Code == types.SOA.Code && zone.CanonicalName(answer.Name) == name {
+			soa, err := zone.ParseSOA(answer.RData)
+
+			return soa.Serial, err
+		}
+	}
+
+	return 0, fmt.Errorf("response without SOA")
+}
+
+// requestTransfer - Get the whole content of a zone with AXFR (RFC 5936)
+func requestTransfer(name, address string, key *tsig.Key) (soa zone.SOA, soaTTL int32, records []types.DNSResource, err error) {
+	query := newQuery(name, types.AXFR)
+	data, err := parser.BuildDNSMessage(query)
+
+	if err != nil {
+		return soa, soaTTL, records, err
+	}
+
+	data, mac := signQuery(data, key)
+
+	conn, err := net.DialTimeout("tcp", address, upstreamTimeout)
+
+	if err != nil {
+		return soa, soaTTL, records, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(transferTimeout))
+	err = writeTCPMessage(conn, data)
+
+	if err != nil {
+		return soa, soaTTL, records, err
+	}
+
+	records = []types.DNSResource{}
+	soas := 0
+
+	for messages := 0; soas < 2; messages++ {
+		conn.SetDeadline(time.Now().Add(transferTimeout))
+		res, err := readTCPMessage(conn)
+
+		if err == nil {
+			// Every message is signed, chaining the MACs
+			res, mac, err = verifyResponse(res, key, mac, messages > 0)
+		}
+
+		if err != nil {
+			return soa, soaTTL, records, err
+		}
+
+		response, err := checkResponse(query, res)
+
+		if err != nil {
+			return soa, soaTTL, records, err
+		}
+
+		// A message without records would keep the transfer waiting
+		// for the last SOA until the timeout
+		if len(response.Answers) == 0 {
+			return soa, soaTTL, records, fmt.Errorf("transfer message without records")
+		}
+
+		for _, answer := range response.Answers {
+			switch {
+			case soas == 0 && answer.Type.Code != types.SOA.Code:
+				return soa, soaTTL, records, fmt.Errorf("transfer doesn't start with a SOA")
+			case answer.Type.Code == types.SOA.Code && zone.CanonicalName(answer.Name) == name:
+				soas++
+
+				if soas == 1 {
+					soa, err = zone.ParseSOA(answer.RData)
+					soaTTL = answer.TTL
+
+					if err != nil {
+						return soa, soaTTL, records, err
+					}
+				}
+			case soas == 1:
+				records = append(records, answer)
+			}
+		}
+	}
+
+	return soa, soaTTL, records, nil
+}
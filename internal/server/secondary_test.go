@@ -0,0 +1,269 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// testKeys - Keyring with the key transfer
+func testKeys(t *testing.T, secret string) *tsig.Keyring {
+	keys := tsig.NewKeyring()
+
+	if err := keys.Load([]config.Key{{Name: "transfer", Secret: secret}}); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	return keys
+}
+
+// testPrimary - Serve a zone over TCP, answering over UDP only with
+// truncated responses, or REFUSED while down is set. Return its
+// address
+func testPrimary(t *testing.T, zoneConfig config.Zone, keys *tsig.Keyring, down *atomic.Bool) string {
+	view, err := NewView(config.View{Zones: []config.Zone{zoneConfig}}, config.Global{}, keys)
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	go listenTCPData(&Server{Mode: "tcp", Views: []*View{view}, Keys: keys}, listener)
+
+	go func() {
+		buffer := make([]byte, 512)
+
+		for {
+			n, client, err := conn.ReadFrom(buffer)
+
+			if err != nil {
+				return
+			}
+
+			message, err := parser.ParseDNSMessage(buffer[:n])
+
+			if err != nil {
+				continue
+			}
+
+			response := reply(message, types.NoError)
+
+			if down.Load() {
+				response.Header.RCode = types.Refuced
+			} else {
+				response.Header.TruncatedMessage = true
+			}
+
+			data, _ := parser.BuildDNSMessage(response)
+			conn.WriteTo(data, client)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestSecondariesRefresh(t *testing.T) {
+	secret := "c2VjcmV0IG9mIHRoZSB0cmFuc2ZlcnMgb2YgdGhlIHRlc3Q="
+
+	// More records than a message holds, so the MACs are chained
+	records := []config.Record{
+		{Name: "@", Type: types.SOA, Value: "ns.example.com. hostmaster.example.com. 1 3600 600 1 120", TTL: 3600},
+	}
+
+	for i := 0; i < 150; i++ {
+		records = append(records, config.Record{Name: fmt.Sprintf("host%d", i), Type: types.A, Value: fmt.Sprintf("192.0.2.%d", i), TTL: 300})
+	}
+
+	var down atomic.Bool
+	address := testPrimary(t, config.Zone{Name: "example.com", TransferKey: "transfer", Records: records}, testKeys(t, secret), &down)
+
+	view, err := NewView(config.View{Zones: []config.Zone{
+		{Name: "example.com", Type: "secondary", Primaries: []string{address}, TransferKey: "transfer"},
+	}}, config.Global{}, testKeys(t, secret))
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	secondary := view.Zones.Get("example.com")
+
+	steps := []struct {
+		name string
+		down bool
+		// wait - Time waited before the refresh
+		wait          time.Duration
+		wantNext      time.Duration
+		wantAvailable bool
+	}{
+		{
+			name:          "Without a version and the primary refusing",
+			down:          true,
+			wantNext:      initialRetry,
+			wantAvailable: false,
+		},
+		{
+			name:          "Transfer",
+			wantNext:      3600 * time.Second,
+			wantAvailable: true,
+		},
+		{
+			name:          "Up to date",
+			wantNext:      3600 * time.Second,
+			wantAvailable: true,
+		},
+		{
+			name:          "Primary refusing",
+			down:          true,
+			wantNext:      600 * time.Second,
+			wantAvailable: true,
+		},
+		{
+			name:          "Expired",
+			down:          true,
+			wait:          1100 * time.Millisecond,
+			wantNext:      initialRetry,
+			wantAvailable: false,
+		},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			down.Store(step.down)
+			time.Sleep(step.wait)
+
+			if diff := cmp.Diff(step.wantNext, view.Secondaries.refresh(secondary)); diff != "" {
+				t.Errorf("refresh() mismatch (-want +got):\n%s", diff)
+			}
+
+			if got := secondary.Available(); got != step.wantAvailable {
+				t.Fatalf("Available() = %v, want %v", got, step.wantAvailable)
+			}
+
+			// The records of the primary, but its SOA
+			if got := len(secondary.Records()); step.wantAvailable && got != len(records)-1 {
+				t.Errorf("Records() returned %d records, want %d", got, len(records)-1)
+			}
+		})
+	}
+}
+
+func TestRequestTransfer(t *testing.T) {
+	tests := []struct {
+		name string
+		// answers - Records of each message sent by the primary
+		answers [][]types.DNSResource
+		wantErr bool
+	}{
+		{
+			name: "Complete transfer",
+			answers: [][]types.DNSResource{
+				{
+					{Name: "example.com", Type: types.SOA, Class: types.IN, TTL: 3600, RData: "ns.example.com. hostmaster.example.com. 1 3600 600 604800 120"},
+					{Name: "www.example.com", Type: types.A, Class: types.IN, TTL: 300, RData: "192.0.2.10"},
+				},
+				{
+					{Name: "example.com", Type: types.SOA, Class: types.IN, TTL: 3600, RData: "ns.example.com. hostmaster.example.com. 1 3600 600 604800 120"},
+				},
+			},
+		},
+		{
+			name:    "Message without records",
+			answers: [][]types.DNSResource{{}},
+			wantErr: true,
+		},
+		{
+			name: "Without the first SOA",
+			answers: [][]types.DNSResource{
+				{{Name: "www.example.com", Type: types.A, Class: types.IN, TTL: 300, RData: "192.0.2.10"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+			if err != nil {
+				t.Fatalf("Listen() error = %v", err)
+			}
+			defer listener.Close()
+
+			// Primary sending the messages and keeping the connection
+			// open, as a transfer without its last SOA
+			go func() {
+				conn, err := listener.Accept()
+
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+
+				data, err := readTCPMessage(conn)
+
+				if err != nil {
+					return
+				}
+
+				query, err := parser.ParseDNSMessage(data)
+
+				if err != nil {
+					return
+				}
+
+				for _, answers := range tt.answers {
+					response := reply(query, types.NoError)
+					response.Answers = answers
+					data, _ := parser.BuildDNSMessage(response)
+					writeTCPMessage(conn, data)
+				}
+
+				readTCPMessage(conn)
+			}()
+
+			done := make(chan error, 1)
+
+			go func() {
+				_, _, _, err := requestTransfer("example.com", listener.Addr().String(), nil)
+				done <- err
+			}()
+
+			select {
+			case err := <-done:
+				if (err != nil) != tt.wantErr {
+					t.Errorf("requestTransfer() error = %v, wantErr %v", err, tt.wantErr)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("requestTransfer() didn't return")
+			}
+		})
+	}
+}
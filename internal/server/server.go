@@ -6,19 +6,57 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"encoding/hex"
-	"fmt"
+	stderrors "errors"
+	"io"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/lucasdc6/gdns/internal/capture"
+	"github.com/lucasdc6/gdns/internal/metrics"
+	"github.com/lucasdc6/gdns/internal/querylog"
+	"github.com/lucasdc6/gdns/internal/ratelimit"
+	"github.com/lucasdc6/gdns/internal/tap"
+	"github.com/lucasdc6/gdns/pkg/blocklist"
 	"github.com/lucasdc6/gdns/pkg/config"
 	"github.com/lucasdc6/gdns/pkg/errors"
-	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/rewrite"
+	"github.com/lucasdc6/gdns/pkg/rpz"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/zone"
 )
 
+// tcpIdleTimeout - Time waited for a new query in an open TCP connection
+const tcpIdleTimeout = 10 * time.Second
+
+const (
+	// udpPacketSize - Size of the buffers of the UDP packets, the
+	// largest UDP payload
+	udpPacketSize = 65535
+	// defaultUDPWorkers - Queries answered at the same time by each
+	// UDP listener without udp-workers
+	defaultUDPWorkers = 128
+)
+
+// udpPackets - Buffers of the UDP packets, reused once their query is
+// answered
+var udpPackets = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, udpPacketSize)
+
+		return &buffer
+	},
+}
+
 // Server - Configuration for the DNS server
 type Server struct {
 	Host              string
@@ -27,118 +65,387 @@ type Server struct {
 	WG                *sync.WaitGroup
 	ConfigurationFile string
 	Configuration     config.Configuration
-	Verbose           string
+	Views             []*View
+	Keys              *tsig.Keyring
+	TLS               *tls.Config
+	QueryLog          *querylog.Logger
+	Tap               *tap.Tap
+	Capture           *capture.Capture
+	RateLimit         *ratelimit.Limiter
+	Blocklist         *blocklist.Blocklist
+	Rewrite           *rewrite.Rules
+	Policies          *rpz.Policies
+	// AllowQuery - Addresses or networks allowed to query the
+	// listener, all when empty
+	AllowQuery []string
+	// Listener - Pre-opened socket of the stream transports, served
+	// instead of listening in Host and Port
+	Listener net.Listener
+	// PacketConn - Pre-opened socket of the packet transports, served
+	// instead of listening in Host and Port
+	PacketConn net.PacketConn
+	// ReusePort - UDP sockets opened with SO_REUSEPORT in Host and
+	// Port, each one with its reader
+	ReusePort int
+	Verbose   string
 }
 
-func startUDPServer(server Server) {
-	addr := net.UDPAddr{
-		IP:   net.ParseIP(server.Host),
-		Port: server.Port,
-	}
-
-	ser, err := net.ListenUDP("udp", &addr)
+func startUDPServer(server *Server) {
+	conns, err := server.listenPackets("udp")
 
 	if err != nil {
 		log.Fatalf("Error starting the server: %v\n", err)
 		os.Exit(errors.StartingServer)
 	}
 
-	log.Printf("UDP Server started at %s:%d\n", server.Host, server.Port)
-	listenUDPPackages(ser)
+	size := server.Configuration.Global.UDPWorkers
+
+	if size <= 0 {
+		size = defaultUDPWorkers
+	}
+
+	// The sockets of the listener share the workers
+	workers := make(chan struct{}, size)
+
+	log.Printf("UDP Server started at %s with %d sockets and %d workers\n", server.localAddress(), len(conns), size)
+
+	for _, conn := range conns[1:] {
+		go listenUDPPackages(server, conn, workers)
+	}
+
+	listenUDPPackages(server, conns[0], workers)
+}
+
+// listenAddress - Network and address of the listener of the server
+// for a transport network: the IPv4 and IPv6 addresses listen only in
+// their family, and the empty address in every address of both
+func (server *Server) listenAddress(network string) (string, string) {
+	ip := net.ParseIP(server.Host)
+
+	switch {
+	case ip == nil:
+	case ip.To4() != nil:
+		network += "4"
+	default:
+		network += "6"
+	}
+
+	return network, net.JoinHostPort(server.Host, strconv.Itoa(server.Port))
+}
+
+// listen - Listener of a stream transport of the server: its
+// pre-opened socket, the unix socket of Host for the unix mode, or a
+// new one in its address
+func (server *Server) listen(network string) (net.Listener, error) {
+	if server.Listener != nil {
+		return server.Listener, nil
+	}
+
+	if server.Mode == "unix" {
+		// The socket of a previous run isn't removed when it's killed
+		if info, err := os.Lstat(server.Host); err == nil && info.Mode()&os.ModeSocket != 0 {
+			os.Remove(server.Host)
+		}
+
+		listener, err := net.Listen("unix", server.Host)
+		server.Listener = listener
+
+		return listener, err
+	}
+
+	network, address := server.listenAddress(network)
+	listener, err := net.Listen(network, address)
+	server.Listener = listener
+
+	return listener, err
+}
+
+// listenPacket - Socket of a packet transport of the server: its
+// pre-opened socket, or a new one in its address
+func (server *Server) listenPacket(network string) (net.PacketConn, error) {
+	if server.PacketConn != nil {
+		return server.PacketConn, nil
+	}
+
+	network, address := server.listenAddress(network)
+	conn, err := net.ListenPacket(network, address)
+	server.PacketConn = conn
+
+	return conn, err
 }
 
-func sendUDP(dstIP string, dstPort int, data []byte) []byte {
-	p := make([]byte, 512)
+// listenPackets - Sockets of a packet transport of the server: its
+// pre-opened socket, or ReusePort new ones sharing its address with
+// SO_REUSEPORT
+func (server *Server) listenPackets(network string) ([]net.PacketConn, error) {
+	if server.PacketConn != nil || server.ReusePort <= 1 {
+		conn, err := server.listenPacket(network)
+
+		return []net.PacketConn{conn}, err
+	}
+
+	network, address := server.listenAddress(network)
+	listenConfig := net.ListenConfig{Control: reusePort}
+	conns := []net.PacketConn{}
+
+	for len(conns) < server.ReusePort {
+		conn, err := listenConfig.ListenPacket(context.Background(), network, address)
+
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+
+			return nil, err
+		}
+
+		// The next sockets use the port of the first one, chosen by
+		// the system for the port 0
+		address = conn.LocalAddr().String()
+		conns = append(conns, conn)
+	}
+
+	server.PacketConn = conns[0]
+
+	return conns, nil
+}
+
+// localAddress - Address where the server listens
+func (server *Server) localAddress() string {
+	switch {
+	case server.Listener != nil:
+		return server.Listener.Addr().String()
+	case server.PacketConn != nil:
+		return server.PacketConn.LocalAddr().String()
+	case server.Mode == "unix":
+		return server.Host
+	}
+
+	return net.JoinHostPort(server.Host, strconv.Itoa(server.Port))
+}
+
+func sendUDP(address string, data []byte) ([]byte, error) {
+	defer metrics.ObserveUpstream(address, "udp", time.Now())
+	p := make([]byte, 65535)
 	conn, err := net.ListenPacket("udp", ":0")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer conn.Close()
 
-	dst, err := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", dstIP, dstPort))
+	dst, err := net.ResolveUDPAddr("udp", address)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	_, err = conn.WriteTo(data, dst)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
+	conn.SetReadDeadline(time.Now().Add(upstreamTimeout))
 	num, _, err := conn.ReadFrom(p)
 
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+
+	log.Printf("Readed %d, with data %v", num, p[:num])
+	return p[:num], nil
+}
+
+func sendTCP(address string, data []byte) ([]byte, error) {
+	defer metrics.ObserveUpstream(address, "tcp", time.Now())
+	conn, err := net.DialTimeout("tcp", address, upstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(upstreamTimeout))
+
+	return exchangeTCP(conn, data)
+}
+
+// withDefaultPort - Add the port to the addresses without one
+func withDefaultPort(address string, port int) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+
+	return net.JoinHostPort(strings.Trim(address, "[]"), strconv.Itoa(port))
+}
+
+// exchangeTCP - Send a length prefixed message and read the response
+func exchangeTCP(conn net.Conn, data []byte) ([]byte, error) {
+	err := writeTCPMessage(conn, data)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Printf("Readed %d, with data %v", num, p)
-	return p
+	return readTCPMessage(conn)
 }
 
-func listenUDPPackages(conn *net.UDPConn) {
+// readTCPMessage - Read a message prefixed with its length
+// (RFC 1035 - Section 4.2.2)
+func readTCPMessage(conn io.Reader) ([]byte, error) {
+	length := make([]byte, 2)
 
-	p := make([]byte, 512)
+	_, err := io.ReadFull(conn, length)
+	if err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, binary.BigEndian.Uint16(length))
+
+	_, err = io.ReadFull(conn, message)
+	if err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// writeTCPMessage - Write a message prefixed with its length
+// (RFC 1035 - Section 4.2.2)
+func writeTCPMessage(conn io.Writer, message []byte) error {
+	data := make([]byte, 2, len(message)+2)
+	binary.BigEndian.PutUint16(data, uint16(len(message)))
+
+	_, err := conn.Write(append(data, message...))
+
+	return err
+}
+
+// listenUDPPackages - Answer the packets received in a socket, each
+// one in a worker. When all the workers are busy, the socket isn't read
+// until one is free, leaving the packets in its buffer
+func listenUDPPackages(server *Server, conn net.PacketConn, workers chan struct{}) {
+	listener := conn.LocalAddr().String()
+	busy := metrics.UDPWorkers.WithLabelValues(listener)
+	saturated := metrics.UDPSaturated.WithLabelValues(listener)
 
 	for {
-		_, remoteaddr, err := conn.ReadFromUDP(p)
+		buffer := udpPackets.Get().(*[]byte)
+		n, remoteaddr, err := conn.ReadFrom(*buffer)
+
+		if stderrors.Is(err, net.ErrClosed) {
+			log.Debugf("UDP listener %s closed", listener)
+			return
+		}
 
 		if err != nil {
 			log.Fatalf("Error retriving UDP package: %v", err)
 			os.Exit(errors.RetrivingUDPPackage)
 		}
-		log.Printf("UDP Query recived from %v", remoteaddr)
-		log.Printf("Data:\n%s\n", hex.Dump(p))
-
-		parser.ParseDNSQuery(p)
-		if true {
-			log.Printf("Send query to authoritative server")
-			res := sendUDP("8.8.8.8", 53, p)
-			log.Printf("Data:\n%s\n", hex.Dump(res))
-			parser.ParseDNSQuery(res)
+
+		p := (*buffer)[:n]
+
+		select {
+		case workers <- struct{}{}:
+		default:
+			log.Debugf("All the workers of the UDP listener %s are busy", listener)
+			saturated.Inc()
+			workers <- struct{}{}
 		}
-	}
-}
 
-func starTCPServer(server Server) {
-	addr := net.TCPAddr{
-		IP:   net.ParseIP(server.Host),
-		Port: server.Port,
+		busy.Inc()
+
+		go func() {
+			defer func() {
+				busy.Dec()
+				udpPackets.Put(buffer)
+				<-workers
+			}()
+
+			log.Debugf("UDP Query recived from %v", remoteaddr)
+
+			if log.IsLevelEnabled(log.DebugLevel) {
+				log.Debugf("Data:\n%s\n", hex.Dump(p))
+			}
+
+			for _, response := range server.serve(p, remoteaddr) {
+				_, err := conn.WriteTo(response, remoteaddr)
+
+				if err != nil {
+					log.Errorf("Error sending UDP response to %v: %v", remoteaddr, err)
+				}
+			}
+		}()
 	}
+}
 
-	ser, err := net.ListenTCP("tcp", &addr)
+func starTCPServer(server *Server) {
+	ser, err := server.listen("tcp")
 
 	if err != nil {
 		log.Fatalf("Error starting the server: %v\n", err)
 		os.Exit(errors.StartingServer)
 	}
 
-	log.Printf("TCP Server started at %s:%d\n", server.Host, server.Port)
-	listenTCPData(ser)
+	log.Printf("%s Server started at %s\n", strings.ToUpper(server.Mode), server.localAddress())
+	listenTCPData(server, ser)
 }
 
-func listenTCPData(server *net.TCPListener) {
-	p := make([]byte, 512)
-
+func listenTCPData(server *Server, listener net.Listener) {
 	for {
-		conn, err := server.Accept()
-		remoteaddr := conn.RemoteAddr()
+		conn, err := listener.Accept()
+
+		if stderrors.Is(err, net.ErrClosed) {
+			log.Debugf("%s listener %s closed", strings.ToUpper(server.Mode), listener.Addr())
+			return
+		}
 
 		if err != nil {
 			log.Fatalf("Error when try to establish connection: %v", err)
 			os.Exit(errors.EstablishingTCPConn)
 		}
-		log.Printf("TCP Query recived from %v", remoteaddr)
 
-		_, err = conn.Read(p)
+		if allowed(conn.RemoteAddr(), server.Configuration.Global.Blackhole) {
+			log.Debugf("Closing %s connection from blackholed client %v", strings.ToUpper(server.Mode), conn.RemoteAddr())
+			conn.Close()
+
+			continue
+		}
+
+		log.Printf("%s connection established from %v", strings.ToUpper(server.Mode), conn.RemoteAddr())
+
+		go handleTCPConnection(server, conn)
+	}
+}
+
+// handleTCPConnection - Answer the queries received in a connection
+// until the client close it or stay idle
+func handleTCPConnection(server *Server, conn net.Conn) {
+	defer conn.Close()
+	connections := metrics.Connections.WithLabelValues(server.Mode)
+	connections.Inc()
+	defer connections.Dec()
+	remoteaddr := conn.RemoteAddr()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		query, err := readTCPMessage(conn)
 
 		if err != nil {
-			log.Fatalf("Error retriving data: %v", err)
-			os.Exit(errors.RetrivingTCPData)
+			if err != io.EOF {
+				log.Debugf("Closing %s connection from %v: %v", strings.ToUpper(server.Mode), remoteaddr, err)
+			}
+			return
 		}
 
-		log.Printf("Data: %+v\n", p)
+		log.Printf("%s Query recived from %v", strings.ToUpper(server.Mode), remoteaddr)
+		log.Printf("Data:\n%s\n", hex.Dump(query))
 
-		parser.ParseDNSQuery(p[2:])
+		for _, response := range server.serve(query, remoteaddr) {
+			conn.SetWriteDeadline(time.Now().Add(tcpIdleTimeout))
+			err = writeTCPMessage(conn, response)
+
+			if err != nil {
+				log.Errorf("Error sending TCP response to %v: %v", remoteaddr, err)
+				return
+			}
+		}
 	}
 }
 
@@ -146,16 +453,32 @@ func listenTCPData(server *net.TCPListener) {
 func Start(server Server) {
 	defer server.WG.Done()
 
-	server.Configuration = config.Load(server.ConfigurationFile)
 	if server.Verbose == "All" {
 		log.Printf("Started in verbose mode")
 	}
 
-	if server.Mode == "udp" {
-		startUDPServer(server)
+	if len(server.Views) == 0 {
+		server.Views = []*View{{Zones: zone.NewStore(""), Forwarder: forwarderAddress(server.Configuration.Global)}}
+	}
+
+	switch server.Mode {
+	case "udp":
+		startUDPServer(&server)
+
+		return
+	case "tls":
+		startTLSServer(&server)
+
+		return
+	case "https":
+		startHTTPSServer(&server)
+
+		return
+	case "quic":
+		startQUICServer(&server)
 
 		return
 	}
 
-	starTCPServer(server)
+	starTCPServer(&server)
 }
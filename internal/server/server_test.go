@@ -0,0 +1,311 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/lucasdc6/gdns/internal/metrics"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// testServer - Server of mode answering the zone example.com, with
+// private.example.com only for 198.51.100.0/24 and blocked.example.com
+// dropping every query
+func testServer(t *testing.T, mode string) *Server {
+	view, err := NewView(config.View{Zones: []config.Zone{
+		{
+			Name: "example.com",
+			Records: []config.Record{
+				{Name: "@", Type: types.SOA, Value: "ns.example.com. hostmaster.example.com. 1 3600 600 604800 120", TTL: 3600},
+				{Name: "www", Type: types.A, Value: "192.0.2.10", TTL: 300},
+				{Name: "mail", Type: types.A, Value: "192.0.2.20", TTL: 60},
+			},
+		},
+		{
+			Name:       "private.example.com",
+			AllowQuery: []string{"198.51.100.0/24"},
+			Records:    []config.Record{{Name: "@", Type: types.A, Value: "192.0.2.30", TTL: 300}},
+		},
+		{
+			Name:      "blocked.example.com",
+			Blackhole: []string{"0.0.0.0/0", "::/0"},
+			Records:   []config.Record{{Name: "@", Type: types.A, Value: "192.0.2.40", TTL: 300}},
+		},
+	}}, config.Global{}, tsig.NewKeyring())
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	return &Server{Mode: mode, Views: []*View{view}, Keys: tsig.NewKeyring()}
+}
+
+// testQuery - Query of a name and type, with the message ID id
+func testQuery(t *testing.T, id uint16, name string, qtype types.QType) []byte {
+	query, err := parser.BuildDNSMessage(types.DNSMessage{
+		Header:     types.DNSHeader{Identifier: id, OpCode: types.Query, RecursionDesired: true, RCode: types.NoError},
+		Questions:  []types.DNSQuestion{{Name: name, Type: qtype, Class: types.IN}},
+		Answers:    []types.DNSResource{},
+		Authority:  []types.DNSResource{},
+		Additional: []types.DNSResource{},
+	})
+
+	if err != nil {
+		t.Fatalf("BuildDNSMessage() error = %v", err)
+	}
+
+	return query
+}
+
+func TestListenUnix(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing func(t *testing.T, path string)
+		wantErr  bool
+	}{
+		{
+			name:     "New socket",
+			existing: func(t *testing.T, path string) {},
+		},
+		{
+			name: "Socket of a previous run",
+			existing: func(t *testing.T, path string) {
+				listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+
+				if err != nil {
+					t.Fatalf("ListenUnix() error = %v", err)
+				}
+
+				// As a killed server, the socket is kept
+				listener.SetUnlinkOnClose(false)
+				listener.Close()
+			},
+		},
+		{
+			name: "Other file in the path",
+			existing: func(t *testing.T, path string) {
+				os.WriteFile(path, []byte("zone data"), 0644)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dns.sock")
+			tt.existing(t, path)
+			server := Server{Mode: "unix", Host: path}
+
+			listener, err := server.listen("unix")
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("listen() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				// Only the sockets are removed
+				if _, err := os.Stat(path); err != nil {
+					t.Errorf("Stat() error = %v, want the file kept", err)
+				}
+
+				return
+			}
+
+			if got := server.localAddress(); got != path {
+				t.Errorf("localAddress() = %s, want %s", got, path)
+			}
+
+			conn, err := net.Dial("unix", path)
+
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+
+			conn.Close()
+			listener.Close()
+
+			// The socket is removed when the server stops
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("Lstat() error = %v, want the socket removed", err)
+			}
+		})
+	}
+}
+
+// slowForwarder - Forwarder answering every query after delay
+func slowForwarder(t *testing.T, delay time.Duration) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		for {
+			buffer := make([]byte, 512)
+			n, client, err := conn.ReadFrom(buffer)
+
+			if err != nil {
+				return
+			}
+
+			go func() {
+				message, err := parser.ParseDNSMessage(buffer[:n])
+
+				if err != nil {
+					return
+				}
+
+				time.Sleep(delay)
+				data, _ := parser.BuildDNSMessage(reply(message, types.NoError))
+				conn.WriteTo(data, client)
+			}()
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestListenUDPPackages(t *testing.T) {
+	tests := []struct {
+		name    string
+		workers int
+		// wantOrder - Identifiers of the responses, in the order received
+		wantOrder     []uint16
+		wantSaturated float64
+	}{
+		{
+			name:          "Local answer while a forward is slow",
+			workers:       4,
+			wantOrder:     []uint16{2, 1},
+			wantSaturated: 0,
+		},
+		{
+			name:          "Single worker",
+			workers:       1,
+			wantOrder:     []uint16{1, 2},
+			wantSaturated: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := testServer(t, "udp")
+			server.Views[0].Forwarder = slowForwarder(t, 500*time.Millisecond)
+
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+			if err != nil {
+				t.Fatalf("ListenPacket() error = %v", err)
+			}
+			defer conn.Close()
+
+			go listenUDPPackages(server, conn, make(chan struct{}, tt.workers))
+
+			client, err := net.Dial("udp", conn.LocalAddr().String())
+
+			if err != nil {
+				t.Fatalf("Dial() error = %v", err)
+			}
+			defer client.Close()
+
+			client.Write(testQuery(t, 1, "forwarded.test", types.A))
+			// Received while the forward is waiting
+			time.Sleep(100 * time.Millisecond)
+			client.Write(testQuery(t, 2, "www.example.com", types.A))
+
+			order := []uint16{}
+			buffer := make([]byte, 512)
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+			for range tt.wantOrder {
+				n, err := client.Read(buffer)
+
+				if err != nil {
+					t.Fatalf("Read() error = %v", err)
+				}
+
+				response, err := parser.ParseDNSMessage(buffer[:n])
+
+				if err != nil {
+					t.Fatalf("ParseDNSMessage() error = %v", err)
+				}
+
+				order = append(order, response.Header.Identifier)
+			}
+
+			if diff := cmp.Diff(tt.wantOrder, order); diff != "" {
+				t.Errorf("listenUDPPackages() order mismatch (-want +got):\n%s", diff)
+			}
+
+			saturated := testutil.ToFloat64(metrics.UDPSaturated.WithLabelValues(conn.LocalAddr().String()))
+
+			if diff := cmp.Diff(tt.wantSaturated, saturated); diff != "" {
+				t.Errorf("UDPSaturated mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConnections(t *testing.T) {
+	server := testServer(t, "tcp")
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	defer listener.Close()
+
+	go listenTCPData(server, listener)
+
+	connections := metrics.Connections.WithLabelValues("tcp")
+	// Connections of other tests closing
+	time.Sleep(100 * time.Millisecond)
+	before := testutil.ToFloat64(connections)
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	writeTCPMessage(client, testQuery(t, 1, "www.example.com", types.A))
+
+	if _, err := readTCPMessage(client); err != nil {
+		t.Fatalf("readTCPMessage() error = %v", err)
+	}
+
+	if diff := cmp.Diff(before+1, testutil.ToFloat64(connections)); diff != "" {
+		t.Errorf("Connections with the client connected mismatch (-want +got):\n%s", diff)
+	}
+
+	client.Close()
+
+	// The connection is closed by the server after reading the EOF
+	deadline := time.Now().Add(2 * time.Second)
+
+	for testutil.ToFloat64(connections) != before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if diff := cmp.Diff(before, testutil.ToFloat64(connections)); diff != "" {
+		t.Errorf("Connections with the client closed mismatch (-want +got):\n%s", diff)
+	}
+}
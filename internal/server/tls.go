@@ -0,0 +1,69 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/errors"
+)
+
+// TLSConfig - TLS configuration of the encrypted listeners, from the
+// certificate and key files in PEM format. When clientCA is set, the
+// clients must present a certificate signed by it
+func TLSConfig(cert, key, clientCA string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(cert, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCA != "" {
+		data, err := ioutil.ReadFile(clientCA)
+
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("%s: no certificates found", clientCA)
+		}
+
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// startTLSServer - Listen for DNS over TLS connections (RFC 7858),
+// answered as the TCP connections
+func startTLSServer(server *Server) {
+	config := server.TLS.Clone()
+	config.NextProtos = []string{"dot"}
+	ser, err := server.listen("tcp")
+
+	if err != nil {
+		log.Fatalf("Error starting the server: %v\n", err)
+		os.Exit(errors.StartingServer)
+	}
+
+	log.Printf("TLS Server started at %s\n", server.localAddress())
+	listenTCPData(server, tls.NewListener(ser, config))
+}
@@ -0,0 +1,201 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// writeCertificate - Write a certificate and its key in PEM format
+// to the directory. Return the paths of both files
+func writeCertificate(t *testing.T, directory string, certificate tls.Certificate) (string, string) {
+	key, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey() error = %v", err)
+	}
+
+	certPath := filepath.Join(directory, "cert.pem")
+	keyPath := filepath.Join(directory, "key.pem")
+	os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0644)
+	os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0600)
+
+	return certPath, keyPath
+}
+
+func TestTLSConfig(t *testing.T) {
+	directory := t.TempDir()
+	certPath, keyPath := writeCertificate(t, directory, testCertificate(t))
+	invalidPath := filepath.Join(directory, "invalid.pem")
+	os.WriteFile(invalidPath, []byte("not a certificate"), 0644)
+	_, otherKeyPath := writeCertificate(t, t.TempDir(), testCertificate(t))
+
+	tests := []struct {
+		name           string
+		cert           string
+		key            string
+		clientCA       string
+		wantClientAuth tls.ClientAuthType
+		wantErr        bool
+	}{
+		{
+			name:           "Certificate",
+			cert:           certPath,
+			key:            keyPath,
+			wantClientAuth: tls.NoClientCert,
+		},
+		{
+			name:           "Certificate and client CA",
+			cert:           certPath,
+			key:            keyPath,
+			clientCA:       certPath,
+			wantClientAuth: tls.RequireAndVerifyClientCert,
+		},
+		{
+			name:    "Missing certificate",
+			cert:    filepath.Join(directory, "missing.pem"),
+			key:     keyPath,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid certificate",
+			cert:    invalidPath,
+			key:     keyPath,
+			wantErr: true,
+		},
+		{
+			name:    "Key of another certificate",
+			cert:    certPath,
+			key:     otherKeyPath,
+			wantErr: true,
+		},
+		{
+			name:     "Missing client CA",
+			cert:     certPath,
+			key:      keyPath,
+			clientCA: filepath.Join(directory, "missing.pem"),
+			wantErr:  true,
+		},
+		{
+			name:     "Client CA without certificates",
+			cert:     certPath,
+			key:      keyPath,
+			clientCA: invalidPath,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := TLSConfig(tt.cert, tt.key, tt.clientCA)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if diff := cmp.Diff(tt.wantClientAuth, config.ClientAuth); diff != "" {
+				t.Errorf("TLSConfig() ClientAuth mismatch (-want +got):\n%s", diff)
+			}
+
+			if (config.ClientCAs != nil) != (tt.clientCA != "") {
+				t.Errorf("TLSConfig() ClientCAs = %v, want them only with a client CA", config.ClientCAs)
+			}
+
+			if len(config.Certificates) != 1 {
+				t.Errorf("TLSConfig() returned %d certificates, want 1", len(config.Certificates))
+			}
+		})
+	}
+}
+
+func TestTLSServer(t *testing.T) {
+	certificate := testCertificate(t)
+	server := testServer(t, "tls")
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{certificate}}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+
+	defer listener.Close()
+	server.Listener = listener
+
+	go startTLSServer(server)
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+
+	if err != nil {
+		t.Fatalf("ParseCertificate() error = %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "localhost", NextProtos: []string{"dot"}})
+
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+
+	defer conn.Close()
+
+	if diff := cmp.Diff("dot", conn.ConnectionState().NegotiatedProtocol); diff != "" {
+		t.Errorf("NegotiatedProtocol mismatch (-want +got):\n%s", diff)
+	}
+
+	// Two queries in a single write, and the last one split in two
+	first := frame(testQuery(t, 1, "www.example.com", types.A))
+	second := frame(testQuery(t, 2, "mail.example.com", types.A))
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write(append(first, second[:3]...))
+	conn.Write(second[3:])
+
+	want := map[uint16][]string{1: {"192.0.2.10"}, 2: {"192.0.2.20"}}
+	got := map[uint16][]string{}
+
+	for range want {
+		data, err := readTCPMessage(conn)
+
+		if err != nil {
+			t.Fatalf("readTCPMessage() error = %v", err)
+		}
+
+		response, err := parser.ParseDNSMessage(data)
+
+		if err != nil {
+			t.Fatalf("ParseDNSMessage() error = %v", err)
+		}
+
+		rdata := []string{}
+
+		for _, answer := range response.Answers {
+			rdata = append(rdata, answer.RData)
+		}
+
+		got[response.Header.Identifier] = rdata
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("responses mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+// configMutex - Serialize the writes of the configuration file
+var configMutex sync.Mutex
+
+// receiveUpdate - Apply a dynamic update (RFC 2136) to a primary zone
+func (server *Server) receiveUpdate(request Request) types.DNSMessage {
+	message := request.Message
+
+	// The zone section is sent in the questions
+	if len(message.Questions) != 1 || message.Questions[0].Type.Code != types.SOA.Code {
+		return reply(message, types.FormatError)
+	}
+
+	name := message.Questions[0].Name
+	updateZone := request.View.Zones.Get(name)
+
+	if updateZone == nil {
+		return reply(message, types.NotAuthoritative)
+	}
+
+	if updateZone.Secondary {
+		log.Warnf("Refusing update from %v for the secondary zone %s", request.Client, updateZone.Name)
+
+		return reply(message, types.Refuced)
+	}
+
+	if !updateAllowed(request, updateZone) {
+		log.Warnf("Refusing update from %v for zone %s", request.Client, updateZone.Name)
+
+		return reply(message, types.Refuced)
+	}
+
+	rcode, changed, err := updateZone.ApplyUpdate(message.Answers, message.Authority)
+
+	if err != nil {
+		log.Errorf("Error recording the update of zone %s: %s", updateZone.Name, err)
+	}
+
+	log.Infof("Update from %v for zone %s: %s", request.Client, updateZone.Name, rcode)
+
+	if changed {
+		request.View.Zones.Changed(updateZone)
+		server.writeUpdate(request.View, updateZone)
+	}
+
+	return reply(message, rcode)
+}
+
+// writeUpdate - Write the records of an updated zone of a view back to
+// the configuration file, when enabled with write-updates
+func (server *Server) writeUpdate(view *View, updateZone *zone.Zone) {
+	if server.ConfigurationFile == "" {
+		return
+	}
+
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	// The update is already applied, a file that can't be read only
+	// loses its write back
+	configuration, err := config.Read(server.ConfigurationFile)
+
+	if err != nil {
+		log.Errorf("Error writing the update of zone %s to %s: %s", updateZone.Name, server.ConfigurationFile, err)
+
+		return
+	}
+
+	if !configuration.Global.WriteUpdates {
+		return
+	}
+
+	// The zones share their array with the configuration
+	zones, _ := viewZones(configuration, view.Name)
+
+	for i, zoneConfig := range zones {
+		if zone.CanonicalName(zoneConfig.Name) == updateZone.Name {
+			zones[i] = zone.ToConfig(updateZone, zoneConfig)
+		}
+	}
+
+	err = config.Save(server.ConfigurationFile, configuration)
+
+	if err != nil {
+		log.Errorf("Error writing the update of zone %s to %s: %s", updateZone.Name, server.ConfigurationFile, err)
+	}
+}
+
+// updateAllowed - Check if the client is allowed to update the zone:
+// its address must be in allow-update and the request signed with the
+// update-key, when each one is configured
+func updateAllowed(request Request, updateZone *zone.Zone) bool {
+	networks := updateZone.AllowUpdate()
+	key := updateZone.UpdateKey()
+
+	if len(networks) == 0 && key == "" {
+		return false
+	}
+
+	if len(networks) > 0 && !allowed(request.Client, networks) {
+		return false
+	}
+
+	return key == "" || request.SignedWith(key)
+}
+
+// allowed - Check if the address of a client is one of the addresses
+// or belongs to one of the networks (CIDR notation) of the list, whose
+// entries are checked by config.Validate. The clients of the unix
+// sockets are local, with the loopback addresses
+func allowed(client net.Addr, list []string) bool {
+	if _, ok := client.(*net.UnixAddr); ok {
+		return allowedIP(net.IPv4(127, 0, 0, 1), list) || allowedIP(net.IPv6loopback, list)
+	}
+
+	host, _, err := net.SplitHostPort(client.String())
+
+	if err != nil {
+		return false
+	}
+
+	return allowedIP(net.ParseIP(host), list)
+}
+
+// allowedIP - Check if an address is one of the addresses or belongs to
+// one of the networks of the list
+func allowedIP(clientIP net.IP, list []string) bool {
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			if net.ParseIP(entry).Equal(clientIP) {
+				return true
+			}
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+
+		if err == nil && network.Contains(clientIP) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,123 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define all the tests for the server package
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+func TestAllowed(t *testing.T) {
+	udp := func(address string) net.Addr {
+		return &net.UDPAddr{IP: net.ParseIP(address), Port: 5353}
+	}
+
+	tests := []struct {
+		name   string
+		client net.Addr
+		list   []string
+		want   bool
+	}{
+		{
+			name:   "IPv4 address",
+			client: udp("192.0.2.1"),
+			list:   []string{"192.0.2.1"},
+			want:   true,
+		},
+		{
+			name:   "Other IPv4 address",
+			client: udp("192.0.2.2"),
+			list:   []string{"192.0.2.1"},
+			want:   false,
+		},
+		{
+			name:   "IPv4 network",
+			client: &net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 40000},
+			list:   []string{"192.0.2.1", "10.0.0.0/8"},
+			want:   true,
+		},
+		{
+			name:   "Outside of the IPv4 network",
+			client: udp("11.0.0.1"),
+			list:   []string{"10.0.0.0/8"},
+			want:   false,
+		},
+		{
+			name:   "IPv6 address",
+			client: udp("2001:db8::1"),
+			list:   []string{"2001:db8:0:0:0:0:0:1"},
+			want:   true,
+		},
+		{
+			name:   "IPv6 network",
+			client: udp("2001:db8:1::53"),
+			list:   []string{"2001:db8::/32"},
+			want:   true,
+		},
+		{
+			name:   "Outside of the IPv6 network",
+			client: udp("2001:db9::1"),
+			list:   []string{"2001:db8::/32"},
+			want:   false,
+		},
+		{
+			name:   "IPv4-mapped IPv6 address in an IPv4 network",
+			client: udp("::ffff:192.0.2.1"),
+			list:   []string{"192.0.2.0/24"},
+			want:   true,
+		},
+		{
+			name:   "IPv4 address in an IPv6 network",
+			client: udp("192.0.2.1"),
+			list:   []string{"::/0"},
+			want:   false,
+		},
+		{
+			name:   "Unix socket client with the IPv4 loopback",
+			client: &net.UnixAddr{Name: "@", Net: "unix"},
+			list:   []string{"127.0.0.1"},
+			want:   true,
+		},
+		{
+			name:   "Unix socket client with the IPv6 loopback",
+			client: &net.UnixAddr{Name: "@", Net: "unix"},
+			list:   []string{"::1/128"},
+			want:   true,
+		},
+		{
+			name:   "Unix socket client without the loopback",
+			client: &net.UnixAddr{Name: "@", Net: "unix"},
+			list:   []string{"192.0.2.0/24"},
+			want:   false,
+		},
+		{
+			name:   "Malformed entries",
+			client: udp("127.0.0.1"),
+			list:   []string{"localhost", "127.0.0.0/33", "127.0.0.1:53", ""},
+			want:   false,
+		},
+		{
+			name:   "Malformed entry before a valid one",
+			client: udp("127.0.0.1"),
+			list:   []string{"localhost", "127.0.0.0/8"},
+			want:   true,
+		},
+		{
+			name:   "Empty list",
+			client: udp("127.0.0.1"),
+			list:   []string{},
+			want:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := allowed(tt.client, tt.list); got != tt.want {
+				t.Errorf("allowed(%s, %v) = %v, want %v", tt.client, tt.list, got, tt.want)
+			}
+		})
+	}
+}
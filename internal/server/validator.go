@@ -0,0 +1,602 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/internal/capture"
+	"github.com/lucasdc6/gdns/internal/metrics"
+	"github.com/lucasdc6/gdns/internal/tap"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/dnssec"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+// validationCacheTime - Time the DS and DNSKEY records validated are kept
+const validationCacheTime = 10 * time.Minute
+
+// Validator - Validate the forwarded responses with DNSSEC, building
+// the chain of trust from the trust anchors (RFC 4035 - Section 5)
+type Validator struct {
+	// Forwarder - Server asked for the records of the chain of trust
+	Forwarder string
+	// Tap - dnstap output of the queries sent to the forwarder
+	Tap *tap.Tap
+	// Capture - pcap capture of the queries sent to the forwarder
+	Capture *capture.Capture
+
+	// anchors - DS or DNSKEY records trusted for each zone
+	anchors map[string][]types.DNSResource
+	// delegations - DS records validated for each name, empty for the
+	// names that aren't zone cuts
+	delegations map[string]validated
+	// keys - DNSKEY records validated for each zone
+	keys  map[string]validated
+	mutex sync.Mutex
+}
+
+// queryError - Error asking the forwarder for the records of the chain
+// of trust. The status of the records isn't known, so it isn't cached
+type queryError struct {
+	err error
+}
+
+func (err queryError) Error() string {
+	return err.err.Error()
+}
+
+// validated - Records of the chain of trust and their status
+type validated struct {
+	records []types.DNSResource
+	status  dnssec.Status
+	err     error
+	expires time.Time
+}
+
+// NewValidator - Generate a validator asking the forwarder, trusting
+// the DS, TA or DNSKEY records of anchors
+func NewValidator(forwarder string, anchors []config.Record) (*Validator, error) {
+	validator := &Validator{
+		Forwarder:   forwarder,
+		anchors:     map[string][]types.DNSResource{},
+		delegations: map[string]validated{},
+		keys:        map[string]validated{},
+	}
+
+	for _, anchor := range anchors {
+		record := types.DNSResource{Name: zone.CanonicalName(anchor.Name), Type: anchor.Type, Class: types.IN, RData: anchor.Value}
+
+		switch anchor.Type.Code {
+		case types.TA.Code:
+			// TA records use the format of the DS records
+			record.Type = types.DS
+		case types.DS.Code, types.DNSKEY.Code:
+		default:
+			return nil, fmt.Errorf("trust anchor %s: type %s isn't DS, TA or DNSKEY", anchor.Name, anchor.Type)
+		}
+
+		if _, err := parser.BuildRData(record.Type, record.RData); err != nil {
+			return nil, fmt.Errorf("trust anchor %s: %s", anchor.Name, err)
+		}
+
+		validator.anchors[record.Name] = append(validator.anchors[record.Name], record)
+	}
+
+	return validator, nil
+}
+
+// Resolve - Forward a query, validating the response. Bogus responses
+// are answered with SERVFAIL, and secure responses set the AD bit
+// when the client understands it (RFC 6840 - Section 5.8)
+func (validator *Validator) Resolve(message types.DNSMessage) types.DNSMessage {
+	question := message.Questions[0]
+	response, err := validator.query(question.Name, question.Type)
+
+	if err != nil {
+		log.Errorf("Error forwarding query to %s: %s", validator.Forwarder, err)
+
+		return reply(message, types.ServerFailure)
+	}
+
+	status, err := validator.validate(question, response)
+
+	if _, ok := err.(queryError); ok {
+		log.Errorf("Error building the chain of trust of %s %s: %s", question.Name, question.Type, err)
+
+		return reply(message, types.ServerFailure)
+	}
+
+	if status == dnssec.Bogus {
+		log.Warnf("Bogus response for %s %s: %s", question.Name, question.Type, err)
+
+		return reply(message, types.ServerFailure)
+	}
+
+	log.Debugf("Response for %s %s is %s", question.Name, question.Type, status)
+
+	result := reply(message, response.Header.RCode)
+	result.Header.RecursionAvailable = response.Header.RecursionAvailable
+	result.Header.AD = status == dnssec.Secure && (wantsDNSSEC(message) || message.Header.AD)
+	result.Answers = response.Answers
+	result.Authority = response.Authority
+
+	for _, resource := range response.Additional {
+		if resource.Type.Code != types.OPT.Code {
+			result.Additional = append(result.Additional, resource)
+		}
+	}
+
+	if !wantsDNSSEC(message) {
+		// RFC 4035 - Section 3.2.1: the DNSSEC records are sent
+		// only to the clients asking for them
+		result.Answers = withoutDNSSEC(result.Answers, question.Type)
+		result.Authority = withoutDNSSEC(result.Authority, question.Type)
+		result.Additional = withoutDNSSEC(result.Additional, question.Type)
+	}
+
+	return result
+}
+
+// withoutDNSSEC - Records that aren't RRSIG, NSEC or NSEC3, unless
+// they are the type asked
+func withoutDNSSEC(records []types.DNSResource, qtype types.QType) []types.DNSResource {
+	result := []types.DNSResource{}
+
+	for _, record := range records {
+		switch record.Type.Code {
+		case types.RRSIG.Code, types.NSEC.Code, types.NSEC3.Code:
+			if record.Type.Code != qtype.Code {
+				continue
+			}
+		}
+
+		result = append(result, record)
+	}
+
+	return result
+}
+
+// query - Ask the forwarder for the records of name with the DO and
+// CD bits, retrying over TCP when the response is truncated
+func (validator *Validator) query(name string, qtype types.QType) (types.DNSMessage, error) {
+	message := newQuery(name, qtype)
+	message.Header.RecursionDesired = true
+	message.Header.CD = true
+	message.Additional = []types.DNSResource{{
+		Type:  types.OPT,
+		Class: types.QClass{Name: "CLASS1232", Code: ednsUDPSize},
+		TTL:   dnssecOK,
+		RData: "\\# 0",
+	}}
+
+	data, err := parser.BuildDNSMessage(message)
+
+	if err != nil {
+		return types.DNSMessage{}, err
+	}
+
+	res, err := exchangeForwarder(validator.Tap, validator.Capture, validator.Forwarder, data, true)
+
+	if err != nil {
+		return types.DNSMessage{}, err
+	}
+
+	response, err := parser.ParseDNSMessage(res)
+
+	if err == nil && (response.Header.Identifier != message.Header.Identifier || !response.Header.QR) {
+		err = fmt.Errorf("unexpected response with id %d", response.Header.Identifier)
+	}
+
+	return response, err
+}
+
+// anchor - Closest trust anchor of name, empty when the name isn't
+// below any of them
+func (validator *Validator) anchor(name string) (string, bool) {
+	for candidate := zone.CanonicalName(name); ; candidate = parentName(candidate) {
+		if _, ok := validator.anchors[candidate]; ok {
+			return candidate, true
+		}
+
+		if candidate == "" {
+			return "", false
+		}
+	}
+}
+
+// parentName - Name without its leftmost label
+func parentName(name string) string {
+	index := strings.Index(name, ".")
+
+	if index == -1 {
+		return ""
+	}
+
+	return name[index+1:]
+}
+
+// split - RRsets of a section, without the RRSIG records, which are
+// returned apart
+func split(records []types.DNSResource) ([][]types.DNSResource, []types.DNSResource) {
+	rrsets := [][]types.DNSResource{}
+	rrsigs := []types.DNSResource{}
+	index := map[string]int{}
+
+	for _, record := range records {
+		if record.Type.Code == types.RRSIG.Code {
+			rrsigs = append(rrsigs, record)
+			continue
+		}
+
+		key := zone.CanonicalName(record.Name) + "|" + record.Type.Name
+
+		if i, ok := index[key]; ok {
+			rrsets[i] = append(rrsets[i], record)
+			continue
+		}
+
+		index[key] = len(rrsets)
+		rrsets = append(rrsets, []types.DNSResource{record})
+	}
+
+	return rrsets, rrsigs
+}
+
+// worst - Status of a response with RRsets of two status
+func worst(a, b dnssec.Status) dnssec.Status {
+	if a == dnssec.Bogus || b == dnssec.Bogus {
+		return dnssec.Bogus
+	}
+
+	if a == dnssec.Insecure || b == dnssec.Insecure {
+		return dnssec.Insecure
+	}
+
+	return dnssec.Secure
+}
+
+// validate - Status of a response: the signatures of its RRsets, and
+// the proofs of the wildcard expansions and negative answers
+// (RFC 4035 - Section 5.3 and 5.4)
+func (validator *Validator) validate(question types.DNSQuestion, response types.DNSMessage) (dnssec.Status, error) {
+	if _, ok := validator.anchor(question.Name); !ok {
+		return dnssec.Insecure, nil
+	}
+
+	if response.Header.RCode.Code != types.NoError.Code && response.Header.RCode.Code != types.NXDomain.Code {
+		return dnssec.Insecure, nil
+	}
+
+	denial, status, err := validator.verifyAuthority(response.Authority, "")
+
+	if status == dnssec.Bogus {
+		return status, err
+	}
+
+	answers, rrsigs := split(response.Answers)
+	name := zone.CanonicalName(question.Name)
+	answered := false
+
+	for _, rrset := range answers {
+		rrsetStatus, err := validator.verify(rrset, rrsigs, "")
+
+		if rrsetStatus == dnssec.Bogus {
+			return rrsetStatus, err
+		}
+
+		status = worst(status, rrsetStatus)
+		owner := zone.CanonicalName(rrset[0].Name)
+
+		if encloser, ok := dnssec.WildcardEncloser(rrset, rrsigs); ok && rrsetStatus == dnssec.Secure && !dnssec.ProveWildcard(owner, encloser, denial) {
+			return dnssec.Bogus, fmt.Errorf("%s %s expanded from a wildcard without proof", owner, rrset[0].Type)
+		}
+
+		if owner != name {
+			continue
+		}
+
+		switch {
+		case rrset[0].Type.Code == question.Type.Code, question.Type.Code == types.QTYPEALL.Code:
+			answered = true
+		case rrset[0].Type.Code == types.CNAME.Code:
+			name = zone.CanonicalName(rrset[0].RData)
+		}
+	}
+
+	if answered || status != dnssec.Secure {
+		return status, nil
+	}
+
+	if response.Header.RCode.Code == types.NXDomain.Code {
+		if !dnssec.ProveNameError(name, denial) {
+			return dnssec.Bogus, fmt.Errorf("%s doesn't exist without proof", name)
+		}
+
+		return status, nil
+	}
+
+	if !dnssec.ProveNoData(name, question.Type, denial) {
+		return dnssec.Bogus, fmt.Errorf("%s without %s records without proof", name, question.Type)
+	}
+
+	return status, nil
+}
+
+// verifyAuthority - Verify the SOA, NSEC and NSEC3 RRsets of the
+// authority section, returning the NSEC and NSEC3 records. When below
+// is set, the RRsets must be signed by one of its ancestors
+func (validator *Validator) verifyAuthority(authority []types.DNSResource, below string) ([]types.DNSResource, dnssec.Status, error) {
+	rrsets, rrsigs := split(authority)
+	denial := []types.DNSResource{}
+	status := dnssec.Secure
+
+	for _, rrset := range rrsets {
+		switch rrset[0].Type.Code {
+		case types.SOA.Code, types.NSEC.Code, types.NSEC3.Code:
+		default:
+			continue
+		}
+
+		rrsetStatus, err := validator.verify(rrset, rrsigs, below)
+
+		if rrsetStatus == dnssec.Bogus {
+			return nil, rrsetStatus, err
+		}
+
+		status = worst(status, rrsetStatus)
+
+		if rrsetStatus == dnssec.Secure && rrset[0].Type.Code != types.SOA.Code {
+			denial = append(denial, rrset...)
+		}
+	}
+
+	return denial, status, nil
+}
+
+// verify - Status of a RRset. The RRsets without signatures are insecure
+// only inside an insecure zone. When below is set, the signer must be
+// one of its ancestors
+func (validator *Validator) verify(rrset, rrsigs []types.DNSResource, below string) (dnssec.Status, error) {
+	owner := zone.CanonicalName(rrset[0].Name)
+
+	if _, ok := validator.anchor(owner); !ok {
+		return dnssec.Insecure, nil
+	}
+
+	signer, signed := dnssec.Signer(rrset, rrsigs)
+
+	if !signed {
+		insecure, err := validator.insecure(owner)
+
+		if err != nil {
+			return dnssec.Bogus, err
+		}
+
+		if insecure {
+			return dnssec.Insecure, nil
+		}
+
+		return dnssec.Bogus, fmt.Errorf("%s %s without signatures", owner, rrset[0].Type)
+	}
+
+	if !dnssec.IsSubdomain(owner, signer) || (below != "" && (signer == below || !dnssec.IsSubdomain(below, signer))) {
+		return dnssec.Bogus, fmt.Errorf("%s %s signed by %s", owner, rrset[0].Type, signer)
+	}
+
+	keys, status, err := validator.zoneKeys(signer)
+
+	if status != dnssec.Secure {
+		return status, err
+	}
+
+	rdatas := []string{}
+	for _, key := range keys {
+		rdatas = append(rdatas, key.RData)
+	}
+
+	if err := dnssec.VerifyRRset(rrset, rrsigs, signer, rdatas, time.Now()); err != nil {
+		return dnssec.Bogus, err
+	}
+
+	return dnssec.Secure, nil
+}
+
+// insecure - Check if name belongs to a zone proven insecure, following
+// the delegations from its trust anchor (RFC 4035 - Section 5.2). The
+// error is the one of the queries failing to reach the forwarder
+func (validator *Validator) insecure(name string) (bool, error) {
+	anchor, ok := validator.anchor(name)
+
+	if !ok {
+		return true, nil
+	}
+
+	labels := strings.Split(name, ".")
+	depth := 0
+
+	if anchor != "" {
+		depth = strings.Count(anchor, ".") + 1
+	}
+
+	for depth++; depth <= len(labels); depth++ {
+		child := strings.Join(labels[len(labels)-depth:], ".")
+		ds, status, err := validator.delegation(child)
+
+		if len(ds) > 0 && status == dnssec.Secure {
+			_, status, err = validator.zoneKeys(child)
+		}
+
+		if _, ok := err.(queryError); ok {
+			return false, err
+		}
+
+		if status != dnssec.Secure {
+			return status == dnssec.Insecure, nil
+		}
+	}
+
+	return false, nil
+}
+
+// delegation - Validated DS records of name. A name without DS
+// records is secure when it isn't a zone cut, and insecure when it
+// is an unsigned delegation. The records failing to reach the forwarder
+// aren't cached, so the next query asks for them again
+func (validator *Validator) delegation(name string) ([]types.DNSResource, dnssec.Status, error) {
+	validator.mutex.Lock()
+	cached, ok := validator.delegations[name]
+	validator.mutex.Unlock()
+	ok = ok && time.Now().Before(cached.expires)
+	metrics.ObserveCache("dnssec-ds", ok)
+
+	if ok {
+		return cached.records, cached.status, cached.err
+	}
+
+	ds, status, err := validator.fetchDelegation(name)
+
+	if _, ok := err.(queryError); ok {
+		return ds, status, err
+	}
+
+	validator.mutex.Lock()
+	validator.delegations[name] = validated{records: ds, status: status, err: err, expires: time.Now().Add(validationCacheTime)}
+	validator.mutex.Unlock()
+
+	return ds, status, err
+}
+
+func (validator *Validator) fetchDelegation(name string) ([]types.DNSResource, dnssec.Status, error) {
+	response, err := validator.query(name, types.DS)
+
+	if err != nil {
+		return nil, dnssec.Bogus, queryError{err}
+	}
+
+	rrsets, rrsigs := split(response.Answers)
+
+	for _, rrset := range rrsets {
+		if zone.CanonicalName(rrset[0].Name) != name {
+			continue
+		}
+
+		switch rrset[0].Type.Code {
+		case types.DS.Code:
+			status, err := validator.verify(rrset, rrsigs, name)
+
+			return rrset, status, err
+		case types.CNAME.Code:
+			// An alias can't be a zone cut
+			return nil, dnssec.Secure, nil
+		}
+	}
+
+	denial, status, err := validator.verifyAuthority(response.Authority, name)
+
+	if status != dnssec.Secure {
+		return nil, status, err
+	}
+
+	switch {
+	case dnssec.ProveInsecureDelegation(name, denial):
+		return nil, dnssec.Insecure, nil
+	case response.Header.RCode.Code == types.NXDomain.Code && dnssec.ProveNameError(name, denial),
+		response.Header.RCode.Code == types.NoError.Code && dnssec.ProveNoData(name, types.DS, denial):
+		return nil, dnssec.Secure, nil
+	}
+
+	return nil, dnssec.Bogus, fmt.Errorf("%s without DS records without proof", name)
+}
+
+// zoneKeys - Validated DNSKEY records of a zone, authenticated by its
+// trust anchor or the DS records of its parent. As the DS records, the
+// ones failing to reach the forwarder aren't cached
+func (validator *Validator) zoneKeys(name string) ([]types.DNSResource, dnssec.Status, error) {
+	validator.mutex.Lock()
+	cached, ok := validator.keys[name]
+	validator.mutex.Unlock()
+	ok = ok && time.Now().Before(cached.expires)
+	metrics.ObserveCache("dnssec-dnskey", ok)
+
+	if ok {
+		return cached.records, cached.status, cached.err
+	}
+
+	keys, status, err := validator.fetchKeys(name)
+
+	if _, ok := err.(queryError); ok {
+		return keys, status, err
+	}
+
+	validator.mutex.Lock()
+	validator.keys[name] = validated{records: keys, status: status, err: err, expires: time.Now().Add(validationCacheTime)}
+	validator.mutex.Unlock()
+
+	return keys, status, err
+}
+
+func (validator *Validator) fetchKeys(name string) ([]types.DNSResource, dnssec.Status, error) {
+	anchor, ok := validator.anchor(name)
+
+	if !ok {
+		return nil, dnssec.Insecure, nil
+	}
+
+	ds := validator.anchors[name]
+
+	if anchor != name {
+		var status dnssec.Status
+		var err error
+
+		if ds, status, err = validator.delegation(name); status != dnssec.Secure {
+			return nil, status, err
+		}
+
+		if len(ds) == 0 {
+			return nil, dnssec.Bogus, fmt.Errorf("zone %s without DS records", name)
+		}
+	}
+
+	response, err := validator.query(name, types.DNSKEY)
+
+	if err != nil {
+		return nil, dnssec.Bogus, queryError{err}
+	}
+
+	rrsets, rrsigs := split(response.Answers)
+
+	for _, rrset := range rrsets {
+		if zone.CanonicalName(rrset[0].Name) != name || rrset[0].Type.Code != types.DNSKEY.Code {
+			continue
+		}
+
+		anchored, supported := dnssec.AnchoredKeys(name, rrset, ds)
+
+		if !supported {
+			return nil, dnssec.Insecure, nil
+		}
+
+		if len(anchored) == 0 {
+			return nil, dnssec.Bogus, fmt.Errorf("zone %s without DNSKEY matching its DS records", name)
+		}
+
+		if err := dnssec.VerifyRRset(rrset, rrsigs, name, anchored, time.Now()); err != nil {
+			return nil, dnssec.Bogus, fmt.Errorf("DNSKEY of zone %s: %s", name, err)
+		}
+
+		return rrset, dnssec.Secure, nil
+	}
+
+	return nil, dnssec.Bogus, fmt.Errorf("zone %s without DNSKEY records", name)
+}
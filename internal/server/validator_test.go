@@ -0,0 +1,316 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/dnssec"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// testChain - Resolver answering the signed zone example, trusted with
+// the returned anchors, and its delegations: secure.example signed with
+// the key of its DS, bogus.example signed with other key, and
+// insecure.example unsigned. While down is set, the queries of the
+// DNSKEY of secure.example fail
+type testChain struct {
+	resolver string
+	anchors  []config.Record
+	down     atomic.Bool
+}
+
+// newTestChain - Start the resolver of the chain, stopped at the end of
+// the test
+func newTestChain(t *testing.T) *testChain {
+	signed := &config.DNSSEC{}
+	children, err := NewView(config.View{Zones: []config.Zone{
+		{Name: "secure.example", DNSSEC: signed, Records: []config.Record{{Name: "www", Type: types.A, Value: "192.0.2.1", TTL: 300}}},
+		{Name: "bogus.example", DNSSEC: signed, Records: []config.Record{{Name: "www", Type: types.A, Value: "192.0.2.2", TTL: 300}}},
+		{Name: "insecure.example", Records: []config.Record{{Name: "www", Type: types.A, Value: "192.0.2.3", TTL: 300}}},
+	}}, config.Global{}, tsig.NewKeyring())
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	other, err := dnssec.GenerateKey(dnssec.ECDSAP256SHA256)
+
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+
+	parent, err := NewView(config.View{Zones: []config.Zone{
+		{Name: "example", DNSSEC: signed, Records: []config.Record{
+			{Name: "secure", Type: types.NS, Value: "ns.secure.example.", TTL: 300},
+			{Name: "secure", Type: types.DS, Value: children.Zones.Get("secure.example").Signing().Key.DS("secure.example"), TTL: 300},
+			{Name: "bogus", Type: types.NS, Value: "ns.bogus.example.", TTL: 300},
+			{Name: "bogus", Type: types.DS, Value: other.DS("bogus.example"), TTL: 300},
+			{Name: "insecure", Type: types.NS, Value: "ns.insecure.example.", TTL: 300},
+		}},
+	}}, config.Global{}, tsig.NewKeyring())
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+
+	chain := &testChain{
+		resolver: conn.LocalAddr().String(),
+		anchors:  []config.Record{{Name: "example", Type: types.DS, Value: parent.Zones.Get("example").Signing().Key.DS("example")}},
+	}
+	parentServer := &Server{Mode: "udp", Views: []*View{parent}, Keys: tsig.NewKeyring()}
+	childServer := &Server{Mode: "udp", Views: []*View{children}, Keys: tsig.NewKeyring()}
+
+	go func() {
+		buffer := make([]byte, 65535)
+
+		for {
+			n, client, err := conn.ReadFrom(buffer)
+
+			if err != nil {
+				return
+			}
+
+			query := append([]byte{}, buffer[:n]...)
+			message, err := parser.ParseDNSMessage(query)
+
+			if err != nil {
+				continue
+			}
+
+			question := message.Questions[0]
+
+			if chain.down.Load() && question.Type.Code == types.DNSKEY.Code && question.Name == "secure.example" {
+				// Truncated, so the query is sent again over TCP, where
+				// nobody listens
+				query[2] |= 0x82
+				conn.WriteTo(query, client)
+
+				continue
+			}
+
+			// The DS records are in the parent side of the delegations
+			server := parentServer
+
+			if question.Type.Code != types.DS.Code && children.Zones.Find(question.Name) != nil {
+				server = childServer
+			}
+
+			for _, response := range server.serve(query, client) {
+				conn.WriteTo(response, client)
+			}
+		}
+	}()
+
+	return chain
+}
+
+// validatedQuery - Query of name and qtype, with the AD and CD bits
+func validatedQuery(t *testing.T, name string, qtype types.QType, ad, cd bool) types.DNSMessage {
+	message, err := parser.ParseDNSMessage(testQuery(t, 1, name, qtype))
+
+	if err != nil {
+		t.Fatalf("ParseDNSMessage() error = %v", err)
+	}
+
+	message.Header.AD = ad
+	message.Header.CD = cd
+
+	return message
+}
+
+// answerData - RDATA of the answers of a message, without signatures
+func answerData(message types.DNSMessage) []string {
+	rdata := []string{}
+
+	for _, answer := range message.Answers {
+		if answer.Type.Code != types.RRSIG.Code {
+			rdata = append(rdata, answer.RData)
+		}
+	}
+
+	return rdata
+}
+
+func TestValidatorResolve(t *testing.T) {
+	chain := newTestChain(t)
+	validator, err := NewValidator(chain.resolver, chain.anchors)
+
+	if err != nil {
+		t.Fatalf("NewValidator() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		query       types.DNSMessage
+		wantRCode   string
+		wantAD      bool
+		wantAnswers []string
+	}{
+		{
+			name:        "Secure answer",
+			query:       validatedQuery(t, "www.secure.example", types.A, true, false),
+			wantRCode:   "NoError",
+			wantAD:      true,
+			wantAnswers: []string{"192.0.2.1"},
+		},
+		{
+			name:        "Secure answer to a client without AD",
+			query:       validatedQuery(t, "www.secure.example", types.A, false, false),
+			wantRCode:   "NoError",
+			wantAnswers: []string{"192.0.2.1"},
+		},
+		{
+			name:        "Secure name error",
+			query:       validatedQuery(t, "missing.secure.example", types.A, true, false),
+			wantRCode:   "NXDomain",
+			wantAD:      true,
+			wantAnswers: []string{},
+		},
+		{
+			name:        "Bogus answer",
+			query:       validatedQuery(t, "www.bogus.example", types.A, true, false),
+			wantRCode:   "ServerFailure",
+			wantAnswers: []string{},
+		},
+		{
+			name:        "Insecure delegation",
+			query:       validatedQuery(t, "www.insecure.example", types.A, true, false),
+			wantRCode:   "NoError",
+			wantAnswers: []string{"192.0.2.3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := validator.Resolve(tt.query)
+
+			if response.Header.RCode.Name != tt.wantRCode {
+				t.Errorf("Resolve() rcode = %s, want %s", response.Header.RCode.Name, tt.wantRCode)
+			}
+
+			if response.Header.AD != tt.wantAD {
+				t.Errorf("Resolve() AD = %v, want %v", response.Header.AD, tt.wantAD)
+			}
+
+			if diff := cmp.Diff(tt.wantAnswers, answerData(response)); diff != "" {
+				t.Errorf("Resolve() answers mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidatorCheckingDisabled(t *testing.T) {
+	chain := newTestChain(t)
+	view, err := NewView(config.View{Forwarder: chain.resolver}, config.Global{}, tsig.NewKeyring())
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	view.Validator, err = NewValidator(chain.resolver, chain.anchors)
+
+	if err != nil {
+		t.Fatalf("NewValidator() error = %v", err)
+	}
+
+	server := &Server{Mode: "udp", Views: []*View{view}, Keys: tsig.NewKeyring()}
+	client := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5353}
+
+	tests := []struct {
+		name        string
+		cd          bool
+		wantRCode   string
+		wantAnswers []string
+	}{
+		{
+			name:        "Bogus answer without CD",
+			wantRCode:   "ServerFailure",
+			wantAnswers: []string{},
+		},
+		{
+			name:        "Bogus answer with CD",
+			cd:          true,
+			wantRCode:   "NoError",
+			wantAnswers: []string{"192.0.2.2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parser.BuildDNSMessage(validatedQuery(t, "www.bogus.example", types.A, false, tt.cd))
+
+			if err != nil {
+				t.Fatalf("BuildDNSMessage() error = %v", err)
+			}
+
+			responses := server.serve(query, client)
+
+			if len(responses) != 1 {
+				t.Fatalf("serve() = %d responses, want 1", len(responses))
+			}
+
+			response, err := parser.ParseDNSMessage(responses[0])
+
+			if err != nil {
+				t.Fatalf("ParseDNSMessage() error = %v", err)
+			}
+
+			if response.Header.RCode.Name != tt.wantRCode {
+				t.Errorf("serve() rcode = %s, want %s", response.Header.RCode.Name, tt.wantRCode)
+			}
+
+			if response.Header.CD != tt.cd {
+				t.Errorf("serve() CD = %v, want %v", response.Header.CD, tt.cd)
+			}
+
+			if diff := cmp.Diff(tt.wantAnswers, answerData(response)); diff != "" {
+				t.Errorf("serve() answers mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestValidatorQueryError(t *testing.T) {
+	chain := newTestChain(t)
+	validator, err := NewValidator(chain.resolver, chain.anchors)
+
+	if err != nil {
+		t.Fatalf("NewValidator() error = %v", err)
+	}
+
+	query := validatedQuery(t, "www.secure.example", types.A, true, false)
+	chain.down.Store(true)
+
+	if response := validator.Resolve(query); response.Header.RCode.Name != "ServerFailure" {
+		t.Errorf("Resolve() without the DNSKEY rcode = %s, want ServerFailure", response.Header.RCode.Name)
+	}
+
+	if _, ok := validator.keys["secure.example"]; ok {
+		t.Errorf("DNSKEY of secure.example cached after failing to reach the resolver")
+	}
+
+	// The next query asks for the keys again
+	chain.down.Store(false)
+	response := validator.Resolve(query)
+
+	if response.Header.RCode.Name != "NoError" || !response.Header.AD {
+		t.Errorf("Resolve() after the failure = %s with AD %v, want NoError with AD", response.Header.RCode.Name, response.Header.AD)
+	}
+}
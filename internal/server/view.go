@@ -0,0 +1,105 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package server define the DNS server
+package server
+
+import (
+	"path/filepath"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+// View - Zones and forwarder answering the queries of the clients that
+// match the view. The views of a server are checked in order, ending
+// with the default view, without name, which matches every client
+type View struct {
+	Name        string
+	Zones       *zone.Store
+	Secondaries *Secondaries
+	Forwarder   string
+	// Validator - Validator of the forwarded responses, nil without
+	// dnssec-validation
+	Validator *Validator
+
+	// clients - Addresses or networks of the clients of the view
+	clients []string
+	// keys - Keys signing the requests of the clients of the view
+	keys []string
+}
+
+// NewView - Load the zones of a view and start the refresh of its
+// secondary zones. The zones of a named view are kept in the
+// subdirectory with its name of the global directory
+func NewView(viewConfig config.View, global config.Global, keys *tsig.Keyring) (*View, error) {
+	directory := global.Directory
+
+	if directory != "" && viewConfig.Name != "" {
+		directory = filepath.Join(directory, viewConfig.Name)
+	}
+
+	if viewConfig.Forwarder != "" {
+		global.Forwarder = viewConfig.Forwarder
+	}
+
+	view := &View{
+		Name:      viewConfig.Name,
+		Zones:     zone.NewStore(directory),
+		Forwarder: forwarderAddress(global),
+		clients:   viewConfig.MatchClients,
+	}
+
+	for _, key := range viewConfig.MatchKeys {
+		view.keys = append(view.keys, zone.CanonicalName(key))
+	}
+
+	view.Zones.OnChange = SendNotify
+
+	if err := view.Zones.Load(viewConfig.Zones); err != nil {
+		return nil, err
+	}
+
+	view.Secondaries = NewSecondaries(view.Zones, keys)
+
+	return view, nil
+}
+
+// Matches - Check if the request is of a client of the view: its
+// address is in match-clients or it's signed with one of match-keys.
+// A view without both matches every client
+func (view *View) Matches(request Request) bool {
+	if len(view.clients) == 0 && len(view.keys) == 0 {
+		return true
+	}
+
+	if len(view.clients) > 0 && allowed(request.Client, view.clients) {
+		return true
+	}
+
+	for _, key := range view.keys {
+		if request.SignedWith(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// viewZones - Zones of a view in the configuration, the zones of the
+// configuration for the default view
+func viewZones(configuration config.Configuration, name string) ([]config.Zone, bool) {
+	if name == "" {
+		return configuration.Zones, true
+	}
+
+	for _, viewConfig := range configuration.Views {
+		if viewConfig.Name == name {
+			return viewConfig.Zones, true
+		}
+	}
+
+	return nil, false
+}
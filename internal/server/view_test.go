@@ -0,0 +1,151 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"net"
+	"testing"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+)
+
+// testView - View of the configuration, without zones
+func testView(t *testing.T, viewConfig config.View) *View {
+	view, err := NewView(viewConfig, config.Global{}, tsig.NewKeyring())
+
+	if err != nil {
+		t.Fatalf("NewView() error = %v", err)
+	}
+
+	return view
+}
+
+// testRequest - Request of the address, signed with the key when it
+// isn't empty
+func testRequest(address, key string) Request {
+	request := Request{Client: &net.UDPAddr{IP: net.ParseIP(address), Port: 5353}}
+
+	if key != "" {
+		request.Key = &tsig.Key{Name: key}
+	}
+
+	return request
+}
+
+func TestViewMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		view    config.View
+		request Request
+		want    bool
+	}{
+		{
+			name:    "View without clients nor keys",
+			view:    config.View{Name: "all"},
+			request: testRequest("192.0.2.1", ""),
+			want:    true,
+		},
+		{
+			name:    "Client in match-clients",
+			view:    config.View{Name: "vpn", MatchClients: []string{"10.8.0.0/16"}},
+			request: testRequest("10.8.3.4", ""),
+			want:    true,
+		},
+		{
+			name:    "Client out of match-clients",
+			view:    config.View{Name: "vpn", MatchClients: []string{"10.8.0.0/16"}},
+			request: testRequest("10.9.3.4", ""),
+			want:    false,
+		},
+		{
+			name:    "Request signed with a key of match-keys",
+			view:    config.View{Name: "signed", MatchKeys: []string{"Internal-Key."}},
+			request: testRequest("192.0.2.1", "internal-key"),
+			want:    true,
+		},
+		{
+			name:    "Request signed with another key",
+			view:    config.View{Name: "signed", MatchKeys: []string{"internal-key"}},
+			request: testRequest("192.0.2.1", "external-key"),
+			want:    false,
+		},
+		{
+			name:    "Unsigned request of a view with keys",
+			view:    config.View{Name: "signed", MatchKeys: []string{"internal-key"}},
+			request: testRequest("192.0.2.1", ""),
+			want:    false,
+		},
+		{
+			name:    "Signed request out of match-clients",
+			view:    config.View{Name: "both", MatchClients: []string{"10.8.0.0/16"}, MatchKeys: []string{"internal-key"}},
+			request: testRequest("192.0.2.1", "internal-key"),
+			want:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := testView(t, tt.view).Matches(tt.request); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerView(t *testing.T) {
+	server := &Server{Views: []*View{
+		testView(t, config.View{Name: "vpn", MatchClients: []string{"10.8.0.0/16"}}),
+		testView(t, config.View{Name: "office", MatchClients: []string{"10.8.1.0/24", "192.168.0.0/16"}}),
+		testView(t, config.View{Name: "signed", MatchKeys: []string{"internal-key"}}),
+		// The default view, with the zones out of the views
+		testView(t, config.View{}),
+	}}
+
+	tests := []struct {
+		name     string
+		request  Request
+		wantView string
+	}{
+		{
+			name:     "Client of the first view",
+			request:  testRequest("10.8.2.1", ""),
+			wantView: "vpn",
+		},
+		{
+			name:     "Client of two views",
+			request:  testRequest("10.8.1.1", ""),
+			wantView: "vpn",
+		},
+		{
+			name:     "Client of the second view",
+			request:  testRequest("192.168.1.1", ""),
+			wantView: "office",
+		},
+		{
+			name:     "Client of the second view signing",
+			request:  testRequest("192.168.1.1", "internal-key"),
+			wantView: "office",
+		},
+		{
+			name:     "Signed request",
+			request:  testRequest("192.0.2.1", "internal-key"),
+			wantView: "signed",
+		},
+		{
+			name:     "Client without view",
+			request:  testRequest("192.0.2.1", ""),
+			wantView: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := server.view(tt.request).Name; got != tt.wantView {
+				t.Errorf("view() = %q, want %q", got, tt.wantView)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tap define the dnstap output of the messages received and
+// sent by the server (https://dnstap.info)
+package tap
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+)
+
+// version - Version of the server in the dnstap messages when it
+// isn't configured
+const version = "gdns"
+
+// protocols - Socket protocol of the dnstap messages of each transport
+var protocols = map[string]dnstap.SocketProtocol{
+	"udp":   dnstap.SocketProtocol_UDP,
+	"tcp":   dnstap.SocketProtocol_TCP,
+	"tls":   dnstap.SocketProtocol_DOT,
+	"https": dnstap.SocketProtocol_DOH,
+}
+
+// Tap - Writer of dnstap messages to a Frame Streams socket or file.
+// The methods of a nil Tap do nothing
+type Tap struct {
+	output   dnstap.Output
+	identity []byte
+	version  []byte
+}
+
+// New - Open the socket or file of the dnstap configuration
+func New(configuration config.Dnstap) (*Tap, error) {
+	var output dnstap.Output
+	var err error
+
+	switch {
+	case configuration.Socket != "":
+		var address *net.UnixAddr
+
+		if address, err = net.ResolveUnixAddr("unix", configuration.Socket); err == nil {
+			output, err = dnstap.NewFrameStreamSockOutput(address)
+		}
+	case configuration.File != "":
+		output, err = dnstap.NewFrameStreamOutputFromFilename(configuration.File)
+	default:
+		err = fmt.Errorf("dnstap without socket nor file")
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	tap := &Tap{output: output, identity: []byte(configuration.Identity), version: []byte(configuration.Version)}
+
+	if configuration.Identity == "" {
+		hostname, _ := os.Hostname()
+		tap.identity = []byte(hostname)
+	}
+
+	if configuration.Version == "" {
+		tap.version = []byte(version)
+	}
+
+	go output.RunOutputLoop()
+
+	return tap, nil
+}
+
+// ClientQuery - Write a query received from client by the listener
+// of address
+func (tap *Tap) ClientQuery(client net.Addr, address, transport string, query []byte, at time.Time) {
+	if tap == nil {
+		return
+	}
+
+	message := newMessage(dnstap.Message_CLIENT_QUERY, transport)
+	message.QueryMessage = query
+	setAddresses(message, client.String(), address)
+	setQueryTime(message, at)
+	tap.write(message)
+}
+
+// ClientResponse - Write a response sent to client by the listener of
+// address, for a query received at queryTime
+func (tap *Tap) ClientResponse(client net.Addr, address, transport string, response []byte, queryTime, at time.Time) {
+	if tap == nil {
+		return
+	}
+
+	message := newMessage(dnstap.Message_CLIENT_RESPONSE, transport)
+	message.ResponseMessage = response
+	setAddresses(message, client.String(), address)
+	setQueryTime(message, queryTime)
+	setResponseTime(message, at)
+	tap.write(message)
+}
+
+// ForwarderQuery - Write a query sent to the forwarder
+func (tap *Tap) ForwarderQuery(forwarder, transport string, query []byte, at time.Time) {
+	if tap == nil {
+		return
+	}
+
+	message := newMessage(dnstap.Message_FORWARDER_QUERY, transport)
+	message.QueryMessage = query
+	setAddresses(message, "", forwarder)
+	setQueryTime(message, at)
+	tap.write(message)
+}
+
+// ForwarderResponse - Write a response received from the forwarder,
+// for a query sent at queryTime
+func (tap *Tap) ForwarderResponse(forwarder, transport string, response []byte, queryTime, at time.Time) {
+	if tap == nil {
+		return
+	}
+
+	message := newMessage(dnstap.Message_FORWARDER_RESPONSE, transport)
+	message.ResponseMessage = response
+	setAddresses(message, "", forwarder)
+	setQueryTime(message, queryTime)
+	setResponseTime(message, at)
+	tap.write(message)
+}
+
+// Close - Flush the pending messages and close the output
+func (tap *Tap) Close() error {
+	tap.output.Close()
+
+	return nil
+}
+
+// write - Send a message to the output, wrapped in a dnstap frame
+func (tap *Tap) write(message *dnstap.Message) {
+	frame, err := proto.Marshal(&dnstap.Dnstap{
+		Type:     dnstap.Dnstap_MESSAGE.Enum(),
+		Identity: tap.identity,
+		Version:  tap.version,
+		Message:  message,
+	})
+
+	if err != nil {
+		log.Errorf("Error building dnstap message: %s", err)
+
+		return
+	}
+
+	tap.output.GetOutputChannel() <- frame
+}
+
+// newMessage - Generate a message of a type, over transport
+func newMessage(messageType dnstap.Message_Type, transport string) *dnstap.Message {
+	message := &dnstap.Message{Type: messageType.Enum()}
+
+	if protocol, ok := protocols[transport]; ok {
+		message.SocketProtocol = protocol.Enum()
+	}
+
+	return message
+}
+
+// setAddresses - Set the addresses of the querier and responder of a
+// message, with their socket family
+func setAddresses(message *dnstap.Message, query, response string) {
+	if ip, port, ok := splitAddress(query); ok {
+		message.QueryAddress, message.QueryPort = ip, &port
+		message.SocketFamily = family(ip).Enum()
+	}
+
+	if ip, port, ok := splitAddress(response); ok {
+		message.ResponseAddress, message.ResponsePort = ip, &port
+		message.SocketFamily = family(ip).Enum()
+	}
+}
+
+// splitAddress - IP and port of an address
+func splitAddress(address string) ([]byte, uint32, bool) {
+	host, port, err := net.SplitHostPort(address)
+
+	if err != nil {
+		return nil, 0, false
+	}
+
+	ip := net.ParseIP(host)
+	number, err := strconv.ParseUint(port, 10, 16)
+
+	if ip == nil || err != nil {
+		return nil, 0, false
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return ip, uint32(number), true
+}
+
+// family - Socket family of an IP
+func family(ip net.IP) dnstap.SocketFamily {
+	if len(ip) == net.IPv4len {
+		return dnstap.SocketFamily_INET
+	}
+
+	return dnstap.SocketFamily_INET6
+}
+
+func setQueryTime(message *dnstap.Message, at time.Time) {
+	seconds, nanoseconds := uint64(at.Unix()), uint32(at.Nanosecond())
+	message.QueryTimeSec, message.QueryTimeNsec = &seconds, &nanoseconds
+}
+
+func setResponseTime(message *dnstap.Message, at time.Time) {
+	seconds, nanoseconds := uint64(at.Unix()), uint32(at.Nanosecond())
+	message.ResponseTimeSec, message.ResponseTimeNsec = &seconds, &nanoseconds
+}
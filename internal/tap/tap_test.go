@@ -0,0 +1,172 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tap define all the tests for the tap package
+package tap_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	dnstap "github.com/dnstap/golang-dnstap"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/lucasdc6/gdns/internal/tap"
+	"github.com/lucasdc6/gdns/pkg/config"
+)
+
+// frame - Fields of a dnstap message written to the output
+type frame struct {
+	Identity        string
+	Type            string
+	Protocol        string
+	Family          string
+	QueryAddress    string
+	QueryPort       uint32
+	ResponseAddress string
+	ResponsePort    uint32
+	Query           []byte
+	Response        []byte
+	QueryTime       time.Time
+	ResponseTime    time.Time
+}
+
+// readFrames - Decode the dnstap messages of a file
+func readFrames(t *testing.T, path string) []frame {
+	input, err := dnstap.NewFrameStreamInputFromFilename(path)
+
+	if err != nil {
+		t.Fatalf("NewFrameStreamInputFromFilename() error = %v", err)
+	}
+
+	data := make(chan []byte, 16)
+	input.ReadInto(data)
+	close(data)
+
+	frames := []frame{}
+
+	for payload := range data {
+		decoded := &dnstap.Dnstap{}
+
+		if err := proto.Unmarshal(payload, decoded); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+
+		message := decoded.GetMessage()
+		got := frame{
+			Identity:     string(decoded.GetIdentity()),
+			Type:         message.GetType().String(),
+			Protocol:     message.GetSocketProtocol().String(),
+			Family:       message.GetSocketFamily().String(),
+			QueryPort:    message.GetQueryPort(),
+			ResponsePort: message.GetResponsePort(),
+			Query:        message.GetQueryMessage(),
+			Response:     message.GetResponseMessage(),
+			QueryTime:    time.Unix(int64(message.GetQueryTimeSec()), int64(message.GetQueryTimeNsec())),
+		}
+
+		if message.QueryAddress != nil {
+			got.QueryAddress = net.IP(message.QueryAddress).String()
+		}
+
+		if message.ResponseAddress != nil {
+			got.ResponseAddress = net.IP(message.ResponseAddress).String()
+		}
+
+		if message.ResponseTimeSec != nil {
+			got.ResponseTime = time.Unix(int64(message.GetResponseTimeSec()), int64(message.GetResponseTimeNsec()))
+		}
+
+		frames = append(frames, got)
+	}
+
+	return frames
+}
+
+func TestTap(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gdns.dnstap")
+	output, err := tap.New(config.Dnstap{File: path, Identity: "ns1"})
+
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	client := &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}
+	query := []byte{0x12, 0x34, 0x01, 0x00}
+	response := []byte{0x12, 0x34, 0x81, 0x80}
+	queryTime := time.Unix(1700000000, 1000).UTC()
+	responseTime := queryTime.Add(time.Millisecond)
+
+	output.ClientQuery(client, "127.0.0.1:53", "udp", query, queryTime)
+	output.ForwarderQuery("[2001:db8::53]:53", "udp", query, queryTime)
+	output.ForwarderResponse("[2001:db8::53]:53", "udp", response, queryTime, responseTime)
+	output.ClientResponse(client, "127.0.0.1:853", "tls", response, queryTime, responseTime)
+	output.Close()
+
+	want := []frame{
+		{
+			Identity:        "ns1",
+			Type:            "CLIENT_QUERY",
+			Protocol:        "UDP",
+			Family:          "INET",
+			QueryAddress:    "192.0.2.1",
+			QueryPort:       40000,
+			ResponseAddress: "127.0.0.1",
+			ResponsePort:    53,
+			Query:           query,
+			QueryTime:       queryTime,
+		},
+		{
+			Identity:        "ns1",
+			Type:            "FORWARDER_QUERY",
+			Protocol:        "UDP",
+			Family:          "INET6",
+			ResponseAddress: "2001:db8::53",
+			ResponsePort:    53,
+			Query:           query,
+			QueryTime:       queryTime,
+		},
+		{
+			Identity:        "ns1",
+			Type:            "FORWARDER_RESPONSE",
+			Protocol:        "UDP",
+			Family:          "INET6",
+			ResponseAddress: "2001:db8::53",
+			ResponsePort:    53,
+			Response:        response,
+			QueryTime:       queryTime,
+			ResponseTime:    responseTime,
+		},
+		{
+			Identity:        "ns1",
+			Type:            "CLIENT_RESPONSE",
+			Protocol:        "DOT",
+			Family:          "INET",
+			QueryAddress:    "192.0.2.1",
+			QueryPort:       40000,
+			ResponseAddress: "127.0.0.1",
+			ResponsePort:    853,
+			Response:        response,
+			QueryTime:       queryTime,
+			ResponseTime:    responseTime,
+		},
+	}
+
+	compareTimes := cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) })
+
+	if diff := cmp.Diff(want, readFrames(t, path), compareTimes); diff != "" {
+		t.Errorf("frames mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestNilTap(t *testing.T) {
+	var output *tap.Tap
+
+	// Without dnstap configured, the messages are ignored
+	output.ClientQuery(&net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 40000}, "127.0.0.1:53", "udp", []byte{0}, time.Now())
+	output.ForwarderQuery("192.0.2.53:53", "udp", []byte{0}, time.Now())
+}
@@ -0,0 +1,263 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package blocklist define the lists of domains whose queries are
+// blocked, with their subdomains
+package blocklist
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+// Actions of the blocked queries
+const (
+	// NXDomain - Answer the blocked queries with NXDOMAIN
+	NXDomain = "nxdomain"
+	// Refused - Answer the blocked queries with REFUSED
+	Refused = "refused"
+	// Sinkhole - Answer the blocked queries with the sinkhole addresses
+	Sinkhole = "sinkhole"
+)
+
+// hostsNames - Names of the hosts files that aren't blocked domains
+var hostsNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"0.0.0.0":               true,
+}
+
+// Block - Query blocked, with the list blocking its name and the
+// answer configured
+type Block struct {
+	List     string
+	Action   string
+	Sinkhole []net.IP
+}
+
+// Blocklist - Domains blocked by each list and the domains allowed.
+// The methods of a nil Blocklist don't block any query
+type Blocklist struct {
+	// blocked - First list blocking each domain
+	blocked  map[string]string
+	allowed  map[string]bool
+	action   string
+	sinkhole []net.IP
+	mutex    sync.RWMutex
+}
+
+// New - Generate an empty blocklist
+func New() *Blocklist {
+	return &Blocklist{blocked: map[string]string{}, allowed: map[string]bool{}, action: NXDomain}
+}
+
+// Load - Replace the lists and the answer of the blocked queries with
+// the configured ones. The blocklist doesn't change on error
+func (blocklist *Blocklist) Load(configuration config.Blocklist) error {
+	blocked, allowed := map[string]string{}, map[string]bool{}
+	action := strings.ToLower(configuration.Action)
+	sinkhole := []net.IP{}
+
+	switch action {
+	case "":
+		action = NXDomain
+	case NXDomain, Refused:
+	case Sinkhole:
+		for _, address := range configuration.Sinkhole {
+			ip := net.ParseIP(address)
+
+			if ip == nil {
+				return fmt.Errorf("invalid sinkhole address %q", address)
+			}
+
+			sinkhole = append(sinkhole, ip)
+		}
+
+		if len(sinkhole) == 0 {
+			return fmt.Errorf("sinkhole action without addresses")
+		}
+	default:
+		return fmt.Errorf("unknown action %q, choose one of nxdomain, refused or sinkhole", configuration.Action)
+	}
+
+	for _, list := range configuration.Lists {
+		file, err := os.Open(list.File)
+
+		if err != nil {
+			return fmt.Errorf("list %s: %w", list.Name, err)
+		}
+
+		domains, exceptions, err := Parse(file)
+		file.Close()
+
+		if err != nil {
+			return fmt.Errorf("list %s: %w", list.Name, err)
+		}
+
+		for _, domain := range domains {
+			if _, ok := blocked[domain]; !ok {
+				blocked[domain] = list.Name
+			}
+		}
+
+		for _, domain := range exceptions {
+			allowed[domain] = true
+		}
+	}
+
+	for _, domain := range configuration.Allowlist {
+		allowed[zone.CanonicalName(domain)] = true
+	}
+
+	blocklist.mutex.Lock()
+	defer blocklist.mutex.Unlock()
+
+	blocklist.blocked, blocklist.allowed = blocked, allowed
+	blocklist.action, blocklist.sinkhole = action, sinkhole
+
+	return nil
+}
+
+// Size - Number of domains blocked
+func (blocklist *Blocklist) Size() int {
+	blocklist.mutex.RLock()
+	defer blocklist.mutex.RUnlock()
+
+	return len(blocklist.blocked)
+}
+
+// Match - Check if the queries of name are blocked: the name or one
+// of its parents is in a list, and neither of them is allowed
+func (blocklist *Blocklist) Match(name string) (Block, bool) {
+	if blocklist == nil {
+		return Block{}, false
+	}
+
+	blocklist.mutex.RLock()
+	defer blocklist.mutex.RUnlock()
+
+	var list string
+	var found bool
+
+	for suffix := zone.CanonicalName(name); suffix != ""; suffix = zone.ParentName(suffix) {
+		if blocklist.allowed[suffix] {
+			return Block{}, false
+		}
+
+		if !found {
+			list, found = blocklist.blocked[suffix]
+		}
+	}
+
+	if !found {
+		return Block{}, false
+	}
+
+	return Block{List: list, Action: blocklist.action, Sinkhole: blocklist.sinkhole}, true
+}
+
+// Parse - Read the domains blocked and allowed by a list, with a rule
+// on each line in one of the formats:
+//
+//	ads.example.com               plain domain
+//	0.0.0.0 ads.example.com       hosts entry, with one or more names
+//	||ads.example.com^            adblock rule
+//	@@||www.example.com^          adblock exception, allowing the domain
+//
+// The comments, starting with # or !, and the adblock rules with
+// options or paths are skipped
+func Parse(reader io.Reader) ([]string, []string, error) {
+	blocked, allowed := []string{}, []string{}
+	scanner := bufio.NewScanner(reader)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "@@") {
+			if domain, ok := adblockDomain(line[2:]); ok {
+				allowed = append(allowed, domain)
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(line, "||") {
+			if domain, ok := adblockDomain(line); ok {
+				blocked = append(blocked, domain)
+			}
+
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		if net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		} else if len(fields) > 1 {
+			continue
+		}
+
+		for _, field := range fields {
+			domain := zone.CanonicalName(strings.TrimPrefix(field, "*."))
+
+			if !hostsNames[domain] && validDomain(domain) {
+				blocked = append(blocked, domain)
+			}
+		}
+	}
+
+	return blocked, allowed, scanner.Err()
+}
+
+// adblockDomain - Domain of an adblock rule ||domain^, false for the
+// rules with options or paths
+func adblockDomain(rule string) (string, bool) {
+	if !strings.HasPrefix(rule, "||") || !strings.HasSuffix(rule, "^") {
+		return "", false
+	}
+
+	domain := zone.CanonicalName(strings.TrimSuffix(strings.TrimPrefix(rule, "||"), "^"))
+
+	return domain, validDomain(domain)
+}
+
+// validDomain - Check if a domain has only the characters of the host
+// names, besides the underscores used by some services
+func validDomain(domain string) bool {
+	if domain == "" {
+		return false
+	}
+
+	for _, c := range domain {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,150 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package blocklist define all the tests for the blocklist package
+package blocklist_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/blocklist"
+	"github.com/lucasdc6/gdns/pkg/config"
+)
+
+func TestParse(t *testing.T) {
+	list := `# Plain domains
+ads.example.com
+*.Tracker.Example.NET.
+# Hosts entries
+127.0.0.1 localhost
+0.0.0.0 metrics.example.org telemetry.example.org # inline comment
+::1 ip6-localhost
+! Adblock rules
+[Adblock Plus 2.0]
+||banners.example.com^
+||cdn.example.com/ads^
+||popups.example.com^$third-party
+@@||static.ads.example.com^
+not a domain
+`
+	blocked, allowed, err := blocklist.Parse(strings.NewReader(list))
+
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	wantBlocked := []string{"ads.example.com", "tracker.example.net", "metrics.example.org", "telemetry.example.org", "banners.example.com"}
+	wantAllowed := []string{"static.ads.example.com"}
+
+	if diff := cmp.Diff(wantBlocked, blocked); diff != "" {
+		t.Errorf("Parse() blocked mismatch (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(wantAllowed, allowed); diff != "" {
+		t.Errorf("Parse() allowed mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	directory := t.TempDir()
+	ads := filepath.Join(directory, "ads.txt")
+	malware := filepath.Join(directory, "malware.hosts")
+	os.WriteFile(ads, []byte("ads.example.com\n@@||static.ads.example.com^\n"), 0644)
+	os.WriteFile(malware, []byte("0.0.0.0 ads.example.com evil.example.net\n"), 0644)
+
+	list := blocklist.New()
+	err := list.Load(config.Blocklist{
+		Lists: []config.BlocklistFile{
+			{Name: "ads", File: ads},
+			{Name: "malware", File: malware},
+		},
+		Allowlist: []string{"good.evil.example.net."},
+		Action:    "sinkhole",
+		Sinkhole:  []string{"0.0.0.0", "::"},
+	})
+
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	sinkhole := []net.IP{net.ParseIP("0.0.0.0"), net.ParseIP("::")}
+	tests := []struct {
+		name  string
+		query string
+		want  blocklist.Block
+		found bool
+	}{
+		{
+			name:  "Blocked domain",
+			query: "ads.example.com",
+			want:  blocklist.Block{List: "ads", Action: blocklist.Sinkhole, Sinkhole: sinkhole},
+			found: true,
+		},
+		{
+			name:  "Subdomain of a blocked domain",
+			query: "a.b.Evil.Example.NET.",
+			want:  blocklist.Block{List: "malware", Action: blocklist.Sinkhole, Sinkhole: sinkhole},
+			found: true,
+		},
+		{
+			name:  "Parent of a blocked domain",
+			query: "example.com",
+		},
+		{
+			name:  "Adblock exception",
+			query: "img.static.ads.example.com",
+		},
+		{
+			name:  "Allowlist",
+			query: "good.evil.example.net",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := list.Match(tt.query)
+
+			if found != tt.found {
+				t.Errorf("Match() found = %v, want %v", found, tt.found)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Match() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		configuration config.Blocklist
+	}{
+		{
+			name:          "Missing file",
+			configuration: config.Blocklist{Lists: []config.BlocklistFile{{Name: "ads", File: "/nonexistent/ads.txt"}}},
+		},
+		{
+			name:          "Unknown action",
+			configuration: config.Blocklist{Action: "drop"},
+		},
+		{
+			name:          "Sinkhole without addresses",
+			configuration: config.Blocklist{Action: "sinkhole"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := blocklist.New().Load(tt.configuration); err == nil {
+				t.Errorf("Load() error = nil, want error")
+			}
+		})
+	}
+}
@@ -8,9 +8,12 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -21,14 +24,182 @@ import (
 
 // Global - Define the struct of the global section
 type Global struct {
+	// Directory - Path where the server keep the state of the zones
+	// (snapshots and journals) between restarts
+	Directory string `yaml:"directory,omitempty" json:"directory,omitempty"`
+	// WriteUpdates - Write the zones changed by dynamic updates back
+	// to the configuration file, rewriting it entirely without its
+	// comments and formatting
+	WriteUpdates bool `yaml:"write-updates,omitempty" json:"write-updates,omitempty"`
+	// Forwarder - Server used to resolve the names outside of the
+	// configured zones
+	Forwarder string `yaml:"forwarder,omitempty" json:"forwarder,omitempty"`
+	// DNSSECValidation - Validate the forwarded responses with the
+	// trust anchors
+	DNSSECValidation bool `yaml:"dnssec-validation,omitempty" json:"dnssec-validation,omitempty"`
+	// QueryLog - File where a JSON line is written for each query
+	QueryLog *QueryLog `yaml:"query-log,omitempty" json:"query-log,omitempty"`
+	// Dnstap - Output of the messages received and sent in dnstap format
+	Dnstap *Dnstap `yaml:"dnstap,omitempty" json:"dnstap,omitempty"`
+	// Capture - pcap file where the messages received and sent are
+	// written
+	Capture string `yaml:"capture,omitempty" json:"capture,omitempty"`
+	// RateLimit - Rate limit of the identical responses sent by the
+	// UDP listener to each client prefix
+	RateLimit *RateLimit `yaml:"rate-limit,omitempty" json:"rate-limit,omitempty"`
+	// UDPWorkers - Queries answered at the same time by each UDP
+	// listener, 128 by default
+	UDPWorkers int `yaml:"udp-workers,omitempty" json:"udp-workers,omitempty"`
+	// AllowQuery - Addresses or networks allowed to query the server,
+	// all when empty
+	AllowQuery []string `yaml:"allow-query,omitempty" json:"allow-query,omitempty"`
+	// AllowRecursion - Addresses or networks allowed to query the
+	// names outside of the zones, all when empty
+	AllowRecursion []string `yaml:"allow-recursion,omitempty" json:"allow-recursion,omitempty"`
+	// Blackhole - Addresses or networks whose messages are dropped
+	Blackhole []string `yaml:"blackhole,omitempty" json:"blackhole,omitempty"`
+	// Blocklist - Lists of domains whose queries are blocked
+	Blocklist *Blocklist `yaml:"blocklist,omitempty" json:"blocklist,omitempty"`
+	// Rewrite - Rules rewriting the query names before answering them
+	Rewrite []Rewrite `yaml:"rewrite,omitempty" json:"rewrite,omitempty"`
+	// ResponsePolicy - Response policy zones (RPZ) applied to the
+	// answers, checked in order
+	ResponsePolicy []ResponsePolicy `yaml:"response-policy,omitempty" json:"response-policy,omitempty"`
 }
 
+// Blocklist - Define the struct of the blocklists
+type Blocklist struct {
+	Lists []BlocklistFile `yaml:"lists" json:"lists"`
+	// Allowlist - Domains never blocked, with their subdomains
+	Allowlist []string `yaml:"allowlist,omitempty" json:"allowlist,omitempty"`
+	// Action - Answer of the blocked queries: "nxdomain" (default),
+	// "refused" or "sinkhole"
+	Action string `yaml:"action,omitempty" json:"action,omitempty"`
+	// Sinkhole - IPv4 and IPv6 addresses answered to the A and AAAA
+	// blocked queries with the sinkhole action
+	Sinkhole []string `yaml:"sinkhole,omitempty" json:"sinkhole,omitempty"`
+}
+
+// BlocklistFile - Define the struct of a blocklist, a file of plain
+// domains, hosts entries or adblock rules
+type BlocklistFile struct {
+	Name string `yaml:"name" json:"name"`
+	File string `yaml:"file" json:"file"`
+}
+
+// Rewrite - Define the struct of a rewrite rule of the query names
+type Rewrite struct {
+	// Type - Match of the query names: "exact" (default), "suffix" or
+	// "regex"
+	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+	// From - Name, suffix or regular expression matched
+	From string `yaml:"from" json:"from"`
+	// To - Name or suffix replacing the matched one. The regex rules
+	// can use the groups of the expression ($1)
+	To string `yaml:"to" json:"to"`
+}
+
+// ResponsePolicy - Define the struct of a response policy zone (RPZ)
+type ResponsePolicy struct {
+	// Name - Origin of the policy zone
+	Name string `yaml:"name" json:"name"`
+	// File - Zone file of the rules, in master file format
+	File string `yaml:"file,omitempty" json:"file,omitempty"`
+	// Records - Rules of the zone, added to the ones of the file
+	Records []Record `yaml:"records,omitempty" json:"records,omitempty"`
+}
+
+// RateLimit - Define the struct of the response rate limiting
+type RateLimit struct {
+	// ResponsesPerSecond - Identical responses sent each second to a
+	// client prefix
+	ResponsesPerSecond float64 `yaml:"responses-per-second" json:"responses-per-second"`
+	// Burst - Identical responses sent at once, responses-per-second
+	// by default
+	Burst int `yaml:"burst,omitempty" json:"burst,omitempty"`
+	// Slip - One of every slip responses over the limit is sent
+	// truncated instead of dropped, 2 by default and 0 to drop them all
+	Slip *int `yaml:"slip,omitempty" json:"slip,omitempty"`
+	// IPv4PrefixLength - Length of the prefixes of the IPv4 clients,
+	// 24 by default
+	IPv4PrefixLength int `yaml:"ipv4-prefix-length,omitempty" json:"ipv4-prefix-length,omitempty"`
+	// IPv6PrefixLength - Length of the prefixes of the IPv6 clients,
+	// 56 by default
+	IPv6PrefixLength int `yaml:"ipv6-prefix-length,omitempty" json:"ipv6-prefix-length,omitempty"`
+	// Exempt - Networks of the clients without limit
+	Exempt []string `yaml:"exempt,omitempty" json:"exempt,omitempty"`
+}
+
+// Dnstap - Define the struct of the dnstap output, written to a
+// Frame Streams unix socket or file
+type Dnstap struct {
+	Socket string `yaml:"socket,omitempty" json:"socket,omitempty"`
+	File   string `yaml:"file,omitempty" json:"file,omitempty"`
+	// Identity - Identity of the server, its hostname by default
+	Identity string `yaml:"identity,omitempty" json:"identity,omitempty"`
+	// Version - Version of the server, gdns by default
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+}
+
+// QueryLog - Define the struct of the query log
+type QueryLog struct {
+	File string `yaml:"file" json:"file"`
+	// MaxSize - Size in MiB at which the file is rotated, 0 to
+	// never rotate it
+	MaxSize int `yaml:"max-size,omitempty" json:"max-size,omitempty"`
+	// MaxFiles - Number of rotated files kept, 5 by default
+	MaxFiles int `yaml:"max-files,omitempty" json:"max-files,omitempty"`
+}
+
+// Zone types
+const (
+	Primary   = "primary"
+	Secondary = "secondary"
+)
+
 // Zone - Define the struct of the Zones in the configuration
 type Zone struct {
-	Name    string   `yaml:"name" json:"name"`
+	Name string `yaml:"name" json:"name"`
+	// Type - "primary" (default) to serve the configured records, or
+	// "secondary" to transfer the zone from the primaries
+	Type      string   `yaml:"type,omitempty" json:"type,omitempty"`
+	Primaries []string `yaml:"primaries,omitempty" json:"primaries,omitempty"`
+	// AlsoNotify - Servers notified (RFC 1996) of every new version
+	// of the zone
+	AlsoNotify []string `yaml:"also-notify,omitempty" json:"also-notify,omitempty"`
+	// AllowUpdate - Addresses or networks allowed to send dynamic
+	// updates (RFC 2136) of the zone
+	AllowUpdate []string `yaml:"allow-update,omitempty" json:"allow-update,omitempty"`
+	// AllowQuery - Addresses or networks allowed to query the zone,
+	// replacing the global allow-query
+	AllowQuery []string `yaml:"allow-query,omitempty" json:"allow-query,omitempty"`
+	// Blackhole - Addresses or networks whose queries of the zone are
+	// dropped
+	Blackhole []string `yaml:"blackhole,omitempty" json:"blackhole,omitempty"`
+	// UpdateKey - Key required to sign the dynamic updates
+	UpdateKey string `yaml:"update-key,omitempty" json:"update-key,omitempty"`
+	// TransferKey - Key required to sign the transfers of a primary
+	// zone, or used to sign the requests to the primaries of a
+	// secondary zone
+	TransferKey string `yaml:"transfer-key,omitempty" json:"transfer-key,omitempty"`
+	// DNSSEC - Sign the answers of the zone, when the clients
+	// request it with the DO bit
+	DNSSEC  *DNSSEC  `yaml:"dnssec,omitempty" json:"dnssec,omitempty"`
 	Records []Record `yaml:"records" json:"records"`
 }
 
+// DNSSEC - Define the struct of the online signing of a zone
+type DNSSEC struct {
+	// Algorithm - "ecdsap256sha256" (default) or "ed25519"
+	Algorithm string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"`
+	// NSEC3 - Deny the existence with NSEC3 (RFC 5155) instead of NSEC
+	NSEC3 bool `yaml:"nsec3,omitempty" json:"nsec3,omitempty"`
+	// Iterations - Additional iterations of the NSEC3 hash
+	Iterations uint16 `yaml:"iterations,omitempty" json:"iterations,omitempty"`
+	// Salt - Salt of the NSEC3 hash, in hexadecimal
+	Salt string `yaml:"salt,omitempty" json:"salt,omitempty"`
+}
+
 // Host - Define the struct of the Hosts in the configuration
 type Record struct {
 	Name  string      `yaml:"name" json:"name"`
@@ -37,9 +208,77 @@ type Record struct {
 	TTL   int         `yaml:"ttl" json:"ttl"`
 }
 
+// Key - Define the struct of the keys used to sign the messages
+// with TSIG (RFC 8945)
+type Key struct {
+	Name string `yaml:"name" json:"name"`
+	// Algorithm - "hmac-sha256" (default) or "hmac-sha512"
+	Algorithm string `yaml:"algorithm,omitempty" json:"algorithm,omitempty"`
+	// Secret - Shared secret encoded in base64
+	Secret string `yaml:"secret" json:"secret"`
+}
+
+// String - Key without its secret, so the configuration can be logged
+func (key Key) String() string {
+	return fmt.Sprintf("{Name:%s Algorithm:%s Secret:<redacted>}", key.Name, key.Algorithm)
+}
+
 // Configuration - Define the general struct of the configuration file
 type Configuration struct {
-	Zones []Zone `yaml:"zones" json:"zones"`
+	Global Global `yaml:"global,omitempty" json:"global,omitempty"`
+	Keys   []Key  `yaml:"keys,omitempty" json:"keys,omitempty"`
+	Zones  []Zone `yaml:"zones" json:"zones"`
+	// TrustAnchors - DS, TA or DNSKEY records trusted to validate
+	// the forwarded responses
+	TrustAnchors []Record `yaml:"trust-anchors,omitempty" json:"trust-anchors,omitempty"`
+	// Views - Zones and forwarders of the clients matching each view.
+	// The first view matching a client answers its queries, and the
+	// clients without view are answered with the zones and forwarder
+	// of the configuration
+	Views []View `yaml:"views,omitempty" json:"views,omitempty"`
+	// Listeners - Addresses where the server listens, replacing the
+	// ones of the command line flags
+	Listeners []Listener `yaml:"listeners,omitempty" json:"listeners,omitempty"`
+}
+
+// Listener - Define the struct of a listener of the server
+type Listener struct {
+	// Address - IPv4 or IPv6 address, or "*" (default) for every
+	// address of both families. The path of the socket for the unix
+	// transport
+	Address string `yaml:"address,omitempty" json:"address,omitempty"`
+	// Port - Port of the listener, the default one of its transport
+	// when it isn't set
+	Port int `yaml:"port,omitempty" json:"port,omitempty"`
+	// Transport - "udp" (default), "tcp", "both" (udp and tcp), "tls",
+	// "https", "quic" or "unix" (DNS over a unix stream socket)
+	Transport string `yaml:"transport,omitempty" json:"transport,omitempty"`
+	// Socket - Name of the sockets passed by the service manager
+	// (LISTEN_FDNAMES) served by the listener, instead of its address
+	// and port
+	Socket string `yaml:"socket,omitempty" json:"socket,omitempty"`
+	// ReusePort - UDP sockets of the listener sharing its address with
+	// SO_REUSEPORT, a single one without the option by default
+	ReusePort int `yaml:"reuse-port,omitempty" json:"reuse-port,omitempty"`
+	// View - View answering the queries of the listener, instead of
+	// the first view matching each client
+	View string `yaml:"view,omitempty" json:"view,omitempty"`
+	// AllowQuery - Addresses or networks allowed to query the
+	// listener, all when empty
+	AllowQuery []string `yaml:"allow-query,omitempty" json:"allow-query,omitempty"`
+}
+
+// View - Define the struct of a view
+type View struct {
+	Name string `yaml:"name" json:"name"`
+	// MatchClients - Addresses or networks of the clients of the view
+	MatchClients []string `yaml:"match-clients,omitempty" json:"match-clients,omitempty"`
+	// MatchKeys - Keys signing the requests of the clients of the view
+	MatchKeys []string `yaml:"match-keys,omitempty" json:"match-keys,omitempty"`
+	// Forwarder - Server used to resolve the names outside of the
+	// zones of the view, the global forwarder by default
+	Forwarder string `yaml:"forwarder,omitempty" json:"forwarder,omitempty"`
+	Zones     []Zone `yaml:"zones" json:"zones"`
 }
 
 func ReadConfigFile(path string) []byte {
@@ -54,6 +293,19 @@ func ReadConfigFile(path string) []byte {
 
 // Parse - Generate the internal configuration
 func Parse(configStr []byte, format string) (config Configuration) {
+	config, err := Decode(configStr, format)
+
+	if err != nil {
+		log.Fatalf("Error reading %s configuration: %v\n", formatName(format), err)
+		os.Exit(formatError(format))
+	}
+
+	return config
+}
+
+// Decode - Generate the internal configuration of data in format, the
+// extension of its file, yaml by default
+func Decode(data []byte, format string) (config Configuration, err error) {
 	log.Tracef("Parsing %s file", format)
 
 	if format == "" {
@@ -64,36 +316,222 @@ func Parse(configStr []byte, format string) (config Configuration) {
 
 	switch format {
 	case ".yaml", ".yml":
-		err := yaml.Unmarshal(configStr, &config)
+		err = yaml.Unmarshal(data, &config)
+	case ".json":
+		err = json.Unmarshal(data, &config)
+	}
+
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	log.Printf("Loaded config: %+v", config)
+
+	return config, nil
+}
+
+// Read - Read the configuration of the file of path, in the format
+// taken from its extension. Unlike Load, the errors are returned, so
+// the running server can keep its configuration
+func Read(path string) (Configuration, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	config, err := Decode(data, filepath.Ext(path))
+
+	if err != nil {
+		return Configuration{}, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return Configuration{}, err
+	}
+
+	return config, nil
+}
+
+// Validate - Check the addresses and networks of the access lists of
+// the configuration, the names of the views and their match-keys,
+// reporting the first invalid entry
+func (config Configuration) Validate() error {
+	if err := validateList("global allow-query", config.Global.AllowQuery); err != nil {
+		return err
+	}
+
+	if err := validateList("global allow-recursion", config.Global.AllowRecursion); err != nil {
+		return err
+	}
+
+	if err := validateList("global blackhole", config.Global.Blackhole); err != nil {
+		return err
+	}
+
+	if err := validateZones("", config.Zones); err != nil {
+		return err
+	}
 
-		if err != nil {
-			log.Fatalf("Error reading yaml configuration: %v\n", err)
-			os.Exit(errors.ReadingYAMLConfiguration)
+	keys := map[string]bool{}
+
+	for _, key := range config.Keys {
+		keys[keyName(key.Name)] = true
+	}
+
+	for _, view := range config.Views {
+		// The name is the directory of the zones of the view
+		if strings.ContainsAny(view.Name, `/\`) || view.Name == "." || view.Name == ".." {
+			return fmt.Errorf("invalid name %q of view, it can't be a path", view.Name)
+		}
+
+		if err := validateList(fmt.Sprintf("match-clients of view %s", view.Name), view.MatchClients); err != nil {
+			return err
+		}
+
+		for _, key := range view.MatchKeys {
+			if !keys[keyName(key)] {
+				return fmt.Errorf("unknown key %q in match-keys of view %s", key, view.Name)
+			}
 		}
-	case ".json":
-		err := json.Unmarshal(configStr, &config)
 
-		if err != nil {
-			log.Fatalf("Error reading json configuration: %v\n", err)
-			os.Exit(errors.ReadingJSONConfiguration)
+		if err := validateZones(fmt.Sprintf(" of view %s", view.Name), view.Zones); err != nil {
+			return err
 		}
 	}
-	log.Printf("Loaded config: %+v", config)
 
-	return config
+	for i, listener := range config.Listeners {
+		if err := validateList(fmt.Sprintf("allow-query of listener %d", i+1), listener.AllowQuery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keyName - Name of a key as compared when signing, in lowercase and
+// without the final dot
+func keyName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
 }
 
+// validateZones - Check the access lists of the zones, with suffix
+// after their name in the errors
+func validateZones(suffix string, zones []Zone) error {
+	for _, zone := range zones {
+		if err := validateList(fmt.Sprintf("allow-update of zone %s%s", zone.Name, suffix), zone.AllowUpdate); err != nil {
+			return err
+		}
+
+		if err := validateList(fmt.Sprintf("allow-query of zone %s%s", zone.Name, suffix), zone.AllowQuery); err != nil {
+			return err
+		}
+
+		if err := validateList(fmt.Sprintf("blackhole of zone %s%s", zone.Name, suffix), zone.Blackhole); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateList - Check that each entry of the access list name is an IP
+// address or a CIDR network
+func validateList(name string, list []string) error {
+	for _, entry := range list {
+		if strings.Contains(entry, "/") {
+			if _, _, err := net.ParseCIDR(entry); err == nil {
+				continue
+			}
+		} else if net.ParseIP(entry) != nil {
+			continue
+		}
+
+		return fmt.Errorf("invalid address or network %q in %s", entry, name)
+	}
+
+	return nil
+}
+
+// Load - Read the configuration of the file of path, exiting on error.
+// Without path, the configuration is empty
 func Load(path string) (config Configuration) {
 	if path == "" {
 		return Configuration{}
 	}
 
 	log.Infof("Server configuration file '%s'", path)
+	log.Debugf("Server configuration format '%s'", filepath.Ext(path))
 
-	file := ReadConfigFile(path)
-	ext := filepath.Ext(path)
+	config, err := Decode(ReadConfigFile(path), filepath.Ext(path))
 
-	log.Debugf("Server configuration format '%s'", ext)
+	if err != nil {
+		log.Fatalf("Error reading %s configuration: %v\n", formatName(filepath.Ext(path)), err)
+		os.Exit(formatError(filepath.Ext(path)))
+	}
+
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Error validating the configuration: %s", err)
+		os.Exit(errors.ValidatingConfiguration)
+	}
+
+	return config
+}
+
+// formatName - Name of the format of a file extension
+func formatName(format string) string {
+	if format == ".json" {
+		return "json"
+	}
+
+	return "yaml"
+}
+
+// formatError - Exit code of the errors reading a format
+func formatError(format string) int {
+	if format == ".json" {
+		return errors.ReadingJSONConfiguration
+	}
+
+	return errors.ReadingYAMLConfiguration
+}
+
+// Save - Write the configuration to path, in the format taken from
+// its extension. The file is replaced atomically, keeping its
+// permissions, and a new one is only readable by its owner, as it
+// holds the secrets of the keys
+func Save(path string, config Configuration) error {
+	var data []byte
+	var err error
+
+	switch filepath.Ext(path) {
+	case ".json":
+		data, err = json.MarshalIndent(config, "", "  ")
+	default:
+		data, err = yaml.Marshal(config)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	mode := os.FileMode(0600)
+
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	temporary := path + ".tmp"
+	err = ioutil.WriteFile(temporary, data, mode)
+
+	if err != nil {
+		return err
+	}
+
+	// The temporary file of a previous write keeps its permissions
+	if err := os.Chmod(temporary, mode); err != nil {
+		return err
+	}
 
-	return Parse(file, ext)
+	return os.Rename(temporary, path)
 }
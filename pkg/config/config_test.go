@@ -7,8 +7,14 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/lucasdc6/gdns/pkg/types"
 )
 
 func TestParse(t *testing.T) {
@@ -46,6 +52,39 @@ func TestParse(t *testing.T) {
 			},
 			wantConfig: Configuration{},
 		},
+		{
+			name: "Views (YAML)",
+			args: args{
+				configStr: []byte(`
+views:
+  - name: vpn
+    match-clients: [10.8.0.0/16]
+    match-keys: [vpn-key]
+    forwarder: 10.8.0.1
+    zones:
+      - name: corp.test
+        records:
+          - name: www
+            type: A
+            value: 10.8.0.80
+zones: []
+`),
+				format: ".yaml",
+			},
+			wantConfig: Configuration{
+				Zones: []Zone{},
+				Views: []View{{
+					Name:         "vpn",
+					MatchClients: []string{"10.8.0.0/16"},
+					MatchKeys:    []string{"vpn-key"},
+					Forwarder:    "10.8.0.1",
+					Zones: []Zone{{
+						Name:    "corp.test",
+						Records: []Record{{Name: "www", Type: types.A, Value: "10.8.0.80"}},
+					}},
+				}},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -56,3 +95,238 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	malformed := filepath.Join(dir, "malformed.yaml")
+	invalidACL := filepath.Join(dir, "invalid-acl.yaml")
+	os.WriteFile(valid, []byte("global:\n  forwarder: 127.0.0.1:53\n"), 0644)
+	os.WriteFile(malformed, []byte("global: [forwarder\n"), 0644)
+	os.WriteFile(invalidACL, []byte("global:\n  allow-query: [localhost]\n"), 0644)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantConfig Configuration
+		wantErr    bool
+	}{
+		{
+			name:       "Valid file",
+			path:       valid,
+			wantConfig: Configuration{Global: Global{Forwarder: "127.0.0.1:53"}},
+		},
+		{
+			name:    "Missing file",
+			path:    filepath.Join(dir, "missing.yaml"),
+			wantErr: true,
+		},
+		{
+			name:    "Malformed file",
+			path:    malformed,
+			wantErr: true,
+		},
+		{
+			name:    "Invalid access list",
+			path:    invalidACL,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotConfig, err := Read(tt.path)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Read() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(gotConfig, tt.wantConfig) {
+				t.Errorf("Read() = %v, want %v", gotConfig, tt.wantConfig)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Configuration
+		wantErr string
+	}{
+		{
+			name: "Addresses and networks",
+			config: Configuration{
+				Global:    Global{AllowQuery: []string{"127.0.0.1", "::1"}, AllowRecursion: []string{"10.0.0.0/8", "2001:db8::/32"}},
+				Zones:     []Zone{{Name: "example.com", AllowUpdate: []string{"192.0.2.1"}}},
+				Views:     []View{{Name: "internal", MatchClients: []string{"192.168.0.0/16"}}},
+				Listeners: []Listener{{AllowQuery: []string{"fe80::1"}}},
+			},
+		},
+		{
+			name:    "Hostname in global allow-query",
+			config:  Configuration{Global: Global{AllowQuery: []string{"localhost"}}},
+			wantErr: `invalid address or network "localhost" in global allow-query`,
+		},
+		{
+			name:    "Invalid network in global allow-recursion",
+			config:  Configuration{Global: Global{AllowRecursion: []string{"10.0.0.0/33"}}},
+			wantErr: `invalid address or network "10.0.0.0/33" in global allow-recursion`,
+		},
+		{
+			name:    "Invalid address in global blackhole",
+			config:  Configuration{Global: Global{Blackhole: []string{"192.0.2.256"}}},
+			wantErr: `invalid address or network "192.0.2.256" in global blackhole`,
+		},
+		{
+			name:    "Invalid address in allow-update of a zone",
+			config:  Configuration{Zones: []Zone{{Name: "example.com", AllowUpdate: []string{"192.0.2"}}}},
+			wantErr: `invalid address or network "192.0.2" in allow-update of zone example.com`,
+		},
+		{
+			name:    "Invalid network in allow-query of a zone",
+			config:  Configuration{Zones: []Zone{{Name: "example.com", AllowQuery: []string{"2001:db8::/"}}}},
+			wantErr: `invalid address or network "2001:db8::/" in allow-query of zone example.com`,
+		},
+		{
+			name:    "Empty entry in blackhole of a zone",
+			config:  Configuration{Zones: []Zone{{Name: "example.com", Blackhole: []string{""}}}},
+			wantErr: `invalid address or network "" in blackhole of zone example.com`,
+		},
+		{
+			name:    "Invalid address in match-clients of a view",
+			config:  Configuration{Views: []View{{Name: "internal", MatchClients: []string{"any"}}}},
+			wantErr: `invalid address or network "any" in match-clients of view internal`,
+		},
+		{
+			name: "Known keys in match-keys of a view",
+			config: Configuration{
+				Keys:  []Key{{Name: "internal-key.", Secret: "c2VjcmV0"}},
+				Views: []View{{Name: "internal", MatchKeys: []string{"Internal-Key"}}},
+			},
+		},
+		{
+			name: "Unknown key in match-keys of a view",
+			config: Configuration{
+				Keys:  []Key{{Name: "internal-key", Secret: "c2VjcmV0"}},
+				Views: []View{{Name: "internal", MatchKeys: []string{"internal-key", "external-key"}}},
+			},
+			wantErr: `unknown key "external-key" in match-keys of view internal`,
+		},
+		{
+			name:    "Path in the name of a view",
+			config:  Configuration{Views: []View{{Name: "../internal"}}},
+			wantErr: `invalid name "../internal" of view, it can't be a path`,
+		},
+		{
+			name:    "Parent directory as the name of a view",
+			config:  Configuration{Views: []View{{Name: ".."}}},
+			wantErr: `invalid name ".." of view, it can't be a path`,
+		},
+		{
+			name:    "Invalid address in a zone of a view",
+			config:  Configuration{Views: []View{{Name: "internal", Zones: []Zone{{Name: "example.com", AllowUpdate: []string{"none"}}}}}},
+			wantErr: `invalid address or network "none" in allow-update of zone example.com of view internal`,
+		},
+		{
+			name:    "Invalid address in allow-query of a listener",
+			config:  Configuration{Listeners: []Listener{{}, {AllowQuery: []string{"127.0.0.1:53"}}}},
+			wantErr: `invalid address or network "127.0.0.1:53" in allow-query of listener 2`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			gotErr := ""
+
+			if err != nil {
+				gotErr = err.Error()
+			}
+
+			if gotErr != tt.wantErr {
+				t.Errorf("Validate() error = %q, want %q", gotErr, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSave(t *testing.T) {
+	config := Configuration{Keys: []Key{{Name: "update-key", Algorithm: "hmac-sha256", Secret: "c2VjcmV0"}}}
+
+	tests := []struct {
+		name     string
+		existing os.FileMode
+		stale    bool
+		wantMode os.FileMode
+	}{
+		{
+			name:     "New file",
+			wantMode: 0600,
+		},
+		{
+			name:     "Existing file",
+			existing: 0640,
+			wantMode: 0640,
+		},
+		{
+			name:     "Existing file and temporary file of a previous write",
+			existing: 0600,
+			stale:    true,
+			wantMode: 0600,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+
+			if tt.existing != 0 {
+				os.WriteFile(path, []byte("zones: []\n"), tt.existing)
+				os.Chmod(path, tt.existing)
+			}
+
+			if tt.stale {
+				os.WriteFile(path+".tmp", []byte("zones: []\n"), 0644)
+				os.Chmod(path+".tmp", 0644)
+			}
+
+			if err := Save(path, config); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+
+			info, err := os.Stat(path)
+
+			if err != nil {
+				t.Fatalf("Stat() error = %v", err)
+			}
+
+			if info.Mode().Perm() != tt.wantMode {
+				t.Errorf("Save() mode = %v, want %v", info.Mode().Perm(), tt.wantMode)
+			}
+
+			saved, err := Read(path)
+
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(saved.Keys, config.Keys) {
+				t.Errorf("Read() keys = %v, want %v", saved.Keys, config.Keys)
+			}
+		})
+	}
+}
+
+func TestKeyString(t *testing.T) {
+	config := Configuration{Keys: []Key{{Name: "update-key", Algorithm: "hmac-sha256", Secret: "c2VjcmV0LXNoYXJlZC1ieS10aGUtc2VydmVycw=="}}}
+	got := fmt.Sprintf("%+v", config)
+
+	if strings.Contains(got, config.Keys[0].Secret) {
+		t.Errorf("Sprintf() = %s, shows the secret of the key", got)
+	}
+
+	if !strings.Contains(got, "{Name:update-key Algorithm:hmac-sha256 Secret:<redacted>}") {
+		t.Errorf("Sprintf() = %s, without the redacted key", got)
+	}
+}
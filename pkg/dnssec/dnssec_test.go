@@ -0,0 +1,134 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dnssec_test define the test for the dnssec package
+package dnssec_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/dnssec"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+func record(name, rdata string) types.DNSResource {
+	return types.DNSResource{Name: name, Type: types.A, Class: types.IN, TTL: 300, RData: rdata}
+}
+
+func TestSignVerify(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name      string
+		algorithm uint8
+		signed    []types.DNSResource
+		received  []types.DNSResource
+		at        time.Time
+		want      error
+	}{
+		{
+			name:      "ECDSA P-256",
+			algorithm: dnssec.ECDSAP256SHA256,
+			signed:    []types.DNSResource{record("a.example.com", "192.168.0.1"), record("a.example.com", "192.168.0.2")},
+			received:  []types.DNSResource{record("A.example.com", "192.168.0.2"), record("a.example.com", "192.168.0.1")},
+			at:        now,
+		},
+		{
+			name:      "Ed25519",
+			algorithm: dnssec.ED25519,
+			signed:    []types.DNSResource{record("a.example.com", "192.168.0.1")},
+			received:  []types.DNSResource{record("a.example.com", "192.168.0.1")},
+			at:        now,
+		},
+		{
+			name:      "Wildcard",
+			algorithm: dnssec.ED25519,
+			signed:    []types.DNSResource{record("*.example.com", "192.168.0.1")},
+			received:  []types.DNSResource{record("b.a.example.com", "192.168.0.1")},
+			at:        now,
+		},
+		{
+			name:      "Modified RRset",
+			algorithm: dnssec.ECDSAP256SHA256,
+			signed:    []types.DNSResource{record("a.example.com", "192.168.0.1")},
+			received:  []types.DNSResource{record("a.example.com", "192.168.0.3")},
+			at:        now,
+			want:      dnssec.ErrSignature,
+		},
+		{
+			name:      "Expired",
+			algorithm: dnssec.ED25519,
+			signed:    []types.DNSResource{record("a.example.com", "192.168.0.1")},
+			received:  []types.DNSResource{record("a.example.com", "192.168.0.1")},
+			at:        now.Add(48 * time.Hour),
+			want:      dnssec.ErrExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := dnssec.GenerateKey(tt.algorithm)
+
+			if err != nil {
+				t.Fatalf("GenerateKey() error = %v", err)
+			}
+
+			rrsig, err := dnssec.Sign(tt.signed, key, "example.com", now.Add(-time.Hour), now.Add(24*time.Hour))
+
+			if err != nil {
+				t.Fatalf("Sign() error = %v", err)
+			}
+
+			err = dnssec.Verify(tt.received, rrsig, key.DNSKEY(), tt.at)
+
+			if diff := cmp.Diff(tt.want, err, cmp.Comparer(func(a, b error) bool { return a == b })); diff != "" {
+				t.Errorf("Verify() error mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func nsec(name, rdata string) types.DNSResource {
+	return types.DNSResource{Name: name, Type: types.NSEC, Class: types.IN, TTL: 300, RData: rdata}
+}
+
+func TestProveDenial(t *testing.T) {
+	apex := nsec("example.com", "a.example.com SOA NS RRSIG NSEC DNSKEY")
+	a := nsec("a.example.com", "c.b.example.com A RRSIG NSEC")
+	c := nsec("c.b.example.com", "example.com TXT RRSIG NSEC")
+
+	tests := []struct {
+		name    string
+		qname   string
+		qtype   types.QType
+		records []types.DNSResource
+		want    bool
+	}{
+		{name: "Name error", qname: "aa.example.com", records: []types.DNSResource{a, apex}, want: true},
+		{name: "Name error without wildcard proof", qname: "aa.example.com", records: []types.DNSResource{a}},
+		{name: "Name error after the last name", qname: "d.b.example.com", records: []types.DNSResource{c, a}, want: true},
+		{name: "Existing name", qname: "a.example.com", records: []types.DNSResource{a, apex}},
+		{name: "No data", qname: "a.example.com", qtype: types.AAAA, records: []types.DNSResource{a}, want: true},
+		{name: "Existing type", qname: "a.example.com", qtype: types.A, records: []types.DNSResource{a}},
+		{name: "Empty non-terminal", qname: "b.example.com", qtype: types.A, records: []types.DNSResource{a}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got bool
+
+			if tt.qtype.Code == 0 {
+				got = dnssec.ProveNameError(tt.qname, tt.records)
+			} else {
+				got = dnssec.ProveNoData(tt.qname, tt.qtype, tt.records)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("proof mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
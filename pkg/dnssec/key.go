@@ -0,0 +1,226 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dnssec define the keys and signatures used to
+// authenticate the zones (RFC 4033, RFC 4034 and RFC 5155)
+package dnssec
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// Algorithms (RFC 8624 - Section 3.1). Only ECDSAP256SHA256 and
+// ED25519 are used to sign, all of them are verified
+const (
+	RSASHA1          = 5
+	RSASHA1NSEC3SHA1 = 7
+	RSASHA256        = 8
+	RSASHA512        = 10
+	ECDSAP256SHA256  = 13
+	ECDSAP384SHA384  = 14
+	ED25519          = 15
+)
+
+// Flags of the DNSKEY records (RFC 4034 - Section 2.1.1)
+const (
+	ZoneKey          = 256
+	SecureEntryPoint = 1
+)
+
+// digestSHA256 - Digest type of the DS records (RFC 4509)
+const digestSHA256 = 2
+
+// algorithmNames - Mnemonics of the algorithms (RFC 8624)
+var algorithmNames = map[string]uint8{
+	"ecdsap256sha256": ECDSAP256SHA256,
+	"ed25519":         ED25519,
+}
+
+// Key - Key of a zone, used to sign all its RRsets as a combined
+// signing key (RFC 6781 - Section 3.1)
+type Key struct {
+	Algorithm uint8
+	Flags     uint16
+	signer    crypto.Signer
+}
+
+// AlgorithmFromString - Algorithm number from its mnemonic or number.
+// The empty string is ECDSAP256SHA256
+func AlgorithmFromString(name string) (uint8, error) {
+	if name == "" {
+		return ECDSAP256SHA256, nil
+	}
+
+	if algorithm, ok := algorithmNames[strings.ToLower(name)]; ok {
+		return algorithm, nil
+	}
+
+	if number, err := strconv.ParseUint(name, 10, 8); err == nil {
+		for _, algorithm := range algorithmNames {
+			if uint8(number) == algorithm {
+				return algorithm, nil
+			}
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported DNSSEC algorithm %q", name)
+}
+
+// GenerateKey - Generate a new key of the algorithm
+func GenerateKey(algorithm uint8) (*Key, error) {
+	var signer crypto.Signer
+	var err error
+
+	switch algorithm {
+	case ECDSAP256SHA256:
+		signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case ED25519:
+		_, signer, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported DNSSEC algorithm %d", algorithm)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{Algorithm: algorithm, Flags: ZoneKey | SecureEntryPoint, signer: signer}, nil
+}
+
+// LoadKey - Read the private key stored in path, in PKCS #8 format,
+// generating and storing a new one when the file doesn't exist
+func LoadKey(path string, algorithm uint8) (*Key, bool, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		key, err := GenerateKey(algorithm)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		return key, true, key.Save(path)
+	}
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, false, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %s", path, err)
+	}
+
+	key := &Key{Flags: ZoneKey | SecureEntryPoint}
+
+	switch private := private.(type) {
+	case *ecdsa.PrivateKey:
+		key.Algorithm, key.signer = ECDSAP256SHA256, private
+	case ed25519.PrivateKey:
+		key.Algorithm, key.signer = ED25519, private
+	default:
+		return nil, false, fmt.Errorf("%s: unsupported key type %T", path, private)
+	}
+
+	if key.Algorithm != algorithm {
+		return nil, false, fmt.Errorf("%s: key of algorithm %d, configured %d", path, key.Algorithm, algorithm)
+	}
+
+	return key, false, nil
+}
+
+// Save - Store the private key in path, in PKCS #8 format
+func (key *Key) Save(path string) error {
+	data, err := x509.MarshalPKCS8PrivateKey(key.signer)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: data}), 0600)
+}
+
+// PublicKey - Wire format of the public key, as in the DNSKEY records
+// (RFC 6605 - Section 4 and RFC 8080 - Section 3)
+func (key *Key) PublicKey() []byte {
+	switch public := key.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		return append(padded(public.X, 32), padded(public.Y, 32)...)
+	case ed25519.PublicKey:
+		return public
+	}
+
+	return nil
+}
+
+// padded - Big endian value of an integer, left padded to size bytes
+func padded(value *big.Int, size int) []byte {
+	data := value.Bytes()
+
+	return append(make([]byte, size-len(data)), data...)
+}
+
+// DNSKEY - RDATA of the DNSKEY record of the key
+func (key *Key) DNSKEY() string {
+	return fmt.Sprintf("%d 3 %d %s", key.Flags, key.Algorithm, base64.StdEncoding.EncodeToString(key.PublicKey()))
+}
+
+// KeyTag - Tag of the key (RFC 4034 - Appendix B)
+func (key *Key) KeyTag() uint16 {
+	rdata, _ := parser.BuildRData(types.DNSKEY, key.DNSKEY())
+
+	return KeyTag(rdata)
+}
+
+// KeyTag - Tag of a DNSKEY RDATA in wire format (RFC 4034 - Appendix B)
+func KeyTag(rdata []byte) uint16 {
+	var accumulator uint32
+
+	for i, octet := range rdata {
+		if i&1 == 0 {
+			accumulator += uint32(octet) << 8
+		} else {
+			accumulator += uint32(octet)
+		}
+	}
+
+	accumulator += accumulator >> 16 & 0xFFFF
+
+	return uint16(accumulator)
+}
+
+// DS - RDATA of the DS record of the key for the zone, to be
+// published in the parent zone (RFC 4034 - Section 5.1.4)
+func (key *Key) DS(zone string) string {
+	owner, _ := parser.BuildName(strings.ToLower(zone))
+	rdata, _ := parser.BuildRData(types.DNSKEY, key.DNSKEY())
+	digest := sha256.Sum256(append(owner, rdata...))
+
+	return fmt.Sprintf("%d %d %d %s", key.KeyTag(), key.Algorithm, digestSHA256, strings.ToUpper(hex.EncodeToString(digest[:])))
+}
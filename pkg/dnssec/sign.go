@@ -0,0 +1,398 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dnssec define the keys and signatures used to
+// authenticate the zones (RFC 4033, RFC 4034 and RFC 5155)
+package dnssec
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// timeFormat - Presentation format of the RRSIG timestamps
+const timeFormat = "20060102150405"
+
+// base32Hex - Encoding of the hashed owner names of NSEC3
+// (RFC 5155 - Section 3.3)
+var base32Hex = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// Verification errors
+var (
+	ErrSignature = errors.New("invalid signature")
+	ErrExpired   = errors.New("signature out of its validity period")
+	ErrKey       = errors.New("signature made with another key")
+)
+
+// rrsigHeader - Fields of the RRSIG RDATA before the signature
+// (RFC 4034 - Section 3.1)
+type rrsigHeader struct {
+	TypeCovered uint16
+	Algorithm   uint8
+	Labels      uint8
+	OriginalTTL uint32
+	Expiration  uint32
+	Inception   uint32
+	KeyTag      uint16
+	SignerName  string
+}
+
+// wire - Wire format of the fields, with the signer name in
+// canonical form
+func (header rrsigHeader) wire() ([]byte, error) {
+	data := make([]byte, 18)
+	binary.BigEndian.PutUint16(data[0:], header.TypeCovered)
+	data[2] = header.Algorithm
+	data[3] = header.Labels
+	binary.BigEndian.PutUint32(data[4:], header.OriginalTTL)
+	binary.BigEndian.PutUint32(data[8:], header.Expiration)
+	binary.BigEndian.PutUint32(data[12:], header.Inception)
+	binary.BigEndian.PutUint16(data[16:], header.KeyTag)
+
+	signer, err := parser.BuildName(strings.ToLower(header.SignerName))
+
+	return append(data, signer...), err
+}
+
+// Labels - Number of labels of an owner name, without the root and
+// the leftmost wildcard label (RFC 4034 - Section 3.1.3)
+func Labels(name string) int {
+	name = strings.TrimSuffix(name, ".")
+
+	if name == "" {
+		return 0
+	}
+
+	labels := strings.Count(name, ".") + 1
+
+	if strings.HasPrefix(name, "*.") || name == "*" {
+		labels--
+	}
+
+	return labels
+}
+
+// signedData - Data covered by the signature of a RRset
+// (RFC 4034 - Section 3.1.8.1)
+func signedData(header rrsigHeader, owner string, rrset []types.DNSResource) ([]byte, error) {
+	data, err := header.wire()
+
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := parser.BuildName(strings.ToLower(owner))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 4034 - Section 6.3: the records are sorted by their
+	// canonical RDATA, without duplicates
+	rdatas := [][]byte{}
+
+	for _, record := range rrset {
+		rdata, err := parser.BuildCanonicalRData(record.Type, record.RData)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %s", record.Name, record.Type, err)
+		}
+
+		rdatas = append(rdatas, rdata)
+	}
+
+	sort.Slice(rdatas, func(i, j int) bool {
+		return bytes.Compare(rdatas[i], rdatas[j]) < 0
+	})
+
+	for i, rdata := range rdatas {
+		if i > 0 && bytes.Equal(rdata, rdatas[i-1]) {
+			continue
+		}
+
+		fields := make([]byte, 10)
+		binary.BigEndian.PutUint16(fields[0:], header.TypeCovered)
+		binary.BigEndian.PutUint16(fields[2:], uint16(rrset[0].Class.Code))
+		binary.BigEndian.PutUint32(fields[4:], header.OriginalTTL)
+		binary.BigEndian.PutUint16(fields[8:], uint16(len(rdata)))
+
+		data = append(data, name...)
+		data = append(data, fields...)
+		data = append(data, rdata...)
+	}
+
+	return data, nil
+}
+
+// Sign - RRSIG record of a RRset of the zone signer, valid between
+// inception and expiration. A RRset owned by a wildcard is signed
+// with the labels of the wildcard
+func Sign(rrset []types.DNSResource, key *Key, signer string, inception, expiration time.Time) (types.DNSResource, error) {
+	if len(rrset) == 0 {
+		return types.DNSResource{}, fmt.Errorf("empty RRset")
+	}
+
+	owner := rrset[0].Name
+	header := rrsigHeader{
+		TypeCovered: uint16(rrset[0].Type.Code),
+		Algorithm:   key.Algorithm,
+		Labels:      uint8(Labels(owner)),
+		OriginalTTL: uint32(rrset[0].TTL),
+		Expiration:  uint32(expiration.Unix()),
+		Inception:   uint32(inception.Unix()),
+		KeyTag:      key.KeyTag(),
+		SignerName:  signer,
+	}
+
+	data, err := signedData(header, owner, rrset)
+
+	if err != nil {
+		return types.DNSResource{}, err
+	}
+
+	signature, err := key.sign(data)
+
+	if err != nil {
+		return types.DNSResource{}, err
+	}
+
+	if signer == "" {
+		signer = "."
+	}
+
+	return types.DNSResource{
+		Name:  owner,
+		Type:  types.RRSIG,
+		Class: rrset[0].Class,
+		TTL:   rrset[0].TTL,
+		RData: fmt.Sprintf("%s %d %d %d %s %s %d %s %s",
+			rrset[0].Type.Name, header.Algorithm, header.Labels, header.OriginalTTL,
+			expiration.UTC().Format(timeFormat), inception.UTC().Format(timeFormat),
+			header.KeyTag, signer, base64.StdEncoding.EncodeToString(signature)),
+	}, nil
+}
+
+// sign - Signature of the data with the private key
+func (key *Key) sign(data []byte) ([]byte, error) {
+	switch private := key.signer.(type) {
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(data)
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+
+		if err != nil {
+			return nil, err
+		}
+
+		// RFC 6605 - Section 4: the signature is r followed by s
+		return append(padded(r, 32), padded(s, 32)...), nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(private, data), nil
+	}
+
+	return nil, fmt.Errorf("unsupported DNSSEC algorithm %d", key.Algorithm)
+}
+
+// parseRRSIG - Fields and signature of a RRSIG RDATA
+func parseRRSIG(value string) (rrsigHeader, []byte, error) {
+	header := rrsigHeader{}
+	rdata, err := parser.BuildRData(types.RRSIG, value)
+
+	if err != nil {
+		return header, nil, err
+	}
+
+	if len(rdata) < 19 {
+		return header, nil, fmt.Errorf("RRSIG rdata too short")
+	}
+
+	header.TypeCovered = binary.BigEndian.Uint16(rdata[0:])
+	header.Algorithm = rdata[2]
+	header.Labels = rdata[3]
+	header.OriginalTTL = binary.BigEndian.Uint32(rdata[4:])
+	header.Expiration = binary.BigEndian.Uint32(rdata[8:])
+	header.Inception = binary.BigEndian.Uint32(rdata[12:])
+	header.KeyTag = binary.BigEndian.Uint16(rdata[16:])
+
+	offset := 18
+	labels := []string{}
+
+	for offset < len(rdata) && rdata[offset] != 0 {
+		length := int(rdata[offset])
+
+		if offset+1+length > len(rdata) {
+			return header, nil, fmt.Errorf("RRSIG signer name out of bounds")
+		}
+
+		labels = append(labels, string(rdata[offset+1:offset+1+length]))
+		offset += 1 + length
+	}
+
+	if offset >= len(rdata) {
+		return header, nil, fmt.Errorf("RRSIG signer name out of bounds")
+	}
+
+	header.SignerName = strings.Join(labels, ".")
+
+	return header, rdata[offset+1:], nil
+}
+
+// Verify - Check the RRSIG of a RRset with the DNSKEY RDATA of the
+// signer, at the time now (RFC 4035 - Section 5.3)
+func Verify(rrset []types.DNSResource, rrsig types.DNSResource, dnskey string, now time.Time) error {
+	if len(rrset) == 0 {
+		return fmt.Errorf("empty RRset")
+	}
+
+	header, signature, err := parseRRSIG(rrsig.RData)
+
+	if err != nil {
+		return err
+	}
+
+	keyData, err := parser.BuildRData(types.DNSKEY, dnskey)
+
+	if err != nil {
+		return err
+	}
+
+	if len(keyData) < 4 || keyData[3] != header.Algorithm || KeyTag(keyData) != header.KeyTag {
+		return ErrKey
+	}
+
+	seconds := uint32(now.Unix())
+
+	if int32(seconds-header.Inception) < 0 || int32(header.Expiration-seconds) < 0 {
+		return ErrExpired
+	}
+
+	// RFC 4035 - Section 5.3.2: the owner of an answer synthesized
+	// from a wildcard is rebuilt with the labels of the signature
+	owner := strings.ToLower(strings.TrimSuffix(rrset[0].Name, "."))
+	labels := strings.Split(owner, ".")
+
+	if owner != "" && int(header.Labels) < len(labels) {
+		owner = strings.Join(append([]string{"*"}, labels[len(labels)-int(header.Labels):]...), ".")
+	}
+
+	data, err := signedData(header, owner, rrset)
+
+	if err != nil {
+		return err
+	}
+
+	if !verifySignature(header.Algorithm, keyData[4:], data, signature) {
+		return ErrSignature
+	}
+
+	return nil
+}
+
+// verifySignature - Check a signature with a public key in the
+// format of the DNSKEY records
+func verifySignature(algorithm uint8, public, data, signature []byte) bool {
+	switch algorithm {
+	case RSASHA1, RSASHA1NSEC3SHA1, RSASHA256, RSASHA512:
+		key := parseRSAKey(public)
+
+		if key == nil {
+			return false
+		}
+
+		hash := map[uint8]crypto.Hash{RSASHA1: crypto.SHA1, RSASHA1NSEC3SHA1: crypto.SHA1, RSASHA256: crypto.SHA256, RSASHA512: crypto.SHA512}[algorithm]
+		digest := hash.New()
+		digest.Write(data)
+
+		return rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), signature) == nil
+	case ECDSAP256SHA256, ECDSAP384SHA384:
+		curve, hash, size := elliptic.P256(), crypto.SHA256, 32
+
+		if algorithm == ECDSAP384SHA384 {
+			curve, hash, size = elliptic.P384(), crypto.SHA384, 48
+		}
+
+		if len(public) != 2*size || len(signature) != 2*size {
+			return false
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(public[:size]),
+			Y:     new(big.Int).SetBytes(public[size:]),
+		}
+		digest := hash.New()
+		digest.Write(data)
+
+		return ecdsa.Verify(key, digest.Sum(nil), new(big.Int).SetBytes(signature[:size]), new(big.Int).SetBytes(signature[size:]))
+	case ED25519:
+		return len(public) == ed25519.PublicKeySize && ed25519.Verify(ed25519.PublicKey(public), data, signature)
+	}
+
+	return false
+}
+
+// parseRSAKey - RSA public key in the format of RFC 3110 - Section 2
+func parseRSAKey(public []byte) *rsa.PublicKey {
+	if len(public) < 3 {
+		return nil
+	}
+
+	length, offset := int(public[0]), 1
+
+	if length == 0 {
+		length, offset = int(binary.BigEndian.Uint16(public[1:])), 3
+	}
+
+	if length == 0 || length > 4 || offset+length >= len(public) {
+		return nil
+	}
+
+	exponent := 0
+	for _, octet := range public[offset : offset+length] {
+		exponent = exponent<<8 | int(octet)
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(public[offset+length:]), E: exponent}
+}
+
+// Supported - Check if the signatures of the algorithm can be verified
+func Supported(algorithm uint8) bool {
+	switch algorithm {
+	case RSASHA1, RSASHA1NSEC3SHA1, RSASHA256, RSASHA512, ECDSAP256SHA256, ECDSAP384SHA384, ED25519:
+		return true
+	}
+
+	return false
+}
+
+// HashName - Hashed owner name of NSEC3 (RFC 5155 - Section 5),
+// encoded in lowercase base32hex
+func HashName(name string, salt []byte, iterations uint16) string {
+	wire, _ := parser.BuildName(strings.ToLower(name))
+	digest := sha1.Sum(append(wire, salt...))
+
+	for i := 0; i < int(iterations); i++ {
+		digest = sha1.Sum(append(digest[:], salt...))
+	}
+
+	return base32Hex.EncodeToString(digest[:])
+}
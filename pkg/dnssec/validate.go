@@ -0,0 +1,517 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package dnssec define the keys and signatures used to
+// authenticate the zones (RFC 4033, RFC 4034 and RFC 5155)
+package dnssec
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// Status - Security status of a RRset or a response (RFC 4035 - Section 4.3)
+type Status int
+
+// Security status
+const (
+	Insecure Status = iota
+	Secure
+	Bogus
+)
+
+func (status Status) String() string {
+	switch status {
+	case Secure:
+		return "secure"
+	case Bogus:
+		return "bogus"
+	}
+
+	return "insecure"
+}
+
+// NSEC3 flags (RFC 5155 - Section 3.1.2)
+const optOut = 1
+
+// canonicalName - Lowercase name without the trailing dot
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// labels - Labels of a name, from the leftmost one
+func labels(name string) []string {
+	name = canonicalName(name)
+
+	if name == "" {
+		return nil
+	}
+
+	return strings.Split(name, ".")
+}
+
+// IsSubdomain - Check if name is equal or below parent
+func IsSubdomain(name, parent string) bool {
+	name, parent = canonicalName(name), canonicalName(parent)
+
+	return parent == "" || name == parent || strings.HasSuffix(name, "."+parent)
+}
+
+// CompareNames - Canonical order of two names (RFC 4034 - Section 6.1)
+func CompareNames(a, b string) int {
+	first, second := labels(a), labels(b)
+
+	for i, j := len(first)-1, len(second)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if result := strings.Compare(first[i], second[j]); result != 0 {
+			return result
+		}
+	}
+
+	switch {
+	case len(first) < len(second):
+		return -1
+	case len(first) > len(second):
+		return 1
+	}
+
+	return 0
+}
+
+// DSDigest - Digest of a DNSKEY RDATA for the DS records
+// (RFC 4034 - Section 5.1.4), empty for the unknown digest types
+func DSDigest(owner, dnskey string, digestType uint8) string {
+	name, err := parser.BuildName(canonicalName(owner))
+
+	if err != nil {
+		return ""
+	}
+
+	rdata, err := parser.BuildRData(types.DNSKEY, dnskey)
+
+	if err != nil {
+		return ""
+	}
+
+	data := append(name, rdata...)
+
+	switch digestType {
+	case 1:
+		digest := sha1.Sum(data)
+		return hex.EncodeToString(digest[:])
+	case digestSHA256:
+		digest := sha256.Sum256(data)
+		return hex.EncodeToString(digest[:])
+	case 4:
+		digest := sha512.Sum384(data)
+		return hex.EncodeToString(digest[:])
+	}
+
+	return ""
+}
+
+// supportedDigest - Check if the DS digest type is known
+func supportedDigest(digestType uint8) bool {
+	return digestType == 1 || digestType == digestSHA256 || digestType == 4
+}
+
+// dnskeyFields - Flags and algorithm of a DNSKEY RDATA, with its tag
+func dnskeyFields(dnskey string) (flags uint16, algorithm uint8, tag uint16, err error) {
+	rdata, err := parser.BuildRData(types.DNSKEY, dnskey)
+
+	if err != nil || len(rdata) < 4 {
+		return 0, 0, 0, fmt.Errorf("invalid DNSKEY %q", dnskey)
+	}
+
+	return uint16(rdata[0])<<8 | uint16(rdata[1]), rdata[3], KeyTag(rdata), nil
+}
+
+// AnchoredKeys - Keys of the DNSKEY RRset of a zone matching one of the
+// trust anchors or DS records of the zone, in DS or DNSKEY format.
+// The second value is false when none of the anchors use a supported
+// algorithm and digest, and the zone must be treated as insecure
+// (RFC 4035 - Section 5.2)
+func AnchoredKeys(zone string, dnskeys, anchors []types.DNSResource) ([]string, bool) {
+	matched := []string{}
+	supported := false
+
+	for _, anchor := range anchors {
+		fields := strings.Fields(anchor.RData)
+
+		if anchor.Type.Code == types.DNSKEY.Code {
+			if _, algorithm, _, err := dnskeyFields(anchor.RData); err == nil && Supported(algorithm) {
+				supported = true
+				matched = append(matched, anchor.RData)
+			}
+
+			continue
+		}
+
+		if len(fields) < 4 {
+			continue
+		}
+
+		tag, _ := strconv.Atoi(fields[0])
+		algorithm, _ := strconv.Atoi(fields[1])
+		digestType, _ := strconv.Atoi(fields[2])
+
+		if !Supported(uint8(algorithm)) || !supportedDigest(uint8(digestType)) {
+			continue
+		}
+
+		supported = true
+
+		for _, dnskey := range dnskeys {
+			_, keyAlgorithm, keyTag, err := dnskeyFields(dnskey.RData)
+
+			if err != nil || int(keyTag) != tag || int(keyAlgorithm) != algorithm {
+				continue
+			}
+
+			if strings.EqualFold(DSDigest(zone, dnskey.RData, uint8(digestType)), strings.Join(fields[3:], "")) {
+				matched = append(matched, dnskey.RData)
+			}
+		}
+	}
+
+	return matched, supported
+}
+
+// VerifyRRset - Check that one of the RRSIG of a RRset was made by one of
+// the zone keys, and the signer is the zone. The RRSIG covering other
+// RRsets are ignored
+func VerifyRRset(rrset, rrsigs []types.DNSResource, zone string, keys []string, now time.Time) error {
+	err := fmt.Errorf("%s %s without signatures", rrset[0].Name, rrset[0].Type)
+
+	for _, rrsig := range rrsigs {
+		header, _, parseErr := parseRRSIG(rrsig.RData)
+
+		if parseErr != nil || int(header.TypeCovered) != rrset[0].Type.Code || canonicalName(rrsig.Name) != canonicalName(rrset[0].Name) {
+			continue
+		}
+
+		if canonicalName(header.SignerName) != canonicalName(zone) || int(header.Labels) > len(labels(rrset[0].Name)) {
+			err = fmt.Errorf("%s %s signed by %s", rrset[0].Name, rrset[0].Type, header.SignerName)
+			continue
+		}
+
+		for _, key := range keys {
+			flags, _, _, keyErr := dnskeyFields(key)
+
+			if keyErr != nil || flags&ZoneKey == 0 {
+				continue
+			}
+
+			if err = Verify(rrset, rrsig, key, now); err == nil {
+				return nil
+			}
+		}
+	}
+
+	return err
+}
+
+// Signer - Zone that signed a RRset, the signer name of its first RRSIG
+func Signer(rrset, rrsigs []types.DNSResource) (string, bool) {
+	for _, rrsig := range rrsigs {
+		header, _, err := parseRRSIG(rrsig.RData)
+
+		if err == nil && int(header.TypeCovered) == rrset[0].Type.Code && canonicalName(rrsig.Name) == canonicalName(rrset[0].Name) {
+			return canonicalName(header.SignerName), true
+		}
+	}
+
+	return "", false
+}
+
+// WildcardEncloser - For a RRset synthesized from a wildcard, the
+// closest encloser of the name asked, given by the labels of its
+// RRSIG (RFC 4035 - Section 5.3.4)
+func WildcardEncloser(rrset, rrsigs []types.DNSResource) (string, bool) {
+	owner := labels(rrset[0].Name)
+
+	for _, rrsig := range rrsigs {
+		header, _, err := parseRRSIG(rrsig.RData)
+
+		if err == nil && int(header.TypeCovered) == rrset[0].Type.Code && canonicalName(rrsig.Name) == canonicalName(rrset[0].Name) {
+			if int(header.Labels) < len(owner) {
+				return strings.Join(owner[len(owner)-int(header.Labels):], "."), true
+			}
+
+			return "", false
+		}
+	}
+
+	return "", false
+}
+
+// denialRecord - NSEC or NSEC3 record of a response
+type denialRecord struct {
+	owner string
+	// next - Next owner name of a NSEC, or next hash of a NSEC3
+	next  string
+	types map[string]bool
+	// NSEC3 parameters
+	hashed     bool
+	flags      int
+	iterations uint16
+	salt       []byte
+}
+
+// parseDenial - NSEC and NSEC3 records of a list of records
+func parseDenial(records []types.DNSResource) []denialRecord {
+	denials := []denialRecord{}
+
+	for _, record := range records {
+		fields := strings.Fields(record.RData)
+		denial := denialRecord{owner: canonicalName(record.Name), types: map[string]bool{}}
+
+		switch {
+		case record.Type.Code == types.NSEC.Code && len(fields) >= 1:
+			denial.next = canonicalName(fields[0])
+			fields = fields[1:]
+		case record.Type.Code == types.NSEC3.Code && len(fields) >= 5:
+			iterations, _ := strconv.Atoi(fields[2])
+			flags, _ := strconv.Atoi(fields[1])
+			denial.hashed, denial.flags, denial.iterations = true, flags, uint16(iterations)
+			denial.next = strings.ToLower(fields[4])
+
+			if fields[3] != "-" {
+				denial.salt, _ = hex.DecodeString(fields[3])
+			}
+
+			fields = fields[5:]
+		default:
+			continue
+		}
+
+		for _, name := range fields {
+			denial.types[name] = true
+		}
+
+		denials = append(denials, denial)
+	}
+
+	return denials
+}
+
+// matches - Check if the NSEC or NSEC3 record is owned by name
+func (denial denialRecord) matches(name string) bool {
+	if !denial.hashed {
+		return denial.owner == canonicalName(name)
+	}
+
+	return labels(denial.owner)[0] == HashName(name, denial.salt, denial.iterations)
+}
+
+// covers - Check if name is between the owner and the next name of
+// the NSEC or NSEC3 record, the last record covering the names after
+// its owner
+func (denial denialRecord) covers(name string) bool {
+	if !denial.hashed {
+		owner := CompareNames(denial.owner, name)
+		next := CompareNames(name, denial.next)
+
+		if CompareNames(denial.owner, denial.next) >= 0 {
+			// The last record of the chain covers the names after it
+			return owner < 0 || next < 0
+		}
+
+		return owner < 0 && next < 0
+	}
+
+	owner := labels(denial.owner)[0]
+	hash := HashName(name, denial.salt, denial.iterations)
+
+	if owner >= denial.next {
+		return hash > owner || hash < denial.next
+	}
+
+	return hash > owner && hash < denial.next
+}
+
+// find - First record of the list matching or covering name
+func find(denials []denialRecord, name string, match bool) (denialRecord, bool) {
+	for _, denial := range denials {
+		if (match && denial.matches(name)) || (!match && denial.covers(name)) {
+			return denial, true
+		}
+	}
+
+	return denialRecord{}, false
+}
+
+// noType - Check if the record proves that its owner doesn't have
+// qtype nor a CNAME
+func (denial denialRecord) noType(qtype types.QType) bool {
+	return !denial.types[qtype.Name] && !denial.types[types.CNAME.Name]
+}
+
+// closestEncloser - Closest encloser of name proven by the NSEC3
+// records, with the next closer name (RFC 5155 - Section 8.3)
+func closestEncloser(denials []denialRecord, name string) (string, string, bool) {
+	nameLabels := labels(name)
+
+	for i := 1; i <= len(nameLabels); i++ {
+		encloser := strings.Join(nameLabels[i:], ".")
+		next := strings.Join(nameLabels[i-1:], ".")
+
+		if _, ok := find(denials, encloser, true); ok {
+			_, covered := find(denials, next, false)
+
+			return encloser, next, covered
+		}
+	}
+
+	return "", "", false
+}
+
+// nsecEncloser - Closest encloser of a name proven by the NSEC record
+// covering it: the longest ancestor shared with its owner or next name
+func nsecEncloser(denial denialRecord, name string) string {
+	encloser := name
+
+	for encloser != "" && !(IsSubdomain(denial.owner, encloser) || IsSubdomain(denial.next, encloser)) {
+		encloser = strings.Join(labels(encloser)[1:], ".")
+	}
+
+	return encloser
+}
+
+// wildcard - Wildcard below a name
+func wildcard(name string) string {
+	return strings.TrimSuffix("*."+name, ".")
+}
+
+// ProveNameError - Check that the NSEC or NSEC3 records prove that
+// name doesn't exist nor a wildcard covering it
+// (RFC 4035 - Section 5.4 and RFC 5155 - Section 8.4)
+func ProveNameError(name string, records []types.DNSResource) bool {
+	denials := parseDenial(records)
+	name = canonicalName(name)
+
+	if len(denials) > 0 && denials[0].hashed {
+		encloser, _, ok := closestEncloser(denials, name)
+
+		if !ok || encloser == name {
+			return false
+		}
+
+		_, ok = find(denials, wildcard(encloser), false)
+
+		return ok
+	}
+
+	denial, ok := find(denials, name, false)
+
+	if !ok {
+		return false
+	}
+
+	_, ok = find(denials, wildcard(nsecEncloser(denial, name)), false)
+
+	return ok
+}
+
+// ProveNoData - Check that the NSEC or NSEC3 records prove that name
+// doesn't have records of qtype, directly or through a wildcard
+// (RFC 4035 - Section 5.4 and RFC 5155 - Sections 8.5 to 8.7)
+func ProveNoData(name string, qtype types.QType, records []types.DNSResource) bool {
+	denials := parseDenial(records)
+	name = canonicalName(name)
+
+	if denial, ok := find(denials, name, true); ok {
+		return denial.noType(qtype)
+	}
+
+	if len(denials) > 0 && denials[0].hashed {
+		encloser, next, ok := closestEncloser(denials, name)
+
+		if !ok {
+			return false
+		}
+
+		// A DS of an unsigned delegation in an opt-out span
+		if qtype.Code == types.DS.Code {
+			if denial, ok := find(denials, next, false); ok && denial.flags&optOut != 0 {
+				return true
+			}
+		}
+
+		denial, ok := find(denials, wildcard(encloser), true)
+
+		return ok && denial.noType(qtype)
+	}
+
+	denial, ok := find(denials, name, false)
+
+	if !ok {
+		return false
+	}
+
+	// An empty non-terminal is covered by the record before it
+	if IsSubdomain(denial.next, name) {
+		return true
+	}
+
+	wildcardDenial, ok := find(denials, wildcard(nsecEncloser(denial, name)), true)
+
+	return ok && wildcardDenial.noType(qtype)
+}
+
+// ProveWildcard - Check that the NSEC or NSEC3 records prove that the
+// name of an answer synthesized from the wildcard of encloser doesn't
+// exist (RFC 4035 - Section 5.3.4 and RFC 5155 - Section 8.8)
+func ProveWildcard(name, encloser string, records []types.DNSResource) bool {
+	denials := parseDenial(records)
+	nameLabels := labels(name)
+	depth := len(labels(encloser))
+
+	if depth >= len(nameLabels) {
+		return false
+	}
+
+	if len(denials) > 0 && denials[0].hashed {
+		_, ok := find(denials, strings.Join(nameLabels[len(nameLabels)-depth-1:], "."), false)
+
+		return ok
+	}
+
+	_, ok := find(denials, name, false)
+
+	return ok
+}
+
+// ProveInsecureDelegation - Check that the NSEC or NSEC3 records prove
+// that name is a delegation without DS records, or is inside an
+// opt-out span (RFC 4035 - Section 5.2 and RFC 5155 - Section 8.9)
+func ProveInsecureDelegation(name string, records []types.DNSResource) bool {
+	denials := parseDenial(records)
+
+	if denial, ok := find(denials, name, true); ok {
+		return denial.types[types.NS.Name] && !denial.types[types.DS.Name] && !denial.types[types.SOA.Name]
+	}
+
+	if len(denials) == 0 || !denials[0].hashed {
+		return false
+	}
+
+	_, next, ok := closestEncloser(denials, canonicalName(name))
+
+	if !ok {
+		return false
+	}
+
+	denial, _ := find(denials, next, false)
+
+	return denial.flags&optOut != 0
+}
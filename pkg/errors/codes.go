@@ -27,4 +27,24 @@ const (
 	RCodeNotFound               = 17
 	QTypeNotFound               = 18
 	QClassNotFound              = 19
+	LoadingZones                = 20
+	LoadingKeys                 = 21
+	LoadingTrustAnchors         = 22
+	LoadingTLSCertificate       = 23
+	OpeningQueryLog             = 24
+	OpeningDnstap               = 25
+	OpeningCapture              = 26
+	ReadingCapture              = 27
+	ReplayingCapture            = 28
+	ReplayMismatch              = 29
+	LoadingRateLimit            = 30
+	LoadingViews                = 31
+	LoadingBlocklist            = 32
+	LoadingRewrite              = 33
+	LoadingResponsePolicy       = 34
+	LoadingListeners            = 35
+	ActivatingSockets           = 36
+	ReadingQueryList            = 37
+	RunningBenchmark            = 38
+	ValidatingConfiguration     = 39
 )
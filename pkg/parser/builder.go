@@ -0,0 +1,176 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package parser define the function used to parse the
+// DNS package
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// builder - State used to generate the wire format of a message
+type builder struct {
+	buffer []byte
+	// names - Offset of the names already written, used for the
+	// compression. A nil map disable the compression
+	names map[string]int
+	// canonical - Write the names of the RDATA in lowercase, as in the
+	// canonical form of RFC 4034 - Section 6.2
+	canonical bool
+}
+
+func (b *builder) writeUint16(value uint16) {
+	b.buffer = append(b.buffer, byte(value>>8), byte(value))
+}
+
+func (b *builder) writeUint32(value uint32) {
+	b.buffer = append(b.buffer, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
+
+/*
+ * RFC 1035 - Section 4.1.4 Message compression
+ * Write a domain name, pointing to a previous occurrence of
+ * any of its suffixes when compress is set
+ */
+func (b *builder) writeName(name string, compress bool) error {
+	name = strings.TrimSuffix(name, ".")
+
+	if len(name) > 253 {
+		return fmt.Errorf("name %q longer than 255 bytes", name)
+	}
+
+	labels := []string{}
+	if name != "" {
+		labels = strings.Split(name, ".")
+	}
+
+	for i, label := range labels {
+		suffix := strings.ToLower(strings.Join(labels[i:], "."))
+
+		if offset, ok := b.names[suffix]; ok && compress {
+			b.writeUint16(uint16(offset) | 49152)
+
+			return nil
+		}
+
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("invalid label %q in name %q", label, name)
+		}
+
+		if b.names != nil && len(b.buffer) < 16384 {
+			b.names[suffix] = len(b.buffer)
+		}
+
+		b.buffer = append(b.buffer, byte(len(label)))
+		b.buffer = append(b.buffer, label...)
+	}
+
+	b.buffer = append(b.buffer, 0)
+
+	return nil
+}
+
+func (b *builder) writeHeader(header types.DNSHeader, message types.DNSMessage) {
+	flags := uint16(header.OpCode.Code&15)<<11 | uint16(header.RCode.Code&15)
+
+	for bit, set := range map[uint16]bool{
+		32768: header.QR,
+		1024:  header.AuthoritativeAnswer,
+		512:   header.TruncatedMessage,
+		256:   header.RecursionDesired,
+		128:   header.RecursionAvailable,
+		64:    header.Z,
+		32:    header.AD,
+		16:    header.CD,
+	} {
+		if set {
+			flags |= bit
+		}
+	}
+
+	b.writeUint16(header.Identifier)
+	b.writeUint16(flags)
+	b.writeUint16(uint16(len(message.Questions)))
+	b.writeUint16(uint16(len(message.Answers)))
+	b.writeUint16(uint16(len(message.Authority)))
+	b.writeUint16(uint16(len(message.Additional)))
+}
+
+func (b *builder) writeResource(resource types.DNSResource) error {
+	err := b.writeName(resource.Name, true)
+
+	if err != nil {
+		return err
+	}
+
+	b.writeUint16(uint16(resource.Type.Code))
+	b.writeUint16(uint16(resource.Class.Code))
+	b.writeUint32(uint32(resource.TTL))
+
+	lengthOffset := len(b.buffer)
+	b.writeUint16(0)
+
+	err = b.writeRData(resource.Type, resource.RData)
+
+	if err != nil {
+		return fmt.Errorf("%s %s: %s", resource.Name, resource.Type, err)
+	}
+
+	length := len(b.buffer) - lengthOffset - 2
+
+	if length > 65535 {
+		return fmt.Errorf("%s %s: rdata longer than 65535 bytes", resource.Name, resource.Type)
+	}
+
+	binary.BigEndian.PutUint16(b.buffer[lengthOffset:], uint16(length))
+
+	return nil
+}
+
+// BuildDNSMessage - Generate the wire format of a DNSMessage. The
+// counts of the header are taken from the length of each section
+func BuildDNSMessage(message types.DNSMessage) ([]byte, error) {
+	b := &builder{
+		buffer: make([]byte, 0, 512),
+		names:  map[string]int{},
+	}
+
+	b.writeHeader(message.Header, message)
+
+	for _, question := range message.Questions {
+		err := b.writeName(question.Name, true)
+
+		if err != nil {
+			return nil, err
+		}
+
+		b.writeUint16(uint16(question.Type.Code))
+		b.writeUint16(uint16(question.Class.Code))
+	}
+
+	for _, section := range [][]types.DNSResource{message.Answers, message.Authority, message.Additional} {
+		for _, resource := range section {
+			err := b.writeResource(resource)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return b.buffer, nil
+}
+
+// BuildName - Generate the uncompressed wire format of a domain name
+func BuildName(name string) ([]byte, error) {
+	b := &builder{}
+	err := b.writeName(name, false)
+
+	return b.buffer, err
+}
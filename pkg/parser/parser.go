@@ -7,9 +7,9 @@
 package parser
 
 import (
-	"bytes"
 	"encoding/binary"
-	"encoding/hex"
+	"fmt"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
@@ -19,211 +19,260 @@ import (
 	"github.com/lucasdc6/gdns/pkg/types"
 )
 
-func mapBytesToString(r rune) rune {
-	if r == 3 || r == 2 || r == 6 {
-		return '.'
-	}
-	return r
-}
-
-func getName(chunk []byte) (string, []byte, error) {
-	chunks := bytes.SplitN(chunk, []byte{0}, 2)
-	name := bytes.Map(mapBytesToString, chunks[0])
+// maxPointers - Maximum number of compression pointers followed
+// in a single name, used to detect loops
+const maxPointers = 64
+
+/*
+ * RFC 1035 - Section 4.1.4 Message compression
+ * Read a domain name starting at offset, following the compression pointers.
+ * Return the name and the offset of the first byte after the name
+ */
+func getName(message []byte, offset int) (string, int, error) {
+	labels := []string{}
+	next := -1
+	pointers := 0
+
+	for {
+		if offset >= len(message) {
+			return "", 0, fmt.Errorf("name out of bounds at offset %d", offset)
+		}
 
-	return string(name), chunks[1], nil
+		length := int(message[offset])
+
+		switch {
+		case length == 0:
+			if next == -1 {
+				next = offset + 1
+			}
+
+			return strings.Join(labels, "."), next, nil
+		case length&192 == 192:
+			if offset+1 >= len(message) {
+				return "", 0, fmt.Errorf("compression pointer out of bounds at offset %d", offset)
+			}
+
+			if next == -1 {
+				next = offset + 2
+			}
+
+			pointers++
+			if pointers > maxPointers {
+				return "", 0, fmt.Errorf("too many compression pointers at offset %d", offset)
+			}
+
+			offset = int(binary.BigEndian.Uint16(message[offset:offset+2]) & 16383)
+		case length&192 != 0:
+			return "", 0, fmt.Errorf("unsupported label type %#x at offset %d", length&192, offset)
+		default:
+			if offset+1+length > len(message) {
+				return "", 0, fmt.Errorf("label out of bounds at offset %d", offset)
+			}
+
+			labels = append(labels, string(message[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
 }
 
 func parseDNSHeader(query []byte) (header types.DNSHeader, err error) {
 	log.Trace("Parsing header")
 
-	qr := query[2] >> 7
-	opCode := query[2] >> 3 &^ 16
-	truncatedMessage := query[2] >> 1 &^ 252
-	recursionDesired := query[2] &^ 254
-	recursionAvailable := query[3] >> 7
-	z := query[3] >> 6 &^ 254
-	ad := query[3] >> 5 &^ 254
-	cd := query[3] >> 4 &^ 254
-	rCode := query[3] &^ 240
+	if len(query) < 12 {
+		return header, fmt.Errorf("header too short: %d bytes", len(query))
+	}
 
-	opCodeStruct, err := types.OpCodeFromCode(int(opCode))
+	opCode, err := types.OpCodeFromCode(int(query[2] >> 3 & 15))
 
 	if err != nil {
-		log.Errorf("Error parsing DNS header: %s", err)
-		os.Exit(errors.OpCodeNotFound)
+		return header, err
 	}
 
-	rCodeStruct, err := types.RCodeFromCode(int(rCode))
+	rCode, err := types.RCodeFromCode(int(query[3] & 15))
 
 	if err != nil {
-		log.Errorf("Error parsing DNS header: %s", err)
-		os.Exit(errors.RCodeNotFound)
+		return header, err
 	}
 
 	header = types.DNSHeader{
-		Identifier:         binary.BigEndian.Uint16(query[0:2]),
-		QR:                 !(qr == 0),
-		OpCode:             opCodeStruct,
-		TruncatedMessage:   !(truncatedMessage == 0),
-		RecursionDesired:   !(recursionDesired == 0),
-		RecursionAvailable: !(recursionAvailable == 0),
-		Z:                  !(z == 0),
-		AD:                 !(ad == 0),
-		CD:                 !(cd == 0),
-		RCode:              rCodeStruct,
-		QDcount:            binary.BigEndian.Uint16(query[4:6]),
-		ANcount:            binary.BigEndian.Uint16(query[6:8]),
-		NScount:            binary.BigEndian.Uint16(query[8:10]),
-		ARcount:            binary.BigEndian.Uint16(query[10:12]),
+		Identifier:          binary.BigEndian.Uint16(query[0:2]),
+		QR:                  query[2]&128 != 0,
+		OpCode:              opCode,
+		AuthoritativeAnswer: query[2]&4 != 0,
+		TruncatedMessage:    query[2]&2 != 0,
+		RecursionDesired:    query[2]&1 != 0,
+		RecursionAvailable:  query[3]&128 != 0,
+		Z:                   query[3]&64 != 0,
+		AD:                  query[3]&32 != 0,
+		CD:                  query[3]&16 != 0,
+		RCode:               rCode,
+		QDcount:             binary.BigEndian.Uint16(query[4:6]),
+		ANcount:             binary.BigEndian.Uint16(query[6:8]),
+		NScount:             binary.BigEndian.Uint16(query[8:10]),
+		ARcount:             binary.BigEndian.Uint16(query[10:12]),
 	}
 
 	return header, nil
 }
 
-func parseDNSQuestions(query []byte, questionsCount uint16) (questions []types.DNSQuestion, last uint16, err error) {
-	log.Trace("Parsing questions")
-	chunk := query
-	last = 0
+func parseQType(code int) types.QType {
+	qtype, err := types.QTypeFromCode(code)
 
-	if questionsCount == 0 {
-		return questions, last, nil
+	if err != nil {
+		return types.QType{Name: fmt.Sprintf("TYPE%d", code), Code: code}
 	}
 
-	for i := 0; i < int(questionsCount); i++ {
-		name := ""
-		/*
-		 * RFC 1035 - Section 4.1.4 Message compression
-		 * Check for compression
-		 */
-		if uint16(chunk[0]&192) > 0 {
-			log.Trace("Follow pointer")
-			nameOffset := (binary.BigEndian.Uint16(chunk[0:2]) & 16383) - 12
-			name, chunk, _ = getName(query[nameOffset:])
-		} else {
-			log.Trace("  Get name")
-			name, chunk, _ = getName(chunk)
-		}
+	return qtype
+}
+
+func parseQClass(code int) types.QClass {
+	qclass, err := types.QClassFromCode(code)
 
-		log.Debug("Name: %s", name)
-		last += uint16(len(name))
+	if err != nil {
+		return types.QClass{Name: fmt.Sprintf("CLASS%d", code), Code: code}
+	}
 
-		qtypeChunk := binary.BigEndian.Uint16(chunk[0:2])
-		qtype, err := types.QTypeFromCode(int(qtypeChunk))
+	return qclass
+}
+
+func parseDNSQuestions(query []byte, offset int, questionsCount uint16) (questions []types.DNSQuestion, last int, err error) {
+	log.Trace("Parsing questions")
+	questions = make([]types.DNSQuestion, 0, questionsCount)
+	last = offset
+
+	for i := 0; i < int(questionsCount); i++ {
+		name, next, err := getName(query, last)
 
 		if err != nil {
-			log.Errorf("Error parsing DNS questions #%d: %s", i, err)
-			os.Exit(errors.QTypeNotFound)
+			return questions, last, fmt.Errorf("question #%d: %s", i, err)
 		}
 
-		classChunk := binary.BigEndian.Uint16(chunk[2:4])
-		class, err := types.QClassFromCode(int(classChunk))
+		log.Tracef("Name: %s", name)
 
-		if err != nil {
-			log.Errorf("Error parsing DNS questions #%d: %s", i, err)
-			os.Exit(errors.QClassNotFound)
+		if next+4 > len(query) {
+			return questions, last, fmt.Errorf("question #%d: message too short", i)
 		}
-		last += 4
 
 		questions = append(questions, types.DNSQuestion{
-			Name:  string(name),
-			Type:  qtype,
-			Class: class,
+			Name:  name,
+			Type:  parseQType(int(binary.BigEndian.Uint16(query[next : next+2]))),
+			Class: parseQClass(int(binary.BigEndian.Uint16(query[next+2 : next+4]))),
 		})
+		last = next + 4
 	}
 
 	return questions, last, nil
 }
 
-func parseDNSAnswers(query []byte, offset, answersCount uint16) (answers []types.DNSResource, last uint16, err error) {
+func parseDNSResources(query []byte, offset int, resourcesCount uint16) (resources []types.DNSResource, last int, err error) {
 	log.WithFields(log.Fields{
-		"relativeOffset": offset,
-		"absoluteOffset": offset + 12,
-	}).Debug("Parsing answers")
+		"offset": offset,
+		"count":  resourcesCount,
+	}).Trace("Parsing resources")
+	resources = make([]types.DNSResource, 0, resourcesCount)
 	last = offset
-	chunk := query[offset:]
-
-	if answersCount == 0 {
-		return answers, last, nil
-	}
-
-	for i := 0; i < int(answersCount); i++ {
-		log.Debug("Data:\n%s", hex.Dump(chunk))
-		name := ""
-
-		/*
-		 * RFC 1035 - Section 4.1.4 Message compression
-		 * Check for compression
-		 */
-		if uint16(chunk[0]&192) > 0 {
-			log.Trace("Follow pointer")
-			nameOffset := (binary.BigEndian.Uint16(chunk[0:2]) & 16383) - 12
-			name, chunk, _ = getName(query[nameOffset:])
-		} else {
-			log.Trace("Get name")
-			name, chunk, _ = getName(chunk)
+
+	for i := 0; i < int(resourcesCount); i++ {
+		name, next, err := getName(query, last)
+
+		if err != nil {
+			return resources, last, fmt.Errorf("resource #%d: %s", i, err)
+		}
+
+		if next+10 > len(query) {
+			return resources, last, fmt.Errorf("resource #%d: message too short", i)
+		}
+
+		qtype := parseQType(int(binary.BigEndian.Uint16(query[next : next+2])))
+		rdLength := int(binary.BigEndian.Uint16(query[next+8 : next+10]))
+
+		if next+10+rdLength > len(query) {
+			return resources, last, fmt.Errorf("resource #%d: rdata out of bounds", i)
+		}
+
+		rData, err := parseRData(query, next+10, rdLength, qtype)
+
+		if err != nil {
+			return resources, last, fmt.Errorf("resource #%d: %s", i, err)
 		}
-		log.Debugf("name: %s", name)
-		nameAddress := uint16(chunk[0])
-		num := binary.BigEndian.Uint16(chunk[0:2])
-		log.Debugf("op %b AND 11000000 = %d", nameAddress, nameAddress&192)
-		log.Printf("op %b AND 00111111 11111111 = %d", num, num&16383)
 
+		resources = append(resources, types.DNSResource{
+			Name:     name,
+			Type:     qtype,
+			Class:    parseQClass(int(binary.BigEndian.Uint16(query[next+2 : next+4]))),
+			TTL:      int32(binary.BigEndian.Uint32(query[next+4 : next+8])),
+			RDLength: int16(rdLength),
+			RData:    rData,
+		})
+		last = next + 10 + rdLength
 	}
-	return answers, last, nil
+
+	return resources, last, nil
 }
 
-func parseDNSAuthority(query []byte, authorityCount uint16) (authority []types.DNSResource, err error) {
-	authority = []types.DNSResource{}
+func parseDNSAnswers(query []byte, offset int, answersCount uint16) (answers []types.DNSResource, last int, err error) {
+	log.Trace("Parsing answers")
 
-	return authority, nil
+	return parseDNSResources(query, offset, answersCount)
 }
 
-func parseDNSAdditional(query []byte, additionalCount uint16) (additional []types.DNSResource, err error) {
-	additional = []types.DNSResource{}
+func parseDNSAuthority(query []byte, offset int, authorityCount uint16) (authority []types.DNSResource, last int, err error) {
+	log.Trace("Parsing authority")
 
-	return additional, nil
+	return parseDNSResources(query, offset, authorityCount)
 }
 
-// ParseDNSQuery - Parse the query and return a DNSMessage
-func ParseDNSQuery(query []byte) types.DNSMessage {
-	headerBytes := query[0:12]
-	header, err := parseDNSHeader(headerBytes)
+func parseDNSAdditional(query []byte, offset int, additionalCount uint16) (additional []types.DNSResource, last int, err error) {
+	log.Trace("Parsing additional")
+
+	return parseDNSResources(query, offset, additionalCount)
+}
+
+// ParseError - Error found parsing one of the sections of a DNS message
+type ParseError struct {
+	Section string
+	Code    int
+	Err     error
+}
+
+func (err *ParseError) Error() string {
+	return fmt.Sprintf("Error parsing %s: %s", err.Section, err.Err)
+}
+
+// ParseDNSMessage - Parse a DNS message in wire format and return a DNSMessage
+// or a *ParseError describing the first malformed section
+func ParseDNSMessage(query []byte) (message types.DNSMessage, err error) {
+	header, err := parseDNSHeader(query)
 
 	if err != nil {
-		log.Fatalf("Error parsing header: %s", err)
-		os.Exit(errors.ParsingHeader)
+		return message, &ParseError{Section: "header", Code: errors.ParsingHeader, Err: err}
 	}
 
-	questions, last, err := parseDNSQuestions(query[12:], header.QDcount)
+	questions, last, err := parseDNSQuestions(query, 12, header.QDcount)
 
 	if err != nil {
-		log.Fatalf("Error parsing questions: %s", err)
-		os.Exit(errors.ParsingQuestions)
+		return message, &ParseError{Section: "questions", Code: errors.ParsingQuestions, Err: err}
 	}
-	last++
 
-	answers, _, err := parseDNSAnswers(query[12:], last, header.ANcount)
+	answers, last, err := parseDNSAnswers(query, last, header.ANcount)
 
 	if err != nil {
-		log.Fatalf("Error parsing answers: %s", err)
-		os.Exit(errors.ParsingAnswers)
+		return message, &ParseError{Section: "answers", Code: errors.ParsingAnswers, Err: err}
 	}
 
-	authority, err := parseDNSAuthority(query[12:], header.NScount)
+	authority, last, err := parseDNSAuthority(query, last, header.NScount)
 
 	if err != nil {
-		log.Fatalf("Error parsing authority: %s", err)
-		os.Exit(errors.ParsingAuthority)
+		return message, &ParseError{Section: "authority", Code: errors.ParsingAuthority, Err: err}
 	}
 
-	additional, err := parseDNSAdditional(query[12:], header.NScount)
+	additional, _, err := parseDNSAdditional(query, last, header.ARcount)
 
 	if err != nil {
-		log.Fatalf("Error parsing additional: %s", err)
-		os.Exit(errors.ParsingAdditional)
+		return message, &ParseError{Section: "additional", Code: errors.ParsingAdditional, Err: err}
 	}
 
-	message := types.DNSMessage{
+	message = types.DNSMessage{
 		Header:     header,
 		Questions:  questions,
 		Answers:    answers,
@@ -231,9 +280,44 @@ func ParseDNSQuery(query []byte) types.DNSMessage {
 		Additional: additional,
 	}
 
-	//messageJSON, err := json.MarshalIndent(message, "", "    ")
-	//messageJSON, err := json.Marshal(message)
-	log.Printf("Data parse: %+v\n", message)
+	log.Debugf("Data parse: %+v", message)
+
+	return message, nil
+}
+
+// LastResourceOffset - Offset of the last resource of the additional
+// section, where the TSIG record of a signed message starts
+// (RFC 8945 - Section 5.1)
+func LastResourceOffset(message []byte) (int, error) {
+	header, err := parseDNSHeader(message)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if header.ARcount == 0 {
+		return 0, fmt.Errorf("message without additional records")
+	}
+
+	_, last, err := parseDNSQuestions(message, 12, header.QDcount)
+
+	if err != nil {
+		return 0, err
+	}
+
+	_, last, err = parseDNSResources(message, last, header.ANcount+header.NScount+header.ARcount-1)
+
+	return last, err
+}
+
+// ParseDNSQuery - Parse the query and return a DNSMessage
+func ParseDNSQuery(query []byte) types.DNSMessage {
+	message, err := ParseDNSMessage(query)
+
+	if err != nil {
+		log.Errorf("Error parsing DNS message: %s", err)
+		os.Exit(err.(*ParseError).Code)
+	}
 
 	return message
 }
@@ -38,14 +38,23 @@ func TestParseDNSQuery(t *testing.T) {
 		},
 		Questions: []types.DNSQuestion{
 			types.DNSQuestion{
-				Name:  "facebook\x03com",
+				Name:  "facebook.com",
 				Type:  types.A,
 				Class: types.IN,
 			},
 		},
-		Answers:    []types.DNSResource{},
-		Authority:  []types.DNSResource{},
-		Additional: []types.DNSResource{},
+		Answers:   []types.DNSResource{},
+		Authority: []types.DNSResource{},
+		Additional: []types.DNSResource{
+			types.DNSResource{
+				Name:     "",
+				Type:     types.OPT,
+				Class:    types.QClass{Name: "CLASS4096", Code: 4096},
+				TTL:      0,
+				RDLength: 12,
+				RData:    "\\# 12 000a0008f993cc35d185797b",
+			},
+		},
 	}
 
 	message := parser.ParseDNSQuery(dnsData)
@@ -55,3 +64,108 @@ func TestParseDNSQuery(t *testing.T) {
 		t.Errorf("Messages mismatch (-want +got):\n%s", diff)
 	}
 }
+
+func TestBuildDNSMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message types.DNSMessage
+	}{
+		{
+			name: "Query",
+			message: types.DNSMessage{
+				Header: types.DNSHeader{
+					Identifier:       1,
+					OpCode:           types.Query,
+					RecursionDesired: true,
+					RCode:            types.NoError,
+					QDcount:          1,
+				},
+				Questions: []types.DNSQuestion{
+					{Name: "www.example.com", Type: types.AAAA, Class: types.IN},
+				},
+				Answers:    []types.DNSResource{},
+				Authority:  []types.DNSResource{},
+				Additional: []types.DNSResource{},
+			},
+		},
+		{
+			name: "Authoritative response",
+			message: types.DNSMessage{
+				Header: types.DNSHeader{
+					Identifier:          65535,
+					QR:                  true,
+					OpCode:              types.Query,
+					AuthoritativeAnswer: true,
+					RCode:               types.NXDomain,
+					QDcount:             1,
+					ANcount:             6,
+					NScount:             1,
+				},
+				Questions: []types.DNSQuestion{
+					{Name: "example.com", Type: types.QTYPEALL, Class: types.IN},
+				},
+				Answers: []types.DNSResource{
+					{Name: "example.com", Type: types.A, Class: types.IN, TTL: 300, RDLength: 4, RData: "192.168.0.1"},
+					{Name: "example.com", Type: types.AAAA, Class: types.IN, TTL: 300, RDLength: 16, RData: "2001:db8::1"},
+					{Name: "example.com", Type: types.MX, Class: types.IN, TTL: 300, RDLength: 4, RData: "10 example.com"},
+					{Name: "example.com", Type: types.TXT, Class: types.IN, TTL: 300, RDLength: 12, RData: "\"v=spf1\" \"-all\""},
+					{Name: "example.com", Type: types.CAA, Class: types.IN, TTL: 300, RDLength: 22, RData: "0 issue \"letsencrypt.org\""},
+					{Name: "_sip._tcp.example.com", Type: types.SRV, Class: types.IN, TTL: 300, RDLength: 23, RData: "10 60 5060 sip.example.com"},
+				},
+				Authority: []types.DNSResource{
+					{Name: "example.com", Type: types.SOA, Class: types.IN, TTL: 3600, RDLength: 38, RData: "ns.example.com hostmaster.example.com 2020010101 3600 600 86400 300"},
+				},
+				Additional: []types.DNSResource{},
+			},
+		},
+		{
+			name: "DNSSEC records",
+			message: types.DNSMessage{
+				Header: types.DNSHeader{
+					Identifier:          2,
+					QR:                  true,
+					OpCode:              types.Query,
+					AuthoritativeAnswer: true,
+					RCode:               types.NoError,
+					QDcount:             1,
+					ANcount:             3,
+					NScount:             3,
+				},
+				Questions: []types.DNSQuestion{
+					{Name: "example.com", Type: types.DNSKEY, Class: types.IN},
+				},
+				Answers: []types.DNSResource{
+					{Name: "example.com", Type: types.DNSKEY, Class: types.IN, TTL: 3600, RDLength: 36, RData: "257 3 15 l02Woi0iS8Aa25FQkUd9RMzZHJpBoRQwAQEX1SxZJA4="},
+					{Name: "example.com", Type: types.RRSIG, Class: types.IN, TTL: 3600, RDLength: 35, RData: "DNSKEY 15 2 3600 20300101000000 20200101000000 3613 example.com AQIDBA=="},
+					{Name: "example.com", Type: types.DS, Class: types.IN, TTL: 3600, RDLength: 6, RData: "3613 15 2 ABCD"},
+				},
+				Authority: []types.DNSResource{
+					{Name: "example.com", Type: types.NSEC, Class: types.IN, TTL: 300, RDLength: 29, RData: "www.example.com A NS SOA RRSIG NSEC DNSKEY CAA"},
+					{Name: "example.com", Type: types.NSEC3PARAM, Class: types.IN, TTL: 300, RDLength: 5, RData: "1 0 0 -"},
+					{Name: "2t7b4g4vsa5smi47k61mv5bv1a22bojr.example.com", Type: types.NSEC3, Class: types.IN, TTL: 300, RDLength: 36, RData: "1 0 10 AABB 2vptu5timamqttgl4luu9kg21e0aor3s A RRSIG"},
+				},
+				Additional: []types.DNSResource{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := parser.BuildDNSMessage(tt.message)
+
+			if err != nil {
+				t.Fatalf("BuildDNSMessage() error = %v", err)
+			}
+
+			message, err := parser.ParseDNSMessage(data)
+
+			if err != nil {
+				t.Fatalf("ParseDNSMessage() error = %v", err)
+			}
+
+			if diff := cmp.Diff(tt.message, message); diff != "" {
+				t.Errorf("Messages mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
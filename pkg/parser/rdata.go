@@ -0,0 +1,667 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package parser define the function used to parse the
+// DNS package
+package parser
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// rdataField - Kind of the fields found in the RDATA of a resource
+type rdataField int
+
+const (
+	fieldName rdataField = iota
+	fieldUint8
+	fieldUint16
+	fieldUint32
+	fieldString
+	fieldStrings
+	fieldIPv4
+	fieldIPv6
+	// DNSSEC fields (RFC 4034 and RFC 5155)
+	fieldType
+	fieldTime
+	fieldBase64
+	fieldHex
+	fieldSalt
+	fieldHash
+	fieldTypes
+)
+
+// rdataFormat - Ordered fields of the RDATA of a type and whether
+// the names on it can be compressed (RFC 3597 - Section 4)
+type rdataFormat struct {
+	fields   []rdataField
+	compress bool
+}
+
+// rdataFormats - RDATA layout of the known types, the remaining
+// types are handled with the generic format of RFC 3597
+var rdataFormats = map[int]rdataFormat{
+	types.A.Code:     {fields: []rdataField{fieldIPv4}},
+	types.AAAA.Code:  {fields: []rdataField{fieldIPv6}},
+	types.NS.Code:    {fields: []rdataField{fieldName}, compress: true},
+	types.MD.Code:    {fields: []rdataField{fieldName}, compress: true},
+	types.MF.Code:    {fields: []rdataField{fieldName}, compress: true},
+	types.CNAME.Code: {fields: []rdataField{fieldName}, compress: true},
+	types.MB.Code:    {fields: []rdataField{fieldName}, compress: true},
+	types.MG.Code:    {fields: []rdataField{fieldName}, compress: true},
+	types.MR.Code:    {fields: []rdataField{fieldName}, compress: true},
+	types.PTR.Code:   {fields: []rdataField{fieldName}, compress: true},
+	types.DNAME.Code: {fields: []rdataField{fieldName}},
+	types.MINFO.Code: {fields: []rdataField{fieldName, fieldName}, compress: true},
+	types.RP.Code:    {fields: []rdataField{fieldName, fieldName}},
+	types.MX.Code:    {fields: []rdataField{fieldUint16, fieldName}, compress: true},
+	types.AFSDB.Code: {fields: []rdataField{fieldUint16, fieldName}},
+	types.KX.Code:    {fields: []rdataField{fieldUint16, fieldName}},
+	types.HINFO.Code: {fields: []rdataField{fieldString, fieldString}},
+	types.TXT.Code:   {fields: []rdataField{fieldStrings}},
+	types.SRV.Code:   {fields: []rdataField{fieldUint16, fieldUint16, fieldUint16, fieldName}},
+	types.CAA.Code:   {fields: []rdataField{fieldUint8, fieldString, fieldString}},
+	types.SOA.Code: {
+		fields:   []rdataField{fieldName, fieldName, fieldUint32, fieldUint32, fieldUint32, fieldUint32, fieldUint32},
+		compress: true,
+	},
+	types.DS.Code:      {fields: []rdataField{fieldUint16, fieldUint8, fieldUint8, fieldHex}},
+	types.CDS.Code:     {fields: []rdataField{fieldUint16, fieldUint8, fieldUint8, fieldHex}},
+	types.TA.Code:      {fields: []rdataField{fieldUint16, fieldUint8, fieldUint8, fieldHex}},
+	types.DNSKEY.Code:  {fields: []rdataField{fieldUint16, fieldUint8, fieldUint8, fieldBase64}},
+	types.CDNSKEY.Code: {fields: []rdataField{fieldUint16, fieldUint8, fieldUint8, fieldBase64}},
+	types.RRSIG.Code: {
+		fields: []rdataField{fieldType, fieldUint8, fieldUint8, fieldUint32, fieldTime, fieldTime, fieldUint16, fieldName, fieldBase64},
+	},
+	types.NSEC.Code:       {fields: []rdataField{fieldName, fieldTypes}},
+	types.NSEC3.Code:      {fields: []rdataField{fieldUint8, fieldUint8, fieldUint16, fieldSalt, fieldHash, fieldTypes}},
+	types.NSEC3PARAM.Code: {fields: []rdataField{fieldUint8, fieldUint8, fieldUint16, fieldSalt}},
+}
+
+// isRestField - Fields that take the rest of the RDATA, and can be
+// split in several words in the presentation format
+func isRestField(field rdataField) bool {
+	return field == fieldBase64 || field == fieldHex || field == fieldTypes || field == fieldStrings
+}
+
+// quoteString - Presentation format of a character-string
+func quoteString(value []byte) string {
+	var builder strings.Builder
+
+	builder.WriteByte('"')
+	for _, char := range value {
+		switch {
+		case char == '"' || char == '\\':
+			builder.WriteByte('\\')
+			builder.WriteByte(char)
+		case char < 32 || char > 126:
+			builder.WriteString(fmt.Sprintf("\\%03d", char))
+		default:
+			builder.WriteByte(char)
+		}
+	}
+	builder.WriteByte('"')
+
+	return builder.String()
+}
+
+// splitRData - Split the presentation format of a RDATA in fields,
+// keeping together the quoted strings and removing the escapes
+func splitRData(value string) ([]string, error) {
+	fields := []string{}
+	var field strings.Builder
+	inField := false
+	quoted := false
+
+	for i := 0; i < len(value); i++ {
+		char := value[i]
+
+		switch {
+		case char == '\\':
+			if i+3 < len(value) && isDigits(value[i+1:i+4]) {
+				code, _ := strconv.Atoi(value[i+1 : i+4])
+				field.WriteByte(byte(code))
+				i += 3
+			} else if i+1 < len(value) {
+				field.WriteByte(value[i+1])
+				i++
+			}
+			inField = true
+		case char == '"':
+			if quoted {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+			quoted = !quoted
+		case (char == ' ' || char == '\t') && !quoted:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteByte(char)
+			inField = true
+		}
+	}
+
+	if quoted {
+		return fields, fmt.Errorf("unterminated quoted string in %q", value)
+	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
+
+func isDigits(value string) bool {
+	for _, char := range value {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+
+	return len(value) > 0
+}
+
+// parseRData - Generate the presentation format of the RDATA placed
+// at offset in the message
+func parseRData(message []byte, offset, length int, qtype types.QType) (string, error) {
+	format, ok := rdataFormats[qtype.Code]
+	rdata := message[offset : offset+length]
+
+	if length == 0 {
+		// Records without data, as the prerequisites and deletions
+		// of the dynamic updates (RFC 2136 - Section 2.4)
+		return "", nil
+	}
+
+	if !ok {
+		return genericRData(rdata), nil
+	}
+
+	fields := []string{}
+	end := offset + length
+
+	for index, field := range format.fields {
+		switch field {
+		case fieldName:
+			name, next, err := getName(message[:end], offset)
+
+			if err != nil {
+				return "", err
+			}
+
+			if name == "" {
+				// The root is the only name written with the trailing dot
+				name = "."
+			}
+
+			fields = append(fields, name)
+			offset = next
+		case fieldUint8:
+			if offset+1 > end {
+				return "", fmt.Errorf("%s rdata too short", qtype)
+			}
+
+			fields = append(fields, strconv.Itoa(int(message[offset])))
+			offset++
+		case fieldUint16:
+			if offset+2 > end {
+				return "", fmt.Errorf("%s rdata too short", qtype)
+			}
+
+			fields = append(fields, strconv.Itoa(int(binary.BigEndian.Uint16(message[offset:offset+2]))))
+			offset += 2
+		case fieldUint32:
+			if offset+4 > end {
+				return "", fmt.Errorf("%s rdata too short", qtype)
+			}
+
+			fields = append(fields, strconv.FormatUint(uint64(binary.BigEndian.Uint32(message[offset:offset+4])), 10))
+			offset += 4
+		case fieldIPv4:
+			if length != net.IPv4len {
+				return "", fmt.Errorf("%s rdata with invalid length %d", qtype, length)
+			}
+
+			fields = append(fields, net.IP(rdata).String())
+			offset += length
+		case fieldIPv6:
+			if length != net.IPv6len {
+				return "", fmt.Errorf("%s rdata with invalid length %d", qtype, length)
+			}
+
+			fields = append(fields, net.IP(rdata).String())
+			offset += length
+		case fieldType:
+			if offset+2 > end {
+				return "", fmt.Errorf("%s rdata too short", qtype)
+			}
+
+			fields = append(fields, parseQType(int(binary.BigEndian.Uint16(message[offset:]))).Name)
+			offset += 2
+		case fieldTime:
+			if offset+4 > end {
+				return "", fmt.Errorf("%s rdata too short", qtype)
+			}
+
+			fields = append(fields, formatTime(binary.BigEndian.Uint32(message[offset:])))
+			offset += 4
+		case fieldBase64:
+			fields = append(fields, base64.StdEncoding.EncodeToString(message[offset:end]))
+			offset = end
+		case fieldHex:
+			fields = append(fields, strings.ToUpper(hex.EncodeToString(message[offset:end])))
+			offset = end
+		case fieldSalt, fieldHash:
+			if offset+1 > end || offset+1+int(message[offset]) > end {
+				return "", fmt.Errorf("%s rdata too short", qtype)
+			}
+
+			value := message[offset+1 : offset+1+int(message[offset])]
+			offset += 1 + len(value)
+
+			switch {
+			case field == fieldHash:
+				fields = append(fields, base32Hex.EncodeToString(value))
+			case len(value) == 0:
+				fields = append(fields, "-")
+			default:
+				fields = append(fields, strings.ToUpper(hex.EncodeToString(value)))
+			}
+		case fieldTypes:
+			bitmap, err := parseTypeBitmap(message[offset:end])
+
+			if err != nil {
+				return "", fmt.Errorf("%s %s", qtype, err)
+			}
+
+			fields = append(fields, bitmap...)
+			offset = end
+		case fieldString, fieldStrings:
+			if qtype.Code == types.CAA.Code && index == len(format.fields)-1 {
+				// The value of a CAA record isn't length prefixed
+				fields = append(fields, quoteString(message[offset:end]))
+				offset = end
+				break
+			}
+
+			for offset < end {
+				size := int(message[offset])
+
+				if offset+1+size > end {
+					return "", fmt.Errorf("%s character-string out of bounds", qtype)
+				}
+
+				fields = append(fields, quoteString(message[offset+1:offset+1+size]))
+				offset += 1 + size
+
+				if field == fieldString {
+					break
+				}
+			}
+		}
+	}
+
+	if qtype.Code == types.CAA.Code && len(fields) == 3 {
+		// The tag of the CAA records is not quoted
+		fields[1] = strings.Trim(fields[1], "\"")
+	}
+
+	return strings.Join(fields, " "), nil
+}
+
+// genericRData - RFC 3597 - Section 5 presentation format for unknown types
+func genericRData(rdata []byte) string {
+	if len(rdata) == 0 {
+		return "\\# 0"
+	}
+
+	return fmt.Sprintf("\\# %d %s", len(rdata), hex.EncodeToString(rdata))
+}
+
+// buildGenericRData - Generate the RDATA from the RFC 3597 generic format
+func buildGenericRData(value string) ([]byte, error) {
+	fields := strings.Fields(value)
+
+	if len(fields) < 2 || fields[0] != "\\#" {
+		return nil, fmt.Errorf("invalid generic rdata %q", value)
+	}
+
+	length, err := strconv.Atoi(fields[1])
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic rdata length %q", fields[1])
+	}
+
+	rdata, err := hex.DecodeString(strings.Join(fields[2:], ""))
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid generic rdata: %s", err)
+	}
+
+	if len(rdata) != length {
+		return nil, fmt.Errorf("generic rdata length %d doesn't match %d bytes", length, len(rdata))
+	}
+
+	return rdata, nil
+}
+
+// writeRData - Append to the message the wire format of a RDATA in
+// presentation format
+func (b *builder) writeRData(qtype types.QType, value string) error {
+	if value == "" && qtype.Code != types.TXT.Code {
+		return nil
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(value), "\\#") {
+		rdata, err := buildGenericRData(value)
+
+		if err != nil {
+			return err
+		}
+
+		b.buffer = append(b.buffer, rdata...)
+
+		return nil
+	}
+
+	format, ok := rdataFormats[qtype.Code]
+
+	if !ok {
+		return fmt.Errorf("type %s requires the generic rdata format", qtype)
+	}
+
+	fields, err := splitRData(value)
+
+	if err != nil {
+		return err
+	}
+
+	if qtype.Code == types.TXT.Code && !strings.Contains(value, "\"") {
+		// Unquoted TXT values are taken as a single text
+		fields = []string{value}
+	}
+
+	for i, field := range format.fields {
+		if i >= len(fields) {
+			if field == fieldStrings || field == fieldTypes {
+				break
+			}
+
+			return fmt.Errorf("%s rdata %q has %d fields, expected %d", qtype, value, len(fields), len(format.fields))
+		}
+
+		switch field {
+		case fieldName:
+			name := fields[i]
+
+			// RFC 6840 - Section 5.1: the next name of a NSEC keeps its case
+			if b.canonical && qtype.Code != types.NSEC.Code {
+				name = strings.ToLower(name)
+			}
+
+			err = b.writeName(name, format.compress)
+		case fieldUint8:
+			err = b.writeUint(fields[i], 8)
+		case fieldUint16:
+			err = b.writeUint(fields[i], 16)
+		case fieldUint32:
+			err = b.writeUint(fields[i], 32)
+		case fieldIPv4:
+			ip := net.ParseIP(fields[i]).To4()
+
+			if ip == nil {
+				return fmt.Errorf("invalid IPv4 address %q", fields[i])
+			}
+
+			b.buffer = append(b.buffer, ip...)
+		case fieldIPv6:
+			ip := net.ParseIP(fields[i])
+
+			if ip == nil || ip.To4() != nil && !strings.Contains(fields[i], ":") {
+				return fmt.Errorf("invalid IPv6 address %q", fields[i])
+			}
+
+			b.buffer = append(b.buffer, ip.To16()...)
+		case fieldType:
+			var rrtype types.QType
+			rrtype, err = types.QTypeFromString(fields[i])
+			b.buffer = append(b.buffer, byte(rrtype.Code>>8), byte(rrtype.Code))
+		case fieldTime:
+			err = b.writeTime(fields[i])
+		case fieldBase64:
+			var data []byte
+			data, err = base64.StdEncoding.DecodeString(strings.Join(fields[i:], ""))
+			b.buffer = append(b.buffer, data...)
+		case fieldHex:
+			var data []byte
+			data, err = hex.DecodeString(strings.Join(fields[i:], ""))
+			b.buffer = append(b.buffer, data...)
+		case fieldSalt, fieldHash:
+			var data []byte
+
+			switch {
+			case field == fieldHash:
+				data, err = base32Hex.DecodeString(strings.ToLower(fields[i]))
+			case fields[i] != "-":
+				data, err = hex.DecodeString(fields[i])
+			}
+
+			if len(data) > 255 {
+				return fmt.Errorf("%s field longer than 255 bytes", qtype)
+			}
+
+			b.buffer = append(b.buffer, byte(len(data)))
+			b.buffer = append(b.buffer, data...)
+		case fieldTypes:
+			var bitmap []byte
+			bitmap, err = buildTypeBitmap(fields[i:])
+			b.buffer = append(b.buffer, bitmap...)
+		case fieldString:
+			if qtype.Code == types.CAA.Code && i == len(format.fields)-1 {
+				// The value of a CAA record isn't length prefixed
+				b.buffer = append(b.buffer, fields[i]...)
+				break
+			}
+
+			err = b.writeString(fields[i])
+		case fieldStrings:
+			for _, text := range fields[i:] {
+				for len(text) > 255 {
+					b.writeString(text[:255])
+					text = text[255:]
+				}
+
+				err = b.writeString(text)
+			}
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if isRestField(field) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (b *builder) writeUint(value string, size int) error {
+	number, err := strconv.ParseUint(value, 10, size)
+
+	if err != nil {
+		return fmt.Errorf("invalid %d bits number %q", size, value)
+	}
+
+	switch size {
+	case 8:
+		b.buffer = append(b.buffer, byte(number))
+	case 16:
+		b.buffer = append(b.buffer, byte(number>>8), byte(number))
+	case 32:
+		b.buffer = append(b.buffer, byte(number>>24), byte(number>>16), byte(number>>8), byte(number))
+	}
+
+	return nil
+}
+
+func (b *builder) writeString(value string) error {
+	if len(value) > 255 {
+		return fmt.Errorf("character-string longer than 255 bytes")
+	}
+
+	b.buffer = append(b.buffer, byte(len(value)))
+	b.buffer = append(b.buffer, value...)
+
+	return nil
+}
+
+// BuildRData - Generate the uncompressed wire format of a RDATA
+// in presentation format
+func BuildRData(qtype types.QType, value string) ([]byte, error) {
+	b := &builder{}
+	err := b.writeRData(qtype, value)
+
+	return b.buffer, err
+}
+
+// BuildCanonicalRData - Generate the canonical wire format of a RDATA
+// in presentation format, used to sign it (RFC 4034 - Section 6.2)
+func BuildCanonicalRData(qtype types.QType, value string) ([]byte, error) {
+	b := &builder{canonical: true}
+	err := b.writeRData(qtype, value)
+
+	return b.buffer, err
+}
+
+// ParseRData - Generate the presentation format of an uncompressed RDATA
+func ParseRData(qtype types.QType, rdata []byte) (string, error) {
+	return parseRData(rdata, 0, len(rdata), qtype)
+}
+
+// base32Hex - Encoding of the hashed names of NSEC3 (RFC 5155 - Section 3.3)
+var base32Hex = base32.NewEncoding("0123456789abcdefghijklmnopqrstuv").WithPadding(base32.NoPadding)
+
+// timeFormat - Presentation format of the RRSIG timestamps
+// (RFC 4034 - Section 3.2)
+const timeFormat = "20060102150405"
+
+// formatTime - Presentation format of a timestamp in serial number
+// arithmetic, taken as the closest date to now
+func formatTime(value uint32) string {
+	now := time.Now().Unix()
+	seconds := now + int64(int32(value-uint32(now)))
+
+	return time.Unix(seconds, 0).UTC().Format(timeFormat)
+}
+
+func (b *builder) writeTime(value string) error {
+	if len(value) != len(timeFormat) {
+		return b.writeUint(value, 32)
+	}
+
+	date, err := time.Parse(timeFormat, value)
+
+	if err != nil {
+		return err
+	}
+
+	seconds := uint32(date.Unix())
+	b.buffer = append(b.buffer, byte(seconds>>24), byte(seconds>>16), byte(seconds>>8), byte(seconds))
+
+	return nil
+}
+
+// parseTypeBitmap - Types present in a NSEC or NSEC3 type bitmap
+// (RFC 4034 - Section 4.1.2)
+func parseTypeBitmap(bitmap []byte) ([]string, error) {
+	names := []string{}
+
+	for len(bitmap) > 0 {
+		if len(bitmap) < 2 || int(bitmap[1]) > 32 || len(bitmap) < 2+int(bitmap[1]) {
+			return nil, fmt.Errorf("invalid type bitmap")
+		}
+
+		window, length := int(bitmap[0]), int(bitmap[1])
+
+		for i, octet := range bitmap[2 : 2+length] {
+			for bit := 0; bit < 8; bit++ {
+				if octet&(128>>bit) != 0 {
+					names = append(names, parseQType(window*256+i*8+bit).Name)
+				}
+			}
+		}
+
+		bitmap = bitmap[2+length:]
+	}
+
+	return names, nil
+}
+
+// buildTypeBitmap - Generate the type bitmap of a list of types
+func buildTypeBitmap(names []string) ([]byte, error) {
+	windows := [256][32]byte{}
+	lengths := [256]int{}
+
+	for _, name := range names {
+		rrtype, err := types.QTypeFromString(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		window, index := rrtype.Code/256, rrtype.Code%256
+		windows[window][index/8] |= 128 >> (index % 8)
+
+		if index/8+1 > lengths[window] {
+			lengths[window] = index/8 + 1
+		}
+	}
+
+	bitmap := []byte{}
+
+	for window, length := range lengths {
+		if length > 0 {
+			bitmap = append(bitmap, byte(window), byte(length))
+			bitmap = append(bitmap, windows[window][:length]...)
+		}
+	}
+
+	return bitmap, nil
+}
+
+// TypeBitmap - Presentation format of the type bitmap of a set of types
+func TypeBitmap(codes []int) string {
+	names := []string{}
+	seen := map[int]bool{}
+
+	for _, code := range codes {
+		if !seen[code] {
+			seen[code] = true
+			names = append(names, parseQType(code).Name)
+		}
+	}
+
+	bitmap, _ := buildTypeBitmap(names)
+	names, _ = parseTypeBitmap(bitmap)
+
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,57 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pcap define the reading and writing of UDP datagrams in
+// the pcap capture format of tcpdump
+package pcap
+
+import (
+	"net/netip"
+	"time"
+)
+
+const (
+	// magic - Magic number of the captures with microsecond timestamps
+	magic = 0xa1b2c3d4
+	// magicNano - Magic number of the captures with nanosecond
+	// timestamps
+	magicNano = 0xa1b23c4d
+	// snapLength - Maximum size of the packets of the captures written
+	snapLength = 65535
+	// protocolUDP - Protocol number of UDP in the IP headers
+	protocolUDP = 17
+	// udpHeaderLength - Size of the UDP header
+	udpHeaderLength = 8
+	// ipv4HeaderLength - Size of the IPv4 header without options
+	ipv4HeaderLength = 20
+	// ipv6HeaderLength - Size of the IPv6 header
+	ipv6HeaderLength = 40
+)
+
+// Link types of the captures (https://www.tcpdump.org/linktypes.html)
+const (
+	// LinkTypeNull - BSD loopback, with the address family in host
+	// byte order
+	LinkTypeNull = 0
+	// LinkTypeEthernet - Ethernet frames
+	LinkTypeEthernet = 1
+	// LinkTypeRaw - IPv4 or IPv6 packets without link layer header
+	LinkTypeRaw = 101
+	// LinkTypeLinuxSLL - Linux cooked capture, of the any interface
+	LinkTypeLinuxSLL = 113
+	// LinkTypeIPv4 - IPv4 packets without link layer header
+	LinkTypeIPv4 = 228
+	// LinkTypeIPv6 - IPv6 packets without link layer header
+	LinkTypeIPv6 = 229
+	// LinkTypeLinuxSLL2 - Linux cooked capture version 2
+	LinkTypeLinuxSLL2 = 276
+)
+
+// Packet - UDP datagram of a capture
+type Packet struct {
+	Time        time.Time
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+	Payload     []byte
+}
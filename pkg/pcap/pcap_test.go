@@ -0,0 +1,178 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pcap define all the tests for the pcap package
+package pcap_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/pcap"
+)
+
+// compareAddresses - Compare the addresses of the packets by value
+var compareAddresses = cmp.Comparer(func(a, b netip.AddrPort) bool { return a == b })
+
+func TestWriteRead(t *testing.T) {
+	at := time.Unix(1760856000, 123456789)
+	tests := []struct {
+		name   string
+		packet pcap.Packet
+		want   pcap.Packet
+	}{
+		{
+			name: "IPv4",
+			packet: pcap.Packet{
+				Time:        at,
+				Source:      netip.MustParseAddrPort("127.0.0.1:54038"),
+				Destination: netip.MustParseAddrPort("127.0.0.1:3000"),
+				Payload:     []byte{0x10, 0x92, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			},
+			want: pcap.Packet{
+				Time:        at,
+				Source:      netip.MustParseAddrPort("127.0.0.1:54038"),
+				Destination: netip.MustParseAddrPort("127.0.0.1:3000"),
+				Payload:     []byte{0x10, 0x92, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			},
+		},
+		{
+			name: "IPv6",
+			packet: pcap.Packet{
+				Time:        at,
+				Source:      netip.MustParseAddrPort("[::1]:3000"),
+				Destination: netip.MustParseAddrPort("[::1]:54038"),
+				Payload:     []byte{0x10, 0x92, 0x81, 0x80, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00},
+			},
+			want: pcap.Packet{
+				Time:        at,
+				Source:      netip.MustParseAddrPort("[::1]:3000"),
+				Destination: netip.MustParseAddrPort("[::1]:54038"),
+				Payload:     []byte{0x10, 0x92, 0x81, 0x80, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00},
+			},
+		},
+		{
+			name: "IPv4 mapped",
+			packet: pcap.Packet{
+				Time:        at,
+				Source:      netip.MustParseAddrPort("[::ffff:192.168.14.7]:53"),
+				Destination: netip.MustParseAddrPort("127.0.0.1:3000"),
+				Payload:     []byte{},
+			},
+			want: pcap.Packet{
+				Time:        at,
+				Source:      netip.MustParseAddrPort("192.168.14.7:53"),
+				Destination: netip.MustParseAddrPort("127.0.0.1:3000"),
+				Payload:     []byte{},
+			},
+		},
+		{
+			name: "Mixed families",
+			packet: pcap.Packet{
+				Time:        at,
+				Source:      netip.MustParseAddrPort("0.0.0.0:0"),
+				Destination: netip.MustParseAddrPort("[2001:db8::53]:53"),
+				Payload:     []byte{0x00, 0x01},
+			},
+			want: pcap.Packet{
+				Time:        at,
+				Source:      netip.MustParseAddrPort("[::ffff:0.0.0.0]:0"),
+				Destination: netip.MustParseAddrPort("[2001:db8::53]:53"),
+				Payload:     []byte{0x00, 0x01},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var capture bytes.Buffer
+			writer, err := pcap.NewWriter(&capture)
+
+			if err != nil {
+				t.Fatalf("NewWriter() error = %v", err)
+			}
+
+			if err := writer.WritePacket(tt.packet); err != nil {
+				t.Fatalf("WritePacket() error = %v", err)
+			}
+
+			reader, err := pcap.NewReader(&capture)
+
+			if err != nil {
+				t.Fatalf("NewReader() error = %v", err)
+			}
+
+			got, err := reader.Next()
+
+			if err != nil {
+				t.Fatalf("Next() error = %v", err)
+			}
+
+			if diff := cmp.Diff(tt.want, got, compareAddresses); diff != "" {
+				t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+			}
+
+			if _, err := reader.Next(); err != io.EOF {
+				t.Errorf("Next() error = %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadEthernet(t *testing.T) {
+	// Capture of tcpdump in big endian, with microsecond timestamps
+	var capture bytes.Buffer
+	binary.Write(&capture, binary.BigEndian, []uint32{0xa1b2c3d4, 0x00020004, 0, 0, 262144, pcap.LinkTypeEthernet})
+
+	frame := func(protocol byte, fragment uint16, data []byte) {
+		ethernet := make([]byte, 14)
+		binary.BigEndian.PutUint16(ethernet[12:], 0x0800)
+		ip := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, protocol, 0, 0, 127, 0, 0, 1, 127, 0, 0, 53}
+		binary.BigEndian.PutUint16(ip[2:], uint16(len(ip)+len(data)))
+		binary.BigEndian.PutUint16(ip[6:], fragment)
+		packet := append(append(ethernet, ip...), data...)
+		// Padding of the short ethernet frames
+		packet = append(packet, 0, 0, 0, 0)
+
+		binary.Write(&capture, binary.BigEndian, []uint32{1760856000, 250000, uint32(len(packet)), uint32(len(packet))})
+		capture.Write(packet)
+	}
+
+	udp := []byte{0xd3, 0x16, 0x0b, 0xb8, 0x00, 0x0a, 0x00, 0x00, 0x12, 0x34}
+	frame(6, 0, make([]byte, 20))
+	frame(17, 0x2000, udp)
+	frame(17, 0x4000, udp)
+
+	reader, err := pcap.NewReader(&capture)
+
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+
+	got, err := reader.Next()
+
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+
+	want := pcap.Packet{
+		Time:        time.Unix(1760856000, 250000000),
+		Source:      netip.MustParseAddrPort("127.0.0.1:54038"),
+		Destination: netip.MustParseAddrPort("127.0.0.53:3000"),
+		Payload:     []byte{0x12, 0x34},
+	}
+
+	if diff := cmp.Diff(want, got, compareAddresses); diff != "" {
+		t.Errorf("Next() mismatch (-want +got):\n%s", diff)
+	}
+
+	if _, err := reader.Next(); err != io.EOF {
+		t.Errorf("Next() error = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pcap define the reading and writing of UDP datagrams in
+// the pcap capture format of tcpdump
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// Ether types of the link layer headers
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+	etherTypeVLAN = 0x8100
+)
+
+// Reader - Reader of the UDP datagrams of a capture
+type Reader struct {
+	input    io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+}
+
+// NewReader - Read the header of a capture from input
+func NewReader(input io.Reader) (*Reader, error) {
+	header := make([]byte, 24)
+
+	if _, err := io.ReadFull(input, header); err != nil {
+		return nil, fmt.Errorf("reading capture header: %w", err)
+	}
+
+	reader := &Reader{input: input}
+
+	switch {
+	case binary.LittleEndian.Uint32(header) == magic:
+		reader.order = binary.LittleEndian
+	case binary.LittleEndian.Uint32(header) == magicNano:
+		reader.order, reader.nano = binary.LittleEndian, true
+	case binary.BigEndian.Uint32(header) == magic:
+		reader.order = binary.BigEndian
+	case binary.BigEndian.Uint32(header) == magicNano:
+		reader.order, reader.nano = binary.BigEndian, true
+	default:
+		return nil, fmt.Errorf("not a pcap capture (pcapng isn't supported)")
+	}
+
+	reader.linkType = reader.order.Uint32(header[20:]) & 0x0FFFFFFF
+
+	switch reader.linkType {
+	case LinkTypeNull, LinkTypeEthernet, LinkTypeRaw, LinkTypeLinuxSLL, LinkTypeIPv4, LinkTypeIPv6, LinkTypeLinuxSLL2:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", reader.linkType)
+	}
+
+	return reader, nil
+}
+
+// Next - Read the next UDP datagram, skipping the other packets and
+// the fragments. Return io.EOF at the end of the capture
+func (reader *Reader) Next() (Packet, error) {
+	for {
+		record := make([]byte, 16)
+
+		if _, err := io.ReadFull(reader.input, record); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return Packet{}, fmt.Errorf("truncated packet header")
+			}
+
+			return Packet{}, err
+		}
+
+		data := make([]byte, reader.order.Uint32(record[8:]))
+
+		if _, err := io.ReadFull(reader.input, data); err != nil {
+			return Packet{}, fmt.Errorf("truncated packet: %w", err)
+		}
+
+		fraction := time.Duration(reader.order.Uint32(record[4:]))
+
+		if !reader.nano {
+			fraction *= time.Microsecond
+		}
+
+		packet, ok := parseIP(reader.network(data))
+
+		if ok {
+			packet.Time = time.Unix(int64(reader.order.Uint32(record[0:])), int64(fraction))
+
+			return packet, nil
+		}
+	}
+}
+
+// network - Network layer packet of a link layer frame, nil when it
+// isn't an IP packet
+func (reader *Reader) network(frame []byte) []byte {
+	switch reader.linkType {
+	case LinkTypeNull:
+		// The IP version tells the family, whatever the byte order
+		if len(frame) < 4 {
+			return nil
+		}
+
+		return frame[4:]
+	case LinkTypeEthernet:
+		if len(frame) < 14 {
+			return nil
+		}
+
+		etherType, data := binary.BigEndian.Uint16(frame[12:]), frame[14:]
+
+		for etherType == etherTypeVLAN && len(data) >= 4 {
+			etherType, data = binary.BigEndian.Uint16(data[2:]), data[4:]
+		}
+
+		return ipPayload(etherType, data)
+	case LinkTypeLinuxSLL:
+		if len(frame) < 16 {
+			return nil
+		}
+
+		return ipPayload(binary.BigEndian.Uint16(frame[14:]), frame[16:])
+	case LinkTypeLinuxSLL2:
+		if len(frame) < 20 {
+			return nil
+		}
+
+		return ipPayload(binary.BigEndian.Uint16(frame[0:]), frame[20:])
+	}
+
+	return frame
+}
+
+// ipPayload - Data of a frame when its ether type is IP
+func ipPayload(etherType uint16, data []byte) []byte {
+	if etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+		return nil
+	}
+
+	return data
+}
+
+// parseIP - UDP datagram of an IP packet
+func parseIP(data []byte) (Packet, bool) {
+	if len(data) == 0 {
+		return Packet{}, false
+	}
+
+	var source, destination netip.Addr
+	var udp []byte
+
+	switch data[0] >> 4 {
+	case 4:
+		length := int(data[0]&0x0F) * 4
+
+		if len(data) < ipv4HeaderLength || length < ipv4HeaderLength || len(data) < length {
+			return Packet{}, false
+		}
+
+		// More fragments flag or fragment offset
+		if data[9] != protocolUDP || binary.BigEndian.Uint16(data[6:])&0x3FFF != 0 {
+			return Packet{}, false
+		}
+
+		source, _ = netip.AddrFromSlice(data[12:16])
+		destination, _ = netip.AddrFromSlice(data[16:20])
+		udp = data[length:]
+
+		if total := int(binary.BigEndian.Uint16(data[2:])); total >= length && total <= len(data) {
+			udp = data[length:total]
+		}
+	case 6:
+		if len(data) < ipv6HeaderLength || data[6] != protocolUDP {
+			return Packet{}, false
+		}
+
+		source, _ = netip.AddrFromSlice(data[8:24])
+		destination, _ = netip.AddrFromSlice(data[24:40])
+		udp = data[ipv6HeaderLength:]
+	default:
+		return Packet{}, false
+	}
+
+	if len(udp) < udpHeaderLength {
+		return Packet{}, false
+	}
+
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+
+	// Datagrams cut by the snap length of the capture
+	if length < udpHeaderLength || length > len(udp) {
+		return Packet{}, false
+	}
+
+	return Packet{
+		Source:      netip.AddrPortFrom(source, binary.BigEndian.Uint16(udp[0:])),
+		Destination: netip.AddrPortFrom(destination, binary.BigEndian.Uint16(udp[2:])),
+		Payload:     udp[udpHeaderLength:length],
+	}, true
+}
@@ -0,0 +1,162 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pcap define the reading and writing of UDP datagrams in
+// the pcap capture format of tcpdump
+package pcap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+)
+
+// Writer - Writer of a capture of raw IP packets, with the datagrams
+// wrapped in synthetic UDP and IP headers
+type Writer struct {
+	output io.Writer
+}
+
+// NewWriter - Write the header of a capture to output
+func NewWriter(output io.Writer) (*Writer, error) {
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header[0:], magicNano)
+	binary.LittleEndian.PutUint16(header[4:], 2)
+	binary.LittleEndian.PutUint16(header[6:], 4)
+	binary.LittleEndian.PutUint32(header[16:], snapLength)
+	binary.LittleEndian.PutUint32(header[20:], LinkTypeRaw)
+
+	if _, err := output.Write(header); err != nil {
+		return nil, err
+	}
+
+	return &Writer{output: output}, nil
+}
+
+// WritePacket - Write a datagram, over IPv4 when both addresses are
+// IPv4 and over IPv6 otherwise
+func (writer *Writer) WritePacket(packet Packet) error {
+	source, destination := packet.Source.Addr().Unmap(), packet.Destination.Addr().Unmap()
+	ipv4 := source.Is4() && destination.Is4()
+	headers := udpHeaderLength + ipv6HeaderLength
+
+	if ipv4 {
+		headers = udpHeaderLength + ipv4HeaderLength
+	}
+
+	if headers+len(packet.Payload) > snapLength {
+		return fmt.Errorf("datagram of %d bytes is too large", len(packet.Payload))
+	}
+
+	var data []byte
+
+	if ipv4 {
+		data = ipv4Packet(source, destination, udpDatagram(packet))
+	} else {
+		data = ipv6Packet(netip.AddrFrom16(source.As16()), netip.AddrFrom16(destination.As16()), udpDatagram(packet))
+	}
+
+	record := make([]byte, 16)
+	binary.LittleEndian.PutUint32(record[0:], uint32(packet.Time.Unix()))
+	binary.LittleEndian.PutUint32(record[4:], uint32(packet.Time.Nanosecond()))
+	binary.LittleEndian.PutUint32(record[8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[12:], uint32(len(data)))
+
+	// A single write keeps the records whole when the output is
+	// shared
+	_, err := writer.output.Write(append(record, data...))
+
+	return err
+}
+
+// udpDatagram - UDP header and payload of a packet, without checksum
+func udpDatagram(packet Packet) []byte {
+	udp := make([]byte, udpHeaderLength, udpHeaderLength+len(packet.Payload))
+	binary.BigEndian.PutUint16(udp[0:], packet.Source.Port())
+	binary.BigEndian.PutUint16(udp[2:], packet.Destination.Port())
+	binary.BigEndian.PutUint16(udp[4:], uint16(udpHeaderLength+len(packet.Payload)))
+
+	return append(udp, packet.Payload...)
+}
+
+// ipv4Packet - IPv4 packet of an UDP datagram
+func ipv4Packet(source, destination netip.Addr, udp []byte) []byte {
+	header := make([]byte, ipv4HeaderLength)
+	header[0] = 0x45
+	binary.BigEndian.PutUint16(header[2:], uint16(ipv4HeaderLength+len(udp)))
+	// Don't fragment
+	header[6] = 0x40
+	header[8] = 64
+	header[9] = protocolUDP
+	copy(header[12:], source.AsSlice())
+	copy(header[16:], destination.AsSlice())
+	binary.BigEndian.PutUint16(header[10:], checksum(header))
+
+	setUDPChecksum(udp, header[12:20])
+
+	return append(header, udp...)
+}
+
+// ipv6Packet - IPv6 packet of an UDP datagram
+func ipv6Packet(source, destination netip.Addr, udp []byte) []byte {
+	header := make([]byte, ipv6HeaderLength)
+	header[0] = 0x60
+	binary.BigEndian.PutUint16(header[4:], uint16(len(udp)))
+	header[6] = protocolUDP
+	header[7] = 64
+	copy(header[8:], source.AsSlice())
+	copy(header[24:], destination.AsSlice())
+
+	setUDPChecksum(udp, header[8:40])
+
+	return append(header, udp...)
+}
+
+// setUDPChecksum - Compute the checksum of a datagram with the
+// pseudo header of its addresses (RFC 768, RFC 8200 - Section 8.1)
+func setUDPChecksum(udp []byte, addresses []byte) {
+	pseudo := make([]byte, 4)
+	binary.BigEndian.PutUint16(pseudo[0:], protocolUDP)
+	binary.BigEndian.PutUint16(pseudo[2:], uint16(len(udp)))
+
+	sum := checksum(addresses, pseudo, udp)
+
+	// A computed checksum of zero is sent as all ones
+	if sum == 0 {
+		sum = 0xFFFF
+	}
+
+	binary.BigEndian.PutUint16(udp[6:], sum)
+}
+
+// checksum - Internet checksum of the concatenation of data
+// (RFC 1071)
+func checksum(data ...[]byte) uint16 {
+	var sum uint32
+	var odd bool
+	var last byte
+
+	for _, chunk := range data {
+		for _, b := range chunk {
+			if odd {
+				sum += uint32(last)<<8 | uint32(b)
+			} else {
+				last = b
+			}
+
+			odd = !odd
+		}
+	}
+
+	if odd {
+		sum += uint32(last) << 8
+	}
+
+	for sum > 0xFFFF {
+		sum = sum>>16 + sum&0xFFFF
+	}
+
+	return ^uint16(sum)
+}
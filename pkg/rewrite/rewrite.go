@@ -0,0 +1,189 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rewrite define the rules rewriting the query names before
+// answering them, and the owner names of the answers back
+package rewrite
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+// Types of the rules
+const (
+	// Exact - Rule matching a name
+	Exact = "exact"
+	// Suffix - Rule matching a name and its subdomains, replacing the
+	// suffix of the names
+	Suffix = "suffix"
+	// Regex - Rule matching the names with a regular expression
+	Regex = "regex"
+)
+
+// rule - Rule of the configuration, with the names of the exact and
+// suffix rules in canonical form
+type rule struct {
+	kind    string
+	from    string
+	to      string
+	pattern *regexp.Regexp
+}
+
+// Rewritten - Name of a query rewritten by a rule
+type Rewritten struct {
+	// Original - Name of the query
+	Original string
+	// Name - Name answered in place of the original one
+	Name string
+	rule rule
+}
+
+// Rules - Rules rewriting the query names, checked in order. The
+// methods of nil Rules don't rewrite any name
+type Rules struct {
+	rules []rule
+	mutex sync.RWMutex
+}
+
+// New - Generate rules without any rule
+func New() *Rules {
+	return &Rules{}
+}
+
+// Load - Replace the rules with the configured ones. The rules don't
+// change on error
+func (rules *Rules) Load(configuration []config.Rewrite) error {
+	loaded := []rule{}
+
+	for _, rewrite := range configuration {
+		if rewrite.From == "" || rewrite.To == "" {
+			return fmt.Errorf("rule without from or to")
+		}
+
+		loadedRule := rule{kind: strings.ToLower(rewrite.Type), to: zone.CanonicalName(rewrite.To)}
+
+		switch loadedRule.kind {
+		case "":
+			loadedRule.kind = Exact
+			loadedRule.from = zone.CanonicalName(rewrite.From)
+		case Exact, Suffix:
+			loadedRule.from = zone.CanonicalName(rewrite.From)
+		case Regex:
+			pattern, err := regexp.Compile(rewrite.From)
+
+			if err != nil {
+				return fmt.Errorf("rule %s: %w", rewrite.From, err)
+			}
+
+			loadedRule.to = rewrite.To
+			loadedRule.pattern = pattern
+		default:
+			return fmt.Errorf("rule %s: unknown type %q, choose one of exact, suffix or regex", rewrite.From, rewrite.Type)
+		}
+
+		loaded = append(loaded, loadedRule)
+	}
+
+	rules.mutex.Lock()
+	defer rules.mutex.Unlock()
+
+	rules.rules = loaded
+
+	return nil
+}
+
+// Size - Number of rules
+func (rules *Rules) Size() int {
+	rules.mutex.RLock()
+	defer rules.mutex.RUnlock()
+
+	return len(rules.rules)
+}
+
+// Rewrite - Rewrite a query name with the first rule matching it
+func (rules *Rules) Rewrite(name string) (Rewritten, bool) {
+	if rules == nil {
+		return Rewritten{}, false
+	}
+
+	rules.mutex.RLock()
+	defer rules.mutex.RUnlock()
+
+	canonical := zone.CanonicalName(name)
+
+	for _, rule := range rules.rules {
+		var rewritten string
+
+		switch rule.kind {
+		case Exact:
+			if canonical != rule.from {
+				continue
+			}
+
+			rewritten = rule.to
+		case Suffix:
+			prefix, ok := cutSuffix(canonical, rule.from)
+
+			if !ok {
+				continue
+			}
+
+			rewritten = prefix + rule.to
+		case Regex:
+			if !rule.pattern.MatchString(canonical) {
+				continue
+			}
+
+			rewritten = zone.CanonicalName(rule.pattern.ReplaceAllString(canonical, rule.to))
+		}
+
+		if rewritten == "" || rewritten == canonical {
+			return Rewritten{}, false
+		}
+
+		return Rewritten{Original: name, Name: rewritten, rule: rule}, true
+	}
+
+	return Rewritten{}, false
+}
+
+// Restore - Map an owner name of the answer of a rewritten name back:
+// the rewritten name is the original one, and for the suffix rules the
+// names under the new suffix are under the original suffix. The other
+// names don't change
+func (rewritten Rewritten) Restore(name string) string {
+	canonical := zone.CanonicalName(name)
+
+	if canonical == rewritten.Name {
+		return rewritten.Original
+	}
+
+	if rewritten.rule.kind == Suffix {
+		if prefix, ok := cutSuffix(canonical, rewritten.rule.to); ok {
+			return prefix + rewritten.rule.from
+		}
+	}
+
+	return name
+}
+
+// cutSuffix - Labels of name before the domain suffix, with their
+// trailing dot, false when the name isn't the suffix or under it
+func cutSuffix(name, suffix string) (string, bool) {
+	if name == suffix {
+		return "", true
+	}
+
+	if strings.HasSuffix(name, "."+suffix) {
+		return strings.TrimSuffix(name, suffix), true
+	}
+
+	return "", false
+}
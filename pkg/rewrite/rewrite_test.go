@@ -0,0 +1,127 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rewrite define all the tests for the rewrite package
+package rewrite_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/rewrite"
+)
+
+func TestRewrite(t *testing.T) {
+	rules := rewrite.New()
+	err := rules.Load([]config.Rewrite{
+		{From: "legacy.test.", To: "www.test"},
+		{Type: "suffix", From: "prod.internal", To: "dev.test"},
+		{Type: "regex", From: `^(.+)\.svc\.cluster$`, To: "$1.local.test"},
+	})
+
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		want     string
+		found    bool
+		owner    string
+		restored string
+	}{
+		{
+			name:     "Exact",
+			query:    "Legacy.Test",
+			want:     "www.test",
+			found:    true,
+			owner:    "www.test",
+			restored: "Legacy.Test",
+		},
+		{
+			name:  "Exact doesn't match subdomains",
+			query: "a.legacy.test",
+		},
+		{
+			name:     "Suffix",
+			query:    "db.prod.internal",
+			want:     "db.dev.test",
+			found:    true,
+			owner:    "replica.dev.test",
+			restored: "replica.prod.internal",
+		},
+		{
+			name:     "Suffix of the zone apex",
+			query:    "prod.internal",
+			want:     "dev.test",
+			found:    true,
+			owner:    "other.test",
+			restored: "other.test",
+		},
+		{
+			name:  "Suffix doesn't match partial labels",
+			query: "db.preprod.internal",
+		},
+		{
+			name:     "Regex",
+			query:    "api.svc.cluster.",
+			want:     "api.local.test",
+			found:    true,
+			owner:    "API.local.test",
+			restored: "api.svc.cluster.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rewritten, found := rules.Rewrite(tt.query)
+
+			if found != tt.found {
+				t.Fatalf("Rewrite() found = %v, want %v", found, tt.found)
+			}
+
+			if diff := cmp.Diff(tt.want, rewritten.Name); diff != "" {
+				t.Errorf("Rewrite() mismatch (-want +got):\n%s", diff)
+			}
+
+			if !found {
+				return
+			}
+
+			if diff := cmp.Diff(tt.restored, rewritten.Restore(tt.owner)); diff != "" {
+				t.Errorf("Restore() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		configuration []config.Rewrite
+	}{
+		{
+			name:          "Unknown type",
+			configuration: []config.Rewrite{{Type: "prefix", From: "a.test", To: "b.test"}},
+		},
+		{
+			name:          "Invalid regex",
+			configuration: []config.Rewrite{{Type: "regex", From: "(a.test", To: "b.test"}},
+		},
+		{
+			name:          "Missing to",
+			configuration: []config.Rewrite{{From: "a.test"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := rewrite.New().Load(tt.configuration); err == nil {
+				t.Errorf("Load() error = nil, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rpz define the response policy zones (RPZ), whose records
+// are rules changing the answers of the names, addresses and name
+// servers they match
+package rpz
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+// Triggers of the rules
+const (
+	// QName - Rule matching the query name
+	QName = "qname"
+	// ResponseIP - Rule matching an address of the answer
+	ResponseIP = "response-ip"
+	// NSDName - Rule matching a name server of the answer
+	NSDName = "nsdname"
+)
+
+// Actions of the rules
+const (
+	// NXDomain - Answer with NXDOMAIN, a CNAME to the root
+	NXDomain = "nxdomain"
+	// NoData - Answer without records, a CNAME to *.
+	NoData = "nodata"
+	// Passthru - Answer without policy, a CNAME to rpz-passthru.
+	Passthru = "passthru"
+	// Drop - Drop the query without response, a CNAME to rpz-drop.
+	Drop = "drop"
+	// TCPOnly - Answer truncated over UDP, so the client retries over
+	// TCP, a CNAME to rpz-tcp-only.
+	TCPOnly = "tcp-only"
+	// LocalData - Answer with the records of the rule
+	LocalData = "local-data"
+)
+
+// Suffixes of the owner names of the triggers in the policy zones
+const (
+	ipSuffix      = ".rpz-ip"
+	nsdnameSuffix = ".rpz-nsdname"
+)
+
+// actions - Action of the CNAME targets of the policy zones
+var actions = map[string]string{
+	".":            NXDomain,
+	"*":            NoData,
+	"rpz-passthru": Passthru,
+	"rpz-drop":     Drop,
+	"rpz-tcp-only": TCPOnly,
+}
+
+// Rule - Rule of a policy zone
+type Rule struct {
+	// Zone - Policy zone of the rule
+	Zone string
+	// Trigger - Trigger of the rule: qname, response-ip or nsdname
+	Trigger string
+	// Name - Name, network or name server matched by the rule
+	Name string
+	// Action - Action of the rule
+	Action string
+	// Records - Records answered by the local-data rules
+	Records []types.DNSResource
+}
+
+// policyZone - Rules of a policy zone by trigger
+type policyZone struct {
+	names        map[string]Rule
+	wildcards    map[string]Rule
+	networks     map[netip.Prefix]Rule
+	nameServers  map[string]Rule
+	nsdWildcards map[string]Rule
+	rules        int
+}
+
+// Policies - Policy zones checked in order, the first one matching
+// wins. The methods of nil Policies don't match any rule
+type Policies struct {
+	zones []policyZone
+	mutex sync.RWMutex
+}
+
+// New - Generate policies without any zone
+func New() *Policies {
+	return &Policies{}
+}
+
+// Load - Replace the policy zones with the configured ones, reading
+// their files. The policies don't change on error
+func (policies *Policies) Load(configuration []config.ResponsePolicy) error {
+	zones := []policyZone{}
+
+	for _, policy := range configuration {
+		loaded, err := loadZone(policy)
+
+		if err != nil {
+			return fmt.Errorf("policy zone %s: %w", policy.Name, err)
+		}
+
+		zones = append(zones, loaded)
+	}
+
+	policies.mutex.Lock()
+	defer policies.mutex.Unlock()
+
+	policies.zones = zones
+
+	return nil
+}
+
+// Size - Number of rules of the policy zones
+func (policies *Policies) Size() int {
+	if policies == nil {
+		return 0
+	}
+
+	policies.mutex.RLock()
+	defer policies.mutex.RUnlock()
+
+	size := 0
+
+	for _, policyZone := range policies.zones {
+		size += policyZone.rules
+	}
+
+	return size
+}
+
+// MatchName - Rule matching a query name: the rule of the name, or the
+// wildcard of its closest parent
+func (policies *Policies) MatchName(name string) (Rule, bool) {
+	if policies == nil {
+		return Rule{}, false
+	}
+
+	policies.mutex.RLock()
+	defer policies.mutex.RUnlock()
+
+	name = zone.CanonicalName(name)
+
+	for _, policyZone := range policies.zones {
+		if rule, found := matchName(name, policyZone.names, policyZone.wildcards); found {
+			return rule, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// MatchIP - Rule matching an address of an answer, the one of the
+// longest network of the first zone matching
+func (policies *Policies) MatchIP(address netip.Addr) (Rule, bool) {
+	if policies == nil {
+		return Rule{}, false
+	}
+
+	policies.mutex.RLock()
+	defer policies.mutex.RUnlock()
+
+	address = address.Unmap()
+
+	for _, policyZone := range policies.zones {
+		var match Rule
+		bits := -1
+
+		for network, rule := range policyZone.networks {
+			if network.Contains(address) && network.Bits() > bits {
+				match, bits = rule, network.Bits()
+			}
+		}
+
+		if bits >= 0 {
+			return match, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// MatchNameServer - Rule matching a name server of an answer: the rule
+// of the name server, or the wildcard of its closest parent
+func (policies *Policies) MatchNameServer(name string) (Rule, bool) {
+	if policies == nil {
+		return Rule{}, false
+	}
+
+	policies.mutex.RLock()
+	defer policies.mutex.RUnlock()
+
+	name = zone.CanonicalName(name)
+
+	for _, policyZone := range policies.zones {
+		if rule, found := matchName(name, policyZone.nameServers, policyZone.nsdWildcards); found {
+			return rule, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// Answer - Records of a local-data rule answering a question, with the
+// question name as owner. The CNAME records answer every type
+func (rule Rule) Answer(question types.DNSQuestion) []types.DNSResource {
+	answers := []types.DNSResource{}
+
+	for _, record := range rule.Records {
+		if record.Type.Code == question.Type.Code || record.Type.Code == types.CNAME.Code || question.Type.Code == types.ANY.Code {
+			record.Name = question.Name
+			answers = append(answers, record)
+		}
+	}
+
+	return answers
+}
+
+// matchName - Rule of a name, or the wildcard of its closest parent
+func matchName(name string, names, wildcards map[string]Rule) (Rule, bool) {
+	if rule, found := names[name]; found {
+		return rule, true
+	}
+
+	for parent := parentName(name); parent != ""; parent = parentName(parent) {
+		if rule, found := wildcards[parent]; found {
+			return rule, true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// loadZone - Read the rules of a policy zone from its file and records
+func loadZone(policy config.ResponsePolicy) (policyZone, error) {
+	loaded := policyZone{
+		names:        map[string]Rule{},
+		wildcards:    map[string]Rule{},
+		networks:     map[netip.Prefix]Rule{},
+		nameServers:  map[string]Rule{},
+		nsdWildcards: map[string]Rule{},
+	}
+	origin := zone.CanonicalName(policy.Name)
+	zoneConfig := config.Zone{Name: origin}
+
+	if policy.File != "" {
+		file, err := os.Open(policy.File)
+
+		if err != nil {
+			return loaded, err
+		}
+
+		zoneConfig.Records, err = zone.ParseFile(file, origin)
+		file.Close()
+
+		if err != nil {
+			return loaded, err
+		}
+	}
+
+	zoneConfig.Records = append(zoneConfig.Records, policy.Records...)
+	_, _, records, err := zone.FromConfig(zoneConfig)
+
+	if err != nil {
+		return loaded, err
+	}
+
+	owners := []string{}
+	rules := map[string][]types.DNSResource{}
+
+	for _, record := range records {
+		owner := zone.CanonicalName(record.Name)
+
+		// The NS records of the apex aren't rules
+		if owner == origin {
+			continue
+		}
+
+		if _, ok := rules[owner]; !ok {
+			owners = append(owners, owner)
+		}
+
+		rules[owner] = append(rules[owner], record)
+	}
+
+	for _, owner := range owners {
+		trigger := strings.TrimSuffix(owner, "."+origin)
+		rule := Rule{Zone: origin, Action: action(rules[owner]), Records: rules[owner]}
+
+		switch {
+		case strings.HasSuffix(trigger, ipSuffix):
+			network, err := parseNetwork(strings.TrimSuffix(trigger, ipSuffix))
+
+			if err != nil {
+				return loaded, fmt.Errorf("rule %s: %w", owner, err)
+			}
+
+			rule.Trigger, rule.Name = ResponseIP, network.String()
+			loaded.networks[network] = rule
+		case strings.HasSuffix(trigger, nsdnameSuffix):
+			rule.Trigger, rule.Name = NSDName, strings.TrimSuffix(trigger, nsdnameSuffix)
+			addName(rule, loaded.nameServers, loaded.nsdWildcards)
+		case strings.HasPrefix(trigger, "rpz-") || strings.Contains(trigger, ".rpz-"):
+			log.Warnf("Ignoring rule %s of policy zone %s with unsupported trigger", owner, origin)
+
+			continue
+		default:
+			rule.Trigger, rule.Name = QName, trigger
+			addName(rule, loaded.names, loaded.wildcards)
+		}
+
+		loaded.rules++
+	}
+
+	return loaded, nil
+}
+
+// addName - Add a rule of a name, or of the subdomains of a wildcard
+func addName(rule Rule, names, wildcards map[string]Rule) {
+	if strings.HasPrefix(rule.Name, "*.") {
+		wildcards[strings.TrimPrefix(rule.Name, "*.")] = rule
+
+		return
+	}
+
+	names[rule.Name] = rule
+}
+
+// action - Action of the records of a rule: the special CNAME targets,
+// or local data for the other records
+func action(records []types.DNSResource) string {
+	if len(records) != 1 || records[0].Type.Code != types.CNAME.Code {
+		return LocalData
+	}
+
+	target := strings.ToLower(records[0].RData)
+
+	if target != "." {
+		target = strings.TrimSuffix(target, ".")
+	}
+
+	if action, ok := actions[target]; ok {
+		return action
+	}
+
+	return LocalData
+}
+
+// parseNetwork - Network of the owner of a response-ip rule: the prefix
+// length followed by the labels of the address in reverse order, with
+// zz replacing the longest run of zeros of the IPv6 addresses
+func parseNetwork(trigger string) (netip.Prefix, error) {
+	labels := strings.Split(trigger, ".")
+
+	if len(labels) < 2 {
+		return netip.Prefix{}, fmt.Errorf("invalid response-ip trigger")
+	}
+
+	bits, err := strconv.Atoi(labels[0])
+
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid prefix length %s", labels[0])
+	}
+
+	parts := labels[1:]
+
+	for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+		parts[i], parts[j] = parts[j], parts[i]
+	}
+
+	var text string
+
+	if len(parts) == 4 && !strings.Contains(trigger, "zz") {
+		text = strings.Join(parts, ".")
+	} else {
+		text = strings.Join(parts, ":")
+		text = strings.Replace(text, "zz", "", 1)
+
+		if text == "" {
+			text = "::"
+		} else if strings.HasPrefix(text, ":") {
+			text = ":" + text
+		}
+
+		if strings.HasSuffix(text, ":") && !strings.HasSuffix(text, "::") {
+			text += ":"
+		}
+	}
+
+	network, err := netip.ParsePrefix(text + "/" + strconv.Itoa(bits))
+
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return network.Masked(), nil
+}
+
+// parentName - Name without its first label, empty for the top level
+// domains
+func parentName(name string) string {
+	if dot := strings.Index(name, "."); dot >= 0 {
+		return name[dot+1:]
+	}
+
+	return ""
+}
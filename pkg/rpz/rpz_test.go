@@ -0,0 +1,155 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rpz define all the tests for the rpz package
+package rpz_test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/rpz"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+const policyFile = `$TTL 60
+@	SOA	localhost. hostmaster.localhost. 1 3600 600 604800 60
+	NS	localhost.
+bad.example.com		CNAME	.
+*.bad.example.com	CNAME	.
+empty.example.com	CNAME	*.
+ok.bad.example.com	CNAME	rpz-passthru.
+drop.example.com	CNAME	rpz-drop.
+tcp.example.com		CNAME	rpz-tcp-only.
+garden.example.com	A	192.0.2.1
+			AAAA	2001:db8::1
+24.0.2.0.198.rpz-ip	CNAME	.
+32.1.2.0.198.rpz-ip	CNAME	rpz-passthru.
+48.zz.db8.2001.rpz-ip	CNAME	*.
+ns.evil.test.rpz-nsdname	CNAME	rpz-drop.
+`
+
+func TestMatch(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "policy.zone")
+	os.WriteFile(file, []byte(policyFile), 0644)
+
+	policies := rpz.New()
+	err := policies.Load([]config.ResponsePolicy{
+		{Name: "first.rpz", Records: []config.Record{{Name: "walled.example.com", Type: types.CNAME, Value: "garden.example.com"}}},
+		{Name: "policy.rpz", File: file},
+	})
+
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if diff := cmp.Diff(12, policies.Size()); diff != "" {
+		t.Errorf("Size() mismatch (-want +got):\n%s", diff)
+	}
+
+	rule := func(zone, trigger, name, action string) rpz.Rule {
+		return rpz.Rule{Zone: zone, Trigger: trigger, Name: name, Action: action}
+	}
+	ignoreRecords := cmpopts.IgnoreFields(rpz.Rule{}, "Records")
+
+	names := []struct {
+		name  string
+		want  rpz.Rule
+		found bool
+	}{
+		{"Bad.Example.com.", rule("policy.rpz", rpz.QName, "bad.example.com", rpz.NXDomain), true},
+		{"a.b.bad.example.com", rule("policy.rpz", rpz.QName, "*.bad.example.com", rpz.NXDomain), true},
+		{"ok.bad.example.com", rule("policy.rpz", rpz.QName, "ok.bad.example.com", rpz.Passthru), true},
+		{"empty.example.com", rule("policy.rpz", rpz.QName, "empty.example.com", rpz.NoData), true},
+		{"drop.example.com", rule("policy.rpz", rpz.QName, "drop.example.com", rpz.Drop), true},
+		{"tcp.example.com", rule("policy.rpz", rpz.QName, "tcp.example.com", rpz.TCPOnly), true},
+		{"walled.example.com", rule("first.rpz", rpz.QName, "walled.example.com", rpz.LocalData), true},
+		{"example.com", rpz.Rule{}, false},
+		{"a.empty.example.com", rpz.Rule{}, false},
+	}
+
+	for _, tt := range names {
+		t.Run("qname "+tt.name, func(t *testing.T) {
+			got, found := policies.MatchName(tt.name)
+
+			if found != tt.found {
+				t.Errorf("MatchName() found = %v, want %v", found, tt.found)
+			}
+
+			if diff := cmp.Diff(tt.want, got, ignoreRecords); diff != "" {
+				t.Errorf("MatchName() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	addresses := []struct {
+		address string
+		want    rpz.Rule
+		found   bool
+	}{
+		{"198.0.2.7", rule("policy.rpz", rpz.ResponseIP, "198.0.2.0/24", rpz.NXDomain), true},
+		{"198.0.2.1", rule("policy.rpz", rpz.ResponseIP, "198.0.2.1/32", rpz.Passthru), true},
+		{"2001:db8:0:1::1", rule("policy.rpz", rpz.ResponseIP, "2001:db8::/48", rpz.NoData), true},
+		{"198.0.3.1", rpz.Rule{}, false},
+	}
+
+	for _, tt := range addresses {
+		t.Run("response-ip "+tt.address, func(t *testing.T) {
+			got, found := policies.MatchIP(netip.MustParseAddr(tt.address))
+
+			if found != tt.found {
+				t.Errorf("MatchIP() found = %v, want %v", found, tt.found)
+			}
+
+			if diff := cmp.Diff(tt.want, got, ignoreRecords); diff != "" {
+				t.Errorf("MatchIP() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	got, found := policies.MatchNameServer("NS.evil.test.")
+
+	if diff := cmp.Diff(rule("policy.rpz", rpz.NSDName, "ns.evil.test", rpz.Drop), got, ignoreRecords); !found || diff != "" {
+		t.Errorf("MatchNameServer() found = %v, mismatch (-want +got):\n%s", found, diff)
+	}
+}
+
+func TestAnswer(t *testing.T) {
+	garden := rpz.Rule{Action: rpz.LocalData, Records: []types.DNSResource{
+		{Name: "garden.example.com.policy.rpz", Type: types.A, Class: types.IN, TTL: 60, RData: "192.0.2.1"},
+		{Name: "garden.example.com.policy.rpz", Type: types.AAAA, Class: types.IN, TTL: 60, RData: "2001:db8::1"},
+	}}
+
+	tests := []struct {
+		name  string
+		qtype types.QType
+		want  []types.DNSResource
+	}{
+		{
+			name:  "Type of the records",
+			qtype: types.A,
+			want:  []types.DNSResource{{Name: "garden.example.com", Type: types.A, Class: types.IN, TTL: 60, RData: "192.0.2.1"}},
+		},
+		{
+			name:  "Type without records",
+			qtype: types.MX,
+			want:  []types.DNSResource{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := garden.Answer(types.DNSQuestion{Name: "garden.example.com", Type: tt.qtype, Class: types.IN})
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Answer() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
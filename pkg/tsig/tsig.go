@@ -0,0 +1,425 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tsig define the authentication of DNS messages
+// with shared secrets (RFC 8945)
+package tsig
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// Supported algorithms (RFC 8945 - Section 6)
+const (
+	HMACSHA256 = "hmac-sha256"
+	HMACSHA512 = "hmac-sha512"
+)
+
+// Fudge - Seconds of difference allowed between the clocks of the
+// client and the server
+const Fudge = 300
+
+// Key - Shared secret used to sign the messages
+type Key struct {
+	Name      string
+	Algorithm string
+	Secret    []byte
+}
+
+// Record - TSIG record of a signed message
+// TSIG RDATA format from RFC 8945 - Section 4.2
+//
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	/                 ALGORITHM NAME                /
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                  TIME SIGNED                  |
+//	|                                               |
+//	|                                               |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                     FUDGE                     |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                   MAC SIZE                    |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	/                      MAC                      /
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                  ORIGINAL ID                  |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                     ERROR                     |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                  OTHER LEN                    |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	/                  OTHER DATA                   /
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+type Record struct {
+	// Name - Name of the key, the owner of the record
+	Name       string
+	Algorithm  string
+	TimeSigned uint64
+	Fudge      uint16
+	MAC        []byte
+	OriginalID uint16
+	Error      uint16
+	Other      []byte
+}
+
+// Error - Failed verification of a signed message, with the rcode
+// of the TSIG record sent in the response
+type Error struct {
+	RCode types.RCode
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("TSIG verification failed: %s", err.RCode)
+}
+
+// Verification errors
+var (
+	ErrBadSig  = &Error{RCode: types.BADSIG}
+	ErrBadKey  = &Error{RCode: types.BADKEY}
+	ErrBadTime = &Error{RCode: types.BADTIME}
+)
+
+// Keyring - Keys known by the server, by name
+type Keyring struct {
+	keys  map[string]Key
+	mutex sync.RWMutex
+}
+
+// NewKeyring - Generate an empty keyring
+func NewKeyring() *Keyring {
+	return &Keyring{keys: map[string]Key{}}
+}
+
+// Load - Replace the keys of the keyring with the configured ones
+func (keyring *Keyring) Load(keys []config.Key) error {
+	loaded := map[string]Key{}
+
+	for _, keyConfig := range keys {
+		algorithm := strings.ToLower(keyConfig.Algorithm)
+
+		if algorithm == "" {
+			algorithm = HMACSHA256
+		}
+
+		if newHash(algorithm) == nil {
+			return fmt.Errorf("key %s: unknown algorithm %q", keyConfig.Name, keyConfig.Algorithm)
+		}
+
+		secret, err := base64.StdEncoding.DecodeString(keyConfig.Secret)
+
+		if err != nil || len(secret) == 0 {
+			return fmt.Errorf("key %s: invalid base64 secret", keyConfig.Name)
+		}
+
+		name := canonicalName(keyConfig.Name)
+		loaded[name] = Key{Name: name, Algorithm: algorithm, Secret: secret}
+	}
+
+	keyring.mutex.Lock()
+	keyring.keys = loaded
+	keyring.mutex.Unlock()
+
+	return nil
+}
+
+// Get - Key with name
+func (keyring *Keyring) Get(name string) (Key, bool) {
+	if keyring == nil {
+		return Key{}, false
+	}
+
+	keyring.mutex.RLock()
+	defer keyring.mutex.RUnlock()
+
+	key, ok := keyring.keys[canonicalName(name)]
+
+	return key, ok
+}
+
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+func newHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case HMACSHA256:
+		return sha256.New
+	case HMACSHA512:
+		return sha512.New
+	}
+
+	return nil
+}
+
+// Size - Length of the TSIG record added by the key to a message
+func Size(key Key) int {
+	name, _ := parser.BuildName(key.Name)
+	algorithm, _ := parser.BuildName(key.Algorithm)
+
+	return len(name) + 10 + len(algorithm) + 16 + newHash(key.Algorithm)().Size()
+}
+
+// Find - TSIG record of a message and the offset where it starts.
+// The last value is false when the message isn't signed
+func Find(message []byte) (Record, int, bool, error) {
+	parsed, err := parser.ParseDNSMessage(message)
+
+	if err != nil {
+		return Record{}, 0, false, err
+	}
+
+	for i, resource := range parsed.Additional {
+		if resource.Type.Code != types.TSIG.Code {
+			continue
+		}
+
+		if i != len(parsed.Additional)-1 {
+			return Record{}, 0, false, fmt.Errorf("TSIG record isn't the last record")
+		}
+
+		offset, err := parser.LastResourceOffset(message)
+
+		if err != nil {
+			return Record{}, 0, false, err
+		}
+
+		rdata, err := parser.BuildRData(types.TSIG, resource.RData)
+
+		if err != nil {
+			return Record{}, 0, false, err
+		}
+
+		record, err := parseRecord(rdata)
+		record.Name = canonicalName(resource.Name)
+
+		return record, offset, true, err
+	}
+
+	return Record{}, 0, false, nil
+}
+
+// parseRecord - Read the RDATA of a TSIG record
+func parseRecord(rdata []byte) (record Record, err error) {
+	offset := 0
+
+	for offset < len(rdata) && rdata[offset] != 0 {
+		offset += int(rdata[offset]) + 1
+	}
+
+	if offset+17 > len(rdata) {
+		return record, fmt.Errorf("TSIG rdata too short")
+	}
+
+	labels := []string{}
+	for i := 0; rdata[i] != 0; i += int(rdata[i]) + 1 {
+		labels = append(labels, string(rdata[i+1:i+1+int(rdata[i])]))
+	}
+
+	record.Algorithm = strings.ToLower(strings.Join(labels, "."))
+	offset++
+
+	record.TimeSigned = uint64(binary.BigEndian.Uint16(rdata[offset:]))<<32 | uint64(binary.BigEndian.Uint32(rdata[offset+2:]))
+	record.Fudge = binary.BigEndian.Uint16(rdata[offset+6:])
+	size := int(binary.BigEndian.Uint16(rdata[offset+8:]))
+	offset += 10
+
+	if offset+size+6 > len(rdata) {
+		return record, fmt.Errorf("TSIG MAC out of bounds")
+	}
+
+	record.MAC = rdata[offset : offset+size]
+	offset += size
+	record.OriginalID = binary.BigEndian.Uint16(rdata[offset:])
+	record.Error = binary.BigEndian.Uint16(rdata[offset+2:])
+	otherSize := int(binary.BigEndian.Uint16(rdata[offset+4:]))
+	offset += 6
+
+	if offset+otherSize != len(rdata) {
+		return record, fmt.Errorf("TSIG other data with invalid length")
+	}
+
+	record.Other = rdata[offset:]
+
+	return record, nil
+}
+
+// rdata - Wire format of the RDATA of the record
+func (record Record) rdata() []byte {
+	algorithm, _ := parser.BuildName(record.Algorithm)
+	data := append([]byte{}, algorithm...)
+	data = append(data, uint48(record.TimeSigned)...)
+	data = appendUint16(data, record.Fudge)
+	data = appendUint16(data, uint16(len(record.MAC)))
+	data = append(data, record.MAC...)
+	data = appendUint16(data, record.OriginalID)
+	data = appendUint16(data, record.Error)
+	data = appendUint16(data, uint16(len(record.Other)))
+
+	return append(data, record.Other...)
+}
+
+func appendUint16(data []byte, value uint16) []byte {
+	return append(data, byte(value>>8), byte(value))
+}
+
+func uint48(value uint64) []byte {
+	return []byte{byte(value >> 40), byte(value >> 32), byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)}
+}
+
+// variables - TSIG variables included in the MAC (RFC 8945 - Section 4.3.3).
+// With timersOnly, only the time signed and fudge are included, as in
+// the messages following the first one of a response
+func (record Record) variables(timersOnly bool) []byte {
+	if timersOnly {
+		return appendUint16(uint48(record.TimeSigned), record.Fudge)
+	}
+
+	name, _ := parser.BuildName(record.Name)
+	algorithm, _ := parser.BuildName(record.Algorithm)
+
+	data := append([]byte{}, name...)
+	data = appendUint16(data, uint16(types.ANY.Code))
+	data = append(data, 0, 0, 0, 0)
+	data = append(data, algorithm...)
+	data = append(data, uint48(record.TimeSigned)...)
+	data = appendUint16(data, record.Fudge)
+	data = appendUint16(data, record.Error)
+	data = appendUint16(data, uint16(len(record.Other)))
+
+	return append(data, record.Other...)
+}
+
+// mac - Compute the MAC of a message without its TSIG record. The MAC
+// of the request, or of the previous message, is prepended when given
+func mac(key Key, message []byte, record Record, previous []byte, timersOnly bool) []byte {
+	digest := hmac.New(newHash(key.Algorithm), key.Secret)
+
+	if previous != nil {
+		digest.Write(appendUint16(nil, uint16(len(previous))))
+		digest.Write(previous)
+	}
+
+	digest.Write(message)
+	digest.Write(record.variables(timersOnly))
+
+	return digest.Sum(nil)
+}
+
+// Append - Add a TSIG record to the end of a message in wire format
+func Append(message []byte, record Record) []byte {
+	name, _ := parser.BuildName(record.Name)
+	rdata := record.rdata()
+
+	data := append([]byte{}, message...)
+	data = append(data, name...)
+	data = appendUint16(data, uint16(types.TSIG.Code))
+	data = appendUint16(data, uint16(types.ANY.Code))
+	data = append(data, 0, 0, 0, 0)
+	data = appendUint16(data, uint16(len(rdata)))
+	data = append(data, rdata...)
+
+	// Increment the ARCOUNT
+	binary.BigEndian.PutUint16(data[10:], binary.BigEndian.Uint16(data[10:])+1)
+
+	return data
+}
+
+// Sign - Add a TSIG record signed with the key to a message. For the
+// responses, previous is the MAC of the request or of the previous
+// message of the response. Return the signed message and its MAC
+func Sign(message []byte, key Key, previous []byte, timersOnly bool) ([]byte, []byte) {
+	return sign(message, key, previous, timersOnly, 0, nil)
+}
+
+// SignBadTime - Sign the response to a request received out of the
+// time window, with the time of the server (RFC 8945 - Section 5.2.3)
+func SignBadTime(message []byte, key Key, requestMAC []byte) []byte {
+	signed, _ := sign(message, key, requestMAC, false, uint16(types.BADTIME.Code), uint48(uint64(time.Now().Unix())))
+
+	return signed
+}
+
+func sign(message []byte, key Key, previous []byte, timersOnly bool, tsigError uint16, other []byte) ([]byte, []byte) {
+	record := Record{
+		Name:       key.Name,
+		Algorithm:  key.Algorithm,
+		TimeSigned: uint64(time.Now().Unix()),
+		Fudge:      Fudge,
+		OriginalID: binary.BigEndian.Uint16(message),
+		Error:      tsigError,
+		Other:      other,
+	}
+	record.MAC = mac(key, message, record, previous, timersOnly)
+
+	return Append(message, record), record.MAC
+}
+
+// Unsigned - Add a TSIG record without MAC to a response, reporting
+// an unknown key or a wrong signature (RFC 8945 - Section 5.3.2)
+func Unsigned(message []byte, request Record, tsigError types.RCode) []byte {
+	return Append(message, Record{
+		Name:       request.Name,
+		Algorithm:  request.Algorithm,
+		TimeSigned: request.TimeSigned,
+		Fudge:      request.Fudge,
+		OriginalID: binary.BigEndian.Uint16(message),
+		Error:      uint16(tsigError.Code),
+	})
+}
+
+// Verify - Check the TSIG record of a message with the key. For the
+// responses, previous is the MAC of the request or of the previous
+// message. Return the message without the TSIG record, as it was
+// before signing it, and its MAC
+func Verify(message []byte, key Key, previous []byte, timersOnly bool) ([]byte, []byte, error) {
+	record, offset, found, err := Find(message)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !found {
+		return nil, nil, fmt.Errorf("message isn't signed")
+	}
+
+	if record.Name != key.Name || record.Algorithm != key.Algorithm {
+		return nil, nil, ErrBadKey
+	}
+
+	unsigned := append([]byte{}, message[:offset]...)
+	binary.BigEndian.PutUint16(unsigned, record.OriginalID)
+	binary.BigEndian.PutUint16(unsigned[10:], binary.BigEndian.Uint16(unsigned[10:])-1)
+
+	expected := mac(key, unsigned, record, previous, timersOnly)
+
+	// Truncated MACs aren't accepted
+	if !hmac.Equal(expected, record.MAC) {
+		return nil, nil, ErrBadSig
+	}
+
+	now := uint64(time.Now().Unix())
+
+	if now > record.TimeSigned+uint64(record.Fudge) || record.TimeSigned > now+uint64(record.Fudge) {
+		return unsigned, record.MAC, ErrBadTime
+	}
+
+	if record.Error != 0 {
+		return unsigned, record.MAC, fmt.Errorf("TSIG error %d", record.Error)
+	}
+
+	return unsigned, record.MAC, nil
+}
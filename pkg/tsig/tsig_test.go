@@ -0,0 +1,127 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tsig_test define the test for the tsig package
+package tsig_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/tsig"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+func TestSignVerify(t *testing.T) {
+	keyring := tsig.NewKeyring()
+	err := keyring.Load([]config.Key{
+		{Name: "sha256-key.", Secret: "c2VjcmV0LWtleS1mb3ItdGVzdGluZw=="},
+		{Name: "sha512-key", Algorithm: "HMAC-SHA512", Secret: "c2VjcmV0LWtleS1mb3ItdGVzdGluZw=="},
+	})
+
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	query, _ := parser.BuildDNSMessage(types.DNSMessage{
+		Header:    types.DNSHeader{Identifier: 1234, OpCode: types.Query},
+		Questions: []types.DNSQuestion{{Name: "example.com", Type: types.SOA, Class: types.IN}},
+	})
+	sha256Key, _ := keyring.Get("sha256-key")
+	sha512Key, _ := keyring.Get("sha512-key")
+
+	tests := []struct {
+		name    string
+		key     tsig.Key
+		tamper  func(message []byte) []byte
+		verify  tsig.Key
+		wantErr error
+	}{
+		{
+			name:   "HMAC-SHA256",
+			key:    sha256Key,
+			verify: sha256Key,
+		},
+		{
+			name:   "HMAC-SHA512",
+			key:    sha512Key,
+			verify: sha512Key,
+		},
+		{
+			name: "Modified message",
+			key:  sha256Key,
+			tamper: func(message []byte) []byte {
+				message[3] ^= 1
+				return message
+			},
+			verify:  sha256Key,
+			wantErr: tsig.ErrBadSig,
+		},
+		{
+			name:    "Other key",
+			key:     sha512Key,
+			verify:  sha256Key,
+			wantErr: tsig.ErrBadKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signed, mac := tsig.Sign(query, tt.key, nil, false)
+
+			if tt.tamper != nil {
+				signed = tt.tamper(signed)
+			}
+
+			unsigned, verified, err := tsig.Verify(signed, tt.verify, nil, false)
+
+			if err != tt.wantErr {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(query, unsigned); diff != "" {
+				t.Errorf("Verify() message mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(mac, verified); diff != "" {
+				t.Errorf("Verify() MAC mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	keyring := tsig.NewKeyring()
+	keyring.Load([]config.Key{{Name: "key", Secret: "c2VjcmV0LWtleS1mb3ItdGVzdGluZw=="}})
+	key, _ := keyring.Get("key")
+
+	message, _ := parser.BuildDNSMessage(types.DNSMessage{
+		Header:    types.DNSHeader{Identifier: 1, QR: true},
+		Questions: []types.DNSQuestion{{Name: "example.com", Type: types.AXFR, Class: types.IN}},
+	})
+
+	_, requestMAC := tsig.Sign(message, key, nil, false)
+	first, firstMAC := tsig.Sign(message, key, requestMAC, false)
+	second, _ := tsig.Sign(message, key, firstMAC, true)
+
+	_, mac, err := tsig.Verify(first, key, requestMAC, false)
+
+	if err != nil {
+		t.Fatalf("Verify() first message error = %v", err)
+	}
+
+	if _, _, err = tsig.Verify(second, key, mac, true); err != nil {
+		t.Errorf("Verify() second message error = %v", err)
+	}
+
+	if _, _, err = tsig.Verify(second, key, requestMAC, true); err != tsig.ErrBadSig {
+		t.Errorf("Verify() second message with the request MAC error = %v, want %v", err, tsig.ErrBadSig)
+	}
+}
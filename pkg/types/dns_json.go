@@ -0,0 +1,90 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package types define all the DNS types used by the server
+package types
+
+// DNSJSON - DNS message in the JSON format of the DNS over HTTPS APIs
+// of Google and Cloudflare (application/dns-json)
+type DNSJSON struct {
+	Status     int               `json:"Status"`
+	TC         bool              `json:"TC"`
+	RD         bool              `json:"RD"`
+	RA         bool              `json:"RA"`
+	AD         bool              `json:"AD"`
+	CD         bool              `json:"CD"`
+	Question   []DNSJSONQuestion `json:"Question"`
+	Answer     []DNSJSONRecord   `json:"Answer,omitempty"`
+	Authority  []DNSJSONRecord   `json:"Authority,omitempty"`
+	Additional []DNSJSONRecord   `json:"Additional,omitempty"`
+}
+
+// DNSJSONQuestion - Question of a DNSJSON message, with the
+// numeric type
+type DNSJSONQuestion struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+}
+
+// DNSJSONRecord - Record of a DNSJSON message, with the numeric type
+// and the RDATA in presentation format
+type DNSJSONRecord struct {
+	Name string `json:"name"`
+	Type int    `json:"type"`
+	TTL  int32  `json:"TTL"`
+	Data string `json:"data"`
+}
+
+// NewDNSJSON - Generate the DNSJSON format of a message. The names are
+// fully qualified and the OPT record is left out
+func NewDNSJSON(message DNSMessage) DNSJSON {
+	result := DNSJSON{
+		Status:   message.Header.RCode.Code,
+		TC:       message.Header.TruncatedMessage,
+		RD:       message.Header.RecursionDesired,
+		RA:       message.Header.RecursionAvailable,
+		AD:       message.Header.AD,
+		CD:       message.Header.CD,
+		Question: []DNSJSONQuestion{},
+	}
+
+	for _, question := range message.Questions {
+		result.Question = append(result.Question, DNSJSONQuestion{Name: fqdn(question.Name), Type: question.Type.Code})
+	}
+
+	result.Answer = jsonRecords(message.Answers)
+	result.Authority = jsonRecords(message.Authority)
+	result.Additional = jsonRecords(message.Additional)
+
+	return result
+}
+
+// jsonRecords - DNSJSON format of a section, nil when it's empty
+func jsonRecords(resources []DNSResource) []DNSJSONRecord {
+	var records []DNSJSONRecord
+
+	for _, resource := range resources {
+		if resource.Type.Code == OPT.Code {
+			continue
+		}
+
+		records = append(records, DNSJSONRecord{
+			Name: fqdn(resource.Name),
+			Type: resource.Type.Code,
+			TTL:  resource.TTL,
+			Data: resource.RData,
+		})
+	}
+
+	return records
+}
+
+// fqdn - Name with the trailing dot
+func fqdn(name string) string {
+	if len(name) == 0 || name[len(name)-1] != '.' {
+		return name + "."
+	}
+
+	return name
+}
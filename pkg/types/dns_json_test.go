@@ -0,0 +1,64 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package types define all the tests for the types package
+package types_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+func TestNewDNSJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		message types.DNSMessage
+		want    types.DNSJSON
+	}{
+		{
+			name: "Answer",
+			message: types.DNSMessage{
+				Header:    types.DNSHeader{QR: true, RecursionDesired: true, AD: true, RCode: types.NoError},
+				Questions: []types.DNSQuestion{{Name: "www.test.com", Type: types.A, Class: types.IN}},
+				Answers: []types.DNSResource{
+					{Name: "www.test.com", Type: types.CNAME, Class: types.IN, TTL: 3600, RData: "one.test.com"},
+					{Name: "one.test.com", Type: types.A, Class: types.IN, TTL: 600, RData: "192.168.14.7"},
+				},
+				Additional: []types.DNSResource{{Type: types.OPT, Class: types.QClass{Code: 1232}}},
+			},
+			want: types.DNSJSON{
+				RD:       true,
+				AD:       true,
+				Question: []types.DNSJSONQuestion{{Name: "www.test.com.", Type: 1}},
+				Answer: []types.DNSJSONRecord{
+					{Name: "www.test.com.", Type: 5, TTL: 3600, Data: "one.test.com"},
+					{Name: "one.test.com.", Type: 1, TTL: 600, Data: "192.168.14.7"},
+				},
+			},
+		},
+		{
+			name: "Name error",
+			message: types.DNSMessage{
+				Header:    types.DNSHeader{QR: true, RCode: types.NXDomain},
+				Questions: []types.DNSQuestion{{Name: "nx.test.com", Type: types.AAAA, Class: types.IN}},
+				Authority: []types.DNSResource{{Name: "test.com", Type: types.SOA, Class: types.IN, TTL: 300, RData: "ns.test.com hostmaster.test.com 1 3600 600 604800 300"}},
+			},
+			want: types.DNSJSON{
+				Status:    3,
+				Question:  []types.DNSJSONQuestion{{Name: "nx.test.com.", Type: 28}},
+				Authority: []types.DNSJSONRecord{{Name: "test.com.", Type: 6, TTL: 300, Data: "ns.test.com hostmaster.test.com 1 3600 600 604800 300"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, types.NewDNSJSON(tt.message)); diff != "" {
+				t.Errorf("NewDNSJSON() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
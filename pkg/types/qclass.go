@@ -26,16 +26,24 @@ var (
 	CS QClass = QClass{Name: "CS", Code: 2}
 	CH QClass = QClass{Name: "CH", Code: 3}
 	HS QClass = QClass{Name: "HS", Code: 4}
+	// NONE and ANY are used in the dynamic updates (RFC 2136 - Section 2.4)
+	NONE QClass = QClass{Name: "NONE", Code: 254}
+	ANY  QClass = QClass{Name: "ANY", Code: 255}
 )
 
 // UnmarshalYAML - Function to Unmarshal to YAML
 func (qclass *QClass) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	err := unmarshal(&qclass.Name)
+	var name string
+	err := unmarshal(&name)
 
 	if err != nil {
 		log.Printf("Error when unmarshal YAML QClass")
+
+		return err
 	}
 
+	*qclass, err = QClassFromString(name)
+
 	return err
 }
 
@@ -45,14 +53,16 @@ func (qclass QClass) MarshalYAML() (interface{}, error) {
 }
 
 // UnmarshalJSON - Function to Unmarshal to JSON
-func (qclass QClass) UnmarshalJSON(bytes []byte) error {
+func (qclass *QClass) UnmarshalJSON(bytes []byte) error {
 	qtypeName, err := strconv.Unquote(string(bytes))
 
 	if err != nil {
 		log.Printf("Error when unmarshal JSON QClass: %s", err)
+
+		return err
 	}
 
-	qclass, err = QClassFromString(qtypeName)
+	*qclass, err = QClassFromString(qtypeName)
 
 	return err
 }
@@ -69,7 +79,7 @@ func (qCLass QClass) String() string {
 }
 
 // QClassFromCode - Generate an QClass struct from a numeric code
-// choose one between 1 and 4
+// choose one between 1 and 4, 254 or 255
 func QClassFromCode(code int) (QClass, error) {
 	switch code {
 	case IN.Code:
@@ -80,13 +90,17 @@ func QClassFromCode(code int) (QClass, error) {
 		return CH, nil
 	case HS.Code:
 		return HS, nil
+	case NONE.Code:
+		return NONE, nil
+	case ANY.Code:
+		return ANY, nil
 	}
 
-	return QClass{}, fmt.Errorf("Code %d not available, choose one between 1 and 4", code)
+	return QClass{}, fmt.Errorf("Code %d not available, choose one between 1 and 4, 254 or 255", code)
 }
 
 // QClassFromString - Generate an OpCode struct form a Name
-// choose one of "IN", "CS", "CH" or "HS"
+// choose one of "IN", "CS", "CH", "HS", "NONE" or "ANY"
 func QClassFromString(name string) (QClass, error) {
 	switch strings.ToUpper(name) {
 	case IN.Name:
@@ -97,7 +111,26 @@ func QClassFromString(name string) (QClass, error) {
 		return CH, nil
 	case HS.Name:
 		return HS, nil
+	case NONE.Name:
+		return NONE, nil
+	case ANY.Name:
+		return ANY, nil
+	}
+
+	// RFC 3597 - Section 5 generic class names (CLASS32, CLASS4096, ...)
+	if strings.HasPrefix(strings.ToUpper(name), "CLASS") {
+		code, err := strconv.Atoi(name[len("CLASS"):])
+
+		if err == nil && code >= 0 && code <= 65535 {
+			qclass, err := QClassFromCode(code)
+
+			if err != nil {
+				return QClass{Name: strings.ToUpper(name), Code: code}, nil
+			}
+
+			return qclass, nil
+		}
 	}
 
-	return QClass{}, fmt.Errorf("Name %s not available, choose one of \"IN\", \"CS\", \"CH\", \"HS\"", name)
+	return QClass{}, fmt.Errorf("Name %s not available, choose one of \"IN\", \"CS\", \"CH\", \"HS\", \"NONE\", \"ANY\"", name)
 }
@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -76,12 +77,17 @@ var (
 
 // UnmarshalYAML - Function to Unmarshal to YAML
 func (qtype *QType) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	err := unmarshal(&qtype.Name)
+	var name string
+	err := unmarshal(&name)
 
 	if err != nil {
 		log.Printf("Error when unmarshal YAML QType")
+
+		return err
 	}
 
+	*qtype, err = QTypeFromString(name)
+
 	return err
 }
 
@@ -91,14 +97,16 @@ func (qtype QType) MarshalYAML() (interface{}, error) {
 }
 
 // UnmarshalJSON - Function to Unmarshal to JSON
-func (qtype QType) UnmarshalJSON(bytes []byte) error {
+func (qtype *QType) UnmarshalJSON(bytes []byte) error {
 	qtypeName, err := strconv.Unquote(string(bytes))
 
 	if err != nil {
 		log.Printf("Error when unmarshal JSON QType: %s", err)
+
+		return err
 	}
 
-	qtype, err = QTypeFromString(qtypeName)
+	*qtype, err = QTypeFromString(qtypeName)
 
 	return err
 }
@@ -333,5 +341,20 @@ func QTypeFromString(name string) (QType, error) {
 		return MAILA, nil
 	}
 
+	// RFC 3597 - Section 5 generic type names (TYPE65, TYPE256, ...)
+	if strings.HasPrefix(name, "TYPE") {
+		code, err := strconv.Atoi(strings.TrimPrefix(name, "TYPE"))
+
+		if err == nil && code >= 0 && code <= 65535 {
+			qtype, err := QTypeFromCode(code)
+
+			if err != nil {
+				return QType{Name: name, Code: code}, nil
+			}
+
+			return qtype, nil
+		}
+	}
+
 	return QType{}, fmt.Errorf("Name %s not available, choose one of \"A\", \"AAAA\", \"AFSDB\", \"APL\", \"CAA\", \"CDNSKEY\", \"CDS\", \"CERT\", \"CNAME\", \"DHCID\", \"DLV\", \"DNSKEY\", \"DS\", \"IPSECKEY\", \"KEY\", \"KX\", \"LOC\", \"MD\", \"MF\", \"MB\", \"MG\", \"MR\", \"MX\", \"NAPTR\", \"NS\", \"NSEC\", \"NSEC3\", \"NSEC3PARAM\", \"NULL\", \"PTR\", \"RRSIG\", \"RP\", \"SIG\", \"SOA\", \"SRV\", \"SSHFP\", \"TA\", \"TKEY\", \"TLSA\", \"TSIG\", \"TXT\", \"DNAME\", \"WKS\", \"HINFO\", \"MINFO\", \"QTYPE_ALL\", \"AXFR\", \"IXFR\", \"OPT\", \"MAILB\", \"MAILA\", ", name)
 }
@@ -0,0 +1,391 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zone define the zones served with authority
+// by the DNS server
+package zone
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/dnssec"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+const (
+	// signatureValidity - Time the signatures are valid since they are made
+	signatureValidity = 14 * 24 * time.Hour
+	// signatureRefresh - Validity left when a cached signature is replaced
+	signatureRefresh = 7 * 24 * time.Hour
+	// signatureSkew - Time the inception of the signatures is set in
+	// the past, for the validators with delayed clocks
+	signatureSkew = time.Hour
+)
+
+// Signing - Key and parameters used to sign the answers of a zone
+type Signing struct {
+	Key *dnssec.Key
+	// NSEC3 - Deny the existence with NSEC3 (RFC 5155) instead of NSEC
+	NSEC3      bool
+	Iterations uint16
+	Salt       []byte
+}
+
+// link - Record of the NSEC or NSEC3 chain, with the key used to
+// sort it: the reversed labels of the owner for NSEC and the hash of
+// the owner for NSEC3
+type link struct {
+	key    string
+	record types.DNSResource
+}
+
+// signature - Cached RRSIG of a RRset
+type signature struct {
+	record     types.DNSResource
+	expiration time.Time
+}
+
+// SetSigning - Sign the answers of the zone with the key, or stop
+// signing them when signing is nil
+func (zone *Zone) SetSigning(signing *Signing) {
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+
+	zone.signing = signing
+	zone.buildChain()
+}
+
+// Signing - Key and parameters used to sign the zone, nil when
+// the zone isn't signed
+func (zone *Zone) Signing() *Signing {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.signing
+}
+
+// saltString - Presentation format of the NSEC3 salt
+func (signing *Signing) saltString() string {
+	if len(signing.Salt) == 0 {
+		return "-"
+	}
+
+	return strings.ToUpper(hex.EncodeToString(signing.Salt))
+}
+
+// absolute - Name in the presentation format of the RDATA
+func absolute(name string) string {
+	if name == "" {
+		return "."
+	}
+
+	return name
+}
+
+// wildcardName - Wildcard of the names below an encloser
+func wildcardName(encloser string) string {
+	return strings.TrimSuffix("*."+encloser, ".")
+}
+
+// apex - Records of the apex generated by the server: the SOA, and
+// the DNSKEY and NSEC3PARAM of a signed zone
+func (zone *Zone) apex(qtype types.QType) []types.DNSResource {
+	records := []types.DNSResource{zone.soaRecord(zone.soa)}
+
+	if zone.signing != nil {
+		records = append(records, types.DNSResource{Name: zone.Name, Type: types.DNSKEY, Class: types.IN, TTL: zone.soaTTL, RData: zone.signing.Key.DNSKEY()})
+
+		if zone.signing.NSEC3 {
+			rdata := fmt.Sprintf("1 0 %d %s", zone.signing.Iterations, zone.signing.saltString())
+			records = append(records, types.DNSResource{Name: zone.Name, Type: types.NSEC3PARAM, Class: types.IN, TTL: zone.soaTTL, RData: rdata})
+		}
+	}
+
+	matches := []types.DNSResource{}
+
+	for _, record := range records {
+		if qtype.Code == types.QTYPEALL.Code || record.Type.Code == qtype.Code {
+			matches = append(matches, record)
+		}
+	}
+
+	return matches
+}
+
+// typesAt - Types of the records owned by name, with the RRSIG
+// covering them, for the bitmaps of the NSEC and NSEC3 records
+func (zone *Zone) typesAt(name string) []int {
+	codes := []int{}
+
+	if name == zone.Name {
+		for _, record := range zone.apex(types.QTYPEALL) {
+			codes = append(codes, record.Type.Code)
+		}
+	}
+
+	for _, record := range zone.names[name] {
+		codes = append(codes, record.Type.Code)
+	}
+
+	if len(codes) > 0 {
+		codes = append(codes, types.RRSIG.Code)
+	}
+
+	return codes
+}
+
+// buildChain - Generate the NSEC (RFC 4034 - Section 4) or NSEC3
+// (RFC 5155 - Section 7.1) chain of the current version of a signed
+// zone, dropping the cached signatures
+func (zone *Zone) buildChain() {
+	zone.chain = nil
+	zone.signatures = map[string]signature{}
+
+	if zone.signing == nil {
+		return
+	}
+
+	ttl := zone.negativeSOA().TTL
+
+	for name, records := range zone.names {
+		switch {
+		case zone.signing.NSEC3:
+			hash := dnssec.HashName(name, zone.signing.Salt, zone.signing.Iterations)
+			owner := strings.TrimSuffix(hash+"."+zone.Name, ".")
+			zone.chain = append(zone.chain, link{key: hash, record: types.DNSResource{Name: owner, Type: types.NSEC3, Class: types.IN, TTL: ttl}})
+		case name == zone.Name || len(records) > 0:
+			// The empty non-terminals don't have NSEC records
+			zone.chain = append(zone.chain, link{key: reverseLabels(name), record: types.DNSResource{Name: name, Type: types.NSEC, Class: types.IN, TTL: ttl}})
+		}
+	}
+
+	sort.Slice(zone.chain, func(i, j int) bool {
+		return zone.chain[i].key < zone.chain[j].key
+	})
+
+	names := map[string]string{}
+
+	if zone.signing.NSEC3 {
+		for name := range zone.names {
+			names[dnssec.HashName(name, zone.signing.Salt, zone.signing.Iterations)] = name
+		}
+	}
+
+	for i := range zone.chain {
+		current := &zone.chain[i].record
+		next := zone.chain[(i+1)%len(zone.chain)]
+
+		if zone.signing.NSEC3 {
+			bitmap := parser.TypeBitmap(zone.typesAt(names[zone.chain[i].key]))
+			current.RData = strings.TrimSpace(fmt.Sprintf("1 0 %d %s %s %s", zone.signing.Iterations, zone.signing.saltString(), next.key, bitmap))
+		} else {
+			bitmap := parser.TypeBitmap(append(zone.typesAt(current.Name), types.NSEC.Code))
+			current.RData = absolute(next.record.Name) + " " + bitmap
+		}
+	}
+}
+
+// chainRecord - NSEC or NSEC3 record matching or covering name
+func (zone *Zone) chainRecord(name string) types.DNSResource {
+	key := reverseLabels(name)
+
+	if zone.signing.NSEC3 {
+		key = dnssec.HashName(name, zone.signing.Salt, zone.signing.Iterations)
+	}
+
+	index := sort.Search(len(zone.chain), func(i int) bool {
+		return zone.chain[i].key > key
+	})
+
+	// The last record of the chain covers the names before the first one
+	if index == 0 {
+		index = len(zone.chain)
+	}
+
+	return zone.chain[index-1].record
+}
+
+// closestEncloser - Closest existing ancestor of a name that doesn't
+// exist in the zone, and the next closer name below it
+// (RFC 5155 - Section 1.3)
+func (zone *Zone) closestEncloser(name string) (string, string) {
+	next := name
+
+	for candidate := name; InZone(candidate, zone.Name); candidate = ParentName(candidate) {
+		if _, ok := zone.names[candidate]; ok {
+			return candidate, next
+		}
+
+		next = candidate
+	}
+
+	return zone.Name, next
+}
+
+// denial - Records proving that name doesn't exist or doesn't have
+// the type asked (RFC 4035 - Section 3.1.3 and RFC 5155 - Section 7.2).
+// A name that doesn't exist is proven with its closest encloser and
+// the absence of the wildcard, or of the type asked in the wildcard
+func (zone *Zone) denial(name string) []types.DNSResource {
+	if _, exists := zone.names[name]; exists {
+		return []types.DNSResource{zone.chainRecord(name)}
+	}
+
+	encloser, next := zone.closestEncloser(name)
+
+	if zone.signing.NSEC3 {
+		return []types.DNSResource{zone.chainRecord(encloser), zone.chainRecord(next), zone.chainRecord(wildcardName(encloser))}
+	}
+
+	return []types.DNSResource{zone.chainRecord(name), zone.chainRecord(wildcardName(encloser))}
+}
+
+// wildcardDenial - Records proving that name doesn't exist, for an
+// answer synthesized from a wildcard (RFC 4035 - Section 3.1.3.3
+// and RFC 5155 - Section 7.2.6)
+func (zone *Zone) wildcardDenial(name string) []types.DNSResource {
+	if zone.signing.NSEC3 {
+		_, next := zone.closestEncloser(name)
+
+		return []types.DNSResource{zone.chainRecord(next)}
+	}
+
+	return []types.DNSResource{zone.chainRecord(name)}
+}
+
+// source - Owner of the records of name in the zone, the wildcard
+// for the names synthesized from it
+func (zone *Zone) source(name string) string {
+	if _, exists := zone.names[name]; exists || !InZone(name, zone.Name) {
+		return name
+	}
+
+	encloser, _ := zone.closestEncloser(name)
+
+	return wildcardName(encloser)
+}
+
+// groupRRsets - Split records in RRsets, in the order they appear
+func groupRRsets(records []types.DNSResource) [][]types.DNSResource {
+	rrsets := [][]types.DNSResource{}
+	index := map[string]int{}
+
+	for _, record := range records {
+		key := CanonicalName(record.Name) + "|" + record.Type.Name
+
+		if i, ok := index[key]; ok {
+			rrsets[i] = append(rrsets[i], record)
+			continue
+		}
+
+		index[key] = len(rrsets)
+		rrsets = append(rrsets, []types.DNSResource{record})
+	}
+
+	return rrsets
+}
+
+// sign - RRSIG of a RRset of the zone, signed as owned by owner.
+// The signatures are cached until half of their validity
+func (zone *Zone) sign(rrset []types.DNSResource, owner string) []types.DNSResource {
+	records := make([]types.DNSResource, len(rrset))
+	rdatas := make([]string, len(rrset))
+
+	for i, record := range rrset {
+		record.Name = owner
+		records[i] = record
+		rdatas[i] = record.RData
+	}
+
+	sort.Strings(rdatas)
+	key := fmt.Sprintf("%s|%d|%d|%s", owner, rrset[0].Type.Code, rrset[0].TTL, strings.Join(rdatas, "|"))
+	now := time.Now()
+
+	zone.signaturesMutex.Lock()
+	cached, ok := zone.signatures[key]
+	zone.signaturesMutex.Unlock()
+
+	if !ok || cached.expiration.Sub(now) < signatureRefresh {
+		expiration := now.Add(signatureValidity)
+		rrsig, err := dnssec.Sign(records, zone.signing.Key, zone.Name, now.Add(-signatureSkew), expiration)
+
+		if err != nil {
+			log.Errorf("Error signing %s %s of zone %s: %s", owner, rrset[0].Type, zone.Name, err)
+
+			return nil
+		}
+
+		cached = signature{record: rrsig, expiration: expiration}
+
+		zone.signaturesMutex.Lock()
+		zone.signatures[key] = cached
+		zone.signaturesMutex.Unlock()
+	}
+
+	rrsig := cached.record
+	rrsig.Name = rrset[0].Name
+
+	return []types.DNSResource{rrsig}
+}
+
+// SignAnswer - Add to the answer of a lookup of name the RRSIG of
+// its RRsets and the proofs of the names and types that doesn't
+// exist (RFC 4035 - Section 3.1). The answer is returned unchanged
+// when the zone isn't signed
+func (zone *Zone) SignAnswer(name string, qtype types.QType, answer Answer) Answer {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	if zone.signing == nil || len(zone.chain) == 0 {
+		return answer
+	}
+
+	signed := Answer{RCode: answer.RCode, Answers: []types.DNSResource{}, Authority: []types.DNSResource{}}
+	proofs := []types.DNSResource{}
+	name = CanonicalName(name)
+
+	for _, rrset := range groupRRsets(answer.Answers) {
+		owner := CanonicalName(rrset[0].Name)
+		source := zone.source(owner)
+
+		signed.Answers = append(signed.Answers, rrset...)
+		signed.Answers = append(signed.Answers, zone.sign(rrset, source)...)
+
+		if source != owner {
+			proofs = append(proofs, zone.wildcardDenial(owner)...)
+		}
+
+		// The lookup follows the aliases of the zone
+		if rrset[0].Type.Code == types.CNAME.Code && owner == name && qtype.Code != types.CNAME.Code {
+			name = CanonicalName(rrset[0].RData)
+		}
+	}
+
+	if len(answer.Authority) > 0 {
+		signed.Authority = append(signed.Authority, answer.Authority...)
+		signed.Authority = append(signed.Authority, zone.sign(answer.Authority, zone.Name)...)
+		proofs = append(proofs, zone.denial(name)...)
+	}
+
+	seen := map[string]bool{}
+
+	for _, proof := range proofs {
+		if seen[proof.Name] {
+			continue
+		}
+
+		seen[proof.Name] = true
+		signed.Authority = append(signed.Authority, proof)
+		signed.Authority = append(signed.Authority, zone.sign([]types.DNSResource{proof}, proof.Name)...)
+	}
+
+	return signed
+}
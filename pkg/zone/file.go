@@ -0,0 +1,206 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zone define the zones served by the server
+package zone
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// classes - Classes accepted in the records of the zone files
+var classes = map[string]bool{"IN": true, "CH": true, "HS": true, "CS": true}
+
+// ParseFile - Read the records of a zone file in master file format
+// (RFC 1035 - Section 5), with the $ORIGIN and $TTL directives. The
+// names of the records are absolute, with their trailing dot
+func ParseFile(reader io.Reader, origin string) ([]config.Record, error) {
+	origin = CanonicalName(origin)
+	records := []config.Record{}
+	scanner := bufio.NewScanner(reader)
+	ttl, owner := 0, ""
+	line := 0
+	hasOwner := false
+
+	for {
+		fields, start, blank, err := nextEntry(scanner, &line)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if fields == nil {
+			return records, scanner.Err()
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "$ORIGIN":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: $ORIGIN without name", start)
+			}
+
+			origin = AbsoluteName(fields[1], origin)
+
+			continue
+		case "$TTL":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: $TTL without value", start)
+			}
+
+			if ttl, err = strconv.Atoi(fields[1]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid $TTL %s", start, fields[1])
+			}
+
+			continue
+		case "$INCLUDE", "$GENERATE":
+			return nil, fmt.Errorf("line %d: %s isn't supported", start, fields[0])
+		}
+
+		// The records starting with a blank have the owner of the
+		// previous one
+		if !blank {
+			owner, hasOwner = AbsoluteName(fields[0], origin), true
+			fields = fields[1:]
+		}
+
+		if !hasOwner {
+			return nil, fmt.Errorf("line %d: record without owner", start)
+		}
+
+		record := config.Record{Name: owner + ".", TTL: ttl}
+
+		for len(fields) > 0 {
+			if value, err := strconv.Atoi(fields[0]); err == nil {
+				record.TTL = value
+			} else if !classes[strings.ToUpper(fields[0])] {
+				break
+			}
+
+			fields = fields[1:]
+		}
+
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: record %s without type", start, owner)
+		}
+
+		record.Type, err = types.QTypeFromString(strings.ToUpper(fields[0]))
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", start, err)
+		}
+
+		record.Value = strings.Join(fields[1:], " ")
+		records = append(records, record)
+	}
+}
+
+// nextEntry - Fields of the next entry of a zone file, joining the
+// lines inside parentheses and without comments, with its first line
+// and whether it starts with a blank. The fields are nil at the end of
+// the file
+func nextEntry(scanner *bufio.Scanner, line *int) ([]string, int, bool, error) {
+	fields := []string{}
+	start, blank, depth := 0, false, 0
+
+	for scanner.Scan() {
+		*line++
+		text := scanner.Text()
+
+		if start == 0 {
+			start = *line
+			blank = text != "" && (text[0] == ' ' || text[0] == '\t')
+		}
+
+		lineFields, err := splitFields(text, &depth)
+
+		if err != nil {
+			return nil, 0, false, fmt.Errorf("line %d: %s", *line, err)
+		}
+
+		fields = append(fields, lineFields...)
+
+		if depth == 0 {
+			return fields, start, blank, nil
+		}
+	}
+
+	if depth > 0 {
+		return nil, 0, false, fmt.Errorf("line %d: unbalanced parentheses", start)
+	}
+
+	return nil, 0, false, nil
+}
+
+// splitFields - Fields of a line of a zone file, keeping the quoted
+// strings with their quotes and skipping the comments. The parentheses
+// change the depth of the entry
+func splitFields(text string, depth *int) ([]string, error) {
+	fields := []string{}
+	field := strings.Builder{}
+	quoted := false
+
+	flush := func() {
+		if field.Len() > 0 {
+			fields = append(fields, field.String())
+			field.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		switch {
+		case quoted:
+			field.WriteByte(c)
+
+			if c == '\\' && i+1 < len(text) {
+				i++
+				field.WriteByte(text[i])
+			} else if c == '"' {
+				quoted = false
+			}
+		case c == '"':
+			quoted = true
+			field.WriteByte(c)
+		case c == ';':
+			flush()
+
+			return fields, nil
+		case c == '(':
+			flush()
+			*depth++
+		case c == ')':
+			flush()
+
+			if *depth == 0 {
+				return nil, fmt.Errorf("unbalanced parentheses")
+			}
+
+			*depth--
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			field.WriteByte(c)
+		}
+	}
+
+	if quoted {
+		return nil, fmt.Errorf("unterminated quoted string")
+	}
+
+	flush()
+
+	return fields, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zone define the zones served with authority
+// by the DNS server
+package zone
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// MaxJournalChanges - Number of changes kept by the journal of a zone
+const MaxJournalChanges = 100
+
+// Change - Record-level difference between two consecutive
+// versions of a zone, as sent in the IXFR responses (RFC 1995)
+type Change struct {
+	From    SOA                 `json:"from"`
+	To      SOA                 `json:"to"`
+	Deleted []types.DNSResource `json:"deleted"`
+	Added   []types.DNSResource `json:"added"`
+}
+
+// Journal - History of the changes of a zone. When Path isn't empty
+// the changes are persisted as JSON lines
+type Journal struct {
+	Path    string
+	changes []Change
+	mutex   sync.Mutex
+}
+
+// OpenJournal - Load the journal stored in path, an empty path
+// generate a journal kept only in memory
+func OpenJournal(path string) (*Journal, error) {
+	journal := &Journal{Path: path}
+
+	if path == "" {
+		return journal, nil
+	}
+
+	file, err := os.Open(path)
+
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		var change Change
+
+		err = json.Unmarshal(scanner.Bytes(), &change)
+
+		if err != nil {
+			log.Errorf("Error reading journal %s, discarding it: %s", path, err)
+
+			return &Journal{Path: path}, nil
+		}
+
+		journal.changes = append(journal.changes, change)
+	}
+
+	return journal, scanner.Err()
+}
+
+// Append - Record a new change, dropping the oldest one when
+// the journal is full
+func (journal *Journal) Append(change Change) error {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	journal.changes = append(journal.changes, change)
+
+	if len(journal.changes) > MaxJournalChanges {
+		journal.changes = journal.changes[len(journal.changes)-MaxJournalChanges:]
+
+		return journal.write(journal.changes, os.O_TRUNC)
+	}
+
+	return journal.write([]Change{change}, os.O_APPEND)
+}
+
+func (journal *Journal) write(changes []Change, mode int) error {
+	if journal.Path == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(journal.Path, os.O_CREATE|os.O_WRONLY|mode, 0644)
+
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+
+	for _, change := range changes {
+		err = encoder.Encode(change)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Since - Changes needed to go from serial to the last version,
+// false when the history isn't available
+func (journal *Journal) Since(serial uint32) ([]Change, bool) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	for i, change := range journal.changes {
+		if change.From.Serial != serial {
+			continue
+		}
+
+		changes := journal.changes[i:]
+
+		for j := 1; j < len(changes); j++ {
+			if changes[j].From.Serial != changes[j-1].To.Serial {
+				return nil, false
+			}
+		}
+
+		return append([]Change{}, changes...), true
+	}
+
+	return nil, false
+}
+
+// Last - Serial of the last version recorded in the journal
+func (journal *Journal) Last() (uint32, bool) {
+	journal.mutex.Lock()
+	defer journal.mutex.Unlock()
+
+	if len(journal.changes) == 0 {
+		return 0, false
+	}
+
+	return journal.changes[len(journal.changes)-1].To.Serial, true
+}
@@ -0,0 +1,115 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zone define the zones served with authority
+// by the DNS server
+package zone
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SOA - Start of authority of a zone
+// SOA RDATA format from RFC 1035 - Section 3.3.13
+//
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	/                     MNAME                     /
+//	/                                               /
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	/                     RNAME                     /
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                    SERIAL                     |
+//	|                                               |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                    REFRESH                    |
+//	|                                               |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                     RETRY                     |
+//	|                                               |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                    EXPIRE                     |
+//	|                                               |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+//	|                    MINIMUM                    |
+//	|                                               |
+//	+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+--+
+type SOA struct {
+	MName   string `json:"mname"`
+	RName   string `json:"rname"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minimum uint32 `json:"minimum"`
+}
+
+// DefaultSOA - SOA used by the zones without a SOA record
+func DefaultSOA(zone string) SOA {
+	return SOA{
+		MName:   strings.TrimPrefix("ns."+zone, "."),
+		RName:   strings.TrimPrefix("hostmaster."+zone, "."),
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  604800,
+		Minimum: 300,
+	}
+}
+
+// ParseSOA - Generate a SOA struct from the presentation format
+// of the RDATA
+func ParseSOA(rdata string) (soa SOA, err error) {
+	fields := strings.Fields(rdata)
+
+	if len(fields) != 7 {
+		return soa, fmt.Errorf("SOA %q has %d fields, expected 7", rdata, len(fields))
+	}
+
+	numbers := make([]uint32, 5)
+
+	for i, field := range fields[2:] {
+		number, err := strconv.ParseUint(field, 10, 32)
+
+		if err != nil {
+			return soa, fmt.Errorf("invalid SOA field %q", field)
+		}
+
+		numbers[i] = uint32(number)
+	}
+
+	return SOA{
+		MName:   strings.TrimSuffix(fields[0], "."),
+		RName:   strings.TrimSuffix(fields[1], "."),
+		Serial:  numbers[0],
+		Refresh: numbers[1],
+		Retry:   numbers[2],
+		Expire:  numbers[3],
+		Minimum: numbers[4],
+	}, nil
+}
+
+// String - Presentation format of the SOA RDATA
+func (soa SOA) String() string {
+	return fmt.Sprintf("%s %s %d %d %d %d %d", soa.MName, soa.RName, soa.Serial, soa.Refresh, soa.Retry, soa.Expire, soa.Minimum)
+}
+
+// SerialLess - Compare two serials following the
+// RFC 1982 - Serial Number Arithmetic
+func SerialLess(a, b uint32) bool {
+	return a != b && b-a < 1<<31
+}
+
+// nextSerial - Serial of the next version of a zone, honouring the
+// serial of the configuration when it is ahead of the current one
+func nextSerial(current, configured uint32) uint32 {
+	next := current + 1
+
+	if SerialLess(next, configured) {
+		return configured
+	}
+
+	return next
+}
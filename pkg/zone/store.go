@@ -0,0 +1,501 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zone define the zones served with authority
+// by the DNS server
+package zone
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/dnssec"
+	"github.com/lucasdc6/gdns/pkg/parser"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// Store - Set of zones served by the server
+type Store struct {
+	// Directory - Path where the snapshots and journals of the zones
+	// are kept. An empty directory keep the zones only in memory
+	Directory string
+	// OnChange - Called every time a new version of a zone is generated
+	OnChange func(zone *Zone)
+
+	zones map[string]*Zone
+	mutex sync.RWMutex
+}
+
+// snapshot - Last version of a zone stored in the directory
+type snapshot struct {
+	Name      string              `json:"name"`
+	SOA       SOA                 `json:"soa"`
+	SOATTL    int32               `json:"soa_ttl"`
+	Records   []types.DNSResource `json:"records"`
+	Refreshed time.Time           `json:"refreshed,omitempty"`
+}
+
+// NewStore - Generate an empty store
+func NewStore(directory string) *Store {
+	return &Store{
+		Directory: directory,
+		zones:     map[string]*Zone{},
+	}
+}
+
+// fileName - Path of a file of the zone kept in the directory
+func (store *Store) fileName(name, extension string) string {
+	if store.Directory == "" {
+		return ""
+	}
+
+	if name == "" {
+		name = "root"
+	}
+
+	return filepath.Join(store.Directory, name+extension)
+}
+
+// Get - Zone with origin name
+func (store *Store) Get(name string) *Zone {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return store.zones[CanonicalName(name)]
+}
+
+// Find - Closest zone enclosing name
+func (store *Store) Find(name string) *Zone {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	name = CanonicalName(name)
+
+	for {
+		if zone, ok := store.zones[name]; ok {
+			return zone
+		}
+
+		if name == "" {
+			return nil
+		}
+
+		name = ParentName(name)
+	}
+}
+
+// Zones - All the zones of the store
+func (store *Store) Zones() []*Zone {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	zones := make([]*Zone, 0, len(store.zones))
+	for _, zone := range store.zones {
+		zones = append(zones, zone)
+	}
+
+	return zones
+}
+
+// Load - Create or update the zones from the configuration. The zones
+// absent from the configuration are removed
+func (store *Store) Load(zones []config.Zone) error {
+	loaded := map[string]bool{}
+
+	if store.Directory != "" {
+		err := os.MkdirAll(store.Directory, 0755)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, zoneConfig := range zones {
+		name := CanonicalName(zoneConfig.Name)
+
+		if zoneConfig.Type == config.Secondary {
+			zone, err := store.openSecondary(name, zoneConfig.Primaries)
+
+			if err != nil {
+				return fmt.Errorf("zone %s: %s", zoneConfig.Name, err)
+			}
+
+			zone.setPolicy(zoneConfig)
+
+			if err := store.setSigning(zone, zoneConfig.DNSSEC); err != nil {
+				return fmt.Errorf("zone %s: %s", zoneConfig.Name, err)
+			}
+
+			if len(zoneConfig.Records) > 0 {
+				log.Warnf("Ignoring the records of the secondary zone %s", name)
+			}
+
+			loaded[name] = true
+			continue
+		}
+
+		if zoneConfig.Type != "" && zoneConfig.Type != config.Primary {
+			return fmt.Errorf("zone %s: unknown type %q", zoneConfig.Name, zoneConfig.Type)
+		}
+
+		soa, soaTTL, records, err := FromConfig(zoneConfig)
+
+		if err != nil {
+			return fmt.Errorf("zone %s: %s", zoneConfig.Name, err)
+		}
+
+		loaded[name] = true
+		zone, restored, err := store.open(name, soa, soaTTL, records)
+
+		if err != nil {
+			return fmt.Errorf("zone %s: %s", zoneConfig.Name, err)
+		}
+
+		zone.setPolicy(zoneConfig)
+
+		if err := store.setSigning(zone, zoneConfig.DNSSEC); err != nil {
+			return fmt.Errorf("zone %s: %s", zoneConfig.Name, err)
+		}
+
+		if !restored {
+			log.Infof("Zone %s loaded with serial %d", zone.Name, zone.Serial())
+			store.Changed(zone)
+			continue
+		}
+
+		changed, err := zone.Update(soa, soaTTL, records)
+
+		if err != nil {
+			return fmt.Errorf("zone %s: %s", zoneConfig.Name, err)
+		}
+
+		if changed {
+			log.Infof("Zone %s loaded with serial %d", zone.Name, zone.Serial())
+			store.Changed(zone)
+		}
+	}
+
+	store.mutex.Lock()
+	for name := range store.zones {
+		if !loaded[name] {
+			log.Infof("Zone %s removed", name)
+			delete(store.zones, name)
+		}
+	}
+	store.mutex.Unlock()
+
+	return nil
+}
+
+// setSigning - Sign a zone with the key kept in the directory,
+// generating it the first time. Without a directory the key is kept
+// only in memory, and a new one is generated on every start
+func (store *Store) setSigning(zone *Zone, dnssecConfig *config.DNSSEC) error {
+	if dnssecConfig == nil {
+		zone.SetSigning(nil)
+
+		return nil
+	}
+
+	algorithm, err := dnssec.AlgorithmFromString(dnssecConfig.Algorithm)
+
+	if err != nil {
+		return err
+	}
+
+	salt, err := hex.DecodeString(strings.TrimPrefix(dnssecConfig.Salt, "-"))
+
+	if err != nil || len(salt) > 255 {
+		return fmt.Errorf("invalid NSEC3 salt %q", dnssecConfig.Salt)
+	}
+
+	signing := &Signing{NSEC3: dnssecConfig.NSEC3, Iterations: dnssecConfig.Iterations, Salt: salt}
+	current := zone.Signing()
+	path := store.fileName(zone.Name, ".key")
+
+	switch {
+	case current != nil && current.Key.Algorithm == algorithm:
+		signing.Key = current.Key
+	case path == "":
+		log.Warnf("The DNSSEC key of zone %s is kept only in memory, set a directory to keep it between restarts", zone.Name)
+		signing.Key, err = dnssec.GenerateKey(algorithm)
+	default:
+		var generated bool
+		signing.Key, generated, err = dnssec.LoadKey(path, algorithm)
+
+		if generated {
+			log.Infof("DNSSEC key of zone %s generated in %s", zone.Name, path)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if current == nil || current.Key != signing.Key {
+		log.Infof("Zone %s signed with the key %d, DS record for the parent zone: %s. IN DS %s",
+			zone.Name, signing.Key.KeyTag(), zone.Name, signing.Key.DS(zone.Name))
+	}
+
+	zone.SetSigning(signing)
+
+	return nil
+}
+
+// open - Get a zone from the store or restore it from the directory.
+// New zones start from the given version, and false is returned
+func (store *Store) open(name string, soa SOA, soaTTL int32, records []types.DNSResource) (*Zone, bool, error) {
+	if zone := store.Get(name); zone != nil && !zone.Secondary {
+		return zone, true, nil
+	}
+
+	journal, err := OpenJournal(store.fileName(name, ".jnl"))
+
+	if err != nil {
+		return nil, false, err
+	}
+
+	zone := NewZone(name, soa, soaTTL, records, journal)
+	previous, restored := store.restore(name)
+
+	if restored {
+		zone = NewZone(name, previous.SOA, previous.SOATTL, previous.Records, journal)
+	}
+
+	store.mutex.Lock()
+	store.zones[name] = zone
+	store.mutex.Unlock()
+
+	return zone, restored, nil
+}
+
+// openSecondary - Get a secondary zone from the store, restoring the
+// last transferred version from the directory
+func (store *Store) openSecondary(name string, primaries []string) (*Zone, error) {
+	if len(primaries) == 0 {
+		return nil, fmt.Errorf("secondary zone without primaries")
+	}
+
+	if zone := store.Get(name); zone != nil && zone.Secondary {
+		zone.mutex.Lock()
+		zone.primaries = primaries
+		zone.mutex.Unlock()
+
+		return zone, nil
+	}
+
+	journal, err := OpenJournal(store.fileName(name, ".jnl"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	zone := NewSecondaryZone(name, primaries, journal)
+
+	if previous, ok := store.restore(name); ok {
+		zone.Transferred(previous.SOA, previous.SOATTL, previous.Records)
+		zone.refreshed = previous.Refreshed
+		log.Infof("Secondary zone %s restored with serial %d", name, zone.Serial())
+	}
+
+	store.mutex.Lock()
+	store.zones[name] = zone
+	store.mutex.Unlock()
+
+	return zone, nil
+}
+
+// restore - Read the snapshot of a zone from the directory
+func (store *Store) restore(name string) (previous snapshot, ok bool) {
+	path := store.fileName(name, ".json")
+
+	if path == "" {
+		return previous, false
+	}
+
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return previous, false
+	}
+
+	err = json.Unmarshal(data, &previous)
+
+	if err != nil {
+		log.Errorf("Error reading snapshot of zone %s, discarding it: %s", name, err)
+
+		return previous, false
+	}
+
+	return previous, true
+}
+
+// Changed - Persist the new version of a zone and notify it
+func (store *Store) Changed(zone *Zone) {
+	store.Save(zone)
+
+	if store.OnChange != nil {
+		store.OnChange(zone)
+	}
+}
+
+// Save - Persist the current version of a zone in the directory
+func (store *Store) Save(zone *Zone) {
+	path := store.fileName(zone.Name, ".json")
+
+	if path == "" {
+		return
+	}
+
+	zone.mutex.RLock()
+	if !zone.available {
+		zone.mutex.RUnlock()
+
+		return
+	}
+
+	data, err := json.Marshal(snapshot{
+		Name:      zone.Name,
+		SOA:       zone.soa,
+		SOATTL:    zone.soaTTL,
+		Records:   zone.records,
+		Refreshed: zone.refreshed,
+	})
+	zone.mutex.RUnlock()
+
+	if err == nil {
+		err = writeFile(path, data)
+	}
+
+	if err != nil {
+		log.Errorf("Error saving snapshot of zone %s: %s", zone.Name, err)
+	}
+}
+
+// writeFile - Replace the content of a file atomically
+func writeFile(path string, data []byte) error {
+	temporary := path + ".tmp"
+	err := ioutil.WriteFile(temporary, data, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path)
+}
+
+// AbsoluteName - Fully qualified name of a record of the zone origin.
+// The empty name and "@" refer to the origin
+func AbsoluteName(name, origin string) string {
+	origin = strings.TrimSuffix(origin, ".")
+
+	switch {
+	case name == "" || name == "@":
+		return origin
+	case strings.HasSuffix(name, "."):
+		return strings.TrimSuffix(name, ".")
+	case InZone(name, origin):
+		return name
+	case origin == "":
+		return name
+	}
+
+	return name + "." + origin
+}
+
+// normalizeRData - Validate the RDATA of a record and return it in the
+// same presentation format generated by the parser
+func normalizeRData(qtype types.QType, value string) (string, error) {
+	rdata, err := parser.BuildRData(qtype, value)
+
+	if err != nil {
+		return "", err
+	}
+
+	return parser.ParseRData(qtype, rdata)
+}
+
+// FromConfig - Generate the SOA and records of a zone from its
+// configuration
+func FromConfig(zoneConfig config.Zone) (soa SOA, soaTTL int32, records []types.DNSResource, err error) {
+	origin := CanonicalName(zoneConfig.Name)
+	soa = DefaultSOA(origin)
+	soaTTL = DefaultTTL
+	records = []types.DNSResource{}
+
+	for _, record := range zoneConfig.Records {
+		name := AbsoluteName(record.Name, origin)
+
+		if !InZone(name, origin) {
+			log.Warnf("Ignoring record %s %s out of zone %s", name, record.Type, origin)
+			continue
+		}
+
+		if record.Type.Code == 0 {
+			return soa, soaTTL, records, fmt.Errorf("record %s without type", name)
+		}
+
+		ttl := int32(record.TTL)
+		if ttl <= 0 {
+			ttl = DefaultTTL
+		}
+
+		if record.Type.Code == types.SOA.Code {
+			soa, err = ParseSOA(record.Value)
+
+			if err != nil {
+				return soa, soaTTL, records, err
+			}
+
+			soaTTL = ttl
+			continue
+		}
+
+		rdata, err := normalizeRData(record.Type, record.Value)
+
+		if err != nil {
+			return soa, soaTTL, records, fmt.Errorf("record %s %s: %s", name, record.Type, err)
+		}
+
+		records = append(records, types.DNSResource{
+			Name:  name,
+			Type:  record.Type,
+			Class: types.IN,
+			TTL:   ttl,
+			RData: rdata,
+		})
+	}
+
+	return soa, soaTTL, records, nil
+}
+
+// ToConfig - Replace the records of the configuration of a zone with
+// its current version
+func ToConfig(zone *Zone, zoneConfig config.Zone) config.Zone {
+	soa := zone.SOARecord()
+	records := []config.Record{{Name: soa.Name, Type: soa.Type, Value: soa.RData, TTL: int(soa.TTL)}}
+
+	for _, record := range zone.Records() {
+		records = append(records, config.Record{
+			Name:  record.Name,
+			Type:  record.Type,
+			Value: record.RData,
+			TTL:   int(record.TTL),
+		})
+	}
+
+	zoneConfig.Records = records
+
+	return zoneConfig
+}
@@ -0,0 +1,288 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zone define the zones served with authority
+// by the DNS server
+package zone
+
+import (
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// isMetaType - Types that can't be stored in a zone
+func isMetaType(qtype types.QType) bool {
+	switch qtype.Code {
+	case types.QTYPEALL.Code, types.AXFR.Code, types.IXFR.Code, types.MAILA.Code, types.MAILB.Code, types.OPT.Code, types.TSIG.Code, types.TKEY.Code:
+		return true
+	}
+
+	return false
+}
+
+// rrset - Records of name with type qtype, including the SOA of the
+// apex. A qtype ANY returns all the records of name
+func (zone *Zone) rrset(name string, qtype types.QType) []types.DNSResource {
+	rrset := []types.DNSResource{}
+	name = CanonicalName(name)
+
+	if name == zone.Name && (qtype.Code == types.SOA.Code || qtype.Code == types.QTYPEALL.Code) {
+		rrset = append(rrset, zone.soaRecord(zone.soa))
+	}
+
+	for _, record := range zone.names[name] {
+		if qtype.Code == types.QTYPEALL.Code || record.Type.Code == qtype.Code {
+			rrset = append(rrset, record)
+		}
+	}
+
+	return rrset
+}
+
+// ApplyUpdate - Check the prerequisites of a dynamic update and apply
+// its changes (RFC 2136 - Section 3.2 to 3.4), bumping the serial.
+// Return the rcode of the response and true when a new version
+// was generated
+func (zone *Zone) ApplyUpdate(prerequisites, updates []types.DNSResource) (types.RCode, bool, error) {
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+
+	if rcode := zone.checkPrerequisites(prerequisites); rcode != types.NoError {
+		return rcode, false, nil
+	}
+
+	if rcode := zone.prescan(updates); rcode != types.NoError {
+		return rcode, false, nil
+	}
+
+	soa, soaTTL := zone.soa, zone.soaTTL
+	records := append([]types.DNSResource{}, zone.records...)
+
+	for _, update := range updates {
+		switch update.Class.Code {
+		case types.ANY.Code:
+			records = zone.deleteRRset(records, update)
+		case types.NONE.Code:
+			records = zone.deleteRecord(records, update)
+		default:
+			if update.Type.Code != types.SOA.Code {
+				records = zone.addRecord(records, update)
+				break
+			}
+
+			// The SOA is replaced only when its serial is ahead
+			updated, err := ParseSOA(update.RData)
+
+			if err == nil && CanonicalName(update.Name) == zone.Name && SerialLess(soa.Serial, updated.Serial) {
+				soa, soaTTL = updated, update.TTL
+			}
+		}
+	}
+
+	deleted, added := Diff(zone.records, records)
+
+	if len(deleted) == 0 && len(added) == 0 && soa == zone.soa && soaTTL == zone.soaTTL {
+		return types.NoError, false, nil
+	}
+
+	return types.NoError, true, zone.apply(soa, soaTTL, records, deleted, added)
+}
+
+// checkPrerequisites - Verify the prerequisite section of an update
+// (RFC 2136 - Section 3.2)
+func (zone *Zone) checkPrerequisites(prerequisites []types.DNSResource) types.RCode {
+	// Records of the "RRset exists (value dependent)" prerequisites
+	// grouped by RRset
+	expected := map[string][]types.DNSResource{}
+
+	for _, prerequisite := range prerequisites {
+		if prerequisite.TTL != 0 {
+			return types.FormatError
+		}
+
+		if !InZone(prerequisite.Name, zone.Name) {
+			return types.NotZone
+		}
+
+		rrset := zone.rrset(prerequisite.Name, prerequisite.Type)
+
+		switch prerequisite.Class.Code {
+		case types.ANY.Code:
+			if prerequisite.RData != "" {
+				return types.FormatError
+			}
+
+			if len(rrset) == 0 && prerequisite.Type.Code == types.QTYPEALL.Code {
+				return types.NXDomain
+			}
+
+			if len(rrset) == 0 {
+				return types.NXRRSet
+			}
+		case types.NONE.Code:
+			if prerequisite.RData != "" {
+				return types.FormatError
+			}
+
+			if len(rrset) != 0 && prerequisite.Type.Code == types.QTYPEALL.Code {
+				return types.YXDomain
+			}
+
+			if len(rrset) != 0 {
+				return types.YXRRSet
+			}
+		case types.IN.Code:
+			if isMetaType(prerequisite.Type) {
+				return types.FormatError
+			}
+
+			key := CanonicalName(prerequisite.Name) + "|" + prerequisite.Type.Name
+			expected[key] = append(expected[key], prerequisite)
+		default:
+			return types.FormatError
+		}
+	}
+
+	for _, records := range expected {
+		if !sameRData(records, zone.rrset(records[0].Name, records[0].Type)) {
+			return types.NXRRSet
+		}
+	}
+
+	return types.NoError
+}
+
+// sameRData - Compare two RRsets ignoring the TTLs
+func sameRData(a, b []types.DNSResource) bool {
+	values := map[string]bool{}
+	for _, record := range a {
+		values[record.RData] = true
+	}
+
+	others := map[string]bool{}
+	for _, record := range b {
+		if !values[record.RData] {
+			return false
+		}
+
+		others[record.RData] = true
+	}
+
+	return len(values) == len(others)
+}
+
+// prescan - Validate the update section (RFC 2136 - Section 3.4.1.3)
+func (zone *Zone) prescan(updates []types.DNSResource) types.RCode {
+	for _, update := range updates {
+		if !InZone(update.Name, zone.Name) {
+			return types.NotZone
+		}
+
+		switch update.Class.Code {
+		case types.IN.Code:
+			if isMetaType(update.Type) || update.RData == "" {
+				return types.FormatError
+			}
+		case types.ANY.Code:
+			if update.TTL != 0 || update.RData != "" || (isMetaType(update.Type) && update.Type.Code != types.QTYPEALL.Code) {
+				return types.FormatError
+			}
+		case types.NONE.Code:
+			if update.TTL != 0 || isMetaType(update.Type) {
+				return types.FormatError
+			}
+		default:
+			return types.FormatError
+		}
+	}
+
+	return types.NoError
+}
+
+// addRecord - Add a record, replacing the record with the same data
+// and keeping a CNAME alone in its name (RFC 2136 - Section 3.4.2.2)
+func (zone *Zone) addRecord(records []types.DNSResource, update types.DNSResource) []types.DNSResource {
+	name := CanonicalName(update.Name)
+	result := []types.DNSResource{}
+
+	for _, record := range records {
+		if CanonicalName(record.Name) != name {
+			result = append(result, record)
+			continue
+		}
+
+		isCNAME := record.Type.Code == types.CNAME.Code
+
+		if isCNAME != (update.Type.Code == types.CNAME.Code) {
+			// A CNAME can't coexist with other data, ignore the update
+			return records
+		}
+
+		if record.Type.Code == update.Type.Code && (isCNAME || record.RData == update.RData) {
+			continue
+		}
+
+		result = append(result, record)
+	}
+
+	update.Class = types.IN
+
+	return append(result, update)
+}
+
+// deleteRRset - Delete all the records of a name (type ANY) or an
+// RRset, keeping the SOA and NS records of the apex
+// (RFC 2136 - Section 3.4.2.3)
+func (zone *Zone) deleteRRset(records []types.DNSResource, update types.DNSResource) []types.DNSResource {
+	name := CanonicalName(update.Name)
+	result := []types.DNSResource{}
+
+	for _, record := range records {
+		matches := CanonicalName(record.Name) == name &&
+			(update.Type.Code == types.QTYPEALL.Code || record.Type.Code == update.Type.Code)
+
+		if matches && name == zone.Name && record.Type.Code == types.NS.Code {
+			matches = false
+		}
+
+		if !matches {
+			result = append(result, record)
+		}
+	}
+
+	return result
+}
+
+// deleteRecord - Delete the record with the data of the update,
+// keeping the SOA and the last NS record of the apex
+// (RFC 2136 - Section 3.4.2.4)
+func (zone *Zone) deleteRecord(records []types.DNSResource, update types.DNSResource) []types.DNSResource {
+	name := CanonicalName(update.Name)
+	nameservers := 0
+
+	for _, record := range records {
+		if CanonicalName(record.Name) == zone.Name && record.Type.Code == types.NS.Code {
+			nameservers++
+		}
+	}
+
+	result := []types.DNSResource{}
+
+	for _, record := range records {
+		matches := CanonicalName(record.Name) == name && record.Type.Code == update.Type.Code && record.RData == update.RData
+
+		if matches && name == zone.Name && record.Type.Code == types.NS.Code {
+			if nameservers == 1 {
+				matches = false
+			} else {
+				nameservers--
+			}
+		}
+
+		if !matches {
+			result = append(result, record)
+		}
+	}
+
+	return result
+}
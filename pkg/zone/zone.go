@@ -0,0 +1,561 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zone define the zones served with authority
+// by the DNS server
+package zone
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/types"
+)
+
+// DefaultTTL - TTL of the records configured without one
+const DefaultTTL = 3600
+
+// maxCNAMEChain - Maximum number of aliases followed in a lookup
+const maxCNAMEChain = 8
+
+// Zone - Zone served with authority. The SOA is kept apart
+// from the rest of the records
+type Zone struct {
+	Name    string
+	Journal *Journal
+	// Secondary - The zone is transferred from its primaries
+	Secondary bool
+
+	soa     SOA
+	soaTTL  int32
+	records []types.DNSResource
+	names   map[string][]types.DNSResource
+	// primaries - Servers a secondary zone is transferred from
+	primaries []string
+	// alsoNotify - Servers notified of the new versions of the zone
+	alsoNotify []string
+	// allowUpdate - Clients allowed to send dynamic updates
+	allowUpdate []string
+	// allowQuery - Clients allowed to query the zone
+	allowQuery []string
+	// blackhole - Clients whose queries of the zone are dropped
+	blackhole []string
+	// updateKey - Key required to sign the dynamic updates
+	updateKey string
+	// transferKey - Key required to sign the transfers, or used to
+	// sign the requests to the primaries of a secondary zone
+	transferKey string
+	// available - The zone has a valid version to serve. Only the
+	// secondary zones not transferred yet or expired are unavailable
+	available bool
+	// refreshed - Last time a secondary zone was checked with its primary
+	refreshed time.Time
+	// signing - Key of a zone signed with DNSSEC, nil when unsigned
+	signing *Signing
+	// chain - NSEC or NSEC3 records of the current version, in order
+	chain []link
+	// signatures - RRSIG of the RRsets already signed, by RRset
+	signatures      map[string]signature
+	signaturesMutex sync.Mutex
+	mutex           sync.RWMutex
+}
+
+// Answer - Result of a lookup in a zone
+type Answer struct {
+	RCode     types.RCode
+	Answers   []types.DNSResource
+	Authority []types.DNSResource
+}
+
+// NewZone - Generate a zone with its initial version
+func NewZone(name string, soa SOA, soaTTL int32, records []types.DNSResource, journal *Journal) *Zone {
+	if journal == nil {
+		journal = &Journal{}
+	}
+
+	zone := &Zone{Name: CanonicalName(name), Journal: journal, available: true}
+	zone.replace(soa, soaTTL, records)
+
+	return zone
+}
+
+// NewSecondaryZone - Generate a secondary zone waiting for
+// its first transfer
+func NewSecondaryZone(name string, primaries []string, journal *Journal) *Zone {
+	zone := NewZone(name, SOA{}, 0, nil, journal)
+	zone.Secondary = true
+	zone.primaries = primaries
+	zone.available = false
+
+	return zone
+}
+
+// CanonicalName - Lowercase name without the trailing dot
+func CanonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// InZone - Check if name is equal or below the zone origin
+func InZone(name, origin string) bool {
+	name = CanonicalName(name)
+	origin = CanonicalName(origin)
+
+	return origin == "" || name == origin || strings.HasSuffix(name, "."+origin)
+}
+
+// recordKey - Identity of a record, used to compute the differences
+// between two versions of a zone
+func recordKey(record types.DNSResource) string {
+	return fmt.Sprintf("%s|%d|%d|%d|%s", CanonicalName(record.Name), record.Type.Code, record.Class.Code, record.TTL, record.RData)
+}
+
+// reverseLabels - Key used to sort the names by its labels from the
+// root to the leaf, as in RFC 4034 - Section 6.1
+func reverseLabels(name string) string {
+	labels := strings.Split(CanonicalName(name), ".")
+
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+
+	return strings.Join(labels, "\x00")
+}
+
+// SortRecords - Sort the records by name, type and data
+func SortRecords(records []types.DNSResource) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := reverseLabels(records[i].Name), reverseLabels(records[j].Name)
+
+		if a != b {
+			return a < b
+		}
+
+		if records[i].Type.Code != records[j].Type.Code {
+			return records[i].Type.Code < records[j].Type.Code
+		}
+
+		return records[i].RData < records[j].RData
+	})
+}
+
+func (zone *Zone) replace(soa SOA, soaTTL int32, records []types.DNSResource) {
+	sorted := append([]types.DNSResource{}, records...)
+	SortRecords(sorted)
+
+	names := map[string][]types.DNSResource{zone.Name: nil}
+
+	for _, record := range sorted {
+		name := CanonicalName(record.Name)
+		names[name] = append(names[name], record)
+
+		// Register the empty non-terminals between the record and the origin
+		for parent := ParentName(name); InZone(parent, zone.Name) && parent != zone.Name; parent = ParentName(parent) {
+			if _, ok := names[parent]; !ok {
+				names[parent] = nil
+			}
+		}
+	}
+
+	zone.soa = soa
+	zone.soaTTL = soaTTL
+	zone.records = sorted
+	zone.names = names
+	zone.buildChain()
+}
+
+// ParentName - Name without its first label, empty for the top level
+// domains
+func ParentName(name string) string {
+	index := strings.Index(name, ".")
+
+	if index == -1 {
+		return ""
+	}
+
+	return name[index+1:]
+}
+
+// SOA - Current SOA of the zone
+func (zone *Zone) SOA() SOA {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.soa
+}
+
+// Serial - Current serial of the zone
+func (zone *Zone) Serial() uint32 {
+	return zone.SOA().Serial
+}
+
+// Records - Copy of the current records of the zone, without the SOA
+func (zone *Zone) Records() []types.DNSResource {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return append([]types.DNSResource{}, zone.records...)
+}
+
+func (zone *Zone) soaRecord(soa SOA) types.DNSResource {
+	return types.DNSResource{
+		Name:  zone.Name,
+		Type:  types.SOA,
+		Class: types.IN,
+		TTL:   zone.soaTTL,
+		RData: soa.String(),
+	}
+}
+
+// SOARecord - Current SOA of the zone as a resource
+func (zone *Zone) SOARecord() types.DNSResource {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.soaRecord(zone.soa)
+}
+
+// negativeSOA - SOA sent in the authority section of the negative
+// answers, with the TTL of RFC 2308 - Section 3
+func (zone *Zone) negativeSOA() types.DNSResource {
+	record := zone.soaRecord(zone.soa)
+
+	if uint32(record.TTL) > zone.soa.Minimum {
+		record.TTL = int32(zone.soa.Minimum)
+	}
+
+	return record
+}
+
+// Update - Replace the records of the zone when they differ from the
+// current ones, bumping the serial and recording the change in the
+// journal. The serial of soa is used when it's ahead of the current
+// one. Return true when a new version was generated
+func (zone *Zone) Update(soa SOA, soaTTL int32, records []types.DNSResource) (bool, error) {
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+
+	deleted, added := Diff(zone.records, records)
+	unchanged := soa
+	unchanged.Serial = zone.soa.Serial
+
+	if len(deleted) == 0 && len(added) == 0 && unchanged == zone.soa && soaTTL == zone.soaTTL && !SerialLess(zone.soa.Serial, soa.Serial) {
+		return false, nil
+	}
+
+	return true, zone.apply(soa, soaTTL, records, deleted, added)
+}
+
+// Transferred - Replace the records of the zone with a version obtained
+// from its primary, keeping the serial of the primary. Return true
+// when a new version was generated
+func (zone *Zone) Transferred(soa SOA, soaTTL int32, records []types.DNSResource) (bool, error) {
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+
+	zone.refreshed = time.Now()
+
+	if zone.available && soa == zone.soa && soaTTL == zone.soaTTL {
+		return false, nil
+	}
+
+	if !zone.available {
+		zone.available = true
+		zone.replace(soa, soaTTL, records)
+
+		return true, nil
+	}
+
+	deleted, added := Diff(zone.records, records)
+	change := Change{From: zone.soa, To: soa, Deleted: deleted, Added: added}
+	zone.replace(soa, soaTTL, records)
+
+	return true, zone.Journal.Append(change)
+}
+
+// Refreshed - Record that the zone was checked with its primary
+// and it's up to date
+func (zone *Zone) Refreshed() {
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+
+	zone.refreshed = time.Now()
+}
+
+// LastRefresh - Last time the zone was checked with its primary
+func (zone *Zone) LastRefresh() time.Time {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.refreshed
+}
+
+// Primaries - Servers the zone is transferred from
+func (zone *Zone) Primaries() []string {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.primaries
+}
+
+// AlsoNotify - Servers notified of the new versions of the zone
+func (zone *Zone) AlsoNotify() []string {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.alsoNotify
+}
+
+// AllowUpdate - Addresses or networks allowed to send dynamic updates
+func (zone *Zone) AllowUpdate() []string {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.allowUpdate
+}
+
+// AllowQuery - Addresses or networks allowed to query the zone, all
+// when empty
+func (zone *Zone) AllowQuery() []string {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.allowQuery
+}
+
+// Blackhole - Addresses or networks whose queries of the zone are
+// dropped
+func (zone *Zone) Blackhole() []string {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.blackhole
+}
+
+// UpdateKey - Name of the key required to sign the dynamic updates
+func (zone *Zone) UpdateKey() string {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.updateKey
+}
+
+// TransferKey - Name of the key required to sign the transfers
+func (zone *Zone) TransferKey() string {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.transferKey
+}
+
+// setPolicy - Replace the servers notified of the new versions, the
+// clients allowed to query and update the zone and the keys required
+func (zone *Zone) setPolicy(zoneConfig config.Zone) {
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+
+	zone.alsoNotify = zoneConfig.AlsoNotify
+	zone.allowUpdate = zoneConfig.AllowUpdate
+	zone.allowQuery = zoneConfig.AllowQuery
+	zone.blackhole = zoneConfig.Blackhole
+	zone.updateKey = CanonicalName(zoneConfig.UpdateKey)
+	zone.transferKey = CanonicalName(zoneConfig.TransferKey)
+}
+
+// Expire - Stop serving the zone, after failing to refresh it from
+// its primary during the expire time of the SOA
+func (zone *Zone) Expire() {
+	zone.mutex.Lock()
+	defer zone.mutex.Unlock()
+
+	zone.available = false
+}
+
+// Available - Check if the zone has a version to serve
+func (zone *Zone) Available() bool {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	return zone.available
+}
+
+// apply - Generate a new version of the zone with the serial of
+// soa, which must be the current one, bumped
+func (zone *Zone) apply(soa SOA, soaTTL int32, records, deleted, added []types.DNSResource) error {
+	configured := soa.Serial
+	soa.Serial = nextSerial(zone.soa.Serial, configured)
+
+	change := Change{From: zone.soa, To: soa, Deleted: deleted, Added: added}
+	zone.replace(soa, soaTTL, records)
+
+	return zone.Journal.Append(change)
+}
+
+// Diff - Records removed and added to go from the old to the new version
+func Diff(old, new []types.DNSResource) (deleted, added []types.DNSResource) {
+	oldKeys := map[string]bool{}
+	newKeys := map[string]bool{}
+
+	for _, record := range old {
+		oldKeys[recordKey(record)] = true
+	}
+
+	for _, record := range new {
+		key := recordKey(record)
+
+		if !oldKeys[key] && !newKeys[key] {
+			added = append(added, record)
+		}
+
+		newKeys[key] = true
+	}
+
+	for _, record := range old {
+		if !newKeys[recordKey(record)] {
+			deleted = append(deleted, record)
+		}
+	}
+
+	return deleted, added
+}
+
+// Transfer - Content of the zone as sent in an AXFR response
+// (RFC 5936 - Section 2.2): the SOA, the records and the SOA again
+func (zone *Zone) Transfer() []types.DNSResource {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	soa := zone.soaRecord(zone.soa)
+	records := []types.DNSResource{soa}
+	records = append(records, zone.records...)
+
+	return append(records, soa)
+}
+
+// Changes - Content of an IXFR response (RFC 1995 - Section 4) for a
+// client with serial. A client already updated receive only the
+// current SOA, and when the history isn't available the whole zone is
+// sent as in an AXFR response
+func (zone *Zone) Changes(serial uint32) []types.DNSResource {
+	zone.mutex.RLock()
+	current := zone.soaRecord(zone.soa)
+	upToDate := !SerialLess(serial, zone.soa.Serial)
+	zone.mutex.RUnlock()
+
+	if upToDate {
+		return []types.DNSResource{current}
+	}
+
+	changes, ok := zone.Journal.Since(serial)
+
+	if !ok || changes[len(changes)-1].To.Serial != zone.Serial() {
+		return zone.Transfer()
+	}
+
+	records := []types.DNSResource{current}
+
+	for _, change := range changes {
+		records = append(records, zone.soaRecord(change.From))
+		records = append(records, change.Deleted...)
+		records = append(records, zone.soaRecord(change.To))
+		records = append(records, change.Added...)
+	}
+
+	return append(records, current)
+}
+
+// Lookup - Search the records of name and qtype, following the
+// aliases and the wildcards inside of the zone
+func (zone *Zone) Lookup(name string, qtype types.QType) Answer {
+	zone.mutex.RLock()
+	defer zone.mutex.RUnlock()
+
+	answer := Answer{RCode: types.NoError}
+	name = CanonicalName(name)
+
+	for i := 0; i < maxCNAMEChain; i++ {
+		records, exists := zone.find(name)
+
+		if !exists {
+			answer.RCode = types.NXDomain
+			answer.Authority = []types.DNSResource{zone.negativeSOA()}
+
+			return answer
+		}
+
+		matches := []types.DNSResource{}
+		var alias *types.DNSResource
+
+		for j, record := range records {
+			switch {
+			case qtype.Code == types.QTYPEALL.Code, record.Type.Code == qtype.Code:
+				matches = append(matches, record)
+			case record.Type.Code == types.CNAME.Code:
+				alias = &records[j]
+			}
+		}
+
+		if name == zone.Name {
+			matches = append(zone.apex(qtype), matches...)
+		}
+
+		if len(matches) > 0 {
+			answer.Answers = append(answer.Answers, matches...)
+
+			return answer
+		}
+
+		if alias == nil {
+			answer.Authority = []types.DNSResource{zone.negativeSOA()}
+
+			return answer
+		}
+
+		answer.Answers = append(answer.Answers, *alias)
+		name = CanonicalName(alias.RData)
+
+		if !InZone(name, zone.Name) {
+			return answer
+		}
+	}
+
+	return answer
+}
+
+// find - Records owned by name, synthesized from a wildcard when
+// needed (RFC 4592). The second value is false when the name
+// doesn't exist in the zone
+func (zone *Zone) find(name string) ([]types.DNSResource, bool) {
+	records, exists := zone.names[name]
+
+	if exists || !InZone(name, zone.Name) {
+		return records, exists
+	}
+
+	// Search the closest encloser and its wildcard
+	for parent := ParentName(name); InZone(parent, zone.Name); parent = ParentName(parent) {
+		if _, ok := zone.names[parent]; !ok {
+			continue
+		}
+
+		wildcard, ok := zone.names[strings.TrimSuffix("*."+parent, ".")]
+
+		if !ok {
+			return nil, false
+		}
+
+		synthesized := make([]types.DNSResource, len(wildcard))
+		for i, record := range wildcard {
+			record.Name = name
+			synthesized[i] = record
+		}
+
+		return synthesized, true
+	}
+
+	return nil, false
+}
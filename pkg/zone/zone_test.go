@@ -0,0 +1,325 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package zone_test define the test for the zone package
+package zone_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/lucasdc6/gdns/pkg/config"
+	"github.com/lucasdc6/gdns/pkg/types"
+	"github.com/lucasdc6/gdns/pkg/zone"
+)
+
+func record(name, rdata string) types.DNSResource {
+	return types.DNSResource{Name: name, Type: types.A, Class: types.IN, TTL: 300, RData: rdata}
+}
+
+func soaRecord(serial string) types.DNSResource {
+	return types.DNSResource{
+		Name:  "example.com",
+		Type:  types.SOA,
+		Class: types.IN,
+		TTL:   zone.DefaultTTL,
+		RData: "ns.example.com hostmaster.example.com " + serial + " 3600 600 604800 300",
+	}
+}
+
+func TestChanges(t *testing.T) {
+	soa := zone.DefaultSOA("example.com")
+	testZone := zone.NewZone("example.com", soa, zone.DefaultTTL, []types.DNSResource{
+		record("a.example.com", "192.168.0.1"),
+	}, nil)
+
+	testZone.Update(soa, zone.DefaultTTL, []types.DNSResource{
+		record("a.example.com", "192.168.0.2"),
+	})
+	testZone.Update(soa, zone.DefaultTTL, []types.DNSResource{
+		record("a.example.com", "192.168.0.2"),
+		record("b.example.com", "192.168.0.3"),
+	})
+
+	tests := []struct {
+		name   string
+		serial uint32
+		want   []types.DNSResource
+	}{
+		{
+			name:   "Up to date",
+			serial: 3,
+			want:   []types.DNSResource{soaRecord("3")},
+		},
+		{
+			name:   "One version behind",
+			serial: 2,
+			want: []types.DNSResource{
+				soaRecord("3"),
+				soaRecord("2"),
+				soaRecord("3"),
+				record("b.example.com", "192.168.0.3"),
+				soaRecord("3"),
+			},
+		},
+		{
+			name:   "Two versions behind",
+			serial: 1,
+			want: []types.DNSResource{
+				soaRecord("3"),
+				soaRecord("1"),
+				record("a.example.com", "192.168.0.1"),
+				soaRecord("2"),
+				record("a.example.com", "192.168.0.2"),
+				soaRecord("2"),
+				soaRecord("3"),
+				record("b.example.com", "192.168.0.3"),
+				soaRecord("3"),
+			},
+		},
+		{
+			name:   "History unavailable",
+			serial: 4294967290,
+			want: []types.DNSResource{
+				soaRecord("3"),
+				record("a.example.com", "192.168.0.2"),
+				record("b.example.com", "192.168.0.3"),
+				soaRecord("3"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, testZone.Changes(tt.serial)); diff != "" {
+				t.Errorf("Changes() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	soa := zone.DefaultSOA("example.com")
+	alias := types.DNSResource{Name: "www.example.com", Type: types.CNAME, Class: types.IN, TTL: 300, RData: "a.example.com"}
+	testZone := zone.NewZone("example.com", soa, zone.DefaultTTL, []types.DNSResource{
+		record("a.example.com", "192.168.0.1"),
+		record("*.dev.example.com", "192.168.0.2"),
+		alias,
+	}, nil)
+	negative := soaRecord("1")
+	negative.TTL = 300
+
+	tests := []struct {
+		name  string
+		qname string
+		qtype types.QType
+		want  zone.Answer
+	}{
+		{
+			name:  "Existing record",
+			qname: "A.example.com",
+			qtype: types.A,
+			want:  zone.Answer{RCode: types.NoError, Answers: []types.DNSResource{record("a.example.com", "192.168.0.1")}},
+		},
+		{
+			name:  "Alias",
+			qname: "www.example.com",
+			qtype: types.A,
+			want:  zone.Answer{RCode: types.NoError, Answers: []types.DNSResource{alias, record("a.example.com", "192.168.0.1")}},
+		},
+		{
+			name:  "Wildcard",
+			qname: "app.dev.example.com",
+			qtype: types.A,
+			want:  zone.Answer{RCode: types.NoError, Answers: []types.DNSResource{record("app.dev.example.com", "192.168.0.2")}},
+		},
+		{
+			name:  "No data",
+			qname: "a.example.com",
+			qtype: types.AAAA,
+			want:  zone.Answer{RCode: types.NoError, Authority: []types.DNSResource{negative}},
+		},
+		{
+			name:  "Non existent domain",
+			qname: "b.example.com",
+			qtype: types.A,
+			want:  zone.Answer{RCode: types.NXDomain, Authority: []types.DNSResource{negative}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, testZone.Lookup(tt.qname, tt.qtype)); diff != "" {
+				t.Errorf("Lookup() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTransferred(t *testing.T) {
+	testZone := zone.NewSecondaryZone("example.com", []string{"192.168.0.53"}, nil)
+
+	if testZone.Available() {
+		t.Fatalf("Available() = true before the first transfer")
+	}
+
+	first := zone.DefaultSOA("example.com")
+	testZone.Transferred(first, zone.DefaultTTL, []types.DNSResource{record("a.example.com", "192.168.0.1")})
+
+	second := first
+	second.Serial = 10
+	changed, err := testZone.Transferred(second, zone.DefaultTTL, []types.DNSResource{record("a.example.com", "192.168.0.2")})
+
+	if !changed || err != nil {
+		t.Fatalf("Transferred() = %v, %v, want true, nil", changed, err)
+	}
+
+	want := []types.DNSResource{
+		soaRecord("10"),
+		soaRecord("1"),
+		record("a.example.com", "192.168.0.1"),
+		soaRecord("10"),
+		record("a.example.com", "192.168.0.2"),
+		soaRecord("10"),
+	}
+
+	if diff := cmp.Diff(want, testZone.Changes(1)); diff != "" {
+		t.Errorf("Changes() mismatch (-want +got):\n%s", diff)
+	}
+
+	if changed, _ := testZone.Transferred(second, zone.DefaultTTL, nil); changed {
+		t.Errorf("Transferred() = true for the same version")
+	}
+}
+
+func TestApplyUpdate(t *testing.T) {
+	resource := func(name string, qtype types.QType, class types.QClass, ttl int32, rdata string) types.DNSResource {
+		return types.DNSResource{Name: name, Type: qtype, Class: class, TTL: ttl, RData: rdata}
+	}
+
+	tests := []struct {
+		name          string
+		prerequisites []types.DNSResource
+		updates       []types.DNSResource
+		wantRCode     types.RCode
+		wantRecords   []types.DNSResource
+	}{
+		{
+			name:        "Add record",
+			updates:     []types.DNSResource{record("b.example.com", "192.168.0.2")},
+			wantRCode:   types.NoError,
+			wantRecords: []types.DNSResource{record("a.example.com", "192.168.0.1"), record("b.example.com", "192.168.0.2")},
+		},
+		{
+			name:          "Name not in use",
+			prerequisites: []types.DNSResource{resource("a.example.com", types.QTYPEALL, types.NONE, 0, "")},
+			updates:       []types.DNSResource{record("a.example.com", "192.168.0.2")},
+			wantRCode:     types.YXDomain,
+			wantRecords:   []types.DNSResource{record("a.example.com", "192.168.0.1")},
+		},
+		{
+			name:          "RRset exists",
+			prerequisites: []types.DNSResource{resource("b.example.com", types.A, types.ANY, 0, "")},
+			wantRCode:     types.NXRRSet,
+			wantRecords:   []types.DNSResource{record("a.example.com", "192.168.0.1")},
+		},
+		{
+			name:          "RRset exists with value",
+			prerequisites: []types.DNSResource{resource("a.example.com", types.A, types.IN, 0, "192.168.0.1")},
+			updates:       []types.DNSResource{resource("a.example.com", types.A, types.ANY, 0, "")},
+			wantRCode:     types.NoError,
+			wantRecords:   []types.DNSResource{},
+		},
+		{
+			name:        "Delete record",
+			updates:     []types.DNSResource{resource("a.example.com", types.A, types.NONE, 0, "192.168.0.1")},
+			wantRCode:   types.NoError,
+			wantRecords: []types.DNSResource{},
+		},
+		{
+			name:        "CNAME with other data",
+			updates:     []types.DNSResource{resource("a.example.com", types.CNAME, types.IN, 300, "b.example.com")},
+			wantRCode:   types.NoError,
+			wantRecords: []types.DNSResource{record("a.example.com", "192.168.0.1")},
+		},
+		{
+			name:        "Out of zone",
+			updates:     []types.DNSResource{record("a.example.org", "192.168.0.1")},
+			wantRCode:   types.NotZone,
+			wantRecords: []types.DNSResource{record("a.example.com", "192.168.0.1")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testZone := zone.NewZone("example.com", zone.DefaultSOA("example.com"), zone.DefaultTTL, []types.DNSResource{
+				record("a.example.com", "192.168.0.1"),
+			}, nil)
+
+			rcode, changed, err := testZone.ApplyUpdate(tt.prerequisites, tt.updates)
+
+			if err != nil {
+				t.Fatalf("ApplyUpdate() error = %v", err)
+			}
+
+			if diff := cmp.Diff(tt.wantRCode, rcode); diff != "" {
+				t.Errorf("ApplyUpdate() rcode mismatch (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tt.wantRecords, testZone.Records()); diff != "" {
+				t.Errorf("Records() mismatch (-want +got):\n%s", diff)
+			}
+
+			wantSerial := uint32(1)
+			if changed {
+				wantSerial = 2
+			}
+
+			if testZone.Serial() != wantSerial {
+				t.Errorf("Serial() = %d, want %d", testZone.Serial(), wantSerial)
+			}
+		})
+	}
+}
+
+func TestParseFile(t *testing.T) {
+	file := `$TTL 300
+@	IN	SOA	ns.example.com. hostmaster.example.com. (
+		1	; serial
+		3600 600 604800 300 )
+	IN	NS	ns.example.com.
+www	3600	IN	A	192.168.0.1
+	IN 60	AAAA	2001:db8::1 ; same owner
+txt	TXT	"hello; world" "(a)"
+$ORIGIN dev.example.com.
+app	CNAME	www.example.com.
+other.test.	A	192.168.0.2
+`
+	want := []config.Record{
+		{Name: "example.com.", Type: types.SOA, Value: "ns.example.com. hostmaster.example.com. 1 3600 600 604800 300", TTL: 300},
+		{Name: "example.com.", Type: types.NS, Value: "ns.example.com.", TTL: 300},
+		{Name: "www.example.com.", Type: types.A, Value: "192.168.0.1", TTL: 3600},
+		{Name: "www.example.com.", Type: types.AAAA, Value: "2001:db8::1", TTL: 60},
+		{Name: "txt.example.com.", Type: types.TXT, Value: `"hello; world" "(a)"`, TTL: 300},
+		{Name: "app.dev.example.com.", Type: types.CNAME, Value: "www.example.com.", TTL: 300},
+		{Name: "other.test.", Type: types.A, Value: "192.168.0.2", TTL: 300},
+	}
+
+	got, err := zone.ParseFile(strings.NewReader(file), "example.com")
+
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ParseFile() mismatch (-want +got):\n%s", diff)
+	}
+
+	for _, invalid := range []string{"$INCLUDE other.zone", "\tA 192.168.0.1", "www A (192.168.0.1", "www UNKNOWN 1"} {
+		if _, err := zone.ParseFile(strings.NewReader(invalid), "example.com"); err == nil {
+			t.Errorf("ParseFile(%q) error = nil, want error", invalid)
+		}
+	}
+}
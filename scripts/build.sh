@@ -27,7 +27,7 @@ go mod download
 # Build!
 echo "==> Building..."
 for cmd in $(ls cmd); do
-  go build -o bin/$cmd cmd/$cmd/main.go
+  go build -o bin/$cmd ./cmd/$cmd
 done
 
 # Done!